@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/hyperbolicresearch/hlog/config"
+	"github.com/hyperbolicresearch/hlog/internal/agent"
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/internal/parser"
+	"github.com/hyperbolicresearch/hlog/internal/pubsub"
+	"github.com/hyperbolicresearch/hlog/internal/receiver"
+)
+
+// pathsFlag collects the repeated -path flags.
+type pathsFlag []string
+
+func (p *pathsFlag) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *pathsFlag) Set(v string) error {
+	*p = append(*p, v)
+	return nil
+}
+
+// runAgent tails log files and produces their lines, for example:
+//
+//	hlog agent -channel nginx -path '/var/log/nginx/*.log'
+//
+// The inputs of the configuration are used when no path is given.
+// The agent produces to Kafka, 'hlog standalone -agent' running it on
+// the embedded queue.
+func runAgent(args []string) {
+	cfg, err := config.FromYAML("config.yaml")
+	if err != nil {
+		cfg = &config.DefaultConfig
+	}
+	acfg := *cfg.Agent
+
+	fs := flag.NewFlagSet("agent", flag.ExitOnError)
+	var paths pathsFlag
+	fs.Var(&paths, "path", "glob pattern of the files to tail, repeatable")
+	channel := fs.String("channel", "default", "channel of the lines of the -path files")
+	fs.StringVar(&acfg.KafkaConfigs.Server, "kafka", acfg.KafkaConfigs.Server, "address of the Kafka broker")
+	fs.StringVar(&acfg.CheckpointFile, "checkpoints", acfg.CheckpointFile, "file where the read positions are saved")
+	fs.BoolVar(&acfg.FromBeginning, "from-beginning", acfg.FromBeginning, "read the existing files from their beginning at the first start")
+	fs.DurationVar(&acfg.PollInterval, "poll", acfg.PollInterval, "how often the files are checked")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: hlog agent [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if len(paths) > 0 {
+		acfg.Inputs = []config.AgentInput{{Paths: paths, Channel: *channel}}
+	}
+
+	p, err := pubsub.NewProducer(&acfg.KafkaConfigs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer p.Close()
+	producer := receiver.ProducerFunc(func(logs []*core.Log) []error {
+		return pubsub.ProduceLogs(p, logs, acfg.ProduceTimeout)
+	})
+
+	parsers, err := parser.NewRules(cfg.Parsing)
+	if err != nil {
+		log.Fatal(err)
+	}
+	a, err := agent.New(producer, acfg, parsers)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
+	log.Println("Hlog agent started...")
+	if err := a.Run(sigchan); err != nil {
+		log.Fatal(err)
+	}
+}
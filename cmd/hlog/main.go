@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
@@ -12,13 +13,50 @@ import (
 	"github.com/hyperbolicresearch/hlog/internal/ingest"
 )
 
-func init() {
-	if err := godotenv.Load(); err != nil {
-		panic("No .env file found")
+const usage = `Usage: hlog [command] [flags]
+
+Commands:
+  (none)    run the ingestion engine
+  tail      follow the logs of a remote livetail server
+  agent     tail log files and produce their lines
+  standalone
+            run the whole pipeline in one process, without Kafka
+  script test
+            run a processor expression on sample logs
+
+Run 'hlog <command> -h' for the flags of a command.
+`
+
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "tail":
+			tail(os.Args[2:])
+			return
+		case "agent":
+			runAgent(os.Args[2:])
+			return
+		case "standalone":
+			runStandalone(os.Args[2:])
+			return
+		case "script":
+			runScript(os.Args[2:])
+			return
+		case "-h", "-help", "--help", "help":
+			fmt.Print(usage)
+			return
+		default:
+			fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+			os.Exit(2)
+		}
 	}
+	engine()
 }
 
-func main() {
+func engine() {
+	if err := godotenv.Load(); err != nil {
+		panic("No .env file found")
+	}
 	log.Println("Hlog engine started...")
 
 	sigchan := make(chan os.Signal, 1)
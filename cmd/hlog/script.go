@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/internal/script"
+)
+
+// scriptCase is a line of the samples given to 'hlog script test': a
+// log, or a log with the value the expression is expected to return.
+type scriptCase struct {
+	Log    *core.Log   `json:"log"`
+	Expect interface{} `json:"expect"`
+}
+
+// runScript runs the script commands, for now only test, which runs an
+// expression on sample logs, for example:
+//
+//	hlog script test -e 'level == "error" && data.status >= 500' samples.jsonl
+//
+// The samples are JSON lines, each one a log or an object with the log
+// under "log" and the value expected under "expect". The command fails
+// when the expression does not compile, or fails or returns something
+// else than expected on a sample.
+func runScript(args []string) {
+	if len(args) == 0 || args[0] != "test" {
+		fmt.Fprintln(os.Stderr, "Usage: hlog script test [flags] [samples...]")
+		os.Exit(2)
+	}
+	fs := flag.NewFlagSet("script test", flag.ExitOnError)
+	source := fs.String("e", "", "expression to test")
+	file := fs.String("f", "", "file holding the expression to test")
+	timeout := fs.Duration("timeout", script.DefaultTimeout, "how long the expression may run on a log")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: hlog script test [flags] [samples...]")
+		fmt.Fprintln(fs.Output(), "The samples are read from the standard input when no file is given.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args[1:])
+
+	if *file != "" {
+		b, err := os.ReadFile(*file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		*source = string(b)
+	}
+	if *source == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	program, err := script.Compile(*source, *timeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "compiling: %v\n", err)
+		os.Exit(1)
+	}
+
+	inputs := fs.Args()
+	if len(inputs) == 0 {
+		inputs = []string{"-"}
+	}
+	failed := 0
+	for _, name := range inputs {
+		n, err := testScript(program, name, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			os.Exit(1)
+		}
+		failed += n
+	}
+	if failed > 0 {
+		fmt.Printf("%d failed\n", failed)
+		os.Exit(1)
+	}
+}
+
+// testScript runs program on the samples of a file, - being the standard
+// input, and returns the number of samples that failed.
+func testScript(program *script.Program, name string, out io.Writer) (int, error) {
+	f := os.Stdin
+	if name != "-" {
+		var err error
+		if f, err = os.Open(name); err != nil {
+			return 0, err
+		}
+		defer f.Close()
+	}
+	failed := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		b := bytes.TrimSpace(scanner.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		var c scriptCase
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(b, &fields); err != nil {
+			return failed, fmt.Errorf("line %d: %v", line, err)
+		}
+		_, hasExpect := fields["expect"]
+		if _, ok := fields["log"]; ok {
+			if err := json.Unmarshal(b, &c); err != nil {
+				return failed, fmt.Errorf("line %d: %v", line, err)
+			}
+		} else if err := json.Unmarshal(b, &c.Log); err != nil {
+			return failed, fmt.Errorf("line %d: %v", line, err)
+		}
+		if c.Log == nil {
+			return failed, fmt.Errorf("line %d: no log", line)
+		}
+
+		v, err := program.Run(c.Log)
+		switch {
+		case err != nil:
+			failed++
+			fmt.Fprintf(out, "FAIL %s:%d: %v\n", name, line, err)
+		case hasExpect && !sameJSON(v, c.Expect):
+			failed++
+			fmt.Fprintf(out, "FAIL %s:%d: expected %s, got %s\n", name, line, toJSON(c.Expect), toJSON(v))
+		case hasExpect:
+			fmt.Fprintf(out, "ok   %s:%d: %s\n", name, line, toJSON(v))
+		default:
+			fmt.Fprintf(out, "     %s:%d: %s\n", name, line, toJSON(v))
+		}
+	}
+	return failed, scanner.Err()
+}
+
+func toJSON(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// sameJSON compares values as JSON, so that the numbers returned by the
+// expressions compare equal to the ones decoded from the samples.
+func sameJSON(v, expect interface{}) bool {
+	var a, b interface{}
+	json.Unmarshal([]byte(toJSON(v)), &a)
+	json.Unmarshal([]byte(toJSON(expect)), &b)
+	return reflect.DeepEqual(a, b)
+}
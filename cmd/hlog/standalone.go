@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/hyperbolicresearch/hlog/config"
+	"github.com/hyperbolicresearch/hlog/internal/agent"
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/internal/gateway"
+	"github.com/hyperbolicresearch/hlog/internal/ingest"
+	"github.com/hyperbolicresearch/hlog/internal/parser"
+	"github.com/hyperbolicresearch/hlog/internal/pubsub"
+	"github.com/hyperbolicresearch/hlog/internal/receiver"
+	"github.com/hyperbolicresearch/hlog/utils"
+)
+
+// runStandalone runs the whole pipeline in one process, the embedded
+// queue replacing Kafka: the gateway and the other receivers, the
+// ClickHouse ingester, the live tail and optionally the file agent and
+// the producer simulator, for example:
+//
+//	hlog standalone -queue ./hlog-queue -simulate
+//
+// A queue directory is only used by one process, so the logs are
+// followed with 'hlog tail' on the live tail server rather than by
+// opening the queue from another process.
+func runStandalone(args []string) {
+	loaded, err := config.FromYAML("config.yaml")
+	if err != nil {
+		loaded = &config.DefaultConfig
+	}
+
+	fs := flag.NewFlagSet("standalone", flag.ExitOnError)
+	dir := fs.String("queue", "hlog-queue", "directory of the embedded queue")
+	ingesting := fs.Bool("ingest", true, "run the ClickHouse ingester, which needs ClickHouse and MongoDB")
+	tailing := fs.Bool("livetail", true, "run the live tail server")
+	tailingFiles := fs.Bool("agent", false, "run the file agent on the inputs of the configuration")
+	simulating := fs.Bool("simulate", false, "run the producer simulator")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: hlog standalone [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	cfg := withQueue(loaded, *dir)
+
+	p, err := pubsub.NewProducer(&cfg.Gateway.KafkaConfigs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer p.Close()
+	producer := receiver.ProducerFunc(func(logs []*core.Log) []error {
+		return pubsub.ProduceLogs(p, logs, cfg.Gateway.ProduceTimeout)
+	})
+	srv, err := gateway.NewServer(cfg, producer)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		log.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var stops []chan os.Signal
+	run := func(f func(stop chan os.Signal)) {
+		stop := make(chan os.Signal, 1)
+		stops = append(stops, stop)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f(stop)
+		}()
+	}
+	if *ingesting {
+		run(ingest.NewClickHouseIngester(cfg).Start)
+	}
+	if *tailing {
+		run(func(stop chan os.Signal) { utils.LiveTail(cfg.Livetail, stop) })
+	}
+	if *tailingFiles {
+		parsers, err := parser.NewRules(cfg.Parsing)
+		if err != nil {
+			log.Fatal(err)
+		}
+		a, err := agent.New(producer, *cfg.Agent, parsers)
+		if err != nil {
+			log.Fatal(err)
+		}
+		run(func(stop chan os.Signal) {
+			if err := a.Run(stop); err != nil {
+				log.Printf("agent: %v", err)
+			}
+		})
+	}
+	if *simulating {
+		run(func(stop chan os.Signal) { utils.GenerateRandomLogs(cfg, stop) })
+	}
+
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
+	log.Printf("Hlog standalone started, queue in %s...", *dir)
+	sig := <-sigchan
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Gateway.ProduceTimeout)
+	defer cancel()
+	srv.Shutdown(ctx)
+	for _, stop := range stops {
+		stop <- sig
+	}
+	wg.Wait()
+}
+
+// withQueue returns a copy of cfg whose components use the embedded
+// queue of dir instead of Kafka.
+func withQueue(cfg *config.Config, dir string) *config.Config {
+	c := *cfg
+	kafka := *c.Kafka
+	kafka.QueueDir = dir
+	c.Kafka = &kafka
+	mongo := *c.MongoDB
+	mongo.KafkaConfigs.QueueDir = dir
+	c.MongoDB = &mongo
+	clickhouse := *c.ClickHouse
+	clickhouse.KafkaConfigs.QueueDir = dir
+	c.ClickHouse = &clickhouse
+	livetail := *c.Livetail
+	livetail.KafkaConfigs.QueueDir = dir
+	c.Livetail = &livetail
+	gateway := *c.Gateway
+	gateway.KafkaConfigs.QueueDir = dir
+	c.Gateway = &gateway
+	agent := *c.Agent
+	agent.KafkaConfigs.QueueDir = dir
+	c.Agent = &agent
+	simulator := *c.Simulator
+	simulator.KafkaConfigs.QueueDir = dir
+	c.Simulator = &simulator
+	return &c
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/hyperbolicresearch/hlog/internal/filter"
+	"github.com/hyperbolicresearch/hlog/internal/livetail"
+	"github.com/hyperbolicresearch/hlog/pkg/logger"
+)
+
+// tail follows the logs of a remote livetail server, for example:
+//
+//	hlog tail -level warn -channel payments ws://logs.internal:1337
+//	hlog tail -output json https://logs.internal:1337 | jq .message
+func tail(args []string) {
+	fs := flag.NewFlagSet("tail", flag.ExitOnError)
+	filterExpr := fs.String("filter", "", `filter expression, e.g. "level:warn channel:a,b field:user.id=42"`)
+	level := fs.String("level", "", "minimum level of the logs to show")
+	channel := fs.String("channel", "", "comma separated list of channels to show")
+	sender := fs.String("sender", "", "comma separated list of sender ids to show")
+	output := fs.String("output", "pretty", "output format: pretty or json")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: hlog tail [flags] [url]")
+		fmt.Fprintln(fs.Output(), "\nThe url defaults to ws://localhost:1337; use http(s):// for server-sent events.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	f, err := filter.Parse(*filterExpr)
+	if err != nil {
+		log.Fatalf("invalid filter: %v", err)
+	}
+	if *level != "" {
+		f.Level = *level
+	}
+	if *channel != "" {
+		f.Channels = append(f.Channels, strings.Split(*channel, ",")...)
+	}
+	if *sender != "" {
+		f.Senders = append(f.Senders, strings.Split(*sender, ",")...)
+	}
+	if err := f.Validate(); err != nil {
+		log.Fatalf("invalid filter: %v", err)
+	}
+
+	url := fs.Arg(0)
+	if url == "" {
+		url = "ws://localhost:1337"
+	}
+
+	var onEvent func(livetail.Event) error
+	switch *output {
+	case "pretty":
+		// The filtering is done by the server, so the local logger lets
+		// everything through.
+		lg := logger.New(logger.DEBUG, os.Stdout)
+		onEvent = func(ev livetail.Event) error {
+			return lg.Log(ev.Log)
+		}
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		onEvent = func(ev livetail.Event) error {
+			return enc.Encode(ev.Log)
+		}
+	default:
+		log.Fatalf("unknown output %q, expected pretty or json", *output)
+	}
+
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
+
+	client := &livetail.Client{
+		URL:     url,
+		Filter:  f,
+		OnEvent: onEvent,
+	}
+	if err := client.Run(sigchan); err != nil {
+		log.Fatal(err)
+	}
+}
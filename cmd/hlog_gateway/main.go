@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/hyperbolicresearch/hlog/config"
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/internal/gateway"
+	"github.com/hyperbolicresearch/hlog/internal/pubsub"
+	"github.com/hyperbolicresearch/hlog/internal/receiver"
+)
+
+func main() {
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
+
+	// We load the configurations by reading the config.yaml, otherwise
+	// (if it fails to load), we load the default configurations.
+	cfg, err := config.FromYAML("config.yaml")
+	if err != nil {
+		cfg = &config.DefaultConfig
+	}
+	gcfg := cfg.Gateway
+
+	p, err := pubsub.NewProducer(&gcfg.KafkaConfigs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer p.Close()
+	producer := receiver.ProducerFunc(func(logs []*core.Log) []error {
+		return pubsub.ProduceLogs(p, logs, gcfg.ProduceTimeout)
+	})
+
+	srv, err := gateway.NewServer(cfg, producer)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		log.Fatal(err)
+	}
+
+	<-sigchan
+	ctx, cancel := context.WithTimeout(context.Background(), gcfg.ProduceTimeout)
+	defer cancel()
+	srv.Shutdown(ctx)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"os"
 	"os/signal"
@@ -9,6 +10,8 @@ import (
 	"github.com/joho/godotenv"
 
 	"github.com/hyperbolicresearch/hlog/config"
+	"github.com/hyperbolicresearch/hlog/internal/filter"
+	"github.com/hyperbolicresearch/hlog/internal/tui"
 	"github.com/hyperbolicresearch/hlog/utils"
 )
 
@@ -23,6 +26,10 @@ func init() {
 }
 
 func main() {
+	plain := flag.Bool("plain", false, "stream the logs to stdout instead of using the interactive UI")
+	filterExpr := flag.String("filter", "", "initial filter of the interactive UI, e.g. \"level:warn channel:default\"")
+	flag.Parse()
+
 	// We load the configurations by reading the config.yaml, otherwise
 	// (if it fails to load), we load the default configurations.
 	cfg, err := config.FromYAML("config.yaml")
@@ -30,11 +37,25 @@ func main() {
 		cfg = &config.DefaultConfig
 	}
 
-	// TODO make a better welcome message here
-	log.Println("Hlog live tail (experimental) up and running...")
-
 	sigchan := make(chan os.Signal, 1)
 	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
 
+	// The interactive UI only makes sense on a terminal, otherwise (when
+	// piping to a file or another program) we keep streaming plain lines.
+	if !*plain && tui.IsTerminal(os.Stdin) && tui.IsTerminal(os.Stdout) {
+		f, err := filter.Parse(*filterExpr)
+		if err != nil {
+			log.Fatalf("invalid filter: %v", err)
+		}
+		opts := tui.DefaultOptions
+		opts.Filter = f
+		if err := utils.LiveTailTUI(cfg.Livetail, sigchan, opts); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// TODO make a better welcome message here
+	log.Println("Hlog live tail (experimental) up and running...")
 	utils.LiveTail(cfg.Livetail, sigchan)
 }
@@ -2,44 +2,85 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
-	"github.com/hyperbolicresearch/hlog/internal/clickhouseservice"
 	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+
+	"github.com/hyperbolicresearch/hlog/config"
+	"github.com/hyperbolicresearch/hlog/internal/clickhouseservice"
+	"github.com/hyperbolicresearch/hlog/internal/ingest"
+	"github.com/hyperbolicresearch/hlog/internal/metrics"
+	"github.com/hyperbolicresearch/hlog/internal/mongodb"
+	"github.com/hyperbolicresearch/hlog/internal/schema"
 )
 
+// countPath is the endpoint counting the logs of a channel.
+const countPath = "/v1/count"
+
 func main() {
-	addrs := []string{"127.0.0.1:9000"}
-	chConn, err := clickhouseservice.Conn(addrs)
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
+
+	cfg, err := config.FromYAML("config.yaml")
 	if err != nil {
-		panic(err)
+		cfg = &config.DefaultConfig
 	}
 
-	count(chConn)
-	// describe(chConn)
-}
-
-func count(chConn driver.Conn) {
-	query := "SELECT COUNT(*) FROM default.default"
-	row := chConn.QueryRow(context.Background(), query)
-	var count uint64
-	if err := row.Scan(&count); err != nil {
+	chConn, err := clickhouseservice.Conn(cfg.ClickHouse.Addr)
+	if err != nil {
 		panic(err)
 	}
-	fmt.Println(count)
-}
-
-func describe(chConn driver.Conn) {
-	query := "SELECT name FROM system.databases"
-	rows, err := chConn.Query(context.Background(), query)
+	store, err := metrics.NewStore(context.Background(), chConn, cfg.Metrics.Table)
 	if err != nil {
 		panic(err)
 	}
-	for rows.Next() {
-		var name string
-		if err := rows.Scan(&name); err != nil {
-			panic(err)
+
+	db := mongodb.Client(cfg.MongoDB.Server).Database(cfg.MongoDB.Database)
+
+	mux := http.NewServeMux()
+	store.Register(mux)
+	schema.NewStore(db, cfg.Validation).Register(mux)
+	mux.HandleFunc(countPath, func(w http.ResponseWriter, r *http.Request) {
+		channel := r.URL.Query().Get("channel")
+		if channel == "" {
+			channel = "default"
+		}
+		n, err := count(r.Context(), chConn, channel)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"channel": channel, "count": n})
+	})
+	srv := &http.Server{Addr: cfg.API.Addr, Handler: mux}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
 		}
-		fmt.Println(name)
+	}()
+	log.Printf("API listening on %s", cfg.API.Addr)
+
+	<-sigchan
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(10)*time.Second)
+	defer cancel()
+	srv.Shutdown(ctx)
+}
+
+// count returns the number of logs stored for a channel.
+func count(ctx context.Context, chConn driver.Conn, channel string) (uint64, error) {
+	query := fmt.Sprintf("SELECT COUNT(*) FROM `%s`", ingest.TableName(channel))
+	row := chConn.QueryRow(ctx, query)
+	var count uint64
+	if err := row.Scan(&count); err != nil {
+		return 0, err
 	}
+	return count, nil
 }
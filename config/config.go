@@ -16,6 +16,20 @@ type Config struct {
 	*ClickHouse
 	*Livetail
 	*Simulator
+	*Gateway
+	*OTLP
+	*Syslog
+	*Agent
+	*Parsing
+	*Forward
+	*Loki
+	*Elastic
+	*Processing
+	*Metrics
+	*API
+	*Routing
+	*Validation
+	*Timestamps
 }
 
 // Kafka holds the configuration for Kafka
@@ -24,6 +38,22 @@ type Kafka struct {
 	GroupId          string
 	AutoOffsetReset  string
 	EnableAutoCommit bool
+	// AllowTopics and DenyTopics are regular expressions restricting the
+	// topics that are consumed, which is mostly useful with pattern
+	// subscriptions (topics starting with ^, like ^hlog\..*). A topic is
+	// consumed if it matches any of AllowTopics, or if AllowTopics is
+	// empty, and none of DenyTopics.
+	AllowTopics []string
+	DenyTopics  []string
+	// MetadataRefreshInterval is how often the broker is asked for the
+	// topics, and therefore how fast the new topics matching a pattern
+	// subscription are picked up. Zero keeps the client default.
+	MetadataRefreshInterval time.Duration
+	// QueueDir replaces the broker with the embedded queue keeping its
+	// segments in this directory, Server being ignored then. The
+	// components of a process using the same directory share the queue,
+	// which lets the whole pipeline run in one process without Kafka.
+	QueueDir string
 }
 
 // MongoDB holds the configuration for MongoDB
@@ -63,6 +93,424 @@ type Livetail struct {
 	DefaultLevel            logger.Level
 	MaxWebsocketConnections int
 	WebsocketPort           int
+	// ReplaySize is the number of recent logs kept to be replayed to
+	// the remote tail clients resuming after a disconnection.
+	ReplaySize int
+}
+
+// Gateway holds the configuration for the HTTP ingestion server
+type Gateway struct {
+	Addr         string
+	KafkaConfigs Kafka
+	// MaxBodySize bounds the size of the request bodies as sent, and
+	// MaxDecodedSize once decompressed.
+	MaxBodySize    int64
+	MaxDecodedSize int64
+	// MaxItems is the maximum number of logs of a request.
+	MaxItems int
+	// ProduceTimeout is how long a request waits for the broker to
+	// acknowledge its logs.
+	ProduceTimeout time.Duration
+}
+
+// OTLP holds the configuration for the OpenTelemetry logs receiver,
+// which produces with the gateway's Kafka configuration
+type OTLP struct {
+	// HTTPAddr and GRPCAddr are the addresses of the OTLP/HTTP and
+	// OTLP/gRPC listeners. An empty address disables the listener.
+	HTTPAddr string
+	GRPCAddr string
+	// ChannelAttribute is the resource attribute holding the channel of
+	// the logs, and DefaultChannel the channel of the resources without
+	// it.
+	ChannelAttribute string
+	DefaultChannel   string
+	MaxBodySize      int64
+	MaxDecodedSize   int64
+}
+
+// Loki holds the configuration for the Loki push endpoint, served by the
+// gateway
+type Loki struct {
+	// ChannelLabel is the stream label holding the channel of the
+	// entries, and DefaultChannel the channel of the streams without it.
+	ChannelLabel   string
+	DefaultChannel string
+	MaxBodySize    int64
+	MaxDecodedSize int64
+}
+
+// Elastic holds the configuration for the Elasticsearch bulk endpoint,
+// served by the gateway
+type Elastic struct {
+	// Routes decide the channel of the documents from their index, the
+	// first matching route winning. The documents matching none go to
+	// the channel named after their index when it is a valid channel
+	// name, to DefaultChannel otherwise.
+	Routes         []ElasticRoute
+	DefaultChannel string
+	MaxBodySize    int64
+	MaxDecodedSize int64
+}
+
+// ElasticRoute sends the documents whose index matches the glob Index,
+// such as filebeat-*, to Channel.
+type ElasticRoute struct {
+	Index   string
+	Channel string
+}
+
+// Syslog holds the configuration for the syslog receiver, which
+// produces with the gateway's Kafka configuration
+type Syslog struct {
+	// UDPAddr, TCPAddr and TLSAddr are the addresses of the listeners.
+	// An empty address disables the listener. The TLS listener needs
+	// TLSCertFile and TLSKeyFile.
+	UDPAddr     string
+	TCPAddr     string
+	TLSAddr     string
+	TLSCertFile string
+	TLSKeyFile  string
+	// Routes decide the channel of the messages, the first matching
+	// route winning. The messages matching none go to DefaultChannel,
+	// and the ones that cannot be parsed to FallbackChannel.
+	Routes          []SyslogRoute
+	DefaultChannel  string
+	FallbackChannel string
+	// MaxMessageSize is the maximum size of a message, larger ones being
+	// truncated over UDP and rejected over TCP.
+	MaxMessageSize int
+	BatchSize      int
+	Linger         time.Duration
+}
+
+// SyslogRoute sends the syslog messages whose Field (app_name,
+// hostname, facility, severity or msg_id) matches the regular
+// expression Match to Channel.
+type SyslogRoute struct {
+	Field   string
+	Match   string
+	Channel string
+}
+
+// Agent holds the configuration for the file-tailing agent
+type Agent struct {
+	KafkaConfigs Kafka
+	Inputs       []AgentInput
+	// CheckpointFile is where the read positions are saved, so that a
+	// restarted agent resumes where it stopped.
+	CheckpointFile string
+	// FromBeginning reads the files found at the first start from their
+	// beginning rather than from their end. The files appearing later,
+	// such as the ones created by a rotation, are always read entirely.
+	FromBeginning bool
+	PollInterval  time.Duration
+	// BatchSize is the maximum number of lines produced at once, and
+	// ProduceTimeout how long the agent waits for the broker to
+	// acknowledge them before trying again.
+	BatchSize      int
+	ProduceTimeout time.Duration
+	// MaxLineSize is the maximum size of a line, longer ones being
+	// truncated.
+	MaxLineSize int
+}
+
+// AgentInput is a set of files tailed by the agent, whose lines go to
+// Channel.
+type AgentInput struct {
+	// Paths are glob patterns, such as /var/log/app/*.log.
+	Paths   []string
+	Channel string
+}
+
+// Parsing holds the rules extracting fields from the plain-text logs,
+// used by the agent and by the ingesters for the messages that are not
+// log envelopes
+type Parsing struct {
+	Rules []ParserRule
+}
+
+// ParserRule tells how to parse the lines of a channel or source, the
+// first rule matching a line being used. Lines matched by no rule are
+// parsed with the auto format.
+type ParserRule struct {
+	// Channel is the channel of the lines, empty matching them all, and
+	// Source a glob matched against their source: the file path for the
+	// agent, the key of the message (the sender id) for the ingesters.
+	Channel string
+	Source  string
+	// Format is auto (JSON objects, and logfmt when the whole line is
+	// made of pairs), json, logfmt, regex, grok or none.
+	Format string
+	// Pattern is the expression of the regex and grok formats, and
+	// GrokPatterns custom grok patterns it may reference.
+	Pattern      string
+	GrokPatterns map[string]string
+	// Multiline groups the lines of an event, such as a stack trace:
+	// "stacktrace" recognizes the Java, Python and Go ones, any other
+	// value being a regular expression matching the first line of the
+	// events. Only the agent groups lines.
+	Multiline string
+	// LevelField, TimeField and MessageField are the fields moved to the
+	// envelope, common names being looked for when empty. TimeFormat is
+	// the layout of the timestamps, which are otherwise detected.
+	LevelField   string
+	TimeField    string
+	MessageField string
+	TimeFormat   string
+}
+
+// Processing holds the processor pipelines the ingesters run on the logs
+// between their consumption and their storage
+type Processing struct {
+	// Pipelines are tried in order, the logs going through the first
+	// one matching their channel only.
+	Pipelines []ProcessorPipeline
+}
+
+// ProcessorPipeline is the chain of stages run on the logs whose channel
+// matches the glob Channel, empty matching them all.
+type ProcessorPipeline struct {
+	Channel string
+	Stages  []ProcessorStage
+}
+
+// ProcessorStage is a stage of a pipeline. The fields are named after
+// the JSON encoding of the logs: channel, log_id, sender_id, timestamp,
+// level and message for the envelope, data.<key> for the data, nested
+// objects being reached with dots (data.user.id).
+type ProcessorStage struct {
+	// Type is one of:
+	//   - rename and copy: move or copy Field to To
+	//   - drop: remove Field
+	//   - set: set Field to Value, and add only when it is missing
+	//   - cast: convert Field to As, one of int, float, string or bool
+	//   - drop_log: drop the logs matching If
+	//   - normalize_level: lowercase Field (level by default) and map
+	//     the level aliases (WARNING, err...) to their canonical name
+	//   - redact: replace the secrets and personal data found by Detectors
+	//     in the message and the string values of the data, or in Field
+	//     only when it is set
+	//   - enrich: look the value of Field up in Table, and set the columns
+	//     of the row found under To (data by default)
+	//   - dedup: collapse the logs of a channel, sender and level whose
+	//     messages have the same template (their numbers, ids and quoted
+	//     strings aside) within Window: the first one goes through, and
+	//     the following ones are replaced when the window ends by one log
+	//     carrying data.repeat_count, data.first_seen and data.last_seen
+	//   - dedup_id: drop the logs whose log_id was seen within Window,
+	//     such as the ones delivered again after a failure
+	//   - filter: keep only the logs for which the expression Expr is true
+	//   - eval: set Field to the value of the expression Expr
+	Type  string
+	Field string
+	To    string
+	Value interface{}
+	As    string
+	// Detectors are the detectors of the redact stage, all the built-in
+	// ones masking what they find when empty. Key is the secret of the
+	// hmac action.
+	Detectors []RedactDetector
+	Key       string
+	// Table is the lookup table of the enrich stage.
+	Table *LookupTable
+	// Window is the window of the dedup and dedup_id stages, and MaxKeys
+	// the maximum number of templates or ids they remember.
+	Window  time.Duration
+	MaxKeys int
+	// Expr is the expression of the filter and eval stages, which may run
+	// for at most Timeout on a log, a default being used when zero.
+	Expr    string
+	Timeout time.Duration
+	// If restricts the stage to the logs matching it.
+	If *ProcessorCondition
+}
+
+// RedactDetector finds values to redact: Name is one of the built-in
+// detectors (email, ipv4, ipv6, pan for the card numbers passing the Luhn
+// check, jwt, aws_key and bearer), or names the custom detector matching
+// the regular expression Pattern, whose first group is redacted when it
+// has groups.
+type RedactDetector struct {
+	Name    string
+	Pattern string
+	// Action is what is done with the values found: mask (the default)
+	// replaces them with [REDACTED:<name>], drop removes the field
+	// holding them, and hmac replaces them with [<name>:<keyed hash>] so
+	// that equal values remain equal. Fields overrides Action per field,
+	// a field taking the action of its closest parent when it has none
+	// (data.user applies to data.user.email).
+	Action string
+	Fields map[string]string
+}
+
+// ProcessorCondition matches the logs whose Field equals one of Equals,
+// or matches the regular expression Matches. A condition with neither
+// matches the logs having the field. A condition with Expr, such as
+// level == "error" && data.status >= 500, matches the logs for which the
+// expression is true instead. The expression runs for at most Timeout
+// on a log, the one of the stage, or a default, being used when zero.
+type ProcessorCondition struct {
+	Field   string
+	Equals  []string
+	Matches string
+	Expr    string
+	Timeout time.Duration
+}
+
+// LookupTable is reference data loaded from File, a CSV file with a
+// header or a JSON file (an array of objects, or an object of objects
+// keyed by their key), or from Collection, a MongoDB collection of
+// Database on MongoServer.
+type LookupTable struct {
+	File        string
+	MongoServer string
+	Database    string
+	Collection  string
+	// Key is the column holding the keys the logs are joined on, and
+	// Columns the columns added to the logs, all the others when empty.
+	Key     string
+	Columns []string
+	// ReloadInterval is how often the file is checked for changes, or the
+	// collection loaded again.
+	ReloadInterval time.Duration
+}
+
+// Routing holds the rules deciding where the ingesters store the logs,
+// evaluated after the processor pipelines
+type Routing struct {
+	// Rules are evaluated in order, the first matching rule deciding the
+	// route of a log unless it lets the next ones match too. Default is
+	// the route of the logs matching no rule, which keeps their channel
+	// and stores them in every sink when empty.
+	Rules   []RouteRule
+	Default Route
+}
+
+// RouteRule routes the logs of the channels matching the glob Channel
+// (empty matching them all) and If, when it is set. With Continue, the
+// next rules are evaluated too, and the routes of the matching rules are
+// combined: the channel is the one set by the last of them, the copies
+// are all sent, and the sinks are the ones of the last rule setting
+// them. For example, to copy the fatal logs to an incidents channel:
+//
+//	{If: {Field: level, Equals: [fatal]}, Route: {Copies: [incidents]}, Continue: true}
+type RouteRule struct {
+	Channel  string
+	If       *ProcessorCondition
+	Route    Route
+	Continue bool
+}
+
+// Route tells where a log is stored.
+type Route struct {
+	// Channel replaces the channel of the log, which is kept when empty,
+	// and Copies are other channels the log is also stored in.
+	Channel string
+	Copies  []string
+	// Sinks are the sinks storing the log and its copies, clickhouse or
+	// mongodb, all of them when empty. A route with Drop stores nothing.
+	Sinks []string
+	Drop  bool
+}
+
+// Metrics holds the rules deriving metrics from the logs, which the
+// ClickHouse ingester aggregates per time bucket and writes to Table
+type Metrics struct {
+	Rules []MetricRule
+	// Bucket is the width of the time buckets, a whole number of seconds.
+	Bucket time.Duration
+	Table  string
+}
+
+// MetricRule derives a metric from the logs of the channels matching the
+// glob Channel (empty matching them all) and If, when it is set.
+type MetricRule struct {
+	// Name names the metric, and Type is one of:
+	//   - counter: counts the logs, and sums Field when it is set
+	//   - gauge: keeps the minimum, maximum, sum and last value of Field
+	//   - histogram: counts the values of Field per bucket, Buckets being
+	//     the upper bounds of the buckets, in increasing order
+	Name    string
+	Type    string
+	Channel string
+	If      *ProcessorCondition
+	Field   string
+	Buckets []float64
+	// Labels are the fields whose values label the series, such as level,
+	// channel, sender_id or data.region.
+	Labels []string
+}
+
+// Validation holds the configuration of the validation of the logs
+// against the JSON Schemas of their channels, which are uploaded through
+// the query API and stored in MongoDB
+type Validation struct {
+	// Collection is the collection of the schemas, and ReportCollection
+	// the one counting the violations per channel and sender.
+	Collection       string
+	ReportCollection string
+	// QuarantineChannel is the channel of the logs rejected by a schema
+	// in enforce mode.
+	QuarantineChannel string
+	// ReloadInterval is how often the ingesters load the schemas again.
+	ReloadInterval time.Duration
+}
+
+// Timestamps holds how the ingesters read the timestamps of the logs,
+// which they store in nanoseconds
+type Timestamps struct {
+	// Units declares the unit of the timestamps of some channels, the
+	// first matching rule winning. The unit of the other timestamps is
+	// detected from their magnitude.
+	Units []TimestampUnit
+	// MaxSkew is how far the timestamp of a log may be from the time it
+	// was produced to Kafka before the log is flagged with ClockSkew,
+	// zero disabling the check.
+	MaxSkew time.Duration
+}
+
+// TimestampUnit declares the unit of the timestamps of the channels
+// matching the glob Channel: s, ms, us or ns. For example, for the
+// producers sending milliseconds:
+//
+//	{Channel: web-*, Unit: ms}
+type TimestampUnit struct {
+	Channel string
+	Unit    string
+}
+
+// API holds the configuration for the query API server
+type API struct {
+	Addr string
+}
+
+// Forward holds the configuration for the Fluent Forward receiver, which
+// produces with the gateway's Kafka configuration
+type Forward struct {
+	// Addr is the address of the TCP listener, empty disabling it.
+	Addr string
+	// Routes decide the channel of the records from their tag, the first
+	// matching route winning. The records matching none go to the
+	// channel named after their tag when it is a valid channel name, to
+	// DefaultChannel otherwise.
+	Routes         []ForwardRoute
+	DefaultChannel string
+	// MaxMessageSize is the maximum size of a Forward message once
+	// decompressed, the connections sending larger ones being closed.
+	MaxMessageSize int
+	// BatchSize and Linger batch the records sent without chunk id; the
+	// ones with a chunk id are produced before the chunk is acknowledged.
+	BatchSize int
+	Linger    time.Duration
+}
+
+// ForwardRoute sends the records whose tag matches Tag to Channel. Tag
+// is a Fluentd match pattern: * matches a part of the tag, ** any
+// number of parts, and {a,b} either a or b.
+type ForwardRoute struct {
+	Tag     string
+	Channel string
 }
 
 // Simulator holds the configurations for the log producing simulator
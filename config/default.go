@@ -8,6 +8,11 @@ import (
 )
 
 var (
+	// DefaultDenyTopics are the topics that are never consumed as
+	// channels, even when they match a pattern subscription: Kafka's
+	// internal topics and the ones hlog uses for itself.
+	DefaultDenyTopics = []string{`^__`, `^hlog-mongodb-callback$`}
+
 	// DefaultConfig is the default top-level configuration for the whole system.
 	DefaultConfig = Config{
 		Kafka:      &DefaultKafkaConfig,
@@ -15,6 +20,20 @@ var (
 		ClickHouse: &DefaultClickHouseConfig,
 		Livetail:   &DefaultLivetailConfig,
 		Simulator:  &DefaultSimulatorConfig,
+		Gateway:    &DefaultGatewayConfig,
+		OTLP:       &DefaultOTLPConfig,
+		Syslog:     &DefaultSyslogConfig,
+		Agent:      &DefaultAgentConfig,
+		Parsing:    &Parsing{},
+		Forward:    &DefaultForwardConfig,
+		Loki:       &DefaultLokiConfig,
+		Elastic:    &DefaultElasticConfig,
+		Processing: &Processing{},
+		Metrics:    &DefaultMetricsConfig,
+		API:        &DefaultAPIConfig,
+		Routing:    &Routing{},
+		Validation: &DefaultValidationConfig,
+		Timestamps: &DefaultTimestampsConfig,
 	}
 
 	// DefaultKafkaConfig is the default kafka configuration.
@@ -35,6 +54,9 @@ var (
 			GroupId:          "hlog-default-mongodb",
 			AutoOffsetReset:  "earliest",
 			EnableAutoCommit: true,
+			DenyTopics:       DefaultDenyTopics,
+
+			MetadataRefreshInterval: time.Duration(30) * time.Second,
 		},
 		KafkaTopics:     []string{"default"},
 		ConsumeInterval: time.Millisecond * time.Duration(100),
@@ -54,6 +76,9 @@ var (
 			GroupId:          "hlog-default-clickhouse",
 			AutoOffsetReset:  "earliest",
 			EnableAutoCommit: false,
+			DenyTopics:       DefaultDenyTopics,
+
+			MetadataRefreshInterval: time.Duration(30) * time.Second,
 		},
 		KafkaTopics:      []string{"default"},
 		ConsumeInterval:  time.Duration(5) * time.Second,
@@ -70,11 +95,111 @@ var (
 			GroupId:          "hlog-livetail-default",
 			AutoOffsetReset:  "earliest",
 			EnableAutoCommit: true,
+			DenyTopics:       DefaultDenyTopics,
+
+			MetadataRefreshInterval: time.Duration(30) * time.Second,
 		},
 		ConsumeInterval:         time.Duration(100) * time.Millisecond,
 		DefaultLevel:            logger.DEBUG,
 		MaxWebsocketConnections: 5,
 		WebsocketPort:           1337,
+		ReplaySize:              1000,
+	}
+
+	// DefaultGatewayConfig is the default HTTP ingestion server configuration.
+	DefaultGatewayConfig = Gateway{
+		Addr: ":8080",
+		KafkaConfigs: Kafka{
+			Server: "0.0.0.0:65007",
+		},
+		MaxBodySize:    5 << 20,
+		MaxDecodedSize: 50 << 20,
+		MaxItems:       10000,
+		ProduceTimeout: time.Duration(10) * time.Second,
+	}
+
+	// DefaultOTLPConfig is the default OpenTelemetry logs receiver configuration.
+	DefaultOTLPConfig = OTLP{
+		HTTPAddr:         ":4318",
+		GRPCAddr:         ":4317",
+		ChannelAttribute: "service.name",
+		DefaultChannel:   "otlp",
+		MaxBodySize:      5 << 20,
+		MaxDecodedSize:   50 << 20,
+	}
+
+	// DefaultLokiConfig is the default Loki push endpoint configuration.
+	DefaultLokiConfig = Loki{
+		ChannelLabel:   "job",
+		DefaultChannel: "loki",
+		MaxBodySize:    5 << 20,
+		MaxDecodedSize: 50 << 20,
+	}
+
+	// DefaultElasticConfig is the default Elasticsearch bulk endpoint
+	// configuration.
+	DefaultElasticConfig = Elastic{
+		DefaultChannel: "elastic",
+		MaxBodySize:    10 << 20,
+		MaxDecodedSize: 100 << 20,
+	}
+
+	// DefaultSyslogConfig is the default syslog receiver configuration.
+	DefaultSyslogConfig = Syslog{
+		UDPAddr:         ":5514",
+		TCPAddr:         ":5514",
+		DefaultChannel:  "syslog",
+		FallbackChannel: "syslog-unparsed",
+		MaxMessageSize:  64 << 10,
+		BatchSize:       500,
+		Linger:          time.Duration(200) * time.Millisecond,
+	}
+
+	// DefaultForwardConfig is the default Fluent Forward receiver
+	// configuration.
+	DefaultForwardConfig = Forward{
+		Addr:           ":24224",
+		DefaultChannel: "fluent",
+		MaxMessageSize: 32 << 20,
+		BatchSize:      500,
+		Linger:         time.Duration(200) * time.Millisecond,
+	}
+
+	// DefaultAgentConfig is the default file-tailing agent configuration.
+	DefaultAgentConfig = Agent{
+		KafkaConfigs: Kafka{
+			Server: "0.0.0.0:65007",
+		},
+		CheckpointFile: "hlog-agent.checkpoints.json",
+		PollInterval:   time.Duration(1) * time.Second,
+		BatchSize:      500,
+		ProduceTimeout: time.Duration(30) * time.Second,
+		MaxLineSize:    256 << 10,
+	}
+
+	// DefaultMetricsConfig is the default log-to-metrics configuration.
+	DefaultMetricsConfig = Metrics{
+		Bucket: time.Duration(1) * time.Minute,
+		Table:  "hlog_metrics",
+	}
+
+	// DefaultAPIConfig is the default query API server configuration.
+	DefaultAPIConfig = API{
+		Addr: ":8090",
+	}
+
+	// DefaultValidationConfig is the default schema validation
+	// configuration.
+	DefaultValidationConfig = Validation{
+		Collection:        "_schemas",
+		ReportCollection:  "_schema_violations",
+		QuarantineChannel: "quarantine",
+		ReloadInterval:    time.Duration(30) * time.Second,
+	}
+
+	// DefaultTimestampsConfig is the default timestamp configuration.
+	DefaultTimestampsConfig = Timestamps{
+		MaxSkew: time.Duration(5) * time.Minute,
 	}
 
 	// DefaultSimulatorConfig is the default Simulator configuration.
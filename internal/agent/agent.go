@@ -0,0 +1,318 @@
+// Package agent tails log files and produces their lines. It follows
+// the files matching glob patterns, notices their rotation (a new file
+// under the same path, or a truncated one) and checkpoints the read
+// positions so that a restarted agent neither loses nor repeats lines.
+//
+// The positions only move forward once the broker acknowledged the
+// lines, so a slow broker slows the reading down instead of having
+// lines pile up in memory. The lines are given ids derived from their
+// position, which makes the ones produced again after a crash (between
+// the acknowledgement and the checkpoint) identifiable as duplicates.
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/hyperbolicresearch/hlog/config"
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/internal/parser"
+	"github.com/hyperbolicresearch/hlog/internal/receiver"
+	"github.com/hyperbolicresearch/hlog/pkg/logger"
+)
+
+// Agent tails the files of its inputs.
+type Agent struct {
+	cfg         config.Agent
+	producer    receiver.Producer
+	parsers     *parser.Rules
+	host        string
+	checkpoints *Checkpoints
+	// followers are the files being tailed, by file id.
+	followers map[string]*follower
+	started   bool
+}
+
+// New creates an Agent producing the lines with p, parsed with the
+// parsers whose rules match the channel and path of the files.
+func New(p receiver.Producer, cfg config.Agent, parsers *parser.Rules) (*Agent, error) {
+	if len(cfg.Inputs) == 0 {
+		return nil, fmt.Errorf("agent: no input configured")
+	}
+	for _, in := range cfg.Inputs {
+		if !receiver.ValidChannel(in.Channel) {
+			return nil, fmt.Errorf("agent: invalid channel %q", in.Channel)
+		}
+		for _, pattern := range in.Paths {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("agent: invalid path %q: %v", pattern, err)
+			}
+		}
+	}
+	defaults := config.DefaultAgentConfig
+	if cfg.CheckpointFile == "" {
+		cfg.CheckpointFile = defaults.CheckpointFile
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaults.PollInterval
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaults.BatchSize
+	}
+	if cfg.MaxLineSize <= 0 {
+		cfg.MaxLineSize = defaults.MaxLineSize
+	}
+	if parsers == nil {
+		parsers, _ = parser.NewRules(nil)
+	}
+	checkpoints, err := LoadCheckpoints(cfg.CheckpointFile)
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	return &Agent{
+		cfg:         cfg,
+		producer:    p,
+		parsers:     parsers,
+		host:        host,
+		checkpoints: checkpoints,
+		followers:   map[string]*follower{},
+	}, nil
+}
+
+// ErrStopped is returned by Poll when stop is signaled while waiting
+// for the broker.
+var ErrStopped = errors.New("agent: stopped")
+
+// Run tails the files until stop is signaled.
+func (a *Agent) Run(stop <-chan os.Signal) error {
+	defer a.close()
+	ticker := time.NewTicker(a.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		if err := a.Poll(stop); err == ErrStopped {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll looks for new, rotated and truncated files, then reads and
+// produces what was appended to the files. It returns ErrStopped early
+// when stop is signaled while waiting for the broker, the signal being
+// consumed.
+func (a *Agent) Poll(stop <-chan os.Signal) error {
+	a.discover()
+	ids := make([]string, 0, len(a.followers))
+	for id := range a.followers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		f := a.followers[id]
+		if ok := a.drain(f, stop); !ok {
+			return ErrStopped
+		}
+		if f.gone {
+			f.close()
+			delete(a.followers, id)
+			delete(a.checkpoints.Positions, id)
+			if err := a.checkpoints.Save(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// discover matches the paths of the inputs against the files. The
+// files no longer matched are marked gone, and the new ones followed
+// from their checkpoint, from their beginning or, at the first start,
+// from their end.
+func (a *Agent) discover() {
+	seen := map[string]bool{}
+	for _, in := range a.cfg.Inputs {
+		for _, pattern := range in.Paths {
+			matches, _ := filepath.Glob(pattern)
+			for _, path := range matches {
+				fi, err := os.Stat(path)
+				if err != nil || !fi.Mode().IsRegular() {
+					continue
+				}
+				id := fileId(path, fi)
+				if seen[id] {
+					continue
+				}
+				seen[id] = true
+				if f, ok := a.followers[id]; ok {
+					// A file renamed by the rotation keeps being read.
+					f.path = path
+					if fi.Size() < f.offset {
+						log.Printf("agent: %s was truncated, reading it again", path)
+						if err := f.rewind(); err != nil {
+							log.Printf("agent: %v", err)
+						}
+					}
+					continue
+				}
+				a.follow(id, path, in.Channel, fi)
+			}
+		}
+	}
+	for id, f := range a.followers {
+		if !seen[id] {
+			f.gone = true
+		}
+	}
+	a.started = true
+}
+
+func (a *Agent) follow(id, path, channel string, fi os.FileInfo) {
+	var offset, number int64
+	pos, ok := a.checkpoints.Positions[id]
+	switch {
+	case ok && pos.Offset <= fi.Size():
+		offset, number = pos.Offset, pos.Line
+	case ok:
+		// The file is smaller than it was: it was truncated, or the id
+		// of a deleted file was reused.
+	case !a.started && !a.cfg.FromBeginning:
+		offset = fi.Size()
+		n, err := countLines(path, offset)
+		if err != nil {
+			log.Printf("agent: %v", err)
+		}
+		number = n
+	}
+	f, err := openFollower(id, path, channel, a.parsers.Select(channel, path), offset, number)
+	if err != nil {
+		log.Printf("agent: %v", err)
+		return
+	}
+	a.followers[id] = f
+}
+
+// drain produces the events appended to a file, in batches. It returns
+// false if stop was signaled before the events could be produced. The
+// checkpoint is the end of the last event produced, so the lines of an
+// event still pending are read again after a restart.
+func (a *Agent) drain(f *follower, stop <-chan os.Signal) bool {
+	for {
+		lines, err := f.read(a.cfg.BatchSize, a.cfg.MaxLineSize)
+		if err != nil {
+			log.Printf("agent: reading %s: %v", f.path, err)
+		}
+		events := f.group(lines, a.cfg.PollInterval)
+		if len(events) == 0 {
+			if len(lines) == 0 {
+				return true
+			}
+			continue
+		}
+		if !a.produce(f, events, stop) {
+			return false
+		}
+		lastEvent := events[len(events)-1]
+		last := lastEvent[len(lastEvent)-1]
+		a.checkpoints.Positions[f.id] = Position{Path: f.path, Offset: last.end, Line: last.number}
+		if err := a.checkpoints.Save(); err != nil {
+			log.Printf("agent: saving checkpoints: %v", err)
+		}
+	}
+}
+
+// produce sends the events until the broker acknowledged them all,
+// waiting longer and longer between the attempts.
+func (a *Agent) produce(f *follower, events [][]line, stop <-chan os.Signal) bool {
+	logs := make([]*core.Log, 0, len(events))
+	for _, event := range events {
+		if len(event) == 1 && event[0].text == "" {
+			continue
+		}
+		logs = append(logs, a.envelope(f, event))
+	}
+	backoff := time.Duration(500) * time.Millisecond
+	for len(logs) > 0 {
+		res := receiver.Submit(a.producer, logs)
+		if res.Unavailable == 0 {
+			if res.Rejected > 0 {
+				log.Printf("agent: %d lines of %s rejected: %v", res.Rejected, f.path, res.FirstError())
+			}
+			return true
+		}
+		var retry []*core.Log
+		for i, err := range res.Errors {
+			// Only the logs refused by the producer are sent again,
+			// the invalid ones would be refused forever.
+			if err != nil && receiver.Prepare(logs[i]) == nil {
+				retry = append(retry, logs[i])
+			}
+		}
+		logs = retry
+		log.Printf("agent: %d lines of %s not produced, retrying in %v: %v",
+			len(logs), f.path, backoff, res.FirstError())
+		select {
+		case <-stop:
+			return false
+		case <-time.After(backoff):
+		}
+		if backoff < time.Duration(30)*time.Second {
+			backoff *= 2
+		}
+	}
+	return true
+}
+
+// envelope turns the lines of an event into a log, parsed with the rule
+// of the file. Its id is derived from the host, the file and the
+// position of the event.
+func (a *Agent) envelope(f *follower, event []line) *core.Log {
+	first := event[0]
+	texts := make([]string, len(event))
+	truncated := false
+	for i, l := range event {
+		texts[i] = l.text
+		truncated = truncated || l.truncated
+	}
+	id := fmt.Sprintf("%s:%s:%d", a.host, f.id, first.offset)
+	l := &core.Log{
+		Channel:  f.channel,
+		LogId:    uuid.NewSHA1(uuid.NameSpaceURL, []byte(id)).String(),
+		SenderId: a.host,
+		Level:    logger.INFO.String(),
+		Data:     map[string]interface{}{},
+	}
+	f.rule.Apply(l, strings.Join(texts, "\n"))
+	// The position of the event wins over the fields of the same name.
+	l.Data["file"] = f.path
+	l.Data["host"] = a.host
+	l.Data["line"] = first.number
+	l.Data["offset"] = first.offset
+	if len(event) > 1 {
+		l.Data["lines"] = len(event)
+	}
+	if truncated {
+		l.Data["truncated"] = true
+	}
+	return l
+}
+
+func (a *Agent) close() {
+	for _, f := range a.followers {
+		f.close()
+	}
+}
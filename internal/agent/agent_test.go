@@ -0,0 +1,201 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/hyperbolicresearch/hlog/config"
+	"github.com/hyperbolicresearch/hlog/internal/parser"
+	"github.com/hyperbolicresearch/hlog/internal/receiver/receivertest"
+)
+
+func appendFile(t *testing.T, path, s string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newAgent(t *testing.T, dir string, p *receivertest.Producer, fromBeginning bool) *Agent {
+	t.Helper()
+	a, err := New(p, config.Agent{
+		Inputs:         []config.AgentInput{{Paths: []string{filepath.Join(dir, "*.log")}, Channel: "app"}},
+		CheckpointFile: filepath.Join(dir, "checkpoints.json"),
+		FromBeginning:  fromBeginning,
+		MaxLineSize:    10,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func expectMessages(t *testing.T, p *receivertest.Producer, expect ...string) {
+	t.Helper()
+	if got := p.Messages(); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Expected=%q, Got=%q", expect, got)
+	}
+}
+
+func TestAgent(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	appendFile(t, path, "old\n")
+
+	p := &receivertest.Producer{}
+	a := newAgent(t, dir, p, false)
+	stop := make(chan os.Signal)
+
+	// The existing content is skipped at the first start, and partial
+	// lines wait for their end.
+	a.Poll(stop)
+	expectMessages(t, p)
+	appendFile(t, path, "one\ntwo\nthr")
+	a.Poll(stop)
+	expectMessages(t, p, "one", "two")
+	appendFile(t, path, "ee\nthis line is too long\n")
+	a.Poll(stop)
+	produced := p.Logs()
+	expectMessages(t, p, "three", "this line ")
+	if l := produced[0]; l.Channel != "app" || l.Data["line"] != int64(4) || l.Data["file"] != path {
+		t.Errorf("Unexpected log: %+v", l)
+	}
+	if produced[1].Data["truncated"] != true {
+		t.Errorf("Expected the long line to be truncated")
+	}
+
+	// Rotation: the rest of the old file is read before the new one.
+	appendFile(t, path, "old tail\n")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, "new\n")
+	a.Poll(stop)
+	// The files are read by id, so the new one may come first.
+	got := p.Messages()
+	sort.Strings(got)
+	if expect := []string{"new", "old tail"}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("Expected=%q, Got=%q", expect, got)
+	}
+
+	// Truncation: the file is read again from its beginning.
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	a.Poll(stop)
+	appendFile(t, path, "fresh\n")
+	a.Poll(stop)
+	expectMessages(t, p, "fresh")
+
+	// A restarted agent resumes from the checkpoints.
+	a.close()
+	appendFile(t, path, "while down\n")
+	b := newAgent(t, dir, p, false)
+	b.Poll(stop)
+	expectMessages(t, p, "while down")
+	b.close()
+}
+
+func TestAgentRetries(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	appendFile(t, path, "a\nb\n")
+
+	p := &receivertest.Producer{Failures: 1}
+	a := newAgent(t, dir, p, true)
+	defer a.close()
+	a.Poll(make(chan os.Signal))
+	expectMessages(t, p, "a", "b")
+
+	// The lines produced are not produced again, their ids being stable.
+	first := a.envelope(a.followers[firstId(a)], []line{{text: "a", offset: 0}})
+	second := a.envelope(a.followers[firstId(a)], []line{{text: "a", offset: 0}})
+	if first.LogId != second.LogId {
+		t.Errorf("Expected stable log ids")
+	}
+}
+
+func TestAgentStopWhileRetrying(t *testing.T) {
+	dir := t.TempDir()
+	appendFile(t, filepath.Join(dir, "app.log"), "a\n")
+
+	p := &receivertest.Producer{Failures: 1000}
+	a := newAgent(t, dir, p, true)
+	stop := make(chan os.Signal)
+	done := make(chan error)
+	go func() { done <- a.Run(stop) }()
+	stop <- os.Interrupt
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected=<nil>, Got=%v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the agent to stop while the broker is down")
+	}
+	expectMessages(t, p)
+}
+
+func firstId(a *Agent) string {
+	for id := range a.followers {
+		return id
+	}
+	return ""
+}
+
+func TestAgentMultiline(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	appendFile(t, path, `{"level":"error","msg":"failed"}`+"\npanic: boom\n\ngoroutine 1 [running]:\n")
+
+	rules, err := parser.NewRules(&config.Parsing{Rules: []config.ParserRule{{Multiline: "stacktrace"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &receivertest.Producer{}
+	a, err := New(p, config.Agent{
+		Inputs:         []config.AgentInput{{Paths: []string{path}, Channel: "app"}},
+		CheckpointFile: filepath.Join(dir, "checkpoints.json"),
+		FromBeginning:  true,
+		PollInterval:   time.Hour,
+	}, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan os.Signal)
+
+	// The trace may go on, it waits for the next event.
+	a.Poll(stop)
+	produced := p.Logs()
+	expectMessages(t, p, "failed")
+	if l := produced[0]; l.Level != "error" || l.Data["file"] != path {
+		t.Errorf("Unexpected log: %+v", l)
+	}
+	appendFile(t, path, "main.main()\n\t/app/main.go:5 +0x1d\nnext\n")
+	a.Poll(stop)
+	produced = p.Logs()
+	expectMessages(t, p, "panic: boom\n\ngoroutine 1 [running]:\nmain.main()\n\t/app/main.go:5 +0x1d")
+	if l := produced[0]; l.Data["line"] != int64(2) || l.Data["lines"] != 5 {
+		t.Errorf("Unexpected log: %+v", l)
+	}
+
+	// The pending event is read again after a restart.
+	a.close()
+	b, err := New(p, a.cfg, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.close()
+	b.cfg.PollInterval = 0
+	b.Poll(stop)
+	expectMessages(t, p, "next")
+}
@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Position is the read position of a file: the offset and the number
+// of the line following the last line acknowledged by the broker.
+type Position struct {
+	Path   string `json:"path"`
+	Offset int64  `json:"offset"`
+	Line   int64  `json:"line"`
+}
+
+// Checkpoints are the positions of the tailed files, by file id. They
+// are keyed by file rather than path so that a file renamed by a
+// rotation is resumed under its new name.
+type Checkpoints struct {
+	path      string
+	Positions map[string]Position
+}
+
+// LoadCheckpoints reads the checkpoints saved at path. A missing file is
+// not an error, there being no checkpoint yet.
+func LoadCheckpoints(path string) (*Checkpoints, error) {
+	c := &Checkpoints{path: path, Positions: map[string]Position{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.Positions); err != nil {
+		return nil, fmt.Errorf("invalid checkpoints %s: %v", path, err)
+	}
+	return c, nil
+}
+
+// Save writes the checkpoints atomically, through a temporary file
+// renamed over the previous one.
+func (c *Checkpoints) Save() error {
+	data, err := json.MarshalIndent(c.Positions, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package agent
+
+import "os"
+
+// fileId identifies a file by its path where inodes are not available,
+// so renamed files are considered new ones.
+func fileId(path string, fi os.FileInfo) string {
+	return path
+}
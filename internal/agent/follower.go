@@ -0,0 +1,203 @@
+package agent
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"time"
+
+	"github.com/hyperbolicresearch/hlog/internal/parser"
+)
+
+// line is a line read from a file, along with its position.
+type line struct {
+	text   string
+	number int64
+	// offset is where the line starts and end where the next one does.
+	offset int64
+	end    int64
+	// truncated tells that the line exceeded the maximum size.
+	truncated bool
+}
+
+// follower reads the lines appended to a file.
+type follower struct {
+	id      string
+	path    string
+	channel string
+	file    *os.File
+	rule    *parser.Rule
+
+	// offset is the position after the last byte read, start the one
+	// of the line being read and number the number of the last line.
+	// partial holds the beginning of a line not terminated yet.
+	offset  int64
+	start   int64
+	number  int64
+	partial []byte
+	// truncated tells that the partial line exceeded the maximum size
+	// and that the rest of it is skipped.
+	truncated bool
+	// gone tells that the path no longer leads to the file, which is
+	// read to its end and closed.
+	gone bool
+
+	// multiline groups the lines of the events when the rule asks to,
+	// pending being the lines of the last event, which may get more,
+	// and pendingAt when its last line was read.
+	multiline *parser.Multiline
+	pending   []line
+	pendingAt time.Time
+}
+
+// openFollower opens the file at path and positions it at offset, the
+// line number being the one of the line starting there.
+func openFollower(id, path, channel string, rule *parser.Rule, offset, number int64) (*follower, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &follower{
+		id:        id,
+		path:      path,
+		channel:   channel,
+		file:      file,
+		rule:      rule,
+		offset:    offset,
+		start:     offset,
+		number:    number,
+		multiline: rule.Multiline(),
+	}, nil
+}
+
+// countLines returns the number of lines before offset, to number the
+// lines of a file not read from its beginning.
+func countLines(path string, offset int64) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	var n int64
+	buf := make([]byte, 32<<10)
+	r := io.LimitReader(file, offset)
+	for {
+		m, err := r.Read(buf)
+		n += int64(bytes.Count(buf[:m], []byte("\n")))
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// rewind restarts reading from the beginning of a truncated file.
+func (f *follower) rewind() error {
+	if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	f.offset, f.start, f.number = 0, 0, 0
+	f.partial, f.truncated = nil, false
+	f.pending = nil
+	if f.multiline != nil {
+		f.multiline = f.rule.Multiline()
+	}
+	return nil
+}
+
+// read reads the complete lines appended since the last read, about max
+// of them at most. The lines longer than maxSize are truncated. When
+// the file is gone, the last line is returned even if not terminated.
+func (f *follower) read(max, maxSize int) ([]line, error) {
+	var lines []line
+	buf := make([]byte, 32<<10)
+	for len(lines) < max {
+		n, err := f.file.Read(buf)
+		data := buf[:n]
+		for len(data) > 0 {
+			i := bytes.IndexByte(data, '\n')
+			chunk := data
+			if i >= 0 {
+				chunk = data[:i]
+			}
+			if room := maxSize - len(f.partial); len(chunk) > room {
+				chunk = chunk[:room]
+				f.truncated = true
+			}
+			f.partial = append(f.partial, chunk...)
+			if i < 0 {
+				f.offset += int64(len(data))
+				break
+			}
+			f.offset += int64(i + 1)
+			lines = append(lines, f.complete())
+			data = data[i+1:]
+		}
+		if err == io.EOF || n == 0 {
+			break
+		}
+		if err != nil {
+			return lines, err
+		}
+	}
+	if f.gone && len(f.partial) > 0 && len(lines) < max {
+		lines = append(lines, f.complete())
+	}
+	return lines, nil
+}
+
+// complete turns the partial line into a line ending at the current
+// offset.
+func (f *follower) complete() line {
+	text := bytes.TrimSuffix(f.partial, []byte("\r"))
+	l := line{
+		text:      string(text),
+		number:    f.number + 1,
+		offset:    f.start,
+		end:       f.offset,
+		truncated: f.truncated,
+	}
+	f.number++
+	f.start = f.offset
+	f.partial = f.partial[:0]
+	f.truncated = false
+	return l
+}
+
+// group gathers the lines into events. Without multiline rule, every
+// line is an event. Otherwise the lines continuing an event are added to
+// it, and the last event is held until a line starts another one, the
+// file is gone, or nothing was appended for wait.
+func (f *follower) group(lines []line, wait time.Duration) [][]line {
+	if f.multiline == nil {
+		events := make([][]line, 0, len(lines))
+		for _, l := range lines {
+			events = append(events, []line{l})
+		}
+		return events
+	}
+	var events [][]line
+	for _, l := range lines {
+		if !f.multiline.Continues(l.text) && len(f.pending) > 0 {
+			events = append(events, f.pending)
+			f.pending = nil
+		}
+		f.pending = append(f.pending, l)
+		f.pendingAt = time.Now()
+	}
+	if len(f.pending) > 0 && len(lines) == 0 && (f.gone || time.Since(f.pendingAt) >= wait) {
+		events = append(events, f.pending)
+		f.pending = nil
+	}
+	return events
+}
+
+func (f *follower) close() error {
+	return f.file.Close()
+}
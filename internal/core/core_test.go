@@ -1 +1,30 @@
-package core
\ No newline at end of file
+package core
+
+import "testing"
+
+func TestToNanoseconds(t *testing.T) {
+	tests := []struct {
+		name   string
+		ts     int64
+		unit   string
+		expect int64
+		ok     bool
+	}{
+		{"seconds", 1700000000, "", 1700000000e9, true},
+		{"milliseconds", 1700000000123, "", 1700000000123e6, true},
+		{"microseconds", 1700000000123456, "", 1700000000123456e3, true},
+		{"nanoseconds", 1700000000123456789, "", 1700000000123456789, true},
+		{"declared", 1700000000, Milliseconds, 1700000000e6, true},
+		{"before 1970", -86400, "", -86400e9, true},
+		{"out of range", 1700000000123, Seconds, 0, false},
+		{"unknown unit", 1700000000, "min", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ToNanoseconds(tt.ts, tt.unit)
+			if got != tt.expect || ok != tt.ok {
+				t.Errorf("Expected=%v %v, Got=%v %v", tt.expect, tt.ok, got, ok)
+			}
+		})
+	}
+}
@@ -1,11 +1,20 @@
 package core
 
 type Log struct {
-	Channel   string                 `json:"channel" bason:"channel"`
-	LogId     string                 `json:"log_id" bson:"log_id"`
-	SenderId  string                 `json:"sender_id" bson:"sender_id"`
+	Channel  string `json:"channel" bason:"channel"`
+	LogId    string `json:"log_id" bson:"log_id"`
+	SenderId string `json:"sender_id" bson:"sender_id"`
+	// Timestamp is when the log was emitted, as a Unix timestamp in any
+	// unit when produced and in nanoseconds once ingested.
 	Timestamp int64                  `json:"timestamp" bson:"timestamp"`
 	Level     string                 `json:"level" bson:"level"`
 	Message   string                 `json:"message" bson:"message"`
 	Data      map[string]interface{} `json:"data" bson:"data"`
+	// IngestedAt is when the log was ingested, and KafkaTimestamp the
+	// timestamp of the message it was read from, in Unix nanoseconds.
+	// ClockSkew flags the logs whose timestamp is too far from the time
+	// they were produced. These are set by the ingesters.
+	IngestedAt     int64 `json:"ingested_at,omitempty" bson:"ingested_at,omitempty"`
+	KafkaTimestamp int64 `json:"kafka_timestamp,omitempty" bson:"kafka_timestamp,omitempty"`
+	ClockSkew      bool  `json:"clock_skew,omitempty" bson:"clock_skew,omitempty"`
 }
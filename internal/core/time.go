@@ -0,0 +1,69 @@
+package core
+
+import (
+	"math"
+	"time"
+)
+
+// The units of the timestamps. The producers send the timestamps of the
+// logs in any of them, the ingesters storing them in nanoseconds.
+const (
+	Seconds      = "s"
+	Milliseconds = "ms"
+	Microseconds = "us"
+	Nanoseconds  = "ns"
+)
+
+// DetectUnit guesses the unit of a Unix timestamp from its magnitude,
+// which tells the units apart for the times between 1973 and 5138.
+func DetectUnit(ts int64) string {
+	if ts < 0 {
+		ts = -ts
+	}
+	switch {
+	case ts < 1e11:
+		return Seconds
+	case ts < 1e14:
+		return Milliseconds
+	case ts < 1e17:
+		return Microseconds
+	default:
+		return Nanoseconds
+	}
+}
+
+// ToNanoseconds converts a timestamp in unit, detected when empty, to
+// nanoseconds. It reports false when the unit is unknown or when the
+// time cannot be represented in nanoseconds, that is outside of the
+// years 1678 to 2262.
+func ToNanoseconds(ts int64, unit string) (int64, bool) {
+	if unit == "" {
+		unit = DetectUnit(ts)
+	}
+	var scale int64
+	switch unit {
+	case Seconds:
+		scale = 1e9
+	case Milliseconds:
+		scale = 1e6
+	case Microseconds:
+		scale = 1e3
+	case Nanoseconds:
+		return ts, true
+	default:
+		return 0, false
+	}
+	if ts > math.MaxInt64/scale || ts < math.MinInt64/scale {
+		return 0, false
+	}
+	return ts * scale, true
+}
+
+// Time returns the time of a timestamp whose unit is detected.
+func Time(ts int64) time.Time {
+	ns, ok := ToNanoseconds(ts, "")
+	if !ok {
+		return time.Unix(ts, 0)
+	}
+	return time.Unix(0, ns)
+}
@@ -0,0 +1,94 @@
+package elastic
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrMissingIndex is the error of the actions without index, when the
+// request has no default one.
+var ErrMissingIndex = errors.New("index is missing")
+
+// Action is an action of a bulk request, along with its document.
+type Action struct {
+	// Op is index, create, update or delete.
+	Op    string
+	Index string
+	Id    string
+	Doc   map[string]interface{}
+	// Err is the error of an action that cannot be carried out, such as
+	// one whose document is not a JSON object.
+	Err error
+}
+
+type actionMeta struct {
+	Index string `json:"_index"`
+	Id    string `json:"_id"`
+}
+
+// ParseBulk parses the body of a bulk request, NDJSON alternating action
+// lines, such as {"index": {"_index": "nginx"}}, and documents. The
+// index of the actions without one is defaultIndex. The errors of the
+// documents are the ones of their action, an invalid action line being
+// the error of the whole request since the documents cannot be told
+// apart from the actions any more.
+func ParseBulk(data []byte, defaultIndex string) ([]Action, error) {
+	var actions []Action
+	lines := splitLines(data)
+	for i := 0; i < len(lines); i++ {
+		var header map[string]actionMeta
+		if err := json.Unmarshal(lines[i], &header); err != nil || len(header) != 1 {
+			return nil, fmt.Errorf("malformed action/metadata line [%d]", i+1)
+		}
+		var a Action
+		for op, meta := range header {
+			a = Action{Op: op, Index: meta.Index, Id: meta.Id}
+		}
+		if a.Index == "" {
+			a.Index = defaultIndex
+		}
+		switch a.Op {
+		case "index", "create", "update":
+			i++
+			if i >= len(lines) {
+				return nil, fmt.Errorf("action [%s] is missing its document", a.Op)
+			}
+			if a.Op == "update" {
+				a.Err = errors.New("update is not supported")
+				break
+			}
+			if err := json.Unmarshal(lines[i], &a.Doc); err != nil || a.Doc == nil {
+				a.Err = fmt.Errorf("failed to parse the document of line [%d]", i+1)
+			}
+		case "delete":
+			a.Err = errors.New("delete is not supported")
+		default:
+			return nil, fmt.Errorf("unknown action [%s] of line [%d]", a.Op, i+1)
+		}
+		if a.Err == nil && a.Index == "" {
+			a.Err = ErrMissingIndex
+		}
+		actions = append(actions, a)
+	}
+	return actions, nil
+}
+
+// splitLines returns the non-empty lines of data.
+func splitLines(data []byte) [][]byte {
+	var lines [][]byte
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i], data[i+1:]
+		} else {
+			data = nil
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,274 @@
+// Package elastic receives the documents written with the bulk API of
+// Elasticsearch, which lets Beats, Logstash and the libraries that can
+// only write to Elasticsearch send to hlog. The index of the documents
+// decides their channel, and their common ECS fields (@timestamp,
+// log.level, message and host.name) the envelope of the logs.
+//
+// Only what the shippers need is implemented: the bulk index and create
+// actions, and the cluster information they ask for when connecting.
+package elastic
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/hyperbolicresearch/hlog/config"
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/internal/parser"
+	"github.com/hyperbolicresearch/hlog/internal/receiver"
+)
+
+// Version is the Elasticsearch version announced to the clients, which
+// some of them check before writing.
+const Version = "8.11.0"
+
+// Receiver converts the bulk requests to logs and hands them over to a
+// producer.
+type Receiver struct {
+	producer receiver.Producer
+	cfg      config.Elastic
+}
+
+// New creates a Receiver producing the logs with p.
+func New(p receiver.Producer, cfg config.Elastic) (*Receiver, error) {
+	if !receiver.ValidChannel(cfg.DefaultChannel) {
+		return nil, fmt.Errorf("invalid default channel %q", cfg.DefaultChannel)
+	}
+	for _, rt := range cfg.Routes {
+		if _, err := path.Match(rt.Index, ""); err != nil {
+			return nil, fmt.Errorf("invalid route index %q: %v", rt.Index, err)
+		}
+		if !receiver.ValidChannel(rt.Channel) {
+			return nil, fmt.Errorf("invalid route channel %q", rt.Channel)
+		}
+	}
+	if cfg.MaxBodySize <= 0 {
+		cfg.MaxBodySize = config.DefaultElasticConfig.MaxBodySize
+	}
+	if cfg.MaxDecodedSize <= 0 {
+		cfg.MaxDecodedSize = config.DefaultElasticConfig.MaxDecodedSize
+	}
+	return &Receiver{producer: p, cfg: cfg}, nil
+}
+
+// Register adds the endpoints to mux. As the index is part of the path
+// of the bulk endpoint (/{index}/_bulk), the receiver handles every path
+// the other handlers of mux do not.
+func (rc *Receiver) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/", rc.ServeHTTP)
+}
+
+// ServeHTTP serves GET / and the bulk endpoints, /_bulk and
+// /{index}/_bulk.
+func (rc *Receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// The official clients refuse to talk to servers without it.
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	p := strings.Trim(r.URL.Path, "/")
+	switch {
+	case p == "" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"name":         "hlog",
+			"cluster_name": "hlog",
+			"version": map[string]interface{}{
+				"number":                              Version,
+				"build_flavor":                        "default",
+				"minimum_wire_compatibility_version":  "7.17.0",
+				"minimum_index_compatibility_version": "7.0.0",
+			},
+			"tagline": "You Know, for Search",
+		})
+	case p == "_bulk" || strings.HasSuffix(p, "/_bulk") && !strings.Contains(strings.TrimSuffix(p, "/_bulk"), "/"):
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			w.Header().Set("Allow", "POST, PUT")
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "use POST or PUT")
+			return
+		}
+		rc.serveBulk(w, r, strings.TrimSuffix(strings.TrimSuffix(p, "_bulk"), "/"))
+	default:
+		writeError(w, http.StatusNotFound, "no_handler_found_exception", fmt.Sprintf("no handler found for uri [%s] and method [%s]", r.URL.Path, r.Method))
+	}
+}
+
+type bulkResponse struct {
+	Took   int64                 `json:"took"`
+	Errors bool                  `json:"errors"`
+	Items  []map[string]bulkItem `json:"items"`
+}
+
+type bulkItem struct {
+	Index       string     `json:"_index"`
+	Id          string     `json:"_id"`
+	Version     int        `json:"_version,omitempty"`
+	Result      string     `json:"result,omitempty"`
+	Shards      *shards    `json:"_shards,omitempty"`
+	SeqNo       *int       `json:"_seq_no,omitempty"`
+	PrimaryTerm int        `json:"_primary_term,omitempty"`
+	Status      int        `json:"status"`
+	Error       *itemError `json:"error,omitempty"`
+}
+
+type shards struct {
+	Total      int `json:"total"`
+	Successful int `json:"successful"`
+	Failed     int `json:"failed"`
+}
+
+type itemError struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// serveBulk produces the documents of a bulk request. As Elasticsearch
+// does, it answers 200 with the result of every action: 201 for the
+// documents produced, 400 for the invalid ones and 429 for the ones the
+// broker did not take, which the shippers send again.
+func (rc *Receiver) serveBulk(w http.ResponseWriter, r *http.Request, index string) {
+	start := time.Now()
+	data, err := receiver.ReadBody(w, r, rc.cfg.MaxBodySize, rc.cfg.MaxDecodedSize)
+	if err != nil {
+		writeError(w, receiver.BodyErrorStatus(err), "parse_exception", err.Error())
+		return
+	}
+	actions, err := ParseBulk(data, index)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "illegal_argument_exception", err.Error())
+		return
+	}
+
+	host, _, _ := net.SplitHostPort(r.RemoteAddr)
+	items := make([]bulkItem, len(actions))
+	logs := make([]*core.Log, 0, len(actions))
+	indexes := make([]int, 0, len(actions))
+	for i, a := range actions {
+		items[i] = bulkItem{Index: a.Index, Id: a.Id}
+		if a.Err != nil {
+			items[i].fail(http.StatusBadRequest, "illegal_argument_exception", a.Err)
+			continue
+		}
+		l := rc.Convert(a, host)
+		if err := receiver.Prepare(l); err != nil {
+			items[i].fail(http.StatusBadRequest, "document_parsing_exception", err)
+			continue
+		}
+		items[i].Id = l.LogId
+		logs = append(logs, l)
+		indexes = append(indexes, i)
+	}
+	res := receiver.Submit(rc.producer, logs)
+	for j, err := range res.Errors {
+		item := &items[indexes[j]]
+		if err != nil {
+			item.fail(http.StatusTooManyRequests, "es_rejected_execution_exception", err)
+			continue
+		}
+		seqNo := 0
+		item.Version, item.Result, item.Status = 1, "created", http.StatusCreated
+		item.Shards, item.SeqNo, item.PrimaryTerm = &shards{Total: 1, Successful: 1}, &seqNo, 1
+	}
+
+	resp := bulkResponse{Took: time.Since(start).Milliseconds(), Items: make([]map[string]bulkItem, len(items))}
+	for i, item := range items {
+		resp.Items[i] = map[string]bulkItem{actions[i].Op: item}
+		resp.Errors = resp.Errors || item.Error != nil
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (item *bulkItem) fail(status int, typ string, err error) {
+	item.Status = status
+	item.Error = &itemError{Type: typ, Reason: err.Error()}
+}
+
+// Channel returns the channel of the documents of an index.
+func (rc *Receiver) Channel(index string) string {
+	for _, rt := range rc.cfg.Routes {
+		if ok, _ := path.Match(rt.Index, index); ok {
+			return rt.Channel
+		}
+	}
+	if receiver.ValidChannel(index) {
+		return index
+	}
+	return rc.cfg.DefaultChannel
+}
+
+// Convert turns the document of an action into a log. The ECS fields
+// @timestamp, log.level, message and host.name, either nested or
+// dotted, are moved to the envelope, host being the sender of the
+// documents without host.name. The id of the action is the one of the
+// log.
+func (rc *Receiver) Convert(a Action, host string) *core.Log {
+	doc := a.Doc
+	l := &core.Log{Channel: rc.Channel(a.Index), LogId: a.Id, SenderId: host, Data: doc}
+	if v, ok := take(doc, "@timestamp"); ok {
+		if ts, ok := parser.ParseTime(v, ""); ok {
+			l.Timestamp = ts.UnixNano()
+		} else {
+			doc["@timestamp"] = v
+		}
+	}
+	if v, ok := take(doc, "log.level"); ok {
+		if level, ok := parser.ParseLevel(v); ok {
+			l.Level = level.String()
+		} else {
+			doc["log.level"] = v
+		}
+	}
+	if v, ok := take(doc, "message"); ok {
+		if s, ok := v.(string); ok {
+			l.Message = s
+		} else {
+			doc["message"] = v
+		}
+	}
+	if v, ok := take(doc, "host.name"); ok {
+		if s, ok := v.(string); ok && s != "" {
+			l.SenderId = s
+		} else {
+			doc["host.name"] = v
+		}
+	}
+	return l
+}
+
+// take removes the field at a dotted path from doc and returns it. The
+// field is looked for under the dotted key first, then in the nested
+// objects, which are removed once empty.
+func take(doc map[string]interface{}, key string) (interface{}, bool) {
+	if v, ok := doc[key]; ok {
+		delete(doc, key)
+		return v, true
+	}
+	parent, child, nested := strings.Cut(key, ".")
+	if !nested {
+		return nil, false
+	}
+	obj, ok := doc[parent].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	v, ok := take(obj, child)
+	if ok && len(obj) == 0 {
+		delete(doc, parent)
+	}
+	return v, ok
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error in the format of Elasticsearch.
+func writeError(w http.ResponseWriter, status int, typ, reason string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error":  map[string]interface{}{"type": typ, "reason": reason},
+		"status": status,
+	})
+}
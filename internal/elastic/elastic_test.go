@@ -0,0 +1,196 @@
+package elastic
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hyperbolicresearch/hlog/config"
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/internal/receiver/receivertest"
+)
+
+func TestParseBulk(t *testing.T) {
+	body := `{"index": {"_index": "nginx", "_id": "1"}}
+{"message": "a"}
+
+{"create": {}}
+{"message": "b"}
+{"delete": {"_index": "nginx", "_id": "1"}}
+{"update": {"_id": "1"}}
+{"doc": {}}
+{"index": {}}
+not json
+`
+	actions, err := ParseBulk([]byte(body), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, a := range actions {
+		got = append(got, a.Op+" "+a.Index+" "+a.Id+" "+func() string {
+			if a.Err != nil {
+				return "error"
+			}
+			return a.Doc["message"].(string)
+		}())
+	}
+	expect := []string{"index nginx 1 a", "create   error", "delete nginx 1 error", "update  1 error", "index   error"}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("Expected=%q, Got=%q", expect, got)
+	}
+	if !errors.Is(actions[1].Err, ErrMissingIndex) {
+		t.Errorf("Expected=%v, Got=%v", ErrMissingIndex, actions[1].Err)
+	}
+
+	for _, body := range []string{
+		"not json\n",
+		`{"index": {}, "create": {}}` + "\n{}\n",
+		`{"upsert": {}}` + "\n{}\n",
+		`{"index": {}}` + "\n",
+	} {
+		if _, err := ParseBulk([]byte(body), "x"); err == nil {
+			t.Errorf("Expected an error for %q", body)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	rc, err := New(nil, config.Elastic{
+		Routes:         []config.ElasticRoute{{Index: "filebeat-*", Channel: "beats"}},
+		DefaultChannel: "elastic",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		index  string
+		doc    string
+		expect core.Log
+	}{
+		{
+			name:  "nested",
+			index: "filebeat-8.11.0-2024.01.01",
+			doc:   `{"@timestamp": "2024-01-01T00:00:00.000Z", "log": {"level": "warning", "file": {"path": "/x"}}, "message": "m", "host": {"name": "web-1", "ip": "10.0.0.1"}}`,
+			expect: core.Log{
+				Channel: "beats", SenderId: "web-1", Timestamp: 1704067200e9, Level: "warn", Message: "m",
+				Data: map[string]interface{}{
+					"log":  map[string]interface{}{"file": map[string]interface{}{"path": "/x"}},
+					"host": map[string]interface{}{"ip": "10.0.0.1"},
+				},
+			},
+		},
+		{
+			name:  "dotted",
+			index: "logs-app-default",
+			doc:   `{"@timestamp": 1704067200000, "log.level": "ERROR", "message": "m", "host.name": "web-2", "user": {"id": 7}}`,
+			expect: core.Log{
+				Channel: "logs-app-default", SenderId: "web-2", Timestamp: 1704067200e9, Level: "error", Message: "m",
+				Data: map[string]interface{}{"user": map[string]interface{}{"id": 7.0}},
+			},
+		},
+		{
+			name:  "unreadable fields",
+			index: "App+Logs",
+			doc:   `{"@timestamp": "yesterday", "log.level": "chatty", "message": {"text": "m"}}`,
+			expect: core.Log{
+				Channel: "elastic", SenderId: "192.0.2.1",
+				Data: map[string]interface{}{
+					"@timestamp": "yesterday", "log.level": "chatty",
+					"message": map[string]interface{}{"text": "m"},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var doc map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.doc), &doc); err != nil {
+				t.Fatal(err)
+			}
+			got := rc.Convert(Action{Op: "index", Index: tt.index, Doc: doc}, "192.0.2.1")
+			if !reflect.DeepEqual(*got, tt.expect) {
+				t.Errorf("Expected=%+v, Got=%+v", tt.expect, *got)
+			}
+		})
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	body := `{"index": {"_id": "a1"}}
+{"message": "first", "log.level": "info"}
+{"create": {"_index": "other"}}
+{"message": "second"}
+{"index": {}}
+{}
+{"delete": {"_id": "a1"}}
+`
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		err      error
+		code     int
+		statuses []int
+	}{
+		{"bulk", http.MethodPost, "/app/_bulk", body, nil, http.StatusOK, []int{201, 201, 400, 400}},
+		{"broker unavailable", http.MethodPost, "/app/_bulk", body, errors.New("broker unavailable"), http.StatusOK, []int{429, 429, 400, 400}},
+		{"malformed", http.MethodPost, "/_bulk", "{\n", nil, http.StatusBadRequest, nil},
+		{"info", http.MethodGet, "/", "", nil, http.StatusOK, nil},
+		{"unknown path", http.MethodGet, "/app/_search", "", nil, http.StatusNotFound, nil},
+		{"wrong method", http.MethodGet, "/_bulk", "", nil, http.StatusMethodNotAllowed, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &receivertest.Producer{Err: tt.err}
+			rc, err := New(p, config.DefaultElasticConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mux := http.NewServeMux()
+			rc.Register(mux)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			if rec.Code != tt.code {
+				t.Fatalf("Expected=%v, Got=%v (%s)", tt.code, rec.Code, rec.Body)
+			}
+			if rec.Header().Get("X-Elastic-Product") != "Elasticsearch" {
+				t.Errorf("Expected the X-Elastic-Product header")
+			}
+			if tt.statuses == nil {
+				return
+			}
+			var resp bulkResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			var statuses []int
+			for _, item := range resp.Items {
+				for _, result := range item {
+					statuses = append(statuses, result.Status)
+				}
+			}
+			if !reflect.DeepEqual(statuses, tt.statuses) || !resp.Errors {
+				t.Errorf("Expected=%v, Got=%v", tt.statuses, statuses)
+			}
+			if tt.err != nil {
+				return
+			}
+			if len(p.Logs()) != 2 {
+				t.Fatalf("Expected 2 logs, Got=%d", len(p.Logs()))
+			}
+			if l := p.Logs()[0]; l.Channel != "app" || l.LogId != "a1" || l.Message != "first" {
+				t.Errorf("Unexpected log: %+v", l)
+			}
+			if l := p.Logs()[1]; l.Channel != "other" || resp.Items[1]["create"].Id != l.LogId {
+				t.Errorf("Unexpected log: %+v", l)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package filter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/pkg/logger"
+)
+
+// Filter describes which logs an observer is interested in. An empty
+// Filter matches every log.
+type Filter struct {
+	// Level is the minimum level of the logs to keep.
+	Level string `json:"level,omitempty"`
+	// Channels and Senders restrict the logs to the given channels and
+	// sender ids. An empty list means no restriction.
+	Channels []string `json:"channels,omitempty"`
+	Senders  []string `json:"senders,omitempty"`
+	// Fields restricts the logs to the ones whose Data holds the given
+	// values. Keys can use dots to reach nested objects (user.id).
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// Parse reads a filter written as space separated terms, for example:
+//
+//	level:warn channel:payments,auth sender:client-0001 field:user.id=42
+func Parse(expr string) (Filter, error) {
+	f := Filter{}
+	for _, term := range strings.Fields(expr) {
+		key, value, ok := strings.Cut(term, ":")
+		if !ok || value == "" {
+			return Filter{}, fmt.Errorf("invalid filter term %q", term)
+		}
+		switch strings.ToLower(key) {
+		case "level":
+			if _, ok := logger.ParseLevel(value); !ok {
+				return Filter{}, fmt.Errorf("unknown level %q", value)
+			}
+			f.Level = value
+		case "channel":
+			f.Channels = append(f.Channels, strings.Split(value, ",")...)
+		case "sender":
+			f.Senders = append(f.Senders, strings.Split(value, ",")...)
+		case "field":
+			k, v, ok := strings.Cut(value, "=")
+			if !ok || k == "" {
+				return Filter{}, fmt.Errorf("invalid field term %q, expected field:key=value", term)
+			}
+			if f.Fields == nil {
+				f.Fields = map[string]string{}
+			}
+			f.Fields[k] = v
+		default:
+			return Filter{}, fmt.Errorf("unknown filter key %q", key)
+		}
+	}
+	return f, nil
+}
+
+// Validate checks that the filter only references known levels.
+func (f Filter) Validate() error {
+	if f.Level == "" {
+		return nil
+	}
+	if _, ok := logger.ParseLevel(f.Level); !ok {
+		return fmt.Errorf("unknown level %q", f.Level)
+	}
+	return nil
+}
+
+// String returns the filter in the syntax accepted by Parse.
+func (f Filter) String() string {
+	var terms []string
+	if f.Level != "" {
+		terms = append(terms, "level:"+f.Level)
+	}
+	if len(f.Channels) > 0 {
+		terms = append(terms, "channel:"+strings.Join(f.Channels, ","))
+	}
+	if len(f.Senders) > 0 {
+		terms = append(terms, "sender:"+strings.Join(f.Senders, ","))
+	}
+	keys := make([]string, 0, len(f.Fields))
+	for k := range f.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		terms = append(terms, fmt.Sprintf("field:%s=%s", k, f.Fields[k]))
+	}
+	return strings.Join(terms, " ")
+}
+
+// Match reports whether the log satisfies every condition of the filter.
+func (f Filter) Match(l *core.Log) bool {
+	if f.Level != "" {
+		min, _ := logger.ParseLevel(f.Level)
+		level, ok := logger.ParseLevel(l.Level)
+		if !ok || level < min {
+			return false
+		}
+	}
+	if len(f.Channels) > 0 && !contains(f.Channels, l.Channel) {
+		return false
+	}
+	if len(f.Senders) > 0 && !contains(f.Senders, l.SenderId) {
+		return false
+	}
+	for k, want := range f.Fields {
+		got, ok := Lookup(l.Data, k)
+		if !ok || fmt.Sprint(got) != want {
+			return false
+		}
+	}
+	return true
+}
+
+// Lookup returns the value at the dotted path inside data.
+func Lookup(data map[string]interface{}, path string) (interface{}, bool) {
+	if v, ok := data[path]; ok {
+		return v, true
+	}
+	head, rest, ok := strings.Cut(path, ".")
+	if !ok {
+		return nil, false
+	}
+	child, ok := data[head].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	return Lookup(child, rest)
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/hyperbolicresearch/hlog/internal/core"
+)
+
+func TestParse(t *testing.T) {
+	f, err := Parse("level:warn channel:a,b sender:s1 field:user.id=42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "level:warn channel:a,b sender:s1 field:user.id=42"
+	if f.String() != expected {
+		t.Errorf("Expected=%v, Got=%v", expected, f.String())
+	}
+	for _, expr := range []string{"level:loud", "color:red", "field:x", "channel"} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Expected an error for %q", expr)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	l := core.Log{
+		Channel:  "payments",
+		SenderId: "client-0001",
+		Level:    "ERROR",
+		Data: map[string]interface{}{
+			"status": 500,
+			"user":   map[string]interface{}{"id": "42"},
+		},
+	}
+	tests := []struct {
+		name   string
+		filter string
+		expect bool
+	}{
+		{"Empty filter", "", true},
+		{"Level below", "level:warn", true},
+		{"Level above", "level:fatal", false},
+		{"Channel match", "channel:auth,payments", true},
+		{"Channel mismatch", "channel:auth", false},
+		{"Sender mismatch", "sender:client-0002", false},
+		{"Field match", "field:status=500", true},
+		{"Nested field match", "field:user.id=42", true},
+		{"Field mismatch", "field:user.id=43", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := Parse(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.Match(&l); got != tt.expect {
+				t.Errorf("Expected=%v, Got=%v", tt.expect, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package forward
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/vmihailenco/msgpack/v5/msgpcode"
+)
+
+// ErrTooLarge is returned for the messages exceeding the maximum size.
+var ErrTooLarge = errors.New("forward: message too large")
+
+// Entry is a record of a message, with its time.
+type Entry struct {
+	Time   time.Time
+	Record map[string]interface{}
+}
+
+// Option is the option map closing the messages.
+type Option struct {
+	// Size is the number of entries announced by the client.
+	Size int
+	// Chunk is the id the client expects to be acknowledged with.
+	Chunk string
+	// Compressed is "gzip" for the CompressedPackedForward mode.
+	Compressed string
+}
+
+// Message is a Forward message. Every mode, Message ([tag, time,
+// record]), Forward ([tag, [[time, record], ...]]), PackedForward and
+// CompressedPackedForward ([tag, entries as msgpack bytes]), is decoded
+// to the entries of the tag.
+type Message struct {
+	Tag     string
+	Entries []Entry
+	Option  Option
+}
+
+// Decoder reads the messages of a stream.
+type Decoder struct {
+	r       *countingReader
+	d       *msgpack.Decoder
+	maxSize int
+}
+
+// NewDecoder returns a Decoder of the messages of r, which may not
+// exceed maxSize bytes once decompressed. As the stream is read ahead,
+// the size of the uncompressed messages is only checked approximately.
+func NewDecoder(r io.Reader, maxSize int) *Decoder {
+	cr := &countingReader{r: r}
+	d := msgpack.NewDecoder(cr)
+	d.UseLooseInterfaceDecoding(true)
+	return &Decoder{r: cr, d: d, maxSize: maxSize}
+}
+
+// countingReader counts the bytes read since the last reset.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += n
+	return n, err
+}
+
+// Decode reads the next message. It returns io.EOF at the end of the
+// stream.
+func (dec *Decoder) Decode() (*Message, error) {
+	dec.r.n = 0
+	d := dec.d
+	n, err := d.DecodeArrayLen()
+	if err != nil {
+		return nil, err
+	}
+	if n < 2 || n > 4 {
+		return nil, fmt.Errorf("forward: invalid message of %d elements", n)
+	}
+	m := &Message{}
+	if m.Tag, err = d.DecodeString(); err != nil {
+		return nil, fmt.Errorf("forward: invalid tag: %v", err)
+	}
+	c, err := d.PeekCode()
+	if err != nil {
+		return nil, err
+	}
+	rest := n - 2
+	var packed []byte
+	switch {
+	case msgpcode.IsFixedArray(c) || c == msgpcode.Array16 || c == msgpcode.Array32:
+		count, err := d.DecodeArrayLen()
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < count; i++ {
+			e, err := decodeEntry(d)
+			if err != nil {
+				return nil, err
+			}
+			m.Entries = append(m.Entries, e)
+			if dec.r.n > dec.maxSize {
+				return nil, ErrTooLarge
+			}
+		}
+	case msgpcode.IsString(c) || msgpcode.IsBin(c):
+		size, err := d.DecodeBytesLen()
+		if err != nil {
+			return nil, err
+		}
+		if size > dec.maxSize {
+			return nil, ErrTooLarge
+		}
+		if size > 0 {
+			packed = make([]byte, size)
+			if err := d.ReadFull(packed); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		// Message mode: the time and the record are elements of the
+		// message itself.
+		if n < 3 {
+			return nil, fmt.Errorf("forward: invalid message of %d elements", n)
+		}
+		e, err := decodeTimeRecord(d)
+		if err != nil {
+			return nil, err
+		}
+		m.Entries = []Entry{e}
+		rest--
+	}
+	if rest > 1 {
+		return nil, fmt.Errorf("forward: invalid message of %d elements", n)
+	}
+	if rest == 1 {
+		if m.Option, err = decodeOption(d); err != nil {
+			return nil, err
+		}
+	}
+	if dec.r.n > dec.maxSize {
+		return nil, ErrTooLarge
+	}
+	if packed != nil {
+		if m.Entries, err = dec.unpack(packed, m.Option.Compressed); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// unpack decodes the entries of the PackedForward and
+// CompressedPackedForward modes.
+func (dec *Decoder) unpack(packed []byte, compressed string) ([]Entry, error) {
+	var r io.Reader = bytes.NewReader(packed)
+	switch compressed {
+	case "", "text":
+	case "gzip":
+		// The chunks may be made of several gzip members, which the
+		// reader reads in sequence.
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("forward: invalid gzip entries: %v", err)
+		}
+		defer zr.Close()
+		b, err := io.ReadAll(io.LimitReader(zr, int64(dec.maxSize)+1))
+		if err != nil {
+			return nil, fmt.Errorf("forward: invalid gzip entries: %v", err)
+		}
+		if len(b) > dec.maxSize {
+			return nil, ErrTooLarge
+		}
+		r = bytes.NewReader(b)
+	default:
+		return nil, fmt.Errorf("forward: unsupported compression %q", compressed)
+	}
+	d := msgpack.NewDecoder(r)
+	d.UseLooseInterfaceDecoding(true)
+	var entries []Entry
+	for {
+		e, err := decodeEntry(d)
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+}
+
+// decodeEntry decodes an entry, [time, record].
+func decodeEntry(d *msgpack.Decoder) (Entry, error) {
+	n, err := d.DecodeArrayLen()
+	if err != nil {
+		return Entry{}, err
+	}
+	if n != 2 {
+		return Entry{}, fmt.Errorf("forward: invalid entry of %d elements", n)
+	}
+	return decodeTimeRecord(d)
+}
+
+func decodeTimeRecord(d *msgpack.Decoder) (Entry, error) {
+	ts, err := decodeTime(d)
+	if err != nil {
+		return Entry{}, err
+	}
+	record, err := d.DecodeMap()
+	if err != nil {
+		return Entry{}, fmt.Errorf("forward: invalid record: %v", err)
+	}
+	if record == nil {
+		record = map[string]interface{}{}
+	}
+	return Entry{Time: ts, Record: record}, nil
+}
+
+// decodeTime decodes the time of an entry: an EventTime (the extension
+// 0 holding the seconds and nanoseconds), or a number of seconds.
+func decodeTime(d *msgpack.Decoder) (time.Time, error) {
+	c, err := d.PeekCode()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if msgpcode.IsExt(c) {
+		id, n, err := d.DecodeExtHeader()
+		if err != nil {
+			return time.Time{}, err
+		}
+		if id != 0 || n != 8 {
+			return time.Time{}, fmt.Errorf("forward: invalid time extension %d of %d bytes", id, n)
+		}
+		b := make([]byte, 8)
+		if err := d.ReadFull(b); err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(int64(binary.BigEndian.Uint32(b)), int64(binary.BigEndian.Uint32(b[4:]))), nil
+	}
+	v, err := d.DecodeInterfaceLoose()
+	if err != nil {
+		return time.Time{}, err
+	}
+	switch x := v.(type) {
+	case int64:
+		return time.Unix(x, 0), nil
+	case uint64:
+		return time.Unix(int64(x), 0), nil
+	case float64:
+		sec, frac := math.Modf(x)
+		return time.Unix(int64(sec), int64(frac*1e9)), nil
+	}
+	return time.Time{}, fmt.Errorf("forward: invalid time %v", v)
+}
+
+func decodeOption(d *msgpack.Decoder) (Option, error) {
+	var o Option
+	fields, err := d.DecodeMap()
+	if err != nil {
+		return o, fmt.Errorf("forward: invalid option: %v", err)
+	}
+	o.Chunk, _ = fields["chunk"].(string)
+	o.Compressed, _ = fields["compressed"].(string)
+	switch size := fields["size"].(type) {
+	case int64:
+		o.Size = int(size)
+	case uint64:
+		o.Size = int(size)
+	}
+	return o, nil
+}
@@ -0,0 +1,355 @@
+// Package forward receives the logs of Fluent Bit and Fluentd over the
+// Forward protocol. Every mode of the protocol is accepted over TCP:
+// Message, Forward, PackedForward and CompressedPackedForward. The tag
+// of the records decides their channel.
+//
+// The records of the messages carrying a chunk id are produced before
+// the chunk is acknowledged, so the client sends the chunks the broker
+// did not take again. Their ids are derived from the chunk id, which
+// makes the records received twice identifiable as duplicates.
+package forward
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/hyperbolicresearch/hlog/config"
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/internal/parser"
+	"github.com/hyperbolicresearch/hlog/internal/receiver"
+	"github.com/hyperbolicresearch/hlog/pkg/logger"
+)
+
+// Router chooses the channel of the records from their tag.
+type Router struct {
+	routes []route
+	def    string
+}
+
+type route struct {
+	re      *regexp.Regexp
+	channel string
+}
+
+// NewRouter compiles the routes. The tags matching none of them are
+// used as channels when they are valid channel names, def being used
+// otherwise.
+func NewRouter(routes []config.ForwardRoute, def string) (*Router, error) {
+	if !receiver.ValidChannel(def) {
+		return nil, fmt.Errorf("invalid default channel %q", def)
+	}
+	r := &Router{def: def}
+	for _, rt := range routes {
+		re, err := compileTag(rt.Tag)
+		if err != nil {
+			return nil, fmt.Errorf("invalid route tag %q: %v", rt.Tag, err)
+		}
+		if !receiver.ValidChannel(rt.Channel) {
+			return nil, fmt.Errorf("invalid route channel %q", rt.Channel)
+		}
+		r.routes = append(r.routes, route{re, rt.Channel})
+	}
+	return r, nil
+}
+
+// Channel returns the channel of the first route matching tag.
+func (r *Router) Channel(tag string) string {
+	for _, rt := range r.routes {
+		if rt.re.MatchString(tag) {
+			return rt.channel
+		}
+	}
+	if receiver.ValidChannel(tag) {
+		return tag
+	}
+	return r.def
+}
+
+// compileTag turns a Fluentd match pattern into a regular expression:
+// * matches a part of the tag, ** zero or more parts, {a,b} a or b.
+func compileTag(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	depth := 0
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case strings.HasPrefix(pattern[i:], ".**"):
+			b.WriteString(`(?:\..*)?`)
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**."):
+			b.WriteString(`(?:.*\.)?`)
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(`.*`)
+			i++
+		case c == '*':
+			b.WriteString(`[^.]+`)
+		case c == '{':
+			b.WriteString(`(?:`)
+			depth++
+		case c == '}' && depth > 0:
+			b.WriteString(`)`)
+			depth--
+		case c == ',' && depth > 0:
+			b.WriteString(`|`)
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	if depth > 0 {
+		return nil, errors.New("unbalanced braces")
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// Stats are the counters of a Server.
+type Stats struct {
+	// Received is the number of records received.
+	Received uint64
+	// Failed is the number of messages that could not be decoded, the
+	// connections sending them being closed.
+	Failed uint64
+	// Rejected is the number of logs that could not be produced.
+	Rejected uint64
+}
+
+// Server receives Forward messages and produces their records.
+type Server struct {
+	cfg      config.Forward
+	router   *Router
+	parsers  *parser.Rules
+	producer receiver.Producer
+	batcher  *receiver.Batcher
+
+	received atomic.Uint64
+	failed   atomic.Uint64
+	rejected atomic.Uint64
+
+	mu       sync.Mutex
+	closed   bool
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+}
+
+// NewServer creates a Server producing the logs with p. The records are
+// moved to the envelope of the logs with the parsers whose rules match
+// their channel and tag.
+func NewServer(p receiver.Producer, cfg config.Forward, parsers *parser.Rules) (*Server, error) {
+	router, err := NewRouter(cfg.Routes, cfg.DefaultChannel)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MaxMessageSize <= 0 {
+		cfg.MaxMessageSize = config.DefaultForwardConfig.MaxMessageSize
+	}
+	if cfg.Linger <= 0 {
+		cfg.Linger = config.DefaultForwardConfig.Linger
+	}
+	if parsers == nil {
+		parsers, _ = parser.NewRules(nil)
+	}
+	s := &Server{
+		cfg:      cfg,
+		router:   router,
+		parsers:  parsers,
+		producer: p,
+		conns:    map[net.Conn]struct{}{},
+	}
+	s.batcher = receiver.NewBatcher(p, cfg.BatchSize, cfg.Linger, func(_ []*core.Log, res receiver.Result) {
+		if res.Rejected > 0 {
+			s.rejected.Add(uint64(res.Rejected))
+			log.Printf("forward: %d logs rejected: %v", res.Rejected, res.FirstError())
+		}
+	})
+	return s, nil
+}
+
+// Stats returns the counters of the server.
+func (s *Server) Stats() Stats {
+	return Stats{
+		Received: s.received.Load(),
+		Failed:   s.failed.Load(),
+		Rejected: s.rejected.Load(),
+	}
+}
+
+// ListenAndServe starts the listener of the configuration and serves it
+// until Close.
+func (s *Server) ListenAndServe() error {
+	if s.cfg.Addr == "" {
+		return nil
+	}
+	ln, err := net.Listen("tcp", s.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	go s.Serve(ln)
+	return nil
+}
+
+// track registers a listener or connection to be closed by Close. It
+// returns false if the server is already closed.
+func (s *Server) track(c io.Closer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		c.Close()
+		return false
+	}
+	switch c := c.(type) {
+	case net.Conn:
+		s.conns[c] = struct{}{}
+	case net.Listener:
+		s.listener = c
+	}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	s.wg.Done()
+}
+
+// Serve accepts the connections of ln.
+func (s *Server) Serve(ln net.Listener) error {
+	if !s.track(ln) {
+		return net.ErrClosed
+	}
+	defer s.wg.Done()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		if !s.track(conn) {
+			return nil
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn handles the messages of a connection, in order. A message
+// that cannot be decoded closes the connection, the stream being lost.
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.untrack(conn)
+	defer conn.Close()
+	dec := NewDecoder(conn, s.cfg.MaxMessageSize)
+	for {
+		m, err := dec.Decode()
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				s.failed.Add(1)
+				log.Printf("forward: closing connection of %v: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		ack := s.handle(m, conn.RemoteAddr())
+		if ack == "" {
+			continue
+		}
+		b, _ := msgpack.Marshal(map[string]string{"ack": ack})
+		if _, err := conn.Write(b); err != nil {
+			log.Printf("forward: acknowledging %v: %v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+// handle converts the records of a message to logs and produces them.
+// The records of a chunk are produced at once, and the chunk id returned
+// to be acknowledged unless the broker was unavailable. The others are
+// queued.
+func (s *Server) handle(m *Message, addr net.Addr) string {
+	if len(m.Entries) == 0 {
+		return m.Option.Chunk
+	}
+	s.received.Add(uint64(len(m.Entries)))
+	channel := s.router.Channel(m.Tag)
+	rule := s.parsers.Select(channel, m.Tag)
+	host, _, _ := net.SplitHostPort(addr.String())
+	logs := make([]*core.Log, len(m.Entries))
+	for i, e := range m.Entries {
+		logs[i] = s.convert(rule, m, i, e, channel, host)
+	}
+	if m.Option.Chunk == "" {
+		for _, l := range logs {
+			s.batcher.Add(l)
+		}
+		return ""
+	}
+	res := receiver.Submit(s.producer, logs)
+	if res.Rejected > 0 {
+		s.rejected.Add(uint64(res.Rejected))
+		log.Printf("forward: %d logs of chunk %s rejected: %v", res.Rejected, m.Option.Chunk, res.FirstError())
+	}
+	if res.Unavailable > 0 {
+		log.Printf("forward: chunk %s not produced, leaving it unacknowledged: %v", m.Option.Chunk, res.FirstError())
+		return ""
+	}
+	return m.Option.Chunk
+}
+
+// convert turns a record into a log of channel. The sender is the host
+// the record names, or the address of the client.
+func (s *Server) convert(rule *parser.Rule, m *Message, i int, e Entry, channel, host string) *core.Log {
+	sender := host
+	for _, key := range []string{"hostname", "host"} {
+		if v, ok := e.Record[key].(string); ok && v != "" {
+			sender = v
+			break
+		}
+	}
+	l := &core.Log{
+		Channel:   channel,
+		SenderId:  sender,
+		Timestamp: e.Time.UnixNano(),
+		Level:     logger.INFO.String(),
+		Data:      map[string]interface{}{},
+	}
+	if m.Option.Chunk != "" {
+		id := fmt.Sprintf("forward:%s:%d", m.Option.Chunk, i)
+		l.LogId = uuid.NewSHA1(uuid.NameSpaceURL, []byte(id)).String()
+	}
+	rule.ApplyFields(l, e.Record)
+	l.Data["tag"] = m.Tag
+	return l
+}
+
+// Close stops the listener, closes the connections and submits the
+// queued logs.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	s.batcher.Close()
+	return nil
+}
@@ -0,0 +1,241 @@
+package forward
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/hyperbolicresearch/hlog/config"
+	"github.com/hyperbolicresearch/hlog/internal/receiver/receivertest"
+)
+
+// eventTime encodes an EventTime, the extension 0 of 8 bytes.
+func eventTime(sec, nsec uint32) msgpack.RawMessage {
+	b := []byte{0xd7, 0x00, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(b[2:], sec)
+	binary.BigEndian.PutUint32(b[6:], nsec)
+	return b
+}
+
+func encode(t *testing.T, v ...interface{}) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	for _, x := range v {
+		if err := enc.Encode(x); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func gzipped(t *testing.T, members ...[]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, m := range members {
+		zw := gzip.NewWriter(&buf)
+		zw.Write(m)
+		zw.Close()
+	}
+	return buf.Bytes()
+}
+
+func TestDecoder(t *testing.T) {
+	record := map[string]interface{}{"log": "hello", "n": 1}
+	entry := []interface{}{eventTime(1700000000, 500), record}
+	second := []interface{}{1700000001, map[string]interface{}{"log": "world"}}
+	expect := []Entry{
+		{Time: time.Unix(1700000000, 500), Record: map[string]interface{}{"log": "hello", "n": int64(1)}},
+		{Time: time.Unix(1700000001, 0), Record: map[string]interface{}{"log": "world"}},
+	}
+	packed := encode(t, entry, second)
+	tests := []struct {
+		name    string
+		input   []byte
+		entries []Entry
+		option  Option
+		err     bool
+	}{
+		{
+			name:    "message",
+			input:   encode(t, []interface{}{"app", eventTime(1700000000, 500), record}),
+			entries: expect[:1],
+		},
+		{
+			name:    "message with option",
+			input:   encode(t, []interface{}{"app", 1700000001, map[string]interface{}{"log": "world"}, map[string]interface{}{"chunk": "c1"}}),
+			entries: expect[1:],
+			option:  Option{Chunk: "c1"},
+		},
+		{
+			name:    "forward",
+			input:   encode(t, []interface{}{"app", []interface{}{entry, second}, map[string]interface{}{"size": 2}}),
+			entries: expect,
+			option:  Option{Size: 2},
+		},
+		{
+			name:    "packed forward",
+			input:   encode(t, []interface{}{"app", packed, map[string]interface{}{"chunk": "c2", "size": 2}}),
+			entries: expect,
+			option:  Option{Chunk: "c2", Size: 2},
+		},
+		{
+			name:    "packed forward as string",
+			input:   encode(t, []interface{}{"app", string(packed)}),
+			entries: expect,
+		},
+		{
+			name: "compressed packed forward",
+			input: encode(t, []interface{}{"app", gzipped(t, encode(t, entry), encode(t, second)),
+				map[string]interface{}{"compressed": "gzip"}}),
+			entries: expect,
+			option:  Option{Compressed: "gzip"},
+		},
+		{
+			name:  "too large",
+			input: encode(t, []interface{}{"app", bytes.Repeat([]byte{0xc0}, 2048)}),
+			err:   true,
+		},
+		{
+			name:  "unknown compression",
+			input: encode(t, []interface{}{"app", packed, map[string]interface{}{"compressed": "zstd"}}),
+			err:   true,
+		},
+		{
+			name:  "invalid entry",
+			input: encode(t, []interface{}{"app", []interface{}{[]interface{}{1}}}),
+			err:   true,
+		},
+		{
+			name:  "invalid message",
+			input: encode(t, []interface{}{"app"}),
+			err:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewDecoder(bytes.NewReader(tt.input), 1024).Decode()
+			if tt.err {
+				if err == nil {
+					t.Errorf("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.Tag != "app" || m.Option != tt.option {
+				t.Errorf("Unexpected message: %+v", m)
+			}
+			if !reflect.DeepEqual(m.Entries, tt.entries) {
+				t.Errorf("Expected=%+v, Got=%+v", tt.entries, m.Entries)
+			}
+		})
+	}
+}
+
+func TestRouter(t *testing.T) {
+	r, err := NewRouter([]config.ForwardRoute{
+		{Tag: "kube.**", Channel: "kubernetes"},
+		{Tag: "app.*.{web,api}", Channel: "frontend"},
+	}, "fluent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		tag, expect string
+	}{
+		{"kube", "kubernetes"},
+		{"kube.var.log.containers.a.log", "kubernetes"},
+		{"app.eu.web", "frontend"},
+		{"app.eu.db", "app.eu.db"},
+		{"app.web", "app.web"},
+		{"not a channel", "fluent"},
+	}
+	for _, tt := range tests {
+		if got := r.Channel(tt.tag); got != tt.expect {
+			t.Errorf("%s: Expected=%v, Got=%v", tt.tag, tt.expect, got)
+		}
+	}
+	if _, err := NewRouter([]config.ForwardRoute{{Tag: "{a,b", Channel: "x"}}, "fluent"); err == nil {
+		t.Errorf("Expected an error for unbalanced braces")
+	}
+}
+
+func TestServer(t *testing.T) {
+	cfg := config.DefaultForwardConfig
+	cfg.Routes = []config.ForwardRoute{{Tag: "kube.**", Channel: "kubernetes"}}
+	cfg.Linger = time.Duration(10) * time.Millisecond
+	p := &receivertest.Producer{Failures: 1}
+	s, err := NewServer(p, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	chunk := encode(t, []interface{}{
+		"kube.var.log.containers.web",
+		[]interface{}{
+			[]interface{}{eventTime(1700000000, 0), map[string]interface{}{
+				"log": "GET /", "level": "warning", "hostname": "node-1", "pod": "web-1",
+			}},
+		},
+		map[string]interface{}{"chunk": "abc"},
+	})
+	ack := func() string {
+		conn.SetReadDeadline(time.Now().Add(time.Duration(200) * time.Millisecond))
+		var resp map[string]string
+		if err := msgpack.NewDecoder(conn).Decode(&resp); err != nil {
+			return ""
+		}
+		return resp["ack"]
+	}
+
+	// The broker is unavailable: the chunk is not acknowledged, and is
+	// acknowledged once sent again.
+	conn.Write(chunk)
+	if got := ack(); got != "" {
+		t.Errorf("Expected no ack, Got=%v", got)
+	}
+	conn.Write(chunk)
+	if got := ack(); got != "abc" {
+		t.Errorf("Expected=abc, Got=%v", got)
+	}
+	conn.Write(encode(t, []interface{}{"web", 1700000001, map[string]interface{}{"message": "no chunk"}}))
+
+	deadline := time.Now().Add(time.Duration(2) * time.Second)
+	for s.Stats().Received < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Duration(10) * time.Millisecond)
+	}
+	s.Close()
+
+	if len(p.Logs()) != 2 {
+		t.Fatalf("Expected 2 logs, Got=%d", len(p.Logs()))
+	}
+	l := p.Logs()[0]
+	if l.Channel != "kubernetes" || l.Level != "warn" || l.Message != "GET /" || l.SenderId != "node-1" ||
+		l.Timestamp != 1700000000e9 || l.Data["pod"] != "web-1" || l.Data["tag"] != "kube.var.log.containers.web" {
+		t.Errorf("Unexpected log: %+v", l)
+	}
+	if l.LogId == "" {
+		t.Errorf("Expected a log id derived from the chunk")
+	}
+	if l := p.Logs()[1]; l.Channel != "web" || l.Message != "no chunk" || l.SenderId != "127.0.0.1" {
+		t.Errorf("Unexpected log: %+v", l)
+	}
+}
@@ -0,0 +1,153 @@
+// Package gateway is the HTTP ingestion server of hlog. It lets the
+// producers that cannot speak Kafka (browsers, serverless functions,
+// locked-down networks) send their logs over HTTP, and produces them to
+// the topics of their channels.
+//
+//	POST /v1/logs?channel=billing
+//	Content-Type: application/json
+//	Content-Encoding: gzip
+//
+//	[{"level": "info", "message": "invoice sent", "data": {"invoice": 42}}]
+//
+// The body is a single log, a JSON array of logs or NDJSON, optionally
+// compressed with gzip or zstd. Each log is validated on its own and the
+// response tells which ones were accepted, in the format of
+// hlog.IngestResponse.
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/internal/receiver"
+	"github.com/hyperbolicresearch/hlog/pkg/hlog"
+)
+
+// IngestPath is the endpoint accepting the logs.
+const IngestPath = "/v1/logs"
+
+// Options holds the limits of the gateway.
+type Options struct {
+	// MaxBodySize is the maximum size of a request body as sent, and
+	// MaxDecodedSize once decompressed.
+	MaxBodySize    int64
+	MaxDecodedSize int64
+	// MaxItems is the maximum number of logs of a request.
+	MaxItems int
+}
+
+// DefaultOptions are the limits used for the zero fields of Options.
+var DefaultOptions = Options{
+	MaxBodySize:    5 << 20,
+	MaxDecodedSize: 50 << 20,
+	MaxItems:       10000,
+}
+
+// Gateway accepts logs over HTTP and hands them over to a producer.
+type Gateway struct {
+	producer receiver.Producer
+	opts     Options
+}
+
+// New creates a Gateway producing the logs with p.
+func New(p receiver.Producer, opts Options) *Gateway {
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = DefaultOptions.MaxBodySize
+	}
+	if opts.MaxDecodedSize <= 0 {
+		opts.MaxDecodedSize = DefaultOptions.MaxDecodedSize
+	}
+	if opts.MaxItems <= 0 {
+		opts.MaxItems = DefaultOptions.MaxItems
+	}
+	return &Gateway{producer: p, opts: opts}
+}
+
+// Register adds the endpoints of the gateway to mux.
+func (g *Gateway) Register(mux *http.ServeMux) {
+	mux.HandleFunc(IngestPath, g.ServeIngest)
+}
+
+// ServeIngest handles POST /v1/logs. The logs without channel go to the
+// one of the `channel` query parameter, if any. The response is 200 when
+// every log was accepted, 207 when some were rejected, and 503 when none
+// could be produced because of the broker, in which case the request
+// can be retried as a whole.
+func (g *Gateway) ServeIngest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	data, err := receiver.ReadBody(w, r, g.opts.MaxBodySize, g.opts.MaxDecodedSize)
+	if err != nil {
+		http.Error(w, err.Error(), receiver.BodyErrorStatus(err))
+		return
+	}
+	items, err := splitItems(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(items) > g.opts.MaxItems {
+		http.Error(w, "too many logs in the request", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	channel := r.URL.Query().Get("channel")
+	results := make([]hlog.IngestResult, len(items))
+	logs := make([]*core.Log, 0, len(items))
+	indexes := make([]int, 0, len(items))
+	for i, item := range items {
+		l, err := decodeLog(item)
+		if err == nil {
+			if l.Channel == "" {
+				l.Channel = channel
+			}
+			err = receiver.Prepare(l)
+		}
+		if err != nil {
+			if l != nil {
+				results[i].LogId = l.LogId
+			}
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].LogId = l.LogId
+		logs = append(logs, l)
+		indexes = append(indexes, i)
+	}
+
+	var produceErrors int
+	if len(logs) > 0 {
+		for j, err := range g.producer.Produce(logs) {
+			i := indexes[j]
+			if err != nil {
+				results[i].Error = err.Error()
+				produceErrors++
+				continue
+			}
+			results[i].Accepted = true
+		}
+	}
+
+	resp := hlog.IngestResponse{Results: results}
+	for _, res := range results {
+		if res.Accepted {
+			resp.Accepted++
+		} else {
+			resp.Rejected++
+		}
+	}
+	status := http.StatusOK
+	switch {
+	case resp.Accepted == 0 && produceErrors > 0:
+		status = http.StatusServiceUnavailable
+	case resp.Rejected > 0:
+		status = http.StatusMultiStatus
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
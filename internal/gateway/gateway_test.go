@@ -0,0 +1,169 @@
+package gateway
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/internal/receiver"
+	"github.com/hyperbolicresearch/hlog/internal/receiver/receivertest"
+	"github.com/hyperbolicresearch/hlog/pkg/hlog"
+)
+
+func gzipped(s string) []byte {
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	zw.Write([]byte(s))
+	zw.Close()
+	return b.Bytes()
+}
+
+func zstded(s string) []byte {
+	enc, _ := zstd.NewWriter(nil)
+	defer enc.Close()
+	return enc.EncodeAll([]byte(s), nil)
+}
+
+func TestServeIngest(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		encoding string
+		body     []byte
+		err      error
+		status   int
+		accepted []bool
+	}{
+		{
+			name:     "single",
+			body:     []byte(`{"channel":"billing","level":"WARNING","message":"late"}`),
+			status:   http.StatusOK,
+			accepted: []bool{true},
+		},
+		{
+			name:     "array with default channel",
+			query:    "?channel=billing",
+			body:     []byte(`[{"message":"a"},{"channel":"other","message":"b"}]`),
+			status:   http.StatusOK,
+			accepted: []bool{true, true},
+		},
+		{
+			name:     "ndjson with invalid lines",
+			query:    "?channel=billing",
+			body:     []byte("{\"message\":\"a\"}\nnot json\n{\"message\":\"c\",\"lvl\":\"info\"}\n{\"message\":\"d\",\"level\":\"loud\"}\n"),
+			status:   http.StatusMultiStatus,
+			accepted: []bool{true, false, false, false},
+		},
+		{
+			name:     "gzip",
+			query:    "?channel=billing",
+			encoding: "gzip",
+			body:     gzipped(`[{"message":"a"}]`),
+			status:   http.StatusOK,
+			accepted: []bool{true},
+		},
+		{
+			name:     "zstd",
+			query:    "?channel=billing",
+			encoding: "zstd",
+			body:     zstded("{\"message\":\"a\"}\n{\"message\":\"b\"}"),
+			status:   http.StatusOK,
+			accepted: []bool{true, true},
+		},
+		{
+			name:     "missing channel",
+			body:     []byte(`{"message":"a"}`),
+			status:   http.StatusMultiStatus,
+			accepted: []bool{false},
+		},
+		{
+			name:     "broker down",
+			query:    "?channel=billing",
+			body:     []byte(`{"message":"a"}`),
+			err:      receivertest.ErrUnavailable,
+			status:   http.StatusServiceUnavailable,
+			accepted: []bool{false},
+		},
+		{
+			name:   "invalid array",
+			body:   []byte(`[{"message":"a"}`),
+			status: http.StatusBadRequest,
+		},
+		{
+			name:     "unsupported encoding",
+			encoding: "br",
+			body:     []byte(`{}`),
+			status:   http.StatusUnsupportedMediaType,
+		},
+		{
+			name:   "too large",
+			body:   []byte(`[` + strings.Repeat(`{"message":"a"},`, 100) + `{"message":"a"}]`),
+			status: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:     "too large once decoded",
+			encoding: "gzip",
+			body:     gzipped(strings.Repeat(" ", 2000) + `{"message":"a"}`),
+			status:   http.StatusRequestEntityTooLarge,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := New(&receivertest.Producer{Err: tt.err}, Options{MaxBodySize: 1000, MaxDecodedSize: 1500})
+			req := httptest.NewRequest(http.MethodPost, IngestPath+tt.query, bytes.NewReader(tt.body))
+			if tt.encoding != "" {
+				req.Header.Set("Content-Encoding", tt.encoding)
+			}
+			rec := httptest.NewRecorder()
+			g.ServeIngest(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("Expected=%v, Got=%v (%s)", tt.status, rec.Code, rec.Body)
+			}
+			if tt.accepted == nil {
+				return
+			}
+			var resp hlog.IngestResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if len(resp.Results) != len(tt.accepted) {
+				t.Fatalf("Expected=%v results, Got=%v", len(tt.accepted), len(resp.Results))
+			}
+			for i, res := range resp.Results {
+				if res.Accepted != tt.accepted[i] {
+					t.Errorf("Result %d: Expected=%v, Got=%+v", i, tt.accepted[i], res)
+				}
+				if res.Accepted && res.LogId == "" {
+					t.Errorf("Result %d: expected a log id", i)
+				}
+			}
+		})
+	}
+}
+
+func TestPrepare(t *testing.T) {
+	l := &core.Log{Channel: "billing", Level: "WARNING", Message: "late"}
+	if err := receiver.Prepare(l); err != nil {
+		t.Fatal(err)
+	}
+	if l.Level != "warn" || l.LogId == "" || l.Timestamp == 0 {
+		t.Errorf("Unexpected log: %+v", l)
+	}
+	for _, l := range []*core.Log{
+		{Message: "no channel"},
+		{Channel: "bad channel", Message: "a"},
+		{Channel: "billing"},
+		{Channel: "billing", Message: "a", Level: "loud"},
+	} {
+		if err := receiver.Prepare(l); err == nil {
+			t.Errorf("Expected an error for %+v", l)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperbolicresearch/hlog/internal/core"
+)
+
+// splitItems splits a body holding a single log, a JSON array of logs
+// or logs separated by newlines (NDJSON). The items are checked later
+// on, one by one, so that a malformed line of NDJSON only rejects that
+// line.
+func splitItems(data []byte) ([]json.RawMessage, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, errors.New("empty body")
+	}
+	if data[0] == '[' {
+		var items []json.RawMessage
+		if err := json.Unmarshal(data, &items); err != nil {
+			return nil, fmt.Errorf("invalid JSON array: %v", err)
+		}
+		return items, nil
+	}
+	// A pretty-printed log spans several lines, so the body is only
+	// split when it is not a single valid value.
+	if json.Valid(data) {
+		return []json.RawMessage{data}, nil
+	}
+	var items []json.RawMessage
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		items = append(items, line)
+	}
+	return items, nil
+}
+
+// decodeLog decodes an item into a log. Unknown fields are rejected, so
+// that misspelled fields are not silently lost.
+func decodeLog(item json.RawMessage) (*core.Log, error) {
+	dec := json.NewDecoder(bytes.NewReader(item))
+	dec.DisallowUnknownFields()
+	var l core.Log
+	if err := dec.Decode(&l); err != nil {
+		return nil, fmt.Errorf("invalid log: %v", err)
+	}
+	if dec.More() {
+		return nil, errors.New("invalid log: trailing data")
+	}
+	return &l, nil
+}
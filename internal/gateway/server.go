@@ -0,0 +1,159 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+
+	"google.golang.org/grpc"
+	// Collectors compress their exports with gzip by default.
+	_ "google.golang.org/grpc/encoding/gzip"
+
+	"github.com/hyperbolicresearch/hlog/config"
+	"github.com/hyperbolicresearch/hlog/internal/elastic"
+	"github.com/hyperbolicresearch/hlog/internal/forward"
+	"github.com/hyperbolicresearch/hlog/internal/loki"
+	"github.com/hyperbolicresearch/hlog/internal/otlp"
+	"github.com/hyperbolicresearch/hlog/internal/parser"
+	"github.com/hyperbolicresearch/hlog/internal/receiver"
+	"github.com/hyperbolicresearch/hlog/internal/syslog"
+)
+
+// Server runs the gateway along with the other receivers of the
+// configuration: Loki and Elasticsearch on the gateway's address, OTLP,
+// syslog and Fluent Forward on their own.
+type Server struct {
+	servers []*http.Server
+	grpc    *grpc.Server
+	grpcLis net.Listener
+	syslog  *syslog.Server
+	forward *forward.Server
+	wg      sync.WaitGroup
+}
+
+// NewServer creates the receivers of cfg, producing the logs with p.
+func NewServer(cfg *config.Config, p receiver.Producer) (*Server, error) {
+	s := &Server{}
+	gcfg := cfg.Gateway
+	mux := http.NewServeMux()
+	New(p, Options{
+		MaxBodySize:    gcfg.MaxBodySize,
+		MaxDecodedSize: gcfg.MaxDecodedSize,
+		MaxItems:       gcfg.MaxItems,
+	}).Register(mux)
+	lcfg := cfg.Loki
+	loki.New(p, loki.Options{
+		ChannelLabel:   lcfg.ChannelLabel,
+		DefaultChannel: lcfg.DefaultChannel,
+		MaxBodySize:    lcfg.MaxBodySize,
+		MaxDecodedSize: lcfg.MaxDecodedSize,
+	}).Register(mux)
+	// The Elasticsearch endpoints take every path left by the others.
+	elasticReceiver, err := elastic.New(p, *cfg.Elastic)
+	if err != nil {
+		return nil, err
+	}
+	elasticReceiver.Register(mux)
+	s.servers = append(s.servers, &http.Server{Addr: gcfg.Addr, Handler: mux})
+
+	// The OpenTelemetry receiver keeps the standard OTLP ports, its HTTP
+	// endpoint having the same path as the one of the gateway.
+	ocfg := cfg.OTLP
+	otlpReceiver := otlp.New(p, otlp.Options{
+		ChannelAttribute: ocfg.ChannelAttribute,
+		DefaultChannel:   ocfg.DefaultChannel,
+		MaxBodySize:      ocfg.MaxBodySize,
+		MaxDecodedSize:   ocfg.MaxDecodedSize,
+	})
+	if ocfg.HTTPAddr != "" {
+		otlpMux := http.NewServeMux()
+		otlpReceiver.Register(otlpMux)
+		s.servers = append(s.servers, &http.Server{Addr: ocfg.HTTPAddr, Handler: otlpMux})
+	}
+	if ocfg.GRPCAddr != "" {
+		lis, err := net.Listen("tcp", ocfg.GRPCAddr)
+		if err != nil {
+			return nil, err
+		}
+		s.grpc, s.grpcLis = grpc.NewServer(), lis
+		otlpReceiver.RegisterGRPC(s.grpc)
+	}
+
+	s.syslog, err = syslog.NewServer(p, *cfg.Syslog)
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+	parsers, err := parser.NewRules(cfg.Parsing)
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+	s.forward, err = forward.NewServer(p, *cfg.Forward, parsers)
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Start starts the listeners, the HTTP ones failing in the background.
+func (s *Server) Start() error {
+	if err := s.syslog.ListenAndServe(); err != nil {
+		s.close()
+		return err
+	}
+	if err := s.forward.ListenAndServe(); err != nil {
+		s.close()
+		return err
+	}
+	if s.grpc != nil {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			log.Printf("OTLP/gRPC receiver listening on %s...", s.grpcLis.Addr())
+			if err := s.grpc.Serve(s.grpcLis); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+	for _, srv := range s.servers {
+		s.wg.Add(1)
+		go func(srv *http.Server) {
+			defer s.wg.Done()
+			log.Printf("Hlog gateway listening on %s...", srv.Addr)
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}(srv)
+	}
+	return nil
+}
+
+// Shutdown stops the receivers, waiting until ctx is done for the
+// requests in flight.
+func (s *Server) Shutdown(ctx context.Context) {
+	for _, srv := range s.servers {
+		srv.Shutdown(ctx)
+	}
+	if s.grpc != nil {
+		s.grpc.GracefulStop()
+	}
+	s.wg.Wait()
+	s.close()
+}
+
+func (s *Server) close() {
+	if s.grpcLis != nil {
+		s.grpcLis.Close()
+	}
+	if s.syslog != nil {
+		s.syslog.Close()
+	}
+	if s.forward != nil {
+		s.forward.Close()
+	}
+}
@@ -29,7 +29,7 @@ func (b *BatcherWorker) Sink(data []map[string]interface{}) (count int, err erro
 	dataByChannel := GetDataByChannel(data)
 	count = 0
 	for channel, item := range dataByChannel {
-		insertQuery := fmt.Sprintf("INSERT INTO %s", channel)
+		insertQuery := fmt.Sprintf("INSERT INTO `%s`", TableName(channel))
 		batch, err := b.Conn.PrepareBatch(context.Background(), insertQuery)
 		if err != nil {
 			return count, err
@@ -0,0 +1,88 @@
+package ingest
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/hyperbolicresearch/hlog/config"
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/internal/pubsub"
+)
+
+// Clock normalizes the timestamps of the logs decoded to nanoseconds,
+// and records when they were produced and ingested.
+type Clock struct {
+	units   []config.TimestampUnit
+	maxSkew time.Duration
+	// now is time.Now, replaced by the tests.
+	now func() time.Time
+}
+
+// NewClock creates the clock of cfg, which may be nil.
+func NewClock(cfg *config.Timestamps) (*Clock, error) {
+	c := &Clock{now: time.Now}
+	if cfg == nil {
+		return c, nil
+	}
+	for i, u := range cfg.Units {
+		if _, err := path.Match(u.Channel, ""); err != nil {
+			return nil, fmt.Errorf("timestamps: unit %d: invalid channel %q", i, u.Channel)
+		}
+		if _, ok := core.ToNanoseconds(0, u.Unit); !ok {
+			return nil, fmt.Errorf("timestamps: unit %d: unknown unit %q", i, u.Unit)
+		}
+	}
+	if cfg.MaxSkew < 0 {
+		return nil, fmt.Errorf("timestamps: negative maximum skew %v", cfg.MaxSkew)
+	}
+	c.units, c.maxSkew = cfg.Units, cfg.MaxSkew
+	return c, nil
+}
+
+// unit returns the unit declared for channel, empty when it is detected.
+func (c *Clock) unit(channel string) string {
+	for _, u := range c.units {
+		if ok, _ := path.Match(u.Channel, channel); ok {
+			return u.Unit
+		}
+	}
+	return ""
+}
+
+// stamp sets the times of l, read from msg. The timestamps of the log
+// envelopes are converted from the unit of their channel, the other logs
+// having theirs in nanoseconds already. The logs without timestamp, or
+// whose timestamp is out of range, get the one of msg, or the time of
+// ingestion.
+func (c *Clock) stamp(l *core.Log, msg *pubsub.Message, envelope bool) {
+	if c == nil {
+		c = &Clock{now: time.Now}
+	}
+	l.IngestedAt = c.now().UnixNano()
+	l.KafkaTimestamp = 0
+	if !msg.Timestamp.IsZero() {
+		l.KafkaTimestamp = msg.Timestamp.UnixNano()
+	}
+	produced := l.KafkaTimestamp
+	if produced == 0 {
+		produced = l.IngestedAt
+	}
+	l.ClockSkew = false
+	if l.Timestamp != 0 && envelope {
+		ns, ok := core.ToNanoseconds(l.Timestamp, c.unit(l.Channel))
+		if !ok {
+			ns = 0
+			l.ClockSkew = true
+		}
+		l.Timestamp = ns
+	}
+	if l.Timestamp == 0 {
+		l.Timestamp = produced
+		return
+	}
+	skew := time.Unix(0, l.Timestamp).Sub(time.Unix(0, produced))
+	if c.maxSkew > 0 && (skew > c.maxSkew || skew < -c.maxSkew) {
+		l.ClockSkew = true
+	}
+}
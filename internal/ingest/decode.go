@@ -0,0 +1,82 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/internal/parser"
+	"github.com/hyperbolicresearch/hlog/internal/pubsub"
+	"github.com/hyperbolicresearch/hlog/pkg/logger"
+)
+
+// envelopeKeys are the keys of the JSON encoding of core.Log.
+var envelopeKeys = map[string]bool{
+	"channel":   true,
+	"log_id":    true,
+	"sender_id": true,
+	"timestamp": true,
+	"level":     true,
+	"message":   true,
+	"data":      true,
+
+	"ingested_at":     true,
+	"kafka_timestamp": true,
+	"clock_skew":      true,
+}
+
+// DecodeMessage turns a message into a log. The messages holding a log
+// envelope are decoded as such, the others (plain text, or JSON written
+// by something else than hlog) are parsed with the rules of their topic
+// and key. Their id is derived from their position in the topic, and
+// their timestamp defaults to the one of the message. The times of the
+// logs are set by clock, which may be nil.
+func DecodeMessage(msg *pubsub.Message, rules *parser.Rules, clock *Clock) (*core.Log, error) {
+	if isEnvelope(msg.Value) {
+		// JSON written by something else may only have keys of the
+		// envelope, with other types, such as a timestamp in RFC 3339: it
+		// is parsed like the other messages.
+		if l, err := pubsub.DecodeLog(msg); err == nil {
+			clock.stamp(l, msg, true)
+			return l, nil
+		}
+	}
+	if msg.Topic == "" {
+		return nil, fmt.Errorf("message without topic")
+	}
+	topic := msg.Topic
+	sender := string(msg.Key)
+	l := &core.Log{
+		Channel:  topic,
+		SenderId: sender,
+		Level:    logger.INFO.String(),
+		LogId: uuid.NewSHA1(uuid.NameSpaceURL, []byte(fmt.Sprintf("kafka:%s:%d:%d",
+			topic, msg.Partition, msg.Offset))).String(),
+		Data: map[string]interface{}{},
+	}
+	if rules == nil {
+		l.Message = string(msg.Value)
+	} else {
+		rules.Select(topic, sender).Apply(l, string(bytes.TrimRight(msg.Value, "\r\n")))
+	}
+	clock.stamp(l, msg, false)
+	return l, nil
+}
+
+// isEnvelope tells whether value is a JSON object whose keys are all the
+// ones of a log envelope.
+func isEnvelope(value []byte) bool {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(value, &fields); err != nil || len(fields) == 0 {
+		return false
+	}
+	for k := range fields {
+		if !envelopeKeys[k] {
+			return false
+		}
+	}
+	return true
+}
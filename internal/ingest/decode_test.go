@@ -0,0 +1,167 @@
+package ingest
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hyperbolicresearch/hlog/config"
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/internal/parser"
+	"github.com/hyperbolicresearch/hlog/internal/pubsub"
+)
+
+func TestDecodeMessage(t *testing.T) {
+	rules, err := parser.NewRules(&config.Parsing{Rules: []config.ParserRule{
+		{Channel: "nginx", Format: "grok", Pattern: `%{IP:client} %{WORD:method} %{NUMBER:status:int}`},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock, err := NewClock(&config.Timestamps{
+		Units:   []config.TimestampUnit{{Channel: "legacy-*", Unit: core.Milliseconds}},
+		MaxSkew: time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := time.Unix(1700000000, 0)
+	ingested := sent.Add(time.Second)
+	clock.now = func() time.Time { return ingested }
+	message := func(topic, value string) *pubsub.Message {
+		return &pubsub.Message{
+			Topic:     topic,
+			Partition: 1,
+			Offset:    7,
+			Key:       []byte("web-1"),
+			Value:     []byte(value),
+			Timestamp: sent,
+		}
+	}
+	tests := []struct {
+		name   string
+		msg    *pubsub.Message
+		expect core.Log
+	}{
+		{
+			"envelope",
+			message("app", `{"log_id":"1","level":"warn","message":"m","timestamp":1699999990}`),
+			core.Log{
+				Channel: "app", LogId: "1", Level: "warn", Message: "m", Timestamp: 1699999990e9,
+				IngestedAt: ingested.UnixNano(), KafkaTimestamp: sent.UnixNano(),
+			},
+		},
+		{
+			"milliseconds",
+			message("app", `{"log_id":"1","timestamp":1699999999123}`),
+			core.Log{
+				Channel: "app", LogId: "1", Timestamp: 1699999999123e6,
+				IngestedAt: ingested.UnixNano(), KafkaTimestamp: sent.UnixNano(),
+			},
+		},
+		{
+			"nanoseconds",
+			message("app", `{"log_id":"1","timestamp":1699999999123456789}`),
+			core.Log{
+				Channel: "app", LogId: "1", Timestamp: 1699999999123456789,
+				IngestedAt: ingested.UnixNano(), KafkaTimestamp: sent.UnixNano(),
+			},
+		},
+		{
+			"declared unit",
+			message("legacy-app", `{"log_id":"1","timestamp":1700000000}`),
+			core.Log{
+				Channel: "legacy-app", LogId: "1", Timestamp: 1700000000e6, ClockSkew: true,
+				IngestedAt: ingested.UnixNano(), KafkaTimestamp: sent.UnixNano(),
+			},
+		},
+		{
+			"out of range",
+			message("legacy-app", `{"log_id":"1","timestamp":1699999999123456789}`),
+			core.Log{
+				Channel: "legacy-app", LogId: "1", Timestamp: sent.UnixNano(), ClockSkew: true,
+				IngestedAt: ingested.UnixNano(), KafkaTimestamp: sent.UnixNano(),
+			},
+		},
+		{
+			"skewed",
+			message("app", `{"log_id":"1","timestamp":1700000600}`),
+			core.Log{
+				Channel: "app", LogId: "1", Timestamp: 1700000600e9, ClockSkew: true,
+				IngestedAt: ingested.UnixNano(), KafkaTimestamp: sent.UnixNano(),
+			},
+		},
+		{
+			"no timestamp",
+			message("app", `{"log_id":"1"}`),
+			core.Log{
+				Channel: "app", LogId: "1", Timestamp: sent.UnixNano(),
+				IngestedAt: ingested.UnixNano(), KafkaTimestamp: sent.UnixNano(),
+			},
+		},
+		{
+			"foreign json",
+			message("app", `{"level":"debug","msg":"hi","user":"a"}`),
+			core.Log{
+				Channel: "app", SenderId: "web-1", Level: "debug", Message: "hi", Timestamp: sent.UnixNano(),
+				IngestedAt: ingested.UnixNano(), KafkaTimestamp: sent.UnixNano(),
+				Data: map[string]interface{}{"user": "a"},
+			},
+		},
+		{
+			"foreign json with envelope keys",
+			message("app", `{"timestamp":"2023-11-14T22:13:10Z","level":"info","message":"hi"}`),
+			core.Log{
+				Channel: "app", SenderId: "web-1", Level: "info", Message: "hi", Timestamp: 1700000000e9 - 10e9,
+				IngestedAt: ingested.UnixNano(), KafkaTimestamp: sent.UnixNano(),
+				Data: map[string]interface{}{},
+			},
+		},
+		{
+			"foreign json with string data",
+			message("app", `{"level":"info","message":"hi","data":"x"}`),
+			core.Log{
+				Channel: "app", SenderId: "web-1", Level: "info", Message: "hi", Timestamp: sent.UnixNano(),
+				IngestedAt: ingested.UnixNano(), KafkaTimestamp: sent.UnixNano(),
+				Data: map[string]interface{}{"data": "x"},
+			},
+		},
+		{
+			"plain text",
+			message("app", "something happened\n"),
+			core.Log{
+				Channel: "app", SenderId: "web-1", Level: "info", Message: "something happened", Timestamp: sent.UnixNano(),
+				IngestedAt: ingested.UnixNano(), KafkaTimestamp: sent.UnixNano(),
+				Data: map[string]interface{}{},
+			},
+		},
+		{
+			"grok",
+			message("nginx", "10.0.0.1 GET 200"),
+			core.Log{
+				Channel: "nginx", SenderId: "web-1", Level: "info", Message: "10.0.0.1 GET 200", Timestamp: sent.UnixNano(),
+				IngestedAt: ingested.UnixNano(), KafkaTimestamp: sent.UnixNano(),
+				Data: map[string]interface{}{"client": "10.0.0.1", "method": "GET", "status": int64(200)},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeMessage(tt.msg, rules, clock)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.expect.LogId == "" {
+				// Derived from the position of the message.
+				again, _ := DecodeMessage(tt.msg, rules, clock)
+				if got.LogId == "" || got.LogId != again.LogId {
+					t.Errorf("Expected a stable log id, Got=%q and %q", got.LogId, again.LogId)
+				}
+				got.LogId = ""
+			}
+			if !reflect.DeepEqual(*got, tt.expect) {
+				t.Errorf("Expected=%+v, Got=%+v", tt.expect, *got)
+			}
+		})
+	}
+}
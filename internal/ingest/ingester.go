@@ -1,7 +1,23 @@
 package ingest
 
+import (
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/internal/router"
+	"github.com/hyperbolicresearch/hlog/internal/schema"
+)
+
 type Ingester interface {
 	Start()
 	Stop() error
 	Consume()
 }
+
+// route returns the logs sink stores for l, validated by v. The logs in
+// quarantine stay there, not to be moved or copied out of it by the rules
+// matching any channel.
+func route(rt *router.Router, v *schema.Validator, l *core.Log, sink string) []*core.Log {
+	if v.Quarantined(l) {
+		return []*core.Log{l}
+	}
+	return rt.Route(l, sink)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log"
 	"os"
 	"reflect"
 	"strings"
@@ -16,8 +17,13 @@ import (
 	"github.com/hyperbolicresearch/hlog/config"
 	"github.com/hyperbolicresearch/hlog/internal/clickhouseservice"
 	"github.com/hyperbolicresearch/hlog/internal/core"
-	"github.com/hyperbolicresearch/hlog/internal/kafkaservice"
+	"github.com/hyperbolicresearch/hlog/internal/metrics"
 	"github.com/hyperbolicresearch/hlog/internal/mongodb"
+	"github.com/hyperbolicresearch/hlog/internal/parser"
+	"github.com/hyperbolicresearch/hlog/internal/processor"
+	"github.com/hyperbolicresearch/hlog/internal/pubsub"
+	"github.com/hyperbolicresearch/hlog/internal/router"
+	"github.com/hyperbolicresearch/hlog/internal/schema"
 )
 
 // IngesterWorker is responsible the handle the end-to-end dumping
@@ -25,13 +31,13 @@ import (
 type IngesterWorker struct {
 	sync.RWMutex
 	*BatcherWorker
-	*kafkaservice.KafkaWorker
+	Consumer      pubsub.Consumer
 	MongoDatabase *mongo.Database
 	IsRunning     bool
 	IsIngesting   bool
 	Messages      *Messages
 	// ConsumeInterval is the periodic interval to consume messages
-	// from kafka.
+	// from the topics.
 	ConsumeInterval time.Duration
 	// MinBatchableSize is the minimum number of messages that should
 	// be stored to allow committing or batching.
@@ -42,6 +48,27 @@ type IngesterWorker struct {
 	// MaxBatchableWait is the threshold for committing and batching
 	// given that MinCommitCount is met.
 	MaxBatchableWait time.Duration
+	// Parsers parse the messages that are not log envelopes.
+	Parsers *parser.Rules
+	// Clock normalizes the timestamps of the logs.
+	Clock *Clock
+	// Processors are the pipelines run on the decoded logs.
+	Processors *processor.Pipelines
+	// Validator validates the logs against the schemas of their channel,
+	// the counts of the violations being written to ValidationStore with
+	// every batch.
+	Validator       *schema.Validator
+	ValidationStore *schema.Store
+	// Metrics aggregates the metrics derived from the logs, written to
+	// MetricsStore with every batch.
+	Metrics      *metrics.Aggregator
+	MetricsStore *metrics.Store
+	// Router decides which logs are stored in ClickHouse, and in which
+	// channels.
+	Router *router.Router
+	// Reserved are the tables of hlog, in which no channel is stored,
+	// besides the ones starting with an underscore.
+	Reserved []string
 }
 
 // Messages is the data structure holding the messages that will be
@@ -55,20 +82,48 @@ type Messages struct {
 
 // TODO: make configs
 func NewClickHouseIngester(cfg *config.Config) *IngesterWorker {
-	kw, err := kafkaservice.NewKafkaWorker(&cfg.ClickHouse.KafkaConfigs)
+	consumer, err := pubsub.NewConsumer(&cfg.ClickHouse.KafkaConfigs)
 	if err != nil {
 		panic("failed to create ingester")
 	}
-	err = kw.ConfigureConsumer()
+	err = consumer.Subscribe(cfg.ClickHouse.KafkaTopics)
 	if err != nil {
 		panic(err)
 	}
-	err = kw.SubscribeTopics(cfg.ClickHouse.KafkaTopics)
+	parsers, err := parser.NewRules(cfg.Parsing)
+	if err != nil {
+		panic(err)
+	}
+	clock, err := NewClock(cfg.Timestamps)
+	if err != nil {
+		panic(err)
+	}
+	processors, err := processor.New(cfg.Processing)
+	if err != nil {
+		panic(err)
+	}
+	aggregator, err := metrics.New(cfg.Metrics)
+	if err != nil {
+		panic(err)
+	}
+	logRouter, err := router.New(cfg.Routing)
 	if err != nil {
 		panic(err)
 	}
 	mongoClient := mongodb.Client(cfg.MongoDB.Server)
 	db := mongoClient.Database(cfg.MongoDB.Database)
+	var (
+		validator       *schema.Validator
+		validationStore *schema.Store
+	)
+	if cfg.Validation != nil {
+		validationStore = schema.NewStore(db, cfg.Validation)
+		validator, err = schema.NewValidator(cfg.Validation, validationStore)
+		if err != nil {
+			panic(err)
+		}
+		validator.Report = true
+	}
 
 	// TODO make configurable
 	addrs := []string{"127.0.0.1:9000"}
@@ -76,6 +131,13 @@ func NewClickHouseIngester(cfg *config.Config) *IngesterWorker {
 	if err != nil {
 		panic(err)
 	}
+	var metricsStore *metrics.Store
+	if cfg.Metrics != nil && len(cfg.Metrics.Rules) > 0 {
+		metricsStore, err = metrics.NewStore(context.Background(), chConn, cfg.Metrics.Table)
+		if err != nil {
+			panic(err)
+		}
+	}
 
 	_i := &IngesterWorker{
 		MongoDatabase: db,
@@ -83,11 +145,22 @@ func NewClickHouseIngester(cfg *config.Config) *IngesterWorker {
 			Conn: chConn,
 		},
 		Messages:         &Messages{},
-		KafkaWorker:      kw,
+		Consumer:         consumer,
 		ConsumeInterval:  cfg.ClickHouse.ConsumeInterval,
 		MinBatchableSize: cfg.ClickHouse.MinBatchableSize,
 		MaxBatchableSize: cfg.ClickHouse.MaxBatchableSize,
 		MaxBatchableWait: cfg.ClickHouse.MaxBatchableWait,
+		Parsers:          parsers,
+		Clock:            clock,
+		Processors:       processors,
+		Validator:        validator,
+		ValidationStore:  validationStore,
+		Metrics:          aggregator,
+		MetricsStore:     metricsStore,
+		Router:           logRouter,
+	}
+	if cfg.Metrics != nil {
+		_i.Reserved = []string{cfg.Metrics.Table}
 	}
 	return _i
 }
@@ -128,26 +201,48 @@ func (i *IngesterWorker) Stop() error {
 	return nil
 }
 
-// Consume reads up to i.MaxBatchableSize messages from Kafka and
+// Consume reads up to i.MaxBatchableSize messages from the topics and
 // orchestrate the further processing of these by invoking the
 // subsequent methods.
 func (i *IngesterWorker) Consume() {
 	// We will try to extract as much as possible messages from
-	// Kafka given that j <= i.MaxBatchableSize.
+	// the topics given that j <= i.MaxBatchableSize.
 	// Doing like that, we make sure that we always read less
 	// or equal to the i.MaxBatchableSize.
 	for j := 0; j < i.MaxBatchableSize; j++ {
-		msg, err := i.KafkaWorker.Consumer.ReadMessage(i.ConsumeInterval)
+		msg, err := i.Consumer.Read(i.ConsumeInterval)
 		if err != nil {
 			continue
 		}
 		fmt.Printf("%+v\n", string(msg.Value))
-		var l core.Log
-		if err := json.Unmarshal(msg.Value, &l); err != nil {
-			 panic(fmt.Errorf("error unmarshalling value %v: %v", msg.Value, err))
+		l, err := DecodeMessage(msg, i.Parsers, i.Clock)
+		if err != nil {
+			log.Printf("ingester: skipping message: %v", err)
+			continue
 		}
+		if !i.Processors.Process(l) {
+			continue
+		}
+		i.Validator.Validate(l)
+		i.Metrics.Observe(l)
+		routed := storable(route(i.Router, i.Validator, l, router.ClickHouse), i.Reserved)
 		i.Messages.Lock()
-		i.Messages.Data = append(i.Messages.Data, &l)
+		i.Messages.Data = append(i.Messages.Data, routed...)
+		i.Messages.Unlock()
+	}
+	i.Processors.LogStats()
+	// The logs released by the processors, such as the ones standing for
+	// repeated logs, are stored with the batch.
+	if logs := i.Processors.Flush(); len(logs) > 0 {
+		var routed []*core.Log
+		for _, l := range logs {
+			i.Validator.Validate(l)
+			i.Metrics.Observe(l)
+			routed = append(routed, route(i.Router, i.Validator, l, router.ClickHouse)...)
+		}
+		routed = storable(routed, i.Reserved)
+		i.Messages.Lock()
+		i.Messages.Data = append(i.Messages.Data, routed...)
 		i.Messages.Unlock()
 	}
 
@@ -160,16 +255,48 @@ func (i *IngesterWorker) Consume() {
 	if err != nil {
 		panic(err)
 	}
+	i.sinkMetrics()
+	i.sinkViolations()
 
 	// Waiting until we have the acks that we successfully sink
 	// the data to ClickHouse (which should be sent from inside
 	// Sink)
-	_, err = i.Consumer.Commit()
+	err = i.Consumer.Commit()
 	if err != nil {
 		panic(err)
 	}
 }
 
+// sinkMetrics writes the metrics aggregated since the last batch. The
+// ones that cannot be written are kept for the next batch.
+func (i *IngesterWorker) sinkMetrics() {
+	if i.MetricsStore == nil {
+		return
+	}
+	points, errors := i.Metrics.Drain()
+	if errors > 0 {
+		log.Printf("ingester: %d values of metrics are not numbers", errors)
+	}
+	if err := i.MetricsStore.Write(context.Background(), points); err != nil {
+		log.Printf("ingester: writing metrics: %v", err)
+		i.Metrics.Restore(points)
+	}
+}
+
+// sinkViolations adds the counts of the violations of the schemas since
+// the last batch to the report. The ones that cannot be written are kept
+// for the next batch.
+func (i *IngesterWorker) sinkViolations() {
+	if i.ValidationStore == nil {
+		return
+	}
+	counts := i.Validator.Drain()
+	if err := i.ValidationStore.WriteCounts(context.Background(), counts); err != nil {
+		log.Printf("ingester: writing the schema violations: %v", err)
+		i.Validator.Restore(counts)
+	}
+}
+
 // Transform will flatten the message to the appropriate format
 // that will be stored to ClickHouse, add metadata.
 func (i *IngesterWorker) Transform() error {
@@ -185,6 +312,11 @@ func (i *IngesterWorker) Transform() error {
 			_value := values.Field(j).Interface()
 			t[_type] = _value
 		}
+		// The times are stored as DateTime64 columns, the timestamp being
+		// kept as a number of nanoseconds in _timestamp as well.
+		t["_time"] = time.Unix(0, entry.Timestamp).UTC()
+		t["_ingestedat"] = time.Unix(0, entry.IngestedAt).UTC()
+		t["_kafkatimestamp"] = time.Unix(0, entry.KafkaTimestamp).UTC()
 		// data fields
 		for k, v := range entry.Data {
 			t[k] = v
@@ -297,27 +429,46 @@ func (i *IngesterWorker) processFields(channel string, chFields []string) error
 		repr[key] = value
 	}
 
+	// Every table has its own schema document, identified by the name
+	// of the table, so that a new channel gets its own table created.
+	table := TableName(channel)
 	i.RLock()
 	col := i.MongoDatabase.Collection("_sqlschemas")
 	i.RUnlock()
-	filter := bson.D{}
+	filter := bson.D{{Key: "_id", Value: table}}
 	var result map[string]interface{}
 	var toCreate bool = false
 	err := col.FindOne(context.TODO(), filter).Decode(&result)
 	if err != nil {
 		// means that either there is no schema because that's the first
 		// time this clientID sends logs or that the data was corrupted.
-		// we therefore create a new one
-		_, err := col.InsertOne(context.TODO(), repr)
+		// we therefore create a new one, from the table when it exists
+		// already, as it does for the tables created before the schemas
+		// were kept per table, whose missing columns are added below.
+		columns, err := i.tableColumns(table)
 		if err != nil {
 			panic(err)
 		}
-		result = repr
-		toCreate = true
+		doc := bson.M{"_id": table}
+		if len(columns) > 0 {
+			for k, v := range columns {
+				doc[k] = v
+			}
+			result = columns
+		} else {
+			for k, v := range repr {
+				doc[k] = v
+			}
+			result = repr
+			toCreate = true
+		}
+		if _, err := col.InsertOne(context.TODO(), doc); err != nil {
+			panic(err)
+		}
 	}
 	if toCreate {
 		// CREATE TABLE...
-		err := GenerateSQLAndApply(result, channel, false)
+		err := GenerateSQLAndApply(result, table, false)
 		if err != nil {
 			panic(err)
 		}
@@ -332,11 +483,35 @@ func (i *IngesterWorker) processFields(channel string, chFields []string) error
 		}
 		if len(toUpdate) > 0 {
 			// ALTER TABLE...
-			err := GenerateSQLAndApply(toUpdate, channel, true)
+			err := GenerateSQLAndApply(toUpdate, table, true)
 			if err != nil {
 				panic(err)
 			}
+			update := bson.D{{Key: "$set", Value: toUpdate}}
+			if _, err := col.UpdateOne(context.TODO(), filter, update); err != nil {
+				panic(err)
+			}
 		}
 	}
 	return nil
 }
+
+// tableColumns returns the columns of a table and their types, none when
+// the table does not exist.
+func (i *IngesterWorker) tableColumns(table string) (map[string]interface{}, error) {
+	rows, err := i.Conn.Query(context.Background(),
+		"SELECT name, type FROM system.columns WHERE database = currentDatabase() AND table = ?", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns := map[string]interface{}{}
+	for rows.Next() {
+		var name, typ string
+		if err := rows.Scan(&name, &typ); err != nil {
+			return nil, err
+		}
+		columns[name] = typ
+	}
+	return columns, rows.Err()
+}
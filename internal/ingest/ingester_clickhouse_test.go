@@ -4,6 +4,7 @@ import (
 	"os"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/hyperbolicresearch/hlog/config"
 	"github.com/hyperbolicresearch/hlog/internal/core"
@@ -34,20 +35,28 @@ func TestTransform(t *testing.T) {
 				Channel:   "testnet",
 				LogId:     "0000-0000-0000-0000-0000",
 				SenderId:  "test-1234",
-				Timestamp: 1709118220916,
+				Timestamp: 1709118220916000000,
 				Level:     "debug",
 				Message:   "lorem ipsum dolor",
 				Data:      map[string]interface{}{"foo": 1, "bar": "helloworld"},
+
+				IngestedAt:     1709118221000000000,
+				KafkaTimestamp: 1709118220950000000,
 			},
 			map[string]interface{}{
 				// metadata
 				"_channel":   "testnet",
 				"_logid":     "0000-0000-0000-0000-0000",
 				"_senderid":  "test-1234",
-				"_timestamp": int64(1709118220916),
+				"_timestamp": int64(1709118220916000000),
 				"_level":     "debug",
 				"_message":   "lorem ipsum dolor",
 				"_data":      map[string]interface{}{"bar": "helloworld", "foo": 1},
+				// times
+				"_time":           time.Unix(0, 1709118220916000000).UTC(),
+				"_ingestedat":     time.Unix(0, 1709118221000000000).UTC(),
+				"_kafkatimestamp": time.Unix(0, 1709118220950000000).UTC(),
+				"_clockskew":      false,
 				// fields
 				"foo": 1,
 				"bar": "helloworld",
@@ -9,23 +9,47 @@ import (
 	"sync"
 	"time"
 
-	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"go.mongodb.org/mongo-driver/mongo"
 
 	"github.com/hyperbolicresearch/hlog/config"
 	"github.com/hyperbolicresearch/hlog/internal/core"
 	"github.com/hyperbolicresearch/hlog/internal/kafkaservice"
 	"github.com/hyperbolicresearch/hlog/internal/mongodb"
+	"github.com/hyperbolicresearch/hlog/internal/parser"
+	"github.com/hyperbolicresearch/hlog/internal/processor"
+	"github.com/hyperbolicresearch/hlog/internal/pubsub"
+	"github.com/hyperbolicresearch/hlog/internal/router"
+	"github.com/hyperbolicresearch/hlog/internal/schema"
 )
 
+// callbackTimeout is how long the ingester waits for the messages
+// produced to the callback topic to be stored.
+const callbackTimeout = 10 * time.Second
+
 type MongoDBIngester struct {
 	sync.RWMutex
 	*mongo.Database
-	*kafkaservice.KafkaWorker
+	Consumer        pubsub.Consumer
+	Producer        pubsub.Producer
 	ConsumeInterval time.Duration
-	// TopicCallback is the Kafka topic to produce to upon successful insertion.
+	// TopicCallback is the topic to produce to upon successful insertion.
 	TopicCallback string
 	CloseChan     chan struct{}
+	// Parsers parse the messages that are not log envelopes.
+	Parsers *parser.Rules
+	// Clock normalizes the timestamps of the logs.
+	Clock *Clock
+	// Processors are the pipelines run on the decoded logs.
+	Processors *processor.Pipelines
+	// Validator validates the logs against the schemas of their channel.
+	// The violations are reported by the ClickHouse ingester only.
+	Validator *schema.Validator
+	// Router decides which logs are stored in MongoDB, and in which
+	// collections.
+	Router *router.Router
+	// Reserved are the collections of hlog in the database, in which no
+	// channel is stored, besides the ones starting with an underscore.
+	Reserved []string
 }
 
 type MongoDBIngesterConfig struct {
@@ -43,40 +67,63 @@ func NewMongoDBIngester(cfg *config.Config) *MongoDBIngester {
 	mongoClient := mongodb.Client(cfg.MongoDB.Server)
 	db := mongoClient.Database(cfg.Database)
 
-	kw, err := kafkaservice.NewKafkaWorker(cfg.Kafka)
+	consumer, err := pubsub.NewConsumer(&cfg.MongoDB.KafkaConfigs)
+	if err != nil {
+		panic(err)
+	}
+	producer, err := pubsub.NewProducer(&cfg.MongoDB.KafkaConfigs)
+	if err != nil {
+		panic(err)
+	}
+	err = consumer.Subscribe(cfg.MongoDB.KafkaTopics)
+	if err != nil {
+		panic(err)
+	}
+	parsers, err := parser.NewRules(cfg.Parsing)
+	if err != nil {
+		panic(err)
+	}
+	clock, err := NewClock(cfg.Timestamps)
 	if err != nil {
 		panic(err)
 	}
-	err = kw.ConfigurePubSub()
+	processors, err := processor.New(cfg.Processing)
 	if err != nil {
 		panic(err)
 	}
-	err = kw.SubscribeTopics(cfg.MongoDB.KafkaTopics)
+	logRouter, err := router.New(cfg.Routing)
 	if err != nil {
 		panic(err)
 	}
+	var validator *schema.Validator
+	if cfg.Validation != nil {
+		validator, err = schema.NewValidator(cfg.Validation, schema.NewStore(db, cfg.Validation))
+		if err != nil {
+			panic(err)
+		}
+	}
 	m := &MongoDBIngester{
 		ConsumeInterval: cfg.MongoDB.ConsumeInterval,
 		Database:        db,
-		KafkaWorker:     kw,
+		Consumer:        consumer,
+		Producer:        producer,
 		TopicCallback:   cfg.MongoDB.TopicCallback,
 		CloseChan:       make(chan struct{}, 1),
+		Parsers:         parsers,
+		Clock:           clock,
+		Processors:      processors,
+		Validator:       validator,
+		Router:          logRouter,
+	}
+	if cfg.Validation != nil {
+		m.Reserved = []string{cfg.Validation.Collection, cfg.Validation.ReportCollection}
 	}
 	return m
 }
 
 // Start spins up everything and starts listening for incoming
-// events from Kafka, and gets ready to sink them to the database.
+// events from the topics, and gets ready to sink them to the database.
 func (m *MongoDBIngester) Start(stop chan os.Signal) {
-	m.RLock()
-	kw := m.KafkaWorker
-	m.RUnlock()
-
-	// we can only start a if it's a consumer
-	if !kw.IsConsumer {
-		return
-	}
-
 	run := true
 	for run {
 		select {
@@ -88,53 +135,105 @@ func (m *MongoDBIngester) Start(stop chan os.Signal) {
 	}
 }
 
+// Stop stops consuming, and stores the logs the processors still hold.
 func (m *MongoDBIngester) Stop() error {
 	m.CloseChan <- struct{}{}
-	return nil
+	return m.storeReleased(m.Processors.Close())
 }
 
 func (m *MongoDBIngester) Consume() error {
 	m.RLock()
 	ci := m.ConsumeInterval
 	m.RUnlock()
-	ev, err := m.KafkaWorker.Consumer.ReadMessage(ci)
+	m.Processors.LogStats()
+	if err := m.storeReleased(m.Processors.Flush()); err != nil {
+		return err
+	}
+	ev, err := m.Consumer.Read(ci)
 	if err != nil {
 		return nil
 	}
-	go m.Sink(ev)
+	go func() {
+		if err := m.Sink(ev); err != nil {
+			log.Printf("ingester: %v", err)
+		}
+	}()
 
-	return err
+	return nil
 }
 
-func (m *MongoDBIngester) Sink(msg *kafka.Message) error {
-	var value core.Log
-	if err := json.Unmarshal(msg.Value, &value); err != nil {
+// storeReleased stores the logs released by the processors, such as the
+// ones standing for repeated logs, on their own.
+func (m *MongoDBIngester) storeReleased(logs []*core.Log) error {
+	for _, l := range logs {
+		m.Validator.Validate(l)
+		for _, routed := range route(m.Router, m.Validator, l, router.MongoDB) {
+			if err := m.insert(routed, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *MongoDBIngester) Sink(msg *pubsub.Message) error {
+	value, err := DecodeMessage(msg, m.Parsers, m.Clock)
+	if err != nil {
 		fmt.Printf("Error unmarshalling value %v", err)
+		return err
+	}
+	if !m.Processors.Process(value) {
+		return nil
 	}
+	invalid := m.Validator.Validate(value)
+	for _, l := range route(m.Router, m.Validator, value, router.MongoDB) {
+		// The message is produced as is to the callback topic unless the
+		// log was routed to another channel or did not validate.
+		raw := msg.Value
+		if l.Channel != msg.Topic || invalid {
+			raw = nil
+		}
+		if err := m.insert(l, raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+// insert stores a log, and produces raw, or the log when raw is nil, to
+// m.TopicCallback.
+func (m *MongoDBIngester) insert(value *core.Log, raw []byte) error {
+	if ReservedChannel(value.Channel, m.Reserved...) {
+		log.Printf("ingester: not storing the log %s of the reserved channel %q", value.LogId, value.Channel)
+		return nil
+	}
+	// Every channel is stored in its own collection, created by MongoDB
+	// on the first insertion.
 	m.Lock()
-	col := m.Database.Collection(*msg.TopicPartition.Topic)
+	col := m.Database.Collection(value.Channel)
 	defer m.Unlock()
 	_, err := col.InsertOne(context.TODO(), value)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("inserting the log %s into %s: %v", value.LogId, value.Channel, err)
 	}
 
 	// Produce to m.TopicCallback if any.
 	// TODO : Probably export to a separate function ???
 	if m.TopicCallback != "" {
-		m.KafkaWorker.Lock()
-		m.KafkaWorker.Producer.Produce(&kafka.Message{
-			TopicPartition: kafka.TopicPartition{
-				Topic:     &m.TopicCallback,
-				Partition: kafka.PartitionAny},
-			Value: []byte(msg.Value),
-		}, nil)
-		m.KafkaWorker.Unlock()
+		if raw == nil {
+			raw, _ = json.Marshal(value)
+		}
+		errs := m.Producer.Produce([]*pubsub.Message{{
+			Topic: m.TopicCallback,
+			Value: raw,
+		}}, callbackTimeout)
+		if errs[0] != nil {
+			log.Printf("Failed to produce to %v: %v", m.TopicCallback, errs[0])
+		}
 	}
 
 	log.Printf("Successfully processed log from topic: %-10v Message: %v\n",
-		*msg.TopicPartition.Topic, value.Message)
+		value.Channel, value.Message)
 
 	return nil
 }
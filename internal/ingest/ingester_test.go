@@ -0,0 +1,53 @@
+package ingest
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hyperbolicresearch/hlog/config"
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/internal/router"
+	"github.com/hyperbolicresearch/hlog/internal/schema"
+)
+
+func TestRouteQuarantine(t *testing.T) {
+	v, err := schema.NewValidator(&config.DefaultValidationConfig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := schema.New("orders", schema.Enforce, `{"required": ["order_id"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.Set([]*schema.Schema{s})
+	rt, err := router.New(&config.Routing{Rules: []config.RouteRule{
+		{If: &config.ProcessorCondition{Field: "level", Equals: []string{"fatal"}},
+			Route: config.Route{Copies: []string{"incidents"}}, Continue: true},
+		{Route: config.Route{Channel: "all"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		data   map[string]interface{}
+		expect []string
+	}{
+		{"valid", map[string]interface{}{"order_id": "o-1"}, []string{"all", "incidents"}},
+		{"quarantined", map[string]interface{}{}, []string{config.DefaultValidationConfig.QuarantineChannel}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &core.Log{Channel: "orders", Level: "fatal", Data: tt.data}
+			v.Validate(l)
+			var got []string
+			for _, routed := range route(rt, v, l, router.ClickHouse) {
+				got = append(got, routed.Channel)
+			}
+			if !reflect.DeepEqual(got, tt.expect) {
+				t.Errorf("Expected=%v, Got=%v", tt.expect, got)
+			}
+		})
+	}
+}
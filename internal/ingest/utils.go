@@ -2,11 +2,15 @@ package ingest
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"log"
 	"sort"
 	"strings"
 
 	"github.com/hyperbolicresearch/hlog/internal/clickhouseservice"
+	"github.com/hyperbolicresearch/hlog/internal/core"
 )
 
 // GetStorableData gets a list of transformed messages and return only a list
@@ -44,15 +48,81 @@ func GetDataByChannel(data []map[string]interface{}) map[string][]map[string]int
 
 type Values string
 
+// storable returns the logs whose channel is not reserved, logging the
+// others.
+func storable(logs []*core.Log, reserved []string) []*core.Log {
+	kept := logs[:0]
+	for _, l := range logs {
+		if ReservedChannel(l.Channel, reserved...) {
+			log.Printf("ingester: not storing the log %s of the reserved channel %q", l.LogId, l.Channel)
+			continue
+		}
+		kept = append(kept, l)
+	}
+	return kept
+}
+
+// ReservedChannel reports whether the logs of channel cannot be stored
+// under its name, kept for the collections and tables of hlog: the names
+// starting with an underscore, such as _sqlschemas, and the ones of
+// internal.
+func ReservedChannel(channel string, internal ...string) bool {
+	if strings.HasPrefix(channel, "_") {
+		return true
+	}
+	for _, name := range internal {
+		if channel == name {
+			return true
+		}
+	}
+	return false
+}
+
+// TableName maps a channel to the name of its ClickHouse table. Channels
+// are named after Kafka topics, which may contain characters (., -) that
+// would be misread in SQL. The channels made of letters, digits and
+// underscores only, not starting with a digit, keep their name. In the
+// others, anything else becomes an underscore, and a hash of the channel
+// is appended so that a.b and a_b do not share a table. These names start
+// with an underscore, which the channels cannot (see ReservedChannel).
+func TableName(channel string) string {
+	table := []rune(channel)
+	lossy := len(table) == 0 || (table[0] >= '0' && table[0] <= '9')
+	for i, r := range table {
+		isLetter := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+		isDigit := r >= '0' && r <= '9'
+		if !isLetter && !isDigit && r != '_' {
+			table[i] = '_'
+			lossy = true
+		}
+	}
+	if !lossy {
+		return channel
+	}
+	sum := sha256.Sum256([]byte(channel))
+	return "_" + string(table) + "_" + hex.EncodeToString(sum[:4])
+}
+
+// metadataTypes are the types of the metadata columns that are not left
+// to ClickHouse to infer: the logid, which the tables are sorted by and
+// every log has by design, so it should not be nullable, and the times,
+// stored with the precision of their source.
+var metadataTypes = map[string]string{
+	"_logid":          "String",
+	"_time":           "DateTime64(9)",
+	"_ingestedat":     "DateTime64(9)",
+	"_kafkatimestamp": "DateTime64(3)",
+}
+
 // GenerateSQLAndApply generates the SQL query for either creating or altering the
 // Clickhouse schema for a given table and makes the given changes to the database.
 func GenerateSQLAndApply(schema map[string]interface{}, table string, isAlter bool) error {
 	var _sql string
 	switch isAlter {
 	case true:
-		_sql += fmt.Sprintf("ALTER TABLE %s ADD COLUMN (\n", table)
+		_sql += fmt.Sprintf("ALTER TABLE `%s` ADD COLUMN (\n", table)
 	case false:
-		_sql += fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n", table)
+		_sql += fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s` (\n", table)
 	}
 
 	_, keys, _, err := SortMap(schema)
@@ -62,12 +132,10 @@ func GenerateSQLAndApply(schema map[string]interface{}, table string, isAlter bo
 	for i := 0; i < len(keys); i++ {
 		key := keys[i]
 		value := schema[key]
-		newLine := fmt.Sprintf("  `%s` %s,\n", key, value)
-		// we will sort by logid, so it should not be nullable. indeed,
-		// all log is required by design to have a logid.
-		if key == "_logid" {
-			newLine = fmt.Sprintf("  `%s` String,\n", key)
+		if t, ok := metadataTypes[key]; ok {
+			value = t
 		}
+		newLine := fmt.Sprintf("  `%s` %s,\n", key, value)
 		_sql += newLine
 	}
 	_sql += ")"
@@ -0,0 +1,45 @@
+package ingest
+
+import "testing"
+
+func TestTableName(t *testing.T) {
+	tests := []struct {
+		channel string
+		expect  string
+	}{
+		{"default", "default"},
+		{"api_v2", "api_v2"},
+		{"hlog.payments-eu", "_hlog_payments_eu_20edc3eb"},
+		{"2024.audit", "_2024_audit_ac64171a"},
+		{"a.b", "_a_b_2e7336dc"},
+		{"a-b", "_a_b_d44362d6"},
+		{"", "__e3b0c442"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.channel, func(t *testing.T) {
+			if got := TableName(tt.channel); got != tt.expect {
+				t.Errorf("Expected=%v, Got=%v", tt.expect, got)
+			}
+		})
+	}
+}
+
+func TestReservedChannel(t *testing.T) {
+	tests := []struct {
+		channel string
+		expect  bool
+	}{
+		{"default", false},
+		{"api_v2", false},
+		{"_schemas", true},
+		{"_sqlschemas", true},
+		{"schemas", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.channel, func(t *testing.T) {
+			if got := ReservedChannel(tt.channel, "schemas"); got != tt.expect {
+				t.Errorf("Expected=%v, Got=%v", tt.expect, got)
+			}
+		})
+	}
+}
@@ -3,8 +3,10 @@ package kafkaservice
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
+
 	"github.com/hyperbolicresearch/hlog/config"
 )
 
@@ -19,9 +21,10 @@ type KafkaWorker struct {
 	sync.RWMutex
 	*kafka.Consumer
 	*kafka.Producer
-	Configs    *config.Kafka
-	IsConsumer bool
-	IsProducer bool
+	Configs     *config.Kafka
+	TopicFilter *TopicFilter
+	IsConsumer  bool
+	IsProducer  bool
 }
 
 type KafkaConfigs struct {
@@ -34,8 +37,13 @@ type KafkaConfigs struct {
 // NewKafkaWorker creates a new KafkaWorker and returns it
 // with an error message.
 func NewKafkaWorker(cfg *config.Kafka) (*KafkaWorker, error) {
+	tf, err := NewTopicFilter(cfg.AllowTopics, cfg.DenyTopics)
+	if err != nil {
+		return nil, err
+	}
 	w := &KafkaWorker{
-		Configs: cfg,
+		Configs:     cfg,
+		TopicFilter: tf,
 	}
 	return w, nil
 }
@@ -60,6 +68,9 @@ func (k *KafkaWorker) ConfigureConsumer() error {
 		"auto.offset.reset":  k.Configs.AutoOffsetReset,
 		"enable.auto.commit": k.Configs.EnableAutoCommit,
 	}
+	if k.Configs.MetadataRefreshInterval > 0 {
+		cfg["topic.metadata.refresh.interval.ms"] = int(k.Configs.MetadataRefreshInterval.Milliseconds())
+	}
 	consumer, err := kafka.NewConsumer(&cfg)
 	if err != nil {
 		return fmt.Errorf("failed to create consumer: %v", err)
@@ -87,7 +98,9 @@ func (k *KafkaWorker) ConfigureProducer() error {
 	return nil
 }
 
-// SubscribeTopics subscribes to a given list of topics for consuming
+// SubscribeTopics subscribes to a given list of topics for consuming.
+// Topics starting with ^ are regular expressions: every existing and
+// future topic matching them is consumed.
 func (k *KafkaWorker) SubscribeTopics(topics []string) error {
 	err := k.Consumer.SubscribeTopics(topics, nil)
 	if err != nil {
@@ -96,3 +109,17 @@ func (k *KafkaWorker) SubscribeTopics(topics []string) error {
 	}
 	return nil
 }
+
+// ReadMessage reads the next message of a topic allowed by the topic
+// filter. The messages of the other topics are skipped, in which case
+// ErrTopicDenied is returned so that the caller can move on.
+func (k *KafkaWorker) ReadMessage(timeout time.Duration) (*kafka.Message, error) {
+	msg, err := k.Consumer.ReadMessage(timeout)
+	if err != nil {
+		return nil, err
+	}
+	if k.TopicFilter != nil && !k.TopicFilter.Allows(*msg.TopicPartition.Topic) {
+		return nil, ErrTopicDenied
+	}
+	return msg, nil
+}
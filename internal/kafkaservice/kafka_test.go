@@ -1 +1,30 @@
-package kafkaservice
\ No newline at end of file
+package kafkaservice
+
+import (
+	"testing"
+)
+
+func TestTopicFilter(t *testing.T) {
+	f, err := NewTopicFilter([]string{`^hlog\.`}, []string{`^hlog\.internal$`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		topic  string
+		expect bool
+	}{
+		{"hlog.payments", true},
+		{"hlog.internal", false},
+		{"payments", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.topic, func(t *testing.T) {
+			if got := f.Allows(tt.topic); got != tt.expect {
+				t.Errorf("Expected=%v, Got=%v", tt.expect, got)
+			}
+		})
+	}
+	if _, err := NewTopicFilter([]string{"("}, nil); err == nil {
+		t.Error("Expected an error for an invalid expression")
+	}
+}
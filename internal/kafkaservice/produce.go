@@ -0,0 +1,54 @@
+package kafkaservice
+
+import (
+	"errors"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// ErrProduceTimeout is reported for the messages that were not
+// acknowledged by the broker in time.
+var ErrProduceTimeout = errors.New("timed out waiting for the broker")
+
+// ProduceMessages produces the messages and waits at most timeout for
+// the broker to acknowledge them. It returns one error per message, nil
+// for the ones produced, whose offset is then set.
+func (k *KafkaWorker) ProduceMessages(msgs []*kafka.Message, timeout time.Duration) []error {
+	errs := make([]error, len(msgs))
+	deliveries := make(chan kafka.Event, len(msgs))
+	pending := 0
+	for i, msg := range msgs {
+		msg.Opaque = i
+		if err := k.Producer.Produce(msg, deliveries); err != nil {
+			errs[i] = err
+			continue
+		}
+		pending++
+	}
+
+	acked := make([]bool, len(msgs))
+	deadline := time.After(timeout)
+	for pending > 0 {
+		select {
+		case e := <-deliveries:
+			msg, ok := e.(*kafka.Message)
+			if !ok {
+				continue
+			}
+			i := msg.Opaque.(int)
+			acked[i] = true
+			errs[i] = msg.TopicPartition.Error
+			msgs[i].TopicPartition = msg.TopicPartition
+			pending--
+		case <-deadline:
+			for i := range msgs {
+				if errs[i] == nil && !acked[i] {
+					errs[i] = ErrProduceTimeout
+				}
+			}
+			return errs
+		}
+	}
+	return errs
+}
@@ -0,0 +1,55 @@
+package kafkaservice
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// ErrTopicDenied is returned when reading a message from a topic that
+// is excluded by the topic filter.
+var ErrTopicDenied = errors.New("topic denied by the topic filter")
+
+// TopicFilter decides which of the subscribed topics are consumed.
+type TopicFilter struct {
+	allow []*regexp.Regexp
+	deny  []*regexp.Regexp
+}
+
+// NewTopicFilter compiles the allow and deny lists of regular expressions.
+func NewTopicFilter(allow, deny []string) (*TopicFilter, error) {
+	f := &TopicFilter{}
+	for _, expr := range allow {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed topic %q: %v", expr, err)
+		}
+		f.allow = append(f.allow, re)
+	}
+	for _, expr := range deny {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid denied topic %q: %v", expr, err)
+		}
+		f.deny = append(f.deny, re)
+	}
+	return f, nil
+}
+
+// Allows reports whether the topic should be consumed.
+func (f *TopicFilter) Allows(topic string) bool {
+	for _, re := range f.deny {
+		if re.MatchString(topic) {
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, re := range f.allow {
+		if re.MatchString(topic) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,234 @@
+package livetail
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/hyperbolicresearch/hlog/internal/filter"
+)
+
+// Client follows the logs of a remote livetail server. It reconnects
+// on failure and resumes from the last event it received, so a short
+// outage does not lose any log still in the server's replay buffer.
+type Client struct {
+	// URL is the address of the server. ws:// and wss:// URLs use the
+	// websocket endpoint, http:// and https:// ones use server-sent events.
+	// A URL without path gets the default endpoint of its scheme.
+	URL    string
+	Filter filter.Filter
+	// OnEvent is called for every received event.
+	OnEvent func(Event) error
+	// MinBackoff and MaxBackoff bound the wait between reconnections.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	lastId uint64
+}
+
+// Run follows the server until a signal is received on stop, or until
+// OnEvent returns an error.
+func (c *Client) Run(stop chan os.Signal) error {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return fmt.Errorf("invalid url %v: %v", c.URL, err)
+	}
+	var follow func(u *url.URL, done <-chan struct{}) error
+	switch u.Scheme {
+	case "ws", "wss":
+		follow = c.followWebsocket
+		if u.Path == "" || u.Path == "/" {
+			u.Path = WebsocketPath
+		}
+	case "http", "https":
+		follow = c.followSSE
+		if u.Path == "" || u.Path == "/" {
+			u.Path = SSEPath
+		}
+	default:
+		return fmt.Errorf("unsupported scheme %q, expected ws, wss, http or https", u.Scheme)
+	}
+	if c.MinBackoff <= 0 {
+		c.MinBackoff = time.Duration(500) * time.Millisecond
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = time.Duration(30) * time.Second
+	}
+
+	done := make(chan struct{})
+	errc := make(chan error, 1)
+	go func() {
+		backoff := c.MinBackoff
+		for {
+			start := time.Now()
+			err := follow(u, done)
+			if _, ok := err.(*handlerError); ok {
+				errc <- err
+				return
+			}
+			select {
+			case <-done:
+				return
+			default:
+			}
+			// A connection that stayed up for a while was healthy, so
+			// we start over with a short wait.
+			if time.Since(start) > c.MaxBackoff {
+				backoff = c.MinBackoff
+			}
+			log.Printf("connection to %v lost: %v, reconnecting in %v", u, err, backoff)
+			select {
+			case <-done:
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > c.MaxBackoff {
+				backoff = c.MaxBackoff
+			}
+		}
+	}()
+
+	select {
+	case <-stop:
+		close(done)
+		return nil
+	case err := <-errc:
+		close(done)
+		return err.(*handlerError).err
+	}
+}
+
+// closeWhenDone closes c when done is closed, which interrupts the
+// connection in progress, until the function returned is called when the
+// connection ends.
+func closeWhenDone(done <-chan struct{}, c io.Closer) func() {
+	closed := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+			c.Close()
+		case <-closed:
+		}
+	}()
+	return func() { close(closed) }
+}
+
+// handlerError wraps the errors returned by OnEvent, which stop the
+// client instead of causing a reconnection.
+type handlerError struct {
+	err error
+}
+
+func (e *handlerError) Error() string {
+	return e.err.Error()
+}
+
+func (c *Client) handle(ev Event) error {
+	c.lastId = ev.Id
+	if err := c.OnEvent(ev); err != nil {
+		return &handlerError{err}
+	}
+	return nil
+}
+
+func (c *Client) followWebsocket(u *url.URL, done <-chan struct{}) error {
+	origin := &url.URL{Scheme: "http", Host: u.Host}
+	if u.Scheme == "wss" {
+		origin.Scheme = "https"
+	}
+	ws, err := websocket.Dial(u.String(), "", origin.String())
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+	defer closeWhenDone(done, ws)()
+
+	req := Request{Type: "subscribe", Filter: c.Filter, After: c.lastId}
+	if err := websocket.JSON.Send(ws, req); err != nil {
+		return err
+	}
+	for {
+		var ev Event
+		if err := websocket.JSON.Receive(ws, &ev); err != nil {
+			return err
+		}
+		if err := c.handle(ev); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Client) followSSE(u *url.URL, done <-chan struct{}) error {
+	target := *u
+	q := Query(c.Filter)
+	for k, v := range target.Query() {
+		q[k] = v
+	}
+	target.RawQuery = q.Encode()
+	req, err := http.NewRequest(http.MethodGet, target.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if c.lastId > 0 {
+		req.Header.Set("Last-Event-ID", strconv.FormatUint(c.lastId, 10))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	defer closeWhenDone(done, resp.Body)()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %v", resp.Status)
+	}
+
+	// We only care about the id and data fields of the events, comments
+	// (heartbeats) and other fields are ignored.
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var ev Event
+	var data strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if data.Len() == 0 {
+				continue
+			}
+			if err := json.Unmarshal([]byte(data.String()), &ev.Log); err != nil {
+				return fmt.Errorf("invalid event: %v", err)
+			}
+			if err := c.handle(ev); err != nil {
+				return err
+			}
+			ev = Event{}
+			data.Reset()
+		case strings.HasPrefix(line, "id:"):
+			id, err := strconv.ParseUint(strings.TrimSpace(line[3:]), 10, 64)
+			if err == nil {
+				ev.Id = id
+			}
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(line[5:], " "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("stream closed by the server")
+}
@@ -0,0 +1,146 @@
+package livetail
+
+import (
+	"sync"
+
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/internal/filter"
+)
+
+// Event is a log as delivered to the remote observers. Id increases by
+// one for every published log and is what clients send back to resume
+// after a disconnection.
+type Event struct {
+	Id  uint64   `json:"id"`
+	Log core.Log `json:"log"`
+}
+
+// Hub fans out the logs to the subscribers interested in them and keeps
+// the most recent ones to be replayed to reconnecting subscribers.
+type Hub struct {
+	sync.RWMutex
+	seq uint64
+	// replay is a ring of the last published events: the oldest is at
+	// head and there are count of them.
+	replay      []Event
+	head        int
+	count       int
+	subscribers map[*Subscription]struct{}
+}
+
+// Subscription is the stream of events received by one observer.
+type Subscription struct {
+	sync.Mutex
+	hub    *Hub
+	filter filter.Filter
+	events chan Event
+	closed bool
+}
+
+// NewHub creates a Hub keeping the last replaySize logs for resuming.
+func NewHub(replaySize int) *Hub {
+	if replaySize < 0 {
+		replaySize = 0
+	}
+	return &Hub{
+		replay:      make([]Event, replaySize),
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish sends the log to every subscriber whose filter matches it.
+func (h *Hub) Publish(l core.Log) {
+	h.Lock()
+	defer h.Unlock()
+	h.seq++
+	ev := Event{Id: h.seq, Log: l}
+	if size := len(h.replay); size > 0 {
+		if h.count < size {
+			h.replay[(h.head+h.count)%size] = ev
+			h.count++
+		} else {
+			h.replay[h.head] = ev
+			h.head = (h.head + 1) % size
+		}
+	}
+	for s := range h.subscribers {
+		s.send(ev)
+	}
+}
+
+// Subscribe registers a new subscription. The buffered logs published
+// after the event `after` and matching f are delivered first, so that a
+// client reconnecting with the id of the last event it saw misses
+// nothing as long as it is still in the replay buffer. An after of 0
+// only subscribes to the new logs.
+func (h *Hub) Subscribe(f filter.Filter, after uint64) *Subscription {
+	h.Lock()
+	defer h.Unlock()
+	s := &Subscription{
+		hub:    h,
+		filter: f,
+		events: make(chan Event, h.count+256),
+	}
+	if after > 0 {
+		// An id from the future means that we restarted since the client
+		// last saw us, in which case everything we have is new to it.
+		if after > h.seq {
+			after = 0
+		}
+		for i := 0; i < h.count; i++ {
+			if ev := h.replay[(h.head+i)%len(h.replay)]; ev.Id > after {
+				s.send(ev)
+			}
+		}
+	}
+	h.subscribers[s] = struct{}{}
+	return s
+}
+
+// Events returns the channel on which the events are delivered. It is
+// closed when the subscription ends.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// SetFilter replaces the filter of the subscription.
+func (s *Subscription) SetFilter(f filter.Filter) {
+	s.Lock()
+	s.filter = f
+	s.Unlock()
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.hub.Lock()
+	delete(s.hub.subscribers, s)
+	s.hub.Unlock()
+	s.close()
+}
+
+func (s *Subscription) close() {
+	s.Lock()
+	defer s.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.events)
+	}
+}
+
+// send delivers ev if it matches the filter. A subscriber that cannot
+// keep up is disconnected rather than slowing down everybody else; it
+// can resume from the replay buffer. The caller must hold the hub lock.
+func (s *Subscription) send(ev Event) {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed || !s.filter.Match(&ev.Log) {
+		return
+	}
+	select {
+	case s.events <- ev:
+	default:
+		s.closed = true
+		close(s.events)
+		delete(s.hub.subscribers, s)
+	}
+}
@@ -0,0 +1,146 @@
+package livetail
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/internal/filter"
+)
+
+func TestHubReplay(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int
+		published int
+		after     uint64
+		expect    []uint64
+	}{
+		{"New subscriber", 3, 5, 0, nil},
+		{"Resume inside the buffer", 3, 5, 3, []uint64{4, 5}},
+		{"Resume before the buffer", 3, 5, 1, []uint64{3, 4, 5}},
+		{"Resume after a restart", 3, 5, 42, []uint64{3, 4, 5}},
+		{"Buffer not full", 3, 2, 1, []uint64{2}},
+		{"Buffer wrapped twice", 3, 7, 1, []uint64{5, 6, 7}},
+		{"No buffer", 0, 5, 1, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := NewHub(tt.size)
+			for i := 1; i <= tt.published; i++ {
+				hub.Publish(core.Log{Message: fmt.Sprint(i), Level: "info"})
+			}
+			sub := hub.Subscribe(filter.Filter{}, tt.after)
+			defer sub.Close()
+			var got []uint64
+			for len(sub.Events()) > 0 {
+				got = append(got, (<-sub.Events()).Id)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.expect) {
+				t.Errorf("Expected=%v, Got=%v", tt.expect, got)
+			}
+		})
+	}
+}
+
+func TestClientResume(t *testing.T) {
+	for _, scheme := range []string{"http", "ws"} {
+		t.Run(scheme, func(t *testing.T) {
+			hub := NewHub(100)
+			mux := http.NewServeMux()
+			hub.Register(mux)
+			srv := httptest.NewServer(mux)
+			defer srv.Close()
+
+			received := make(chan Event, 10)
+			client := &Client{
+				URL:        strings.Replace(srv.URL, "http", scheme, 1),
+				Filter:     filter.Filter{Level: "warn"},
+				MinBackoff: time.Millisecond,
+				OnEvent: func(ev Event) error {
+					received <- ev
+					return nil
+				},
+			}
+			stop := make(chan os.Signal, 1)
+			go client.Run(stop)
+			defer func() { stop <- os.Interrupt }()
+
+			waitSubscribers(t, hub, 1)
+			hub.Publish(core.Log{Level: "info", Message: "filtered out"})
+			hub.Publish(core.Log{Level: "error", Message: "first"})
+			if ev := <-received; ev.Log.Message != "first" {
+				t.Errorf("Expected=first, Got=%v", ev.Log.Message)
+			}
+
+			// We drop every connection; what is published meanwhile must
+			// be replayed on reconnection.
+			srv.CloseClientConnections()
+			hub.Lock()
+			for s := range hub.subscribers {
+				delete(hub.subscribers, s)
+				s.close()
+			}
+			hub.Unlock()
+			hub.Publish(core.Log{Level: "error", Message: "second"})
+			select {
+			case ev := <-received:
+				if ev.Log.Message != "second" || ev.Id != 3 {
+					t.Errorf("Expected=second (3), Got=%v (%v)", ev.Log.Message, ev.Id)
+				}
+			case <-time.After(5 * time.Second):
+				t.Error("the client did not resume")
+			}
+		})
+	}
+}
+
+func waitSubscribers(t *testing.T, hub *Hub, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		hub.RLock()
+		count := len(hub.subscribers)
+		hub.RUnlock()
+		if count == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Expected=%v subscribers before the deadline", n)
+}
+
+type closeCounter struct{ closed chan struct{} }
+
+func (c *closeCounter) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func TestCloseWhenDone(t *testing.T) {
+	done := make(chan struct{})
+	// A connection ending on its own is not closed again, and its
+	// watcher is gone when done is closed later.
+	ended := &closeCounter{closed: make(chan struct{})}
+	closeWhenDone(done, ended)()
+	time.Sleep(10 * time.Millisecond)
+	// A connection in progress is closed when done is closed.
+	running := &closeCounter{closed: make(chan struct{})}
+	stop := closeWhenDone(done, running)
+	defer stop()
+	close(done)
+	select {
+	case <-running.closed:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the running connection to be closed")
+	}
+	select {
+	case <-ended.closed:
+		t.Error("Expected the ended connection not to be closed")
+	default:
+	}
+}
@@ -0,0 +1,188 @@
+package livetail
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/hyperbolicresearch/hlog/internal/filter"
+)
+
+const (
+	// SSEPath is the endpoint streaming the logs as server-sent events.
+	SSEPath = "/v1/tail"
+	// WebsocketPath is the endpoint streaming the logs over a websocket.
+	WebsocketPath = "/v1/tail/ws"
+
+	heartbeatInterval = time.Duration(15) * time.Second
+)
+
+// Request is the message sent by websocket clients to subscribe, or to
+// change the filter of their subscription.
+type Request struct {
+	Type   string        `json:"type"`
+	Filter filter.Filter `json:"filter"`
+	After  uint64        `json:"after"`
+}
+
+// Register adds the SSE and websocket endpoints of the hub to mux.
+func (h *Hub) Register(mux *http.ServeMux) {
+	mux.HandleFunc(SSEPath, h.ServeSSE)
+	mux.Handle(WebsocketPath, websocket.Handler(h.ServeWebsocket))
+}
+
+// ServeSSE streams the logs as server-sent events. The filter is given
+// in the query (level, channel, sender and field=key=value, the last
+// three being repeatable) and a reconnecting client resumes with the
+// standard Last-Event-ID header or the `after` query parameter.
+func (h *Hub) ServeSSE(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	f, err := FilterFromQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	after := r.Header.Get("Last-Event-ID")
+	if after == "" {
+		after = r.URL.Query().Get("after")
+	}
+	var afterId uint64
+	if after != "" {
+		afterId, err = strconv.ParseUint(after, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid event id", http.StatusBadRequest)
+			return
+		}
+	}
+
+	sub := h.Subscribe(f, afterId)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case ev, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(ev.Log)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\nevent: log\ndata: %s\n\n", ev.Id, data)
+			flusher.Flush()
+		}
+	}
+}
+
+// ServeWebsocket streams the logs over a websocket. The client sends a
+// Request to subscribe and can send new ones at any time to change its
+// filter; every log is sent back as a JSON encoded Event.
+func (h *Hub) ServeWebsocket(ws *websocket.Conn) {
+	defer ws.Close()
+	var req Request
+	if err := websocket.JSON.Receive(ws, &req); err != nil {
+		return
+	}
+	if err := req.Filter.Validate(); err != nil {
+		websocket.JSON.Send(ws, map[string]string{"error": err.Error()})
+		return
+	}
+	sub := h.Subscribe(req.Filter, req.After)
+	defer sub.Close()
+
+	// Filter updates are read concurrently with the sending of events.
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			var req Request
+			if err := websocket.JSON.Receive(ws, &req); err != nil {
+				return
+			}
+			if err := req.Filter.Validate(); err != nil {
+				continue
+			}
+			sub.SetFilter(req.Filter)
+		}
+	}()
+
+	for {
+		select {
+		case <-gone:
+			return
+		case ev, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			if err := websocket.JSON.Send(ws, ev); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// FilterFromQuery reads a filter from the query parameters of r.
+func FilterFromQuery(r *http.Request) (filter.Filter, error) {
+	q := r.URL.Query()
+	f := filter.Filter{
+		Level: q.Get("level"),
+	}
+	for _, v := range q["channel"] {
+		f.Channels = append(f.Channels, strings.Split(v, ",")...)
+	}
+	for _, v := range q["sender"] {
+		f.Senders = append(f.Senders, strings.Split(v, ",")...)
+	}
+	for _, v := range q["field"] {
+		key, value, ok := strings.Cut(v, "=")
+		if !ok {
+			return filter.Filter{}, fmt.Errorf("invalid field %q, expected key=value", v)
+		}
+		if f.Fields == nil {
+			f.Fields = map[string]string{}
+		}
+		f.Fields[key] = value
+	}
+	return f, f.Validate()
+}
+
+// Query encodes f the way FilterFromQuery reads it.
+func Query(f filter.Filter) url.Values {
+	q := url.Values{}
+	if f.Level != "" {
+		q.Set("level", f.Level)
+	}
+	for _, c := range f.Channels {
+		q.Add("channel", c)
+	}
+	for _, s := range f.Senders {
+		q.Add("sender", s)
+	}
+	for k, v := range f.Fields {
+		q.Add("field", k+"="+v)
+	}
+	return q
+}
@@ -0,0 +1,212 @@
+// Package loki receives the logs pushed with the Loki push API, which
+// lets the shippers having a Loki sink (Promtail, Grafana Agent, Vector)
+// send to hlog unchanged. Both encodings of the API are accepted:
+// snappy-compressed protobuf and JSON.
+package loki
+
+import (
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/klauspost/compress/snappy"
+
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/internal/receiver"
+	"github.com/hyperbolicresearch/hlog/pkg/logger"
+)
+
+// PushPath is the endpoint of the push API.
+const PushPath = "/loki/api/v1/push"
+
+// Options configures a Receiver.
+type Options struct {
+	// ChannelLabel is the stream label holding the channel of the
+	// entries, and DefaultChannel the channel of the streams without it.
+	ChannelLabel   string
+	DefaultChannel string
+	// MaxBodySize is the maximum size of a request body as sent, and
+	// MaxDecodedSize once decompressed.
+	MaxBodySize    int64
+	MaxDecodedSize int64
+}
+
+// DefaultOptions are the values used for the zero fields of Options.
+var DefaultOptions = Options{
+	ChannelLabel:   "job",
+	DefaultChannel: "loki",
+	MaxBodySize:    5 << 20,
+	MaxDecodedSize: 50 << 20,
+}
+
+// Receiver converts the push requests to logs and hands them over to a
+// producer.
+type Receiver struct {
+	producer receiver.Producer
+	opts     Options
+}
+
+// New creates a Receiver producing the logs with p.
+func New(p receiver.Producer, opts Options) *Receiver {
+	if opts.ChannelLabel == "" {
+		opts.ChannelLabel = DefaultOptions.ChannelLabel
+	}
+	if opts.DefaultChannel == "" {
+		opts.DefaultChannel = DefaultOptions.DefaultChannel
+	}
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = DefaultOptions.MaxBodySize
+	}
+	if opts.MaxDecodedSize <= 0 {
+		opts.MaxDecodedSize = DefaultOptions.MaxDecodedSize
+	}
+	return &Receiver{producer: p, opts: opts}
+}
+
+// Register adds the push endpoint to mux.
+func (rc *Receiver) Register(mux *http.ServeMux) {
+	mux.HandleFunc(PushPath, rc.ServeHTTP)
+}
+
+// ServeHTTP implements the push API. It answers 204 once every entry
+// was produced, and 503 when the broker was unavailable, for the client
+// to push again: the ids of the logs being derived from the entries,
+// the ones produced twice are identifiable as duplicates. The invalid
+// entries are reported with a 400, which is not retried.
+func (rc *Receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != "" && contentType != "application/x-protobuf" && contentType != "application/json" {
+		http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+	data, err := receiver.ReadBody(w, r, rc.opts.MaxBodySize, rc.opts.MaxDecodedSize)
+	if err != nil {
+		http.Error(w, err.Error(), receiver.BodyErrorStatus(err))
+		return
+	}
+
+	var streams []Stream
+	if contentType == "application/json" {
+		streams, err = DecodeJSON(data)
+	} else {
+		streams, err = rc.decodeSnappyProto(data)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid push request: %v", err), receiver.BodyErrorStatus(err))
+		return
+	}
+
+	host, _, _ := net.SplitHostPort(r.RemoteAddr)
+	res := receiver.Submit(rc.producer, rc.Convert(streams, host))
+	switch {
+	case res.Unavailable > 0:
+		http.Error(w, res.FirstError().Error(), http.StatusServiceUnavailable)
+	case res.Rejected > 0:
+		http.Error(w, fmt.Sprintf("%d entries rejected: %v", res.Rejected, res.FirstError()), http.StatusBadRequest)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// decodeSnappyProto decompresses and decodes a protobuf push request.
+func (rc *Receiver) decodeSnappyProto(data []byte) ([]Stream, error) {
+	n, err := snappy.DecodedLen(data)
+	if err != nil {
+		return nil, err
+	}
+	if int64(n) > rc.opts.MaxDecodedSize {
+		return nil, receiver.ErrTooLarge
+	}
+	data, err = snappy.Decode(nil, data)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeProto(data)
+}
+
+// Convert turns the entries of the streams into logs. The labels of the
+// streams, which are in the data of the logs, decide their channel,
+// level and sender, host being the sender of the streams without host
+// label. The structured metadata of the entries are in the data, under
+// structured_metadata.
+func (rc *Receiver) Convert(streams []Stream, host string) []*core.Log {
+	var logs []*core.Log
+	for _, s := range streams {
+		channel := s.Labels[rc.opts.ChannelLabel]
+		if !receiver.ValidChannel(channel) {
+			channel = rc.opts.DefaultChannel
+		}
+		sender := host
+		for _, key := range []string{"host", "hostname", "instance"} {
+			if v := s.Labels[key]; v != "" {
+				sender = v
+				break
+			}
+		}
+		key := streamKey(s.Labels)
+		for _, e := range s.Entries {
+			data := make(map[string]interface{}, len(s.Labels)+1)
+			for k, v := range s.Labels {
+				data[k] = v
+			}
+			if len(e.StructuredMetadata) > 0 {
+				md := make(map[string]interface{}, len(e.StructuredMetadata))
+				for k, v := range e.StructuredMetadata {
+					md[k] = v
+				}
+				data["structured_metadata"] = md
+			}
+			id := key + strconv.FormatInt(e.Timestamp.UnixNano(), 10) + "\x00" + e.Line
+			logs = append(logs, &core.Log{
+				Channel:   channel,
+				LogId:     uuid.NewSHA1(uuid.NameSpaceURL, []byte(id)).String(),
+				SenderId:  sender,
+				Timestamp: e.Timestamp.UnixNano(),
+				Level:     entryLevel(s.Labels, e.StructuredMetadata).String(),
+				Message:   e.Line,
+				Data:      data,
+			})
+		}
+	}
+	return logs
+}
+
+// entryLevel reads the level of an entry from the level label of its
+// structured metadata or stream, info being the default.
+func entryLevel(labels, metadata map[string]string) logger.Level {
+	for _, m := range []map[string]string{metadata, labels} {
+		for _, key := range []string{"level", "detected_level", "severity"} {
+			if level, ok := logger.ParseLevel(m[key]); ok {
+				return level
+			}
+		}
+	}
+	return logger.INFO
+}
+
+// streamKey identifies a stream by its sorted labels, like Loki does.
+func streamKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, k := range names {
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(labels[k])
+		b.WriteByte(0)
+	}
+	return b.String()
+}
@@ -0,0 +1,200 @@
+package loki
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/internal/receiver/receivertest"
+)
+
+func appendMessage(b []byte, num protowire.Number, m []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
+
+// pushRequest encodes a push request of one stream with protobuf.
+func pushRequest(labels string, entries ...Entry) []byte {
+	var stream []byte
+	stream = appendMessage(stream, 1, []byte(labels))
+	for _, e := range entries {
+		var ts, entry []byte
+		ts = protowire.AppendTag(ts, 1, protowire.VarintType)
+		ts = protowire.AppendVarint(ts, uint64(e.Timestamp.Unix()))
+		ts = protowire.AppendTag(ts, 2, protowire.VarintType)
+		ts = protowire.AppendVarint(ts, uint64(e.Timestamp.Nanosecond()))
+		entry = appendMessage(entry, 1, ts)
+		entry = appendMessage(entry, 2, []byte(e.Line))
+		for k, v := range e.StructuredMetadata {
+			var pair []byte
+			pair = appendMessage(pair, 1, []byte(k))
+			pair = appendMessage(pair, 2, []byte(v))
+			entry = appendMessage(entry, 3, pair)
+		}
+		stream = appendMessage(stream, 2, entry)
+	}
+	stream = protowire.AppendTag(stream, 3, protowire.VarintType)
+	stream = protowire.AppendVarint(stream, 42)
+	return appendMessage(nil, 1, stream)
+}
+
+func TestDecode(t *testing.T) {
+	ts := time.Unix(1700000000, 123456789)
+	expect := []Stream{{
+		Labels: map[string]string{"job": "api", "level": "warn"},
+		Entries: []Entry{
+			{Timestamp: ts, Line: "slow request", StructuredMetadata: map[string]string{"trace_id": "abc"}},
+			{Timestamp: ts.Add(time.Nanosecond), Line: "second"},
+		},
+	}}
+	got, err := DecodeProto(pushRequest(`{job="api", level="warn"}`, expect[0].Entries...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("Expected=%+v, Got=%+v", expect, got)
+	}
+	got, err = DecodeJSON([]byte(`{"streams": [{"stream": {"job": "api", "level": "warn"}, "values": [
+		["1700000000123456789", "slow request", {"trace_id": "abc"}],
+		["1700000000123456790", "second"]
+	]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("Expected=%+v, Got=%+v", expect, got)
+	}
+	for _, body := range []string{
+		`{"streams": [{"stream": {}, "values": [["soon", "x"]]}]}`,
+		`{"streams": [{"stream": {}, "values": [["1"]]}]}`,
+	} {
+		if _, err := DecodeJSON([]byte(body)); err == nil {
+			t.Errorf("Expected an error for %s", body)
+		}
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect map[string]string
+		err    bool
+	}{
+		{`{}`, map[string]string{}, false},
+		{`{job="api"}`, map[string]string{"job": "api"}, false},
+		{`{ job="api" , path="C:\\logs", msg="say \"hi\", bye" }`, map[string]string{"job": "api", "path": `C:\logs`, "msg": `say "hi", bye`}, false},
+		{`job="api"`, nil, true},
+		{`{job=api}`, nil, true},
+		{`{job="api" env="x"}`, nil, true},
+		{`{job="api}`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseLabels(tt.input)
+			if (err != nil) != tt.err {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !tt.err && !reflect.DeepEqual(got, tt.expect) {
+				t.Errorf("Expected=%v, Got=%v", tt.expect, got)
+			}
+		})
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	proto := snappy.Encode(nil, pushRequest(`{job="checkout", host="web-1"}`,
+		Entry{Timestamp: time.Unix(1700000000, 5), Line: "paid", StructuredMetadata: map[string]string{"level": "error"}}))
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		err         error
+		code        int
+		expect      *core.Log
+	}{
+		{
+			name:        "protobuf",
+			contentType: "application/x-protobuf",
+			body:        string(proto),
+			code:        http.StatusNoContent,
+			expect: &core.Log{
+				Channel: "checkout", SenderId: "web-1", Timestamp: 1700000000000000005, Level: "error", Message: "paid",
+				Data: map[string]interface{}{
+					"job": "checkout", "host": "web-1",
+					"structured_metadata": map[string]interface{}{"level": "error"},
+				},
+			},
+		},
+		{
+			name:        "json without channel label",
+			contentType: "application/json",
+			body:        `{"streams": [{"stream": {"app": "x"}, "values": [["1700000000000000000", "hi"]]}]}`,
+			code:        http.StatusNoContent,
+			expect: &core.Log{
+				Channel: "loki", SenderId: "192.0.2.1", Timestamp: 1700000000e9, Level: "info", Message: "hi",
+				Data: map[string]interface{}{"app": "x"},
+			},
+		},
+		{
+			name:        "broker unavailable",
+			contentType: "application/x-protobuf",
+			body:        string(proto),
+			err:         errors.New("broker unavailable"),
+			code:        http.StatusServiceUnavailable,
+		},
+		{
+			name:        "timestamp before the epoch",
+			contentType: "application/json",
+			body:        `{"streams": [{"stream": {}, "values": [["-5000000000", "x"]]}]}`,
+			code:        http.StatusBadRequest,
+		},
+		{
+			name:        "invalid snappy",
+			contentType: "application/x-protobuf",
+			body:        "not snappy",
+			code:        http.StatusBadRequest,
+		},
+		{
+			name:        "unsupported content type",
+			contentType: "text/plain",
+			body:        "x",
+			code:        http.StatusUnsupportedMediaType,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &receivertest.Producer{Err: tt.err}
+			mux := http.NewServeMux()
+			New(p, Options{}).Register(mux)
+			req := httptest.NewRequest(http.MethodPost, PushPath, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != tt.code {
+				t.Fatalf("Expected=%v, Got=%v (%s)", tt.code, rec.Code, rec.Body)
+			}
+			if tt.expect == nil {
+				return
+			}
+			if len(p.Logs()) != 1 {
+				t.Fatalf("Expected 1 log, Got=%d", len(p.Logs()))
+			}
+			got := p.Logs()[0]
+			if got.LogId == "" {
+				t.Errorf("Expected a log id")
+			}
+			got.LogId = ""
+			if !reflect.DeepEqual(got, tt.expect) {
+				t.Errorf("Expected=%+v, Got=%+v", tt.expect, got)
+			}
+		})
+	}
+}
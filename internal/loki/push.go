@@ -0,0 +1,275 @@
+package loki
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Stream is a stream of a push request, its entries sharing its labels.
+type Stream struct {
+	Labels  map[string]string
+	Entries []Entry
+}
+
+// Entry is a line of a stream.
+type Entry struct {
+	Timestamp time.Time
+	Line      string
+	// StructuredMetadata are the labels attached to the entry alone.
+	StructuredMetadata map[string]string
+}
+
+// DecodeProto decodes a push request encoded with protobuf, once
+// decompressed. The messages are the ones of Loki's logproto package:
+//
+//	message PushRequest { repeated Stream streams = 1; }
+//	message Stream { string labels = 1; repeated Entry entries = 2; uint64 hash = 3; }
+//	message Entry {
+//	  google.protobuf.Timestamp timestamp = 1;
+//	  string line = 2;
+//	  repeated LabelPair structuredMetadata = 3;
+//	}
+//	message LabelPair { string name = 1; string value = 2; }
+func DecodeProto(b []byte) ([]Stream, error) {
+	var streams []Stream
+	err := eachField(b, func(num protowire.Number, v []byte) error {
+		if num != 1 {
+			return nil
+		}
+		s, err := decodeStream(v)
+		if err != nil {
+			return err
+		}
+		streams = append(streams, s)
+		return nil
+	})
+	return streams, err
+}
+
+func decodeStream(b []byte) (Stream, error) {
+	var s Stream
+	err := eachField(b, func(num protowire.Number, v []byte) error {
+		switch num {
+		case 1:
+			labels, err := ParseLabels(string(v))
+			if err != nil {
+				return err
+			}
+			s.Labels = labels
+		case 2:
+			e, err := decodeEntry(v)
+			if err != nil {
+				return err
+			}
+			s.Entries = append(s.Entries, e)
+		}
+		return nil
+	})
+	return s, err
+}
+
+func decodeEntry(b []byte) (Entry, error) {
+	var e Entry
+	err := eachField(b, func(num protowire.Number, v []byte) error {
+		switch num {
+		case 1:
+			var sec, nsec uint64
+			err := eachVarint(v, func(num protowire.Number, x uint64) {
+				switch num {
+				case 1:
+					sec = x
+				case 2:
+					nsec = x
+				}
+			})
+			if err != nil {
+				return err
+			}
+			e.Timestamp = time.Unix(int64(sec), int64(int32(nsec)))
+		case 2:
+			e.Line = string(v)
+		case 3:
+			var name, value string
+			err := eachField(v, func(num protowire.Number, v []byte) error {
+				switch num {
+				case 1:
+					name = string(v)
+				case 2:
+					value = string(v)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if e.StructuredMetadata == nil {
+				e.StructuredMetadata = map[string]string{}
+			}
+			e.StructuredMetadata[name] = value
+		}
+		return nil
+	})
+	return e, err
+}
+
+// eachField calls fn with the length-delimited fields of a message,
+// skipping the others.
+func eachField(b []byte, fn func(num protowire.Number, v []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if err := fn(num, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eachVarint calls fn with the varint fields of a message, skipping the
+// others.
+func eachVarint(b []byte, fn func(num protowire.Number, x uint64)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.VarintType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		x, n := protowire.ConsumeVarint(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		fn(num, x)
+	}
+	return nil
+}
+
+// DecodeJSON decodes a push request encoded with JSON:
+//
+//	{"streams": [{"stream": {"job": "api"}, "values": [["<ns>", "<line>", {"trace_id": "..."}]]}]}
+//
+// The third element of the values, the structured metadata, is optional.
+func DecodeJSON(b []byte) ([]Stream, error) {
+	var req struct {
+		Streams []struct {
+			Stream map[string]string   `json:"stream"`
+			Values [][]json.RawMessage `json:"values"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(b, &req); err != nil {
+		return nil, err
+	}
+	streams := make([]Stream, 0, len(req.Streams))
+	for _, rs := range req.Streams {
+		s := Stream{Labels: rs.Stream, Entries: make([]Entry, 0, len(rs.Values))}
+		for _, v := range rs.Values {
+			if len(v) < 2 || len(v) > 3 {
+				return nil, fmt.Errorf("invalid value of %d elements", len(v))
+			}
+			var ts string
+			var e Entry
+			if err := json.Unmarshal(v[0], &ts); err != nil {
+				return nil, fmt.Errorf("invalid timestamp: %v", err)
+			}
+			ns, err := strconv.ParseInt(ts, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid timestamp %q", ts)
+			}
+			e.Timestamp = time.Unix(0, ns)
+			if err := json.Unmarshal(v[1], &e.Line); err != nil {
+				return nil, fmt.Errorf("invalid line: %v", err)
+			}
+			if len(v) == 3 {
+				if err := json.Unmarshal(v[2], &e.StructuredMetadata); err != nil {
+					return nil, fmt.Errorf("invalid structured metadata: %v", err)
+				}
+			}
+			s.Entries = append(s.Entries, e)
+		}
+		streams = append(streams, s)
+	}
+	return streams, nil
+}
+
+// ParseLabels parses the labels of a stream in the Prometheus notation,
+// such as {job="api", filename="/var/log/api.log"}.
+func ParseLabels(s string) (map[string]string, error) {
+	labels := map[string]string{}
+	rest := strings.TrimSpace(s)
+	if !strings.HasPrefix(rest, "{") || !strings.HasSuffix(rest, "}") {
+		return nil, fmt.Errorf("invalid labels %q", s)
+	}
+	rest = strings.TrimSpace(rest[1 : len(rest)-1])
+	for rest != "" {
+		eq := strings.IndexByte(rest, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("invalid labels %q", s)
+		}
+		name := strings.TrimSpace(rest[:eq])
+		rest = strings.TrimSpace(rest[eq+1:])
+		value, tail, err := quoted(rest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid labels %q: %v", s, err)
+		}
+		labels[name] = value
+		rest = strings.TrimSpace(tail)
+		if rest != "" {
+			if rest[0] != ',' {
+				return nil, fmt.Errorf("invalid labels %q", s)
+			}
+			rest = strings.TrimSpace(rest[1:])
+		}
+	}
+	return labels, nil
+}
+
+// quoted reads the double-quoted string at the beginning of s and
+// returns it along with what follows.
+func quoted(s string) (string, string, error) {
+	if !strings.HasPrefix(s, `"`) {
+		return "", "", fmt.Errorf("unquoted value")
+	}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			v, err := strconv.Unquote(s[:i+1])
+			if err != nil || !utf8.ValidString(v) {
+				return "", "", fmt.Errorf("invalid value %s", s[:i+1])
+			}
+			return v, s[i+1:], nil
+		}
+	}
+	return "", "", fmt.Errorf("unterminated value")
+}
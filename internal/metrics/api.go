@@ -0,0 +1,128 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// QueryPath is the endpoint returning the points of a metric.
+	QueryPath = "/v1/metrics/query"
+	// NamesPath is the endpoint listing the metrics.
+	NamesPath = "/v1/metrics"
+
+	defaultRange = time.Hour
+	defaultStep  = time.Minute
+	// maxPoints bounds the number of steps of a query.
+	maxPoints = 10000
+)
+
+// Register adds the endpoints of the store to mux.
+func (s *Store) Register(mux *http.ServeMux) {
+	mux.HandleFunc(NamesPath, s.ServeNames)
+	mux.HandleFunc(QueryPath, s.ServeQuery)
+}
+
+// ServeNames lists the metrics.
+func (s *Store) ServeNames(w http.ResponseWriter, r *http.Request) {
+	names, err := s.Names(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if names == nil {
+		names = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"metrics": names})
+}
+
+// ServeQuery returns the points of a metric. The query is given in the
+// parameters: name, from and to (RFC 3339 times or Unix seconds, the
+// last hour by default), step (1m by default), label=key=value, which is
+// repeatable, and by, a comma-separated list of labels.
+func (s *Store) ServeQuery(w http.ResponseWriter, r *http.Request) {
+	q, err := QueryFromRequest(r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	points, err := s.Query(r.Context(), q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if points == nil {
+		points = []*Point{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"name": q.Name, "points": points})
+}
+
+// QueryFromRequest reads a query from the parameters of r.
+func QueryFromRequest(r *http.Request, now time.Time) (Query, error) {
+	params := r.URL.Query()
+	q := Query{Name: params.Get("name"), To: now, Step: defaultStep}
+	if q.Name == "" {
+		return q, fmt.Errorf("missing name")
+	}
+	var err error
+	if v := params.Get("to"); v != "" {
+		if q.To, err = parseTime(v); err != nil {
+			return q, err
+		}
+	}
+	q.From = q.To.Add(-defaultRange)
+	if v := params.Get("from"); v != "" {
+		if q.From, err = parseTime(v); err != nil {
+			return q, err
+		}
+	}
+	if v := params.Get("step"); v != "" {
+		if q.Step, err = time.ParseDuration(v); err != nil {
+			return q, fmt.Errorf("invalid step %q", v)
+		}
+	}
+	if q.Step < time.Second {
+		return q, fmt.Errorf("step %v is shorter than a second", q.Step)
+	}
+	if !q.From.Before(q.To) || q.To.Sub(q.From)/q.Step > maxPoints {
+		return q, fmt.Errorf("invalid range, or more than %d steps", maxPoints)
+	}
+	for _, v := range params["label"] {
+		key, value, ok := strings.Cut(v, "=")
+		if !ok {
+			return q, fmt.Errorf("invalid label %q, expected key=value", v)
+		}
+		if q.Labels == nil {
+			q.Labels = map[string]string{}
+		}
+		q.Labels[key] = value
+	}
+	if by, ok := params["by"]; ok {
+		q.By = []string{}
+		for _, v := range by {
+			for _, name := range strings.Split(v, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					q.By = append(q.By, name)
+				}
+			}
+		}
+	}
+	return q, nil
+}
+
+func parseTime(v string) (time.Time, error) {
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(n, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return t, fmt.Errorf("invalid time %q", v)
+	}
+	return t, nil
+}
@@ -0,0 +1,280 @@
+// Package metrics derives metrics from the logs as they are ingested,
+// following the rules of the configuration, for example the number of
+// logs per level and channel:
+//
+//	{Name: logs, Type: counter, Labels: [channel, level]}
+//
+// or the distribution of a latency per sender:
+//
+//	{Name: latency, Type: histogram, Field: data.latency_ms, Labels: [sender_id]}
+//
+// The metrics are aggregated per time bucket in memory, and the
+// aggregates are written to a ClickHouse table along with the logs they
+// come from, so that charting a rate reads a few rows per bucket rather
+// than counting the logs.
+package metrics
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hyperbolicresearch/hlog/config"
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/internal/processor"
+)
+
+// The types of metrics.
+const (
+	Counter   = "counter"
+	Gauge     = "gauge"
+	Histogram = "histogram"
+)
+
+// DefaultBuckets are the upper bounds of the buckets of the histograms
+// that do not configure theirs, fitting latencies in milliseconds.
+var DefaultBuckets = []float64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// Point is the aggregate of a series over a time bucket.
+type Point struct {
+	Name   string            `json:"name"`
+	Type   string            `json:"type"`
+	Labels map[string]string `json:"labels"`
+	// Time is the start of the bucket.
+	Time time.Time `json:"time"`
+	// Count is the number of logs, Sum the sum of their values (their
+	// count for the counters without field), Min and Max the extremes of
+	// the values and Last the value of the latest log, seen at LastTime.
+	Count    uint64    `json:"count"`
+	Sum      float64   `json:"sum"`
+	Min      float64   `json:"min"`
+	Max      float64   `json:"max"`
+	Last     float64   `json:"last"`
+	LastTime time.Time `json:"-"`
+	// Bounds are the upper bounds of the buckets of a histogram, and
+	// BucketCounts the number of values in each bucket, the last one
+	// counting the values above every bound.
+	Bounds       []float64 `json:"bounds,omitempty"`
+	BucketCounts []uint64  `json:"bucket_counts,omitempty"`
+}
+
+// observe adds a value seen at ts to p.
+func (p *Point) observe(v float64, ts time.Time) {
+	if p.Count == 0 || v < p.Min {
+		p.Min = v
+	}
+	if p.Count == 0 || v > p.Max {
+		p.Max = v
+	}
+	if !ts.Before(p.LastTime) {
+		p.Last, p.LastTime = v, ts
+	}
+	p.Count++
+	p.Sum += v
+	if p.Bounds != nil {
+		p.BucketCounts[sort.SearchFloat64s(p.Bounds, v)]++
+	}
+}
+
+// rule is a compiled metric rule.
+type rule struct {
+	config.MetricRule
+	cond *processor.Condition
+}
+
+// Aggregator aggregates the metrics of the logs until they are drained.
+type Aggregator struct {
+	rules  []*rule
+	bucket time.Duration
+
+	mu     sync.Mutex
+	points map[string]*Point
+	// errors counts the logs whose value could not be read.
+	errors uint64
+}
+
+// New creates the aggregator of the rules of cfg, which may be nil.
+func New(cfg *config.Metrics) (*Aggregator, error) {
+	a := &Aggregator{bucket: time.Minute, points: map[string]*Point{}}
+	if cfg == nil {
+		return a, nil
+	}
+	if cfg.Bucket != 0 {
+		if cfg.Bucket < time.Second || cfg.Bucket%time.Second != 0 {
+			return nil, fmt.Errorf("metrics: bucket %v is not a whole number of seconds", cfg.Bucket)
+		}
+		a.bucket = cfg.Bucket
+	}
+	names := map[string]bool{}
+	for i, rcfg := range cfg.Rules {
+		r := &rule{MetricRule: rcfg}
+		if r.Name == "" || names[r.Name] {
+			return nil, fmt.Errorf("metrics: rule %d: missing or duplicate name %q", i, r.Name)
+		}
+		names[r.Name] = true
+		switch r.Type {
+		case Counter:
+		case Gauge, Histogram:
+			if r.Field == "" {
+				return nil, fmt.Errorf("metrics: %s: a %s needs a field", r.Name, r.Type)
+			}
+		default:
+			return nil, fmt.Errorf("metrics: %s: unknown type %q", r.Name, r.Type)
+		}
+		if r.Type == Histogram {
+			if len(r.Buckets) == 0 {
+				r.Buckets = DefaultBuckets
+			}
+			if !sort.Float64sAreSorted(r.Buckets) {
+				return nil, fmt.Errorf("metrics: %s: the buckets are not in increasing order", r.Name)
+			}
+		}
+		if _, err := path.Match(r.Channel, ""); err != nil {
+			return nil, fmt.Errorf("metrics: %s: invalid channel %q", r.Name, r.Channel)
+		}
+		if r.If != nil {
+			cond, err := processor.NewCondition(*r.If)
+			if err != nil {
+				return nil, fmt.Errorf("metrics: %s: %v", r.Name, err)
+			}
+			r.cond = cond
+		}
+		a.rules = append(a.rules, r)
+	}
+	return a, nil
+}
+
+// Observe adds l to the metrics whose rules it matches.
+func (a *Aggregator) Observe(l *core.Log) {
+	if a == nil || len(a.rules) == 0 {
+		return
+	}
+	ts := core.Time(l.Timestamp)
+	if l.Timestamp == 0 {
+		ts = time.Now()
+	}
+	start := ts.Truncate(a.bucket)
+	for _, r := range a.rules {
+		if r.Channel != "" {
+			if ok, _ := path.Match(r.Channel, l.Channel); !ok {
+				continue
+			}
+		}
+		if r.cond != nil && !r.cond.Match(l) {
+			continue
+		}
+		v := 1.0
+		if r.Field != "" {
+			raw, ok := processor.Get(l, r.Field)
+			if !ok {
+				continue
+			}
+			f, err := processor.Cast(raw, "float")
+			if err != nil {
+				a.mu.Lock()
+				a.errors++
+				a.mu.Unlock()
+				continue
+			}
+			v = f.(float64)
+		}
+		labels := make(map[string]string, len(r.Labels))
+		for _, name := range r.Labels {
+			if raw, ok := processor.Get(l, name); ok {
+				s, _ := processor.Cast(raw, "string")
+				labels[name], _ = s.(string)
+			}
+		}
+		key := seriesKey(r.Name, labels, start)
+		a.mu.Lock()
+		p := a.points[key]
+		if p == nil {
+			p = &Point{Name: r.Name, Type: r.Type, Labels: labels, Time: start}
+			if r.Type == Histogram {
+				p.Bounds = r.Buckets
+				p.BucketCounts = make([]uint64, len(r.Buckets)+1)
+			}
+			a.points[key] = p
+		}
+		p.observe(v, ts)
+		a.mu.Unlock()
+	}
+}
+
+// seriesKey identifies the point of a series and bucket.
+func seriesKey(name string, labels map[string]string, start time.Time) string {
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\x00%d", name, start.Unix())
+	for _, k := range names {
+		fmt.Fprintf(&b, "\x00%s=%s", k, labels[k])
+	}
+	return b.String()
+}
+
+// Drain returns the points aggregated since the last call, and the
+// number of logs whose value could not be read.
+func (a *Aggregator) Drain() ([]*Point, uint64) {
+	if a == nil {
+		return nil, 0
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	points := make([]*Point, 0, len(a.points))
+	for _, p := range a.points {
+		points = append(points, p)
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if !points[i].Time.Equal(points[j].Time) {
+			return points[i].Time.Before(points[j].Time)
+		}
+		return points[i].Name < points[j].Name
+	})
+	errors := a.errors
+	a.points, a.errors = map[string]*Point{}, 0
+	return points, errors
+}
+
+// Restore puts back points drained but not stored, to be stored with the
+// next ones.
+func (a *Aggregator) Restore(points []*Point) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, p := range points {
+		key := seriesKey(p.Name, p.Labels, p.Time)
+		cur, ok := a.points[key]
+		if !ok {
+			a.points[key] = p
+			continue
+		}
+		cur.merge(p)
+	}
+}
+
+// merge adds the aggregate q of the same series and bucket to p.
+func (p *Point) merge(q *Point) {
+	if q.Min < p.Min {
+		p.Min = q.Min
+	}
+	if q.Max > p.Max {
+		p.Max = q.Max
+	}
+	if q.LastTime.After(p.LastTime) {
+		p.Last, p.LastTime = q.Last, q.LastTime
+	}
+	p.Count += q.Count
+	p.Sum += q.Sum
+	for i := range p.BucketCounts {
+		p.BucketCounts[i] += q.BucketCounts[i]
+	}
+}
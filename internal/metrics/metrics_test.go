@@ -0,0 +1,155 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperbolicresearch/hlog/config"
+	"github.com/hyperbolicresearch/hlog/internal/core"
+)
+
+func TestAggregator(t *testing.T) {
+	a, err := New(&config.Metrics{
+		Bucket: time.Minute,
+		Rules: []config.MetricRule{
+			{Name: "logs", Type: Counter, Labels: []string{"channel", "level"}},
+			{Name: "latency", Type: Histogram, Channel: "api*", Field: "data.latency_ms", Buckets: []float64{10, 100}, Labels: []string{"sender_id"}},
+			{Name: "queue", Type: Gauge, Field: "data.queue", If: &config.ProcessorCondition{Field: "level", Equals: []string{"info"}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs := []*core.Log{
+		{Channel: "api", SenderId: "web-1", Level: "info", Timestamp: 60, Data: map[string]interface{}{"latency_ms": 5.0, "queue": 3.0}},
+		{Channel: "api", SenderId: "web-1", Level: "info", Timestamp: 90, Data: map[string]interface{}{"latency_ms": "50", "queue": 1.0}},
+		{Channel: "api", SenderId: "web-1", Level: "error", Timestamp: 100, Data: map[string]interface{}{"latency_ms": 500.0, "queue": 9.0}},
+		{Channel: "api", SenderId: "web-2", Level: "info", Timestamp: 130, Data: map[string]interface{}{"latency_ms": "slow"}},
+		{Channel: "worker", SenderId: "w-1", Level: "info", Timestamp: 110, Data: map[string]interface{}{"latency_ms": 1.0}},
+	}
+	for _, l := range logs {
+		a.Observe(l)
+	}
+	points, errors := a.Drain()
+	if errors != 1 {
+		t.Errorf("Expected=1 error, Got=%d", errors)
+	}
+	got := map[string]*Point{}
+	for _, p := range points {
+		got[seriesKey(p.Name, p.Labels, p.Time)] = p
+	}
+	minute := time.Unix(60, 0)
+	tests := []struct {
+		name   string
+		labels map[string]string
+		start  time.Time
+		expect Point
+	}{
+		{"logs", map[string]string{"channel": "api", "level": "info"}, minute, Point{Count: 2, Sum: 2, Min: 1, Max: 1, Last: 1}},
+		{"logs", map[string]string{"channel": "api", "level": "info"}, time.Unix(120, 0), Point{Count: 1, Sum: 1, Min: 1, Max: 1, Last: 1}},
+		{"logs", map[string]string{"channel": "api", "level": "error"}, minute, Point{Count: 1, Sum: 1, Min: 1, Max: 1, Last: 1}},
+		{"logs", map[string]string{"channel": "worker", "level": "info"}, minute, Point{Count: 1, Sum: 1, Min: 1, Max: 1, Last: 1}},
+		{
+			"latency", map[string]string{"sender_id": "web-1"}, minute,
+			Point{Count: 3, Sum: 555, Min: 5, Max: 500, Last: 500, Bounds: []float64{10, 100}, BucketCounts: []uint64{1, 1, 1}},
+		},
+		{"queue", map[string]string{}, minute, Point{Count: 2, Sum: 4, Min: 1, Max: 3, Last: 1}},
+	}
+	if len(points) != len(tests) {
+		t.Errorf("Expected=%d points, Got=%d", len(tests), len(points))
+	}
+	for _, tt := range tests {
+		found := got[seriesKey(tt.name, tt.labels, tt.start)]
+		if found == nil {
+			t.Errorf("Expected a point for %s %v at %v", tt.name, tt.labels, tt.start)
+			continue
+		}
+		p := *found
+		p.Name, p.Type, p.Labels, p.Time, p.LastTime = "", "", nil, time.Time{}, time.Time{}
+		if !reflect.DeepEqual(p, tt.expect) {
+			t.Errorf("%s %v: Expected=%+v, Got=%+v", tt.name, tt.labels, tt.expect, p)
+		}
+	}
+
+	a.Observe(logs[0])
+	a.Restore(points)
+	again, _ := a.Drain()
+	if len(again) != len(points) {
+		t.Errorf("Expected the restored points, Got=%d", len(again))
+	}
+	key := seriesKey("logs", map[string]string{"channel": "api", "level": "info"}, minute)
+	for _, p := range again {
+		if seriesKey(p.Name, p.Labels, p.Time) == key && p.Count != 3 {
+			t.Errorf("Expected the restored point to be merged, Got=%+v", p)
+		}
+	}
+	if again, _ := a.Drain(); len(again) != 0 {
+		t.Errorf("Expected nothing after a drain, Got=%d", len(again))
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	for _, cfg := range []*config.Metrics{
+		{Bucket: 1500 * time.Millisecond},
+		{Rules: []config.MetricRule{{Type: Counter}}},
+		{Rules: []config.MetricRule{{Name: "a", Type: Counter}, {Name: "a", Type: Counter}}},
+		{Rules: []config.MetricRule{{Name: "a", Type: "summary"}}},
+		{Rules: []config.MetricRule{{Name: "a", Type: Gauge}}},
+		{Rules: []config.MetricRule{{Name: "a", Type: Histogram, Field: "data.x", Buckets: []float64{10, 1}}}},
+	} {
+		if _, err := New(cfg); err == nil {
+			t.Errorf("Expected an error for %+v", cfg)
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	now := time.Unix(7200, 0)
+	r := httptest.NewRequest("GET", QueryPath+"?name=logs&step=5m&label=channel=api&by=level", nil)
+	q, err := QueryFromRequest(r, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := Query{
+		Name:   "logs",
+		From:   time.Unix(3600, 0),
+		To:     now,
+		Step:   5 * time.Minute,
+		Labels: map[string]string{"channel": "api"},
+		By:     []string{"level"},
+	}
+	if !reflect.DeepEqual(q, expect) {
+		t.Errorf("Expected=%+v, Got=%+v", expect, q)
+	}
+	sql, args, err := q.SQL("hlog_metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range []string{"INTERVAL 300 SECOND", "map('level', labels['level'])", "labels[?] = ?", "FROM `hlog_metrics`"} {
+		if !strings.Contains(sql, part) {
+			t.Errorf("Expected %q in %s", part, sql)
+		}
+	}
+	if len(args) != 5 {
+		t.Errorf("Expected=5 arguments, Got=%v", args)
+	}
+
+	for _, query := range []string{
+		"step=1m",
+		"name=logs&step=1ms",
+		"name=logs&from=7200&to=3600",
+		"name=logs&from=0&to=100000&step=1s",
+		"name=logs&label=channel",
+		"name=logs&to=yesterday",
+	} {
+		if _, err := QueryFromRequest(httptest.NewRequest("GET", QueryPath+"?"+query, nil), now); err == nil {
+			t.Errorf("Expected an error for %s", query)
+		}
+	}
+	if _, _, err := (Query{Name: "logs", Step: time.Minute, By: []string{"a'b"}}).SQL("hlog_metrics"); err == nil {
+		t.Error("Expected an error for an invalid label")
+	}
+}
@@ -0,0 +1,176 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// identifierPattern matches the table and label names that can be put in
+// the queries.
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+// Store writes the points to a ClickHouse table and queries them.
+type Store struct {
+	Conn  driver.Conn
+	Table string
+}
+
+// NewStore returns the store of table, creating it if needed.
+func NewStore(ctx context.Context, conn driver.Conn, table string) (*Store, error) {
+	if !identifierPattern.MatchString(table) || strings.Contains(table, ".") {
+		return nil, fmt.Errorf("metrics: invalid table name %q", table)
+	}
+	s := &Store{Conn: conn, Table: table}
+	// The rows of a series and bucket are not merged when written, as a
+	// bucket may be written several times; the queries aggregate them.
+	err := conn.Exec(ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s` ("+
+		"name LowCardinality(String), "+
+		"type LowCardinality(String), "+
+		"labels Map(String, String), "+
+		"time DateTime, "+
+		"count UInt64, "+
+		"sum Float64, "+
+		"min Float64, "+
+		"max Float64, "+
+		"last Float64, "+
+		"last_time DateTime64(3), "+
+		"bounds Array(Float64), "+
+		"bucket_counts Array(UInt64)"+
+		") ENGINE = MergeTree ORDER BY (name, time)", table))
+	if err != nil {
+		return nil, fmt.Errorf("metrics: creating %s: %v", table, err)
+	}
+	return s, nil
+}
+
+// Write stores points.
+func (s *Store) Write(ctx context.Context, points []*Point) error {
+	if len(points) == 0 {
+		return nil
+	}
+	batch, err := s.Conn.PrepareBatch(ctx, fmt.Sprintf("INSERT INTO `%s`", s.Table))
+	if err != nil {
+		return err
+	}
+	for _, p := range points {
+		bounds, counts := p.Bounds, p.BucketCounts
+		if bounds == nil {
+			bounds, counts = []float64{}, []uint64{}
+		}
+		err := batch.Append(p.Name, p.Type, p.Labels, p.Time, p.Count, p.Sum,
+			p.Min, p.Max, p.Last, p.LastTime, bounds, counts)
+		if err != nil {
+			return err
+		}
+	}
+	return batch.Send()
+}
+
+// Query selects the points of a metric.
+type Query struct {
+	Name string
+	From time.Time
+	To   time.Time
+	// Step is the width of the points returned, rounded to seconds, and
+	// a multiple of the bucket of the metric to be meaningful.
+	Step time.Duration
+	// Labels restricts the query to the series having these labels, and
+	// By groups the series by some of their labels, all the series being
+	// aggregated together when it is empty. Each series is returned on
+	// its own when By is nil.
+	Labels map[string]string
+	By     []string
+}
+
+// SQL returns the statement of the query, and its arguments.
+func (q Query) SQL(table string) (string, []interface{}, error) {
+	step := int64(q.Step / time.Second)
+	if step <= 0 {
+		return "", nil, fmt.Errorf("invalid step %v", q.Step)
+	}
+	group := "labels"
+	if q.By != nil {
+		var pairs []string
+		for _, name := range q.By {
+			if !identifierPattern.MatchString(name) {
+				return "", nil, fmt.Errorf("invalid label %q", name)
+			}
+			pairs = append(pairs, fmt.Sprintf("'%s', labels['%s']", name, name))
+		}
+		group = "map(" + strings.Join(pairs, ", ") + ")"
+		if len(pairs) == 0 {
+			group = "map()"
+		}
+	}
+	where := []string{"name = ?", "time >= ?", "time < ?"}
+	args := []interface{}{q.Name, q.From, q.To}
+	names := make([]string, 0, len(q.Labels))
+	for name := range q.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		where = append(where, "labels[?] = ?")
+		args = append(args, name, q.Labels[name])
+	}
+	sql := fmt.Sprintf("SELECT "+
+		"toStartOfInterval(time, INTERVAL %d SECOND) AS t, "+
+		"CAST(%s, 'Map(String, String)') AS series, "+
+		"any(type), sum(count), sum(sum), min(min), max(max), argMax(last, last_time), "+
+		"any(bounds), sumForEach(bucket_counts) "+
+		"FROM `%s` WHERE %s GROUP BY t, series ORDER BY t, series",
+		step, group, table, strings.Join(where, " AND "))
+	return sql, args, nil
+}
+
+// Query runs a query, the points returned having their Time at the start
+// of their step and their LastTime unset.
+func (s *Store) Query(ctx context.Context, q Query) ([]*Point, error) {
+	sql, args, err := q.SQL(s.Table)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.Conn.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var points []*Point
+	for rows.Next() {
+		p := &Point{Name: q.Name}
+		err := rows.Scan(&p.Time, &p.Labels, &p.Type, &p.Count, &p.Sum, &p.Min, &p.Max,
+			&p.Last, &p.Bounds, &p.BucketCounts)
+		if err != nil {
+			return nil, err
+		}
+		if len(p.Bounds) == 0 {
+			p.Bounds, p.BucketCounts = nil, nil
+		}
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+// Names returns the names of the metrics stored.
+func (s *Store) Names(ctx context.Context) ([]string, error) {
+	rows, err := s.Conn.Query(ctx, fmt.Sprintf("SELECT DISTINCT name FROM `%s` ORDER BY name", s.Table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
@@ -0,0 +1,161 @@
+package otlp
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/internal/receiver"
+	"github.com/hyperbolicresearch/hlog/pkg/logger"
+)
+
+// senderAttributes are the resource attributes identifying the sender,
+// by order of preference.
+var senderAttributes = []string{"service.instance.id", "host.name"}
+
+// Convert turns the records of an export request into logs:
+//   - the severity becomes the level, the number taking precedence over
+//     the text;
+//   - a string body becomes the message, other bodies go in data under
+//     "body";
+//   - the attributes of the record go in data, the ones of the resource
+//     and of the scope under "resource" and "scope";
+//   - the trace and span ids go in data, hex-encoded, under "trace_id"
+//     and "span_id".
+//
+// The channel is the value of the ChannelAttribute resource attribute,
+// DefaultChannel when it is missing or is not a valid channel name.
+func (rc *Receiver) Convert(req *collogspb.ExportLogsServiceRequest) []*core.Log {
+	var logs []*core.Log
+	for _, rl := range req.GetResourceLogs() {
+		resource := attributes(rl.GetResource().GetAttributes())
+		channel, _ := resource[rc.opts.ChannelAttribute].(string)
+		if !receiver.ValidChannel(channel) {
+			channel = rc.opts.DefaultChannel
+		}
+		var sender string
+		for _, key := range senderAttributes {
+			if s, ok := resource[key].(string); ok && s != "" {
+				sender = s
+				break
+			}
+		}
+		for _, sl := range rl.GetScopeLogs() {
+			var scope map[string]interface{}
+			if s := sl.GetScope(); s != nil && (s.GetName() != "" || s.GetVersion() != "") {
+				scope = map[string]interface{}{
+					"name":    s.GetName(),
+					"version": s.GetVersion(),
+				}
+			}
+			for _, lr := range sl.GetLogRecords() {
+				l := convertRecord(lr)
+				l.Channel = channel
+				l.SenderId = sender
+				if len(resource) > 0 {
+					l.Data["resource"] = resource
+				}
+				if scope != nil {
+					l.Data["scope"] = scope
+				}
+				logs = append(logs, l)
+			}
+		}
+	}
+	return logs
+}
+
+func convertRecord(lr *logspb.LogRecord) *core.Log {
+	data := attributes(lr.GetAttributes())
+	l := &core.Log{
+		Level: severityLevel(lr.GetSeverityNumber(), lr.GetSeverityText()).String(),
+		Data:  data,
+	}
+	switch body := lr.GetBody().GetValue().(type) {
+	case nil:
+	case *commonpb.AnyValue_StringValue:
+		l.Message = body.StringValue
+	default:
+		data["body"] = anyValue(lr.GetBody())
+	}
+	ts := lr.GetTimeUnixNano()
+	if ts == 0 {
+		ts = lr.GetObservedTimeUnixNano()
+	}
+	l.Timestamp = int64(ts)
+	if len(lr.GetTraceId()) > 0 {
+		data["trace_id"] = hex.EncodeToString(lr.GetTraceId())
+	}
+	if len(lr.GetSpanId()) > 0 {
+		data["span_id"] = hex.EncodeToString(lr.GetSpanId())
+	}
+	if lr.GetFlags() != 0 {
+		data["trace_flags"] = int64(lr.GetFlags())
+	}
+	if lr.GetSeverityText() != "" {
+		data["severity_text"] = lr.GetSeverityText()
+	}
+	return l
+}
+
+// severityLevel maps the OpenTelemetry severities to the levels, the
+// trace ones being considered debug. Records without severity number
+// fall back on their text, and are info otherwise.
+func severityLevel(n logspb.SeverityNumber, text string) logger.Level {
+	switch {
+	case n >= logspb.SeverityNumber_SEVERITY_NUMBER_FATAL:
+		return logger.FATAL
+	case n >= logspb.SeverityNumber_SEVERITY_NUMBER_ERROR:
+		return logger.ERROR
+	case n >= logspb.SeverityNumber_SEVERITY_NUMBER_WARN:
+		return logger.WARNING
+	case n >= logspb.SeverityNumber_SEVERITY_NUMBER_INFO:
+		return logger.INFO
+	case n >= logspb.SeverityNumber_SEVERITY_NUMBER_TRACE:
+		return logger.DEBUG
+	}
+	if level, ok := logger.ParseLevel(text); ok {
+		return level
+	}
+	if strings.EqualFold(text, "trace") {
+		return logger.DEBUG
+	}
+	return logger.INFO
+}
+
+func attributes(kvs []*commonpb.KeyValue) map[string]interface{} {
+	m := make(map[string]interface{}, len(kvs))
+	for _, kv := range kvs {
+		m[kv.GetKey()] = anyValue(kv.GetValue())
+	}
+	return m
+}
+
+func anyValue(v *commonpb.AnyValue) interface{} {
+	switch x := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return x.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return x.BoolValue
+	case *commonpb.AnyValue_IntValue:
+		return x.IntValue
+	case *commonpb.AnyValue_DoubleValue:
+		return x.DoubleValue
+	case *commonpb.AnyValue_BytesValue:
+		return base64.StdEncoding.EncodeToString(x.BytesValue)
+	case *commonpb.AnyValue_ArrayValue:
+		values := make([]interface{}, len(x.ArrayValue.GetValues()))
+		for i, av := range x.ArrayValue.GetValues() {
+			values[i] = anyValue(av)
+		}
+		return values
+	case *commonpb.AnyValue_KvlistValue:
+		return attributes(x.KvlistValue.GetValues())
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+// Package otlp receives the logs exported with the OpenTelemetry
+// protocol, over HTTP (protobuf and JSON) and gRPC, and produces them to
+// the topics of their channels.
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/hyperbolicresearch/hlog/internal/receiver"
+)
+
+// LogsPath is the endpoint of the OTLP/HTTP log exports.
+const LogsPath = "/v1/logs"
+
+// Options configures a Receiver.
+type Options struct {
+	// ChannelAttribute is the resource attribute holding the channel of
+	// the logs, and DefaultChannel the channel of the resources without
+	// it or whose attribute is not a valid channel name.
+	ChannelAttribute string
+	DefaultChannel   string
+	// MaxBodySize is the maximum size of an HTTP request body as sent,
+	// and MaxDecodedSize once decompressed.
+	MaxBodySize    int64
+	MaxDecodedSize int64
+}
+
+// DefaultOptions are the values used for the zero fields of Options.
+var DefaultOptions = Options{
+	ChannelAttribute: "service.name",
+	DefaultChannel:   "otlp",
+	MaxBodySize:      5 << 20,
+	MaxDecodedSize:   50 << 20,
+}
+
+// Receiver converts the OTLP log exports to logs and hands them over to
+// a producer. It serves both OTLP/HTTP and OTLP/gRPC.
+type Receiver struct {
+	collogspb.UnimplementedLogsServiceServer
+	producer receiver.Producer
+	opts     Options
+}
+
+// New creates a Receiver producing the logs with p.
+func New(p receiver.Producer, opts Options) *Receiver {
+	if opts.ChannelAttribute == "" {
+		opts.ChannelAttribute = DefaultOptions.ChannelAttribute
+	}
+	if opts.DefaultChannel == "" {
+		opts.DefaultChannel = DefaultOptions.DefaultChannel
+	}
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = DefaultOptions.MaxBodySize
+	}
+	if opts.MaxDecodedSize <= 0 {
+		opts.MaxDecodedSize = DefaultOptions.MaxDecodedSize
+	}
+	return &Receiver{producer: p, opts: opts}
+}
+
+// Register adds the OTLP/HTTP endpoint to mux.
+func (rc *Receiver) Register(mux *http.ServeMux) {
+	mux.HandleFunc(LogsPath, rc.ServeHTTP)
+}
+
+// RegisterGRPC adds the OTLP/gRPC logs service to s.
+func (rc *Receiver) RegisterGRPC(s *grpc.Server) {
+	collogspb.RegisterLogsServiceServer(s, rc)
+}
+
+// export produces the logs of req. The records that could not be
+// produced are reported as a partial success.
+func (rc *Receiver) export(req *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceResponse, receiver.Result) {
+	res := receiver.Submit(rc.producer, rc.Convert(req))
+	resp := &collogspb.ExportLogsServiceResponse{}
+	if res.Rejected > 0 {
+		resp.PartialSuccess = &collogspb.ExportLogsPartialSuccess{
+			RejectedLogRecords: int64(res.Rejected),
+			ErrorMessage:       res.FirstError().Error(),
+		}
+	}
+	return resp, res
+}
+
+// Export implements the OTLP/gRPC logs service.
+func (rc *Receiver) Export(_ context.Context, req *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceResponse, error) {
+	resp, res := rc.export(req)
+	if res.Retryable() {
+		return nil, status.Error(codes.Unavailable, res.FirstError().Error())
+	}
+	return resp, nil
+}
+
+// ServeHTTP implements OTLP/HTTP, the request and the response being
+// either protobuf or JSON depending on the Content-Type.
+func (rc *Receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != "application/x-protobuf" && contentType != "application/json" {
+		http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+	data, err := receiver.ReadBody(w, r, rc.opts.MaxBodySize, rc.opts.MaxDecodedSize)
+	if err != nil {
+		http.Error(w, err.Error(), receiver.BodyErrorStatus(err))
+		return
+	}
+
+	req := &collogspb.ExportLogsServiceRequest{}
+	if contentType == "application/json" {
+		err = unmarshalJSON(data, req)
+	} else {
+		err = proto.Unmarshal(data, req)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid export request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	resp, res := rc.export(req)
+	code := http.StatusOK
+	if res.Retryable() {
+		code = http.StatusServiceUnavailable
+	}
+	var body []byte
+	if contentType == "application/json" {
+		body, err = protojson.Marshal(resp)
+	} else {
+		body, err = proto.Marshal(resp)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(code)
+	w.Write(body)
+}
+
+// unmarshalJSON decodes an OTLP/JSON request. Unlike the canonical JSON
+// mapping of protobuf, OTLP encodes the trace and span ids in hex rather
+// than base64, so they are converted before decoding.
+func unmarshalJSON(data []byte, req *collogspb.ExportLogsServiceRequest) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+	if err := hexIdsToBase64(v); err != nil {
+		return err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(data, req)
+}
+
+func hexIdsToBase64(v interface{}) error {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, child := range x {
+			if s, ok := child.(string); ok && (k == "traceId" || k == "spanId") {
+				b, err := hex.DecodeString(s)
+				if err != nil {
+					return fmt.Errorf("invalid %s %q", k, s)
+				}
+				x[k] = base64.StdEncoding.EncodeToString(b)
+				continue
+			}
+			if err := hexIdsToBase64(child); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, child := range x {
+			if err := hexIdsToBase64(child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
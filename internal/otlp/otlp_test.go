@@ -0,0 +1,223 @@
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/hyperbolicresearch/hlog/internal/receiver/receivertest"
+)
+
+func str(s string) *commonpb.AnyValue {
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: s}}
+}
+
+func exportRequest() *collogspb.ExportLogsServiceRequest {
+	return &collogspb.ExportLogsServiceRequest{
+		ResourceLogs: []*logspb.ResourceLogs{{
+			Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+				{Key: "service.name", Value: str("checkout")},
+				{Key: "host.name", Value: str("web-1")},
+			}},
+			ScopeLogs: []*logspb.ScopeLogs{{
+				Scope: &commonpb.InstrumentationScope{Name: "app", Version: "1.0"},
+				LogRecords: []*logspb.LogRecord{
+					{
+						TimeUnixNano:   1700000000123456789,
+						SeverityNumber: logspb.SeverityNumber_SEVERITY_NUMBER_WARN2,
+						SeverityText:   "WARN",
+						Body:           str("payment slow"),
+						Attributes: []*commonpb.KeyValue{
+							{Key: "attempt", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: 2}}},
+						},
+						TraceId: []byte{0x5b, 0x8e, 0xfb, 0xf3, 0x4c, 0x7a, 0x4f, 0x10, 0x9c, 0x2b, 0x1a, 0x3e, 0x2d, 0x4f, 0x5a, 0x6b},
+						SpanId:  []byte{0xeb, 0x2a, 0x3f, 0x4e, 0x5d, 0x6c, 0x7b, 0x8a},
+					},
+					{
+						ObservedTimeUnixNano: 1700000001000000000,
+						SeverityText:         "error",
+						Body: &commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{KvlistValue: &commonpb.KeyValueList{
+							Values: []*commonpb.KeyValue{{Key: "code", Value: str("E42")}},
+						}}},
+					},
+				},
+			}},
+		}, {
+			// Without service.name, the logs go to the default channel.
+			ScopeLogs: []*logspb.ScopeLogs{{
+				LogRecords: []*logspb.LogRecord{{Body: str("orphan")}},
+			}},
+		}},
+	}
+}
+
+func TestConvert(t *testing.T) {
+	rc := New(&receivertest.Producer{}, Options{})
+	logs := rc.Convert(exportRequest())
+	if len(logs) != 3 {
+		t.Fatalf("Expected=3, Got=%v", len(logs))
+	}
+
+	l := logs[0]
+	if l.Channel != "checkout" || l.SenderId != "web-1" || l.Level != "warn" ||
+		l.Message != "payment slow" || l.Timestamp != 1700000000123456789 {
+		t.Errorf("Unexpected envelope: %+v", l)
+	}
+	expected := map[string]interface{}{
+		"attempt":       int64(2),
+		"trace_id":      "5b8efbf34c7a4f109c2b1a3e2d4f5a6b",
+		"span_id":       "eb2a3f4e5d6c7b8a",
+		"severity_text": "WARN",
+		"resource":      map[string]interface{}{"service.name": "checkout", "host.name": "web-1"},
+		"scope":         map[string]interface{}{"name": "app", "version": "1.0"},
+	}
+	if !reflect.DeepEqual(l.Data, expected) {
+		t.Errorf("Expected=%v, Got=%v", expected, l.Data)
+	}
+
+	l = logs[1]
+	if l.Level != "error" || l.Message != "" || l.Timestamp != 1700000001e9 {
+		t.Errorf("Unexpected envelope: %+v", l)
+	}
+	if body, _ := l.Data["body"].(map[string]interface{}); body["code"] != "E42" {
+		t.Errorf("Expected the body in data, Got=%v", l.Data)
+	}
+
+	if logs[2].Channel != "otlp" || logs[2].Level != "info" {
+		t.Errorf("Unexpected envelope: %+v", logs[2])
+	}
+}
+
+func TestConvertInvalidChannel(t *testing.T) {
+	rc := New(&receivertest.Producer{}, Options{})
+	tests := []struct {
+		name    string
+		service string
+		expect  string
+	}{
+		{"valid", "checkout.api", "checkout.api"},
+		{"space", "my service", "otlp"},
+		{"slash", "io.foo/bar", "otlp"},
+		{"too long", strings.Repeat("a", 250), "otlp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := rc.Convert(&collogspb.ExportLogsServiceRequest{
+				ResourceLogs: []*logspb.ResourceLogs{{
+					Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+						{Key: "service.name", Value: str(tt.service)},
+					}},
+					ScopeLogs: []*logspb.ScopeLogs{{
+						LogRecords: []*logspb.LogRecord{{Body: str("m")}},
+					}},
+				}},
+			})
+			if len(logs) != 1 || logs[0].Channel != tt.expect {
+				t.Errorf("Expected=%v, Got=%+v", tt.expect, logs)
+			}
+		})
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	body, err := proto.Marshal(exportRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		producerErr error
+		status      int
+		produced    int
+	}{
+		{"protobuf", "application/x-protobuf", body, nil, http.StatusOK, 3},
+		{
+			"json", "application/json",
+			[]byte(`{"resourceLogs":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"checkout"}}]},
+				"scopeLogs":[{"logRecords":[{"timeUnixNano":"1700000000000000000","severityNumber":17,
+				"body":{"stringValue":"failed"},"traceId":"5b8efbf34c7a4f109c2b1a3e2d4f5a6b","spanId":"eb2a3f4e5d6c7b8a"}]}]}]}`),
+			nil, http.StatusOK, 1,
+		},
+		{"broker down", "application/x-protobuf", body, errors.New("down"), http.StatusServiceUnavailable, 0},
+		{"invalid", "application/x-protobuf", []byte("garbage"), nil, http.StatusBadRequest, 0},
+		{"unsupported", "text/plain", body, nil, http.StatusUnsupportedMediaType, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &receivertest.Producer{Err: tt.producerErr}
+			rc := New(p, Options{})
+			req := httptest.NewRequest(http.MethodPost, LogsPath, bytes.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			rec := httptest.NewRecorder()
+			rc.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("Expected=%v, Got=%v (%s)", tt.status, rec.Code, rec.Body)
+			}
+			if len(p.Logs()) != tt.produced {
+				t.Fatalf("Expected=%v produced, Got=%v", tt.produced, len(p.Logs()))
+			}
+			if tt.name == "json" {
+				l := p.Logs()[0]
+				if l.Channel != "checkout" || l.Level != "error" || l.Data["trace_id"] != "5b8efbf34c7a4f109c2b1a3e2d4f5a6b" {
+					t.Errorf("Unexpected log: %+v", l)
+				}
+				if !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+					t.Errorf("Expected a JSON response")
+				}
+			}
+		})
+	}
+}
+
+func TestExportGRPC(t *testing.T) {
+	p := &receivertest.Producer{}
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	New(p, Options{}).RegisterGRPC(srv)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := collogspb.NewLogsServiceClient(conn)
+
+	resp, err := client.Export(context.Background(), exportRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetPartialSuccess().GetRejectedLogRecords() != 0 || len(p.Logs()) != 3 {
+		t.Errorf("Expected 3 logs produced, Got=%v (%v)", len(p.Logs()), resp)
+	}
+
+	p.Err = errors.New("down")
+	_, err = client.Export(context.Background(), exportRequest())
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("Expected=%v, Got=%v", codes.Unavailable, err)
+	}
+}
@@ -0,0 +1,131 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// GrokPatterns are the built-in grok patterns.
+var GrokPatterns = map[string]string{
+	"WORD":              `\b\w+\b`,
+	"NOTSPACE":          `\S+`,
+	"SPACE":             `\s*`,
+	"DATA":              `.*?`,
+	"GREEDYDATA":        `.*`,
+	"INT":               `[+-]?\d+`,
+	"NUMBER":            `[+-]?(?:\d+(?:\.\d*)?|\.\d+)`,
+	"BASE10NUM":         `[+-]?(?:\d+(?:\.\d*)?|\.\d+)`,
+	"POSINT":            `\b[1-9]\d*\b`,
+	"USERNAME":          `[a-zA-Z0-9._-]+`,
+	"USER":              `%{USERNAME}`,
+	"QUOTEDSTRING":      `"(?:[^"\\]|\\.)*"`,
+	"QS":                `%{QUOTEDSTRING}`,
+	"UUID":              `[A-Fa-f0-9]{8}-(?:[A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}`,
+	"IPV4":              `(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)`,
+	"IPV6":              `(?:[A-Fa-f0-9]{0,4}:){2,7}[A-Fa-f0-9]{0,4}`,
+	"IP":                `(?:%{IPV6}|%{IPV4})`,
+	"HOSTNAME":          `\b[0-9A-Za-z][0-9A-Za-z-]{0,62}(?:\.[0-9A-Za-z][0-9A-Za-z-]{0,62})*\.?\b`,
+	"IPORHOST":          `(?:%{IP}|%{HOSTNAME})`,
+	"HOSTPORT":          `%{IPORHOST}:%{POSINT}`,
+	"PATH":              `(?:/[^\s]*)+`,
+	"URIPATH":           `(?:/[A-Za-z0-9$.+!*'(){},~:;=@#%&_\-]*)+`,
+	"URIPARAM":          `\?[A-Za-z0-9$.+!*'|(){},~@#%&/=:;_?\-\[\]<>]*`,
+	"URIPATHPARAM":      `%{URIPATH}(?:%{URIPARAM})?`,
+	"LOGLEVEL":          `(?i:trace|debug|info|notice|warn(?:ing)?|err(?:or)?|crit(?:ical)?|fatal|severe|emerg(?:ency)?|alert|panic)`,
+	"MONTH":             `\b(?:Jan(?:uary)?|Feb(?:ruary)?|Mar(?:ch)?|Apr(?:il)?|May|Jun(?:e)?|Jul(?:y)?|Aug(?:ust)?|Sep(?:tember)?|Oct(?:ober)?|Nov(?:ember)?|Dec(?:ember)?)\b`,
+	"MONTHDAY":          `(?:0[1-9]|[12]\d|3[01]|[1-9])`,
+	"YEAR":              `\d{4}`,
+	"TIME":              `\d{2}:\d{2}(?::\d{2}(?:[.,]\d+)?)?`,
+	"ISO8601_TIMEZONE":  `(?:Z|[+-]\d{2}(?::?\d{2})?)`,
+	"TIMESTAMP_ISO8601": `\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}(?::?\d{2}(?:[.,]\d+)?)?%{ISO8601_TIMEZONE}?`,
+	"HTTPDATE":          `%{MONTHDAY}/%{MONTH}/%{YEAR}:%{TIME} [+-]\d{4}`,
+	"SYSLOGTIMESTAMP":   `%{MONTH} +%{MONTHDAY} %{TIME}`,
+	"COMMONAPACHELOG":   `%{IPORHOST:client.ip} %{USER:ident} %{USER:auth} \[%{HTTPDATE:timestamp}\] "(?:%{WORD:http.method} %{NOTSPACE:http.path}(?: HTTP/%{NUMBER:http.version})?|%{DATA:http.request})" %{NUMBER:http.status:int} (?:%{NUMBER:http.bytes:int}|-)`,
+}
+
+var grokReference = regexp.MustCompile(`%\{(\w+)(?::([\w.@-]+))?(?::(int|float))?\}`)
+
+// Grok parses lines with a grok pattern, such as
+// `%{IP:client} %{WORD:method} %{URIPATHPARAM:path} %{NUMBER:bytes:int}`,
+// which is a regular expression whose %{PATTERN:field:type} references
+// are replaced by the patterns they name. The captured fields are
+// typed with int or float when asked to.
+type Grok struct {
+	*Regex
+}
+
+// NewGrok compiles a grok pattern, patterns adding to or overriding
+// GrokPatterns.
+func NewGrok(pattern string, patterns map[string]string) (*Grok, error) {
+	c := &grokCompiler{patterns: patterns}
+	expr, err := c.expand(pattern, 0)
+	if err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	names := re.SubexpNames()
+	fields := make([]string, len(names))
+	types := make([]string, len(names))
+	for i, name := range names {
+		if name == "" {
+			continue
+		}
+		// The named groups of the pattern itself are fields as well.
+		var n int
+		if _, err := fmt.Sscanf(name, "_grok%d", &n); err != nil || n >= len(c.fields) {
+			fields[i] = name
+			continue
+		}
+		fields[i] = c.fields[n]
+		types[i] = c.types[n]
+	}
+	return &Grok{&Regex{re: re, names: names, fields: fields, types: types}}, nil
+}
+
+type grokCompiler struct {
+	patterns map[string]string
+	// fields and types are the ones of the captures, the groups being
+	// named _grok0, _grok1... since field names are not valid group
+	// names.
+	fields []string
+	types  []string
+}
+
+func (c *grokCompiler) lookup(name string) (string, bool) {
+	if p, ok := c.patterns[name]; ok {
+		return p, true
+	}
+	p, ok := GrokPatterns[name]
+	return p, ok
+}
+
+func (c *grokCompiler) expand(pattern string, depth int) (string, error) {
+	if depth > 16 {
+		return "", fmt.Errorf("grok patterns nested too deeply")
+	}
+	var err error
+	expanded := grokReference.ReplaceAllStringFunc(pattern, func(ref string) string {
+		m := grokReference.FindStringSubmatch(ref)
+		def, ok := c.lookup(m[1])
+		if !ok {
+			err = fmt.Errorf("unknown grok pattern %q", m[1])
+			return ""
+		}
+		sub, e := c.expand(def, depth+1)
+		if e != nil {
+			err = e
+			return ""
+		}
+		if m[2] == "" {
+			return "(?:" + sub + ")"
+		}
+		name := fmt.Sprintf("_grok%d", len(c.fields))
+		c.fields = append(c.fields, m[2])
+		c.types = append(c.types, m[3])
+		return "(?P<" + name + ">" + sub + ")"
+	})
+	return expanded, err
+}
@@ -0,0 +1,75 @@
+package parser
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// Java: "\tat com.acme.Main.run(Main.java:42)", "Caused by: ...",
+	// "\t... 12 more".
+	javaContinuation = regexp.MustCompile(`^(?:Caused by: |Suppressed: |\.\.\. \d+ (?:more|common frames omitted))`)
+	// Python: "Traceback (most recent call last):", the indented frames
+	// then the exception, "ValueError: invalid literal".
+	pythonTraceback = regexp.MustCompile(`^Traceback \(most recent call last\):$`)
+	pythonException = regexp.MustCompile(`^[A-Za-z_][\w.]*(?:: .*)?$`)
+	// Go: "panic: boom", "goroutine 1 [running]:", the functions
+	// "main.main()" followed by their indented files.
+	goPanic     = regexp.MustCompile(`^(?:panic|fatal error): `)
+	goGoroutine = regexp.MustCompile(`^goroutine \d+ \[.*\]:$`)
+	goFunction  = regexp.MustCompile(`^(?:created by )?[\w./*()\[\]-]+\(.*\)(?: in goroutine \d+)?$`)
+)
+
+type traceState int
+
+const (
+	noTrace traceState = iota
+	pythonTrace
+	goTrace
+)
+
+// Multiline groups the lines of the events spanning several lines. It
+// either uses an expression matching the first line of the events, or
+// recognizes the Java, Python and Go stack traces.
+type Multiline struct {
+	start    *regexp.Regexp
+	state    traceState
+	indented bool
+}
+
+// Continues tells whether line belongs to the event of the previous
+// lines, rather than starting a new one. It must see every line, in
+// order.
+func (m *Multiline) Continues(line string) bool {
+	if m.start != nil {
+		return !m.start.MatchString(line)
+	}
+	indented := strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+	wasIndented := m.indented
+	m.indented = indented
+	switch {
+	case line == "":
+		return m.state == goTrace
+	case indented:
+		return true
+	case javaContinuation.MatchString(line):
+		return true
+	case pythonTraceback.MatchString(line):
+		m.state = pythonTrace
+		return true
+	case m.state == pythonTrace && wasIndented && pythonException.MatchString(line):
+		// The exception ends the trace.
+		m.state = noTrace
+		return true
+	case goGoroutine.MatchString(line):
+		m.state = goTrace
+		return true
+	case m.state == goTrace && (goFunction.MatchString(line) || strings.HasPrefix(line, "exit status ")):
+		return true
+	}
+	m.state = noTrace
+	if goPanic.MatchString(line) {
+		m.state = goTrace
+	}
+	return false
+}
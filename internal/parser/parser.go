@@ -0,0 +1,189 @@
+// Package parser extracts fields from the plain-text logs: JSON
+// documents, logfmt, named-capture regular expressions and grok
+// patterns. The rules of the configuration decide which parser reads
+// the lines of a channel or source, and the extracted level, timestamp
+// and message are moved to the envelope of the log.
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Parser extracts the fields of a line. It returns false when the line
+// does not have the expected format.
+type Parser interface {
+	Parse(line string) (map[string]interface{}, bool)
+}
+
+// JSON parses lines holding a JSON object.
+type JSON struct{}
+
+func (JSON) Parse(line string) (map[string]interface{}, bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "{") {
+		return nil, false
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		return nil, false
+	}
+	return fields, true
+}
+
+// Logfmt parses key=value pairs separated by spaces, the values being
+// optionally quoted. A key without value is set to true.
+type Logfmt struct {
+	// Strict rejects the lines having words that are not pairs, which
+	// tells logfmt apart from free text.
+	Strict bool
+}
+
+func (p Logfmt) Parse(line string) (map[string]interface{}, bool) {
+	fields := map[string]interface{}{}
+	s := strings.TrimSpace(line)
+	for len(s) > 0 {
+		end := strings.IndexAny(s, "= ")
+		if end < 0 {
+			end = len(s)
+		}
+		key := s[:end]
+		if key == "" {
+			return nil, false
+		}
+		s = s[end:]
+		if !strings.HasPrefix(s, "=") {
+			if p.Strict {
+				return nil, false
+			}
+			fields[key] = true
+			s = strings.TrimLeft(s, " ")
+			continue
+		}
+		s = s[1:]
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			quoted, rest, ok := unquote(s)
+			if !ok {
+				return nil, false
+			}
+			value, s = quoted, rest
+		} else {
+			i := strings.IndexByte(s, ' ')
+			if i < 0 {
+				i = len(s)
+			}
+			value, s = s[:i], s[i:]
+		}
+		fields[key] = value
+		s = strings.TrimLeft(s, " ")
+	}
+	if len(fields) == 0 {
+		return nil, false
+	}
+	return fields, true
+}
+
+// unquote reads a quoted string at the beginning of s and returns it
+// along with what follows.
+func unquote(s string) (string, string, bool) {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			v, err := strconv.Unquote(s[:i+1])
+			if err != nil {
+				return "", "", false
+			}
+			return v, s[i+1:], true
+		}
+	}
+	return "", "", false
+}
+
+// Regex parses lines with a regular expression, the named groups being
+// the fields.
+type Regex struct {
+	re     *regexp.Regexp
+	names  []string
+	fields []string
+	types  []string
+}
+
+// NewRegex compiles a regular expression with named groups, such as
+// `^(?P<level>\w+) (?P<message>.*)$`.
+func NewRegex(expr string) (*Regex, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	names := re.SubexpNames()
+	return &Regex{re: re, names: names, fields: names, types: make([]string, len(names))}, nil
+}
+
+func (p *Regex) Parse(line string) (map[string]interface{}, bool) {
+	match := p.re.FindStringSubmatchIndex(line)
+	if match == nil {
+		return nil, false
+	}
+	fields := map[string]interface{}{}
+	for i := 1; i < len(p.names); i++ {
+		if p.fields[i] == "" || match[2*i] < 0 {
+			continue
+		}
+		fields[p.fields[i]] = convert(line[match[2*i]:match[2*i+1]], p.types[i])
+	}
+	return fields, true
+}
+
+// convert converts a captured value to the type of its field, keeping
+// the string when it does not convert.
+func convert(v, typ string) interface{} {
+	switch typ {
+	case "int":
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return n
+		}
+	case "float":
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	}
+	return v
+}
+
+// Auto parses JSON objects, and logfmt when every word of the line is
+// a pair. The other lines are free text, without fields.
+type Auto struct{}
+
+func (Auto) Parse(line string) (map[string]interface{}, bool) {
+	if fields, ok := (JSON{}).Parse(line); ok {
+		return fields, true
+	}
+	return Logfmt{Strict: true}.Parse(line)
+}
+
+// New returns the parser of a format: auto, json, logfmt, regex, grok
+// or none. pattern is the expression of the regex and grok formats, and
+// patterns the custom grok patterns.
+func New(format, pattern string, patterns map[string]string) (Parser, error) {
+	switch format {
+	case "", "auto":
+		return Auto{}, nil
+	case "json":
+		return JSON{}, nil
+	case "logfmt":
+		return Logfmt{}, nil
+	case "regex":
+		return NewRegex(pattern)
+	case "grok":
+		return NewGrok(pattern, patterns)
+	case "none":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
@@ -0,0 +1,282 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperbolicresearch/hlog/config"
+	"github.com/hyperbolicresearch/hlog/internal/core"
+)
+
+func TestParsers(t *testing.T) {
+	grok, err := NewGrok(`%{COMMONAPACHELOG}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	custom, err := NewGrok(`%{LEVEL:level} \[%{WORD:component}\] %{GREEDYDATA:message}`,
+		map[string]string{"LEVEL": `[A-Z]+`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	regex, err := NewRegex(`^(?P<level>\w+): (?P<message>.*)$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		parser Parser
+		line   string
+		expect map[string]interface{}
+		ok     bool
+	}{
+		{"json", JSON{}, `{"level":"warn","n":1}`, map[string]interface{}{"level": "warn", "n": 1.0}, true},
+		{"json invalid", JSON{}, `{"level":`, nil, false},
+		{"json not an object", JSON{}, `[1]`, nil, false},
+		{
+			"logfmt", Logfmt{}, `level=info msg="user logged in" user=42 admin`,
+			map[string]interface{}{"level": "info", "msg": "user logged in", "user": "42", "admin": true}, true,
+		},
+		{"logfmt escaped quote", Logfmt{}, `msg="say \"hi\""`, map[string]interface{}{"msg": `say "hi"`}, true},
+		{"logfmt unterminated", Logfmt{}, `msg="oops`, nil, false},
+		{"regex", regex, "ERROR: disk full", map[string]interface{}{"level": "ERROR", "message": "disk full"}, true},
+		{"regex no match", regex, "disk full", nil, false},
+		{
+			"grok apache", grok, `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326`,
+			map[string]interface{}{
+				"client.ip": "127.0.0.1", "ident": "-", "auth": "frank",
+				"timestamp": "10/Oct/2000:13:55:36 -0700", "http.method": "GET",
+				"http.path": "/apache_pb.gif", "http.version": "1.0",
+				"http.status": int64(200), "http.bytes": int64(2326),
+			}, true,
+		},
+		{
+			"grok custom", custom, "WARN [db] slow query",
+			map[string]interface{}{"level": "WARN", "component": "db", "message": "slow query"}, true,
+		},
+		{"auto json", Auto{}, `{"a":"b"}`, map[string]interface{}{"a": "b"}, true},
+		{"auto logfmt", Auto{}, `a=b c="d e"`, map[string]interface{}{"a": "b", "c": "d e"}, true},
+		{"auto text", Auto{}, `connection reset by peer a=b`, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.parser.Parse(tt.line)
+			if ok != tt.ok {
+				t.Fatalf("Expected=%v, Got=%v", tt.ok, ok)
+			}
+			if ok && !reflect.DeepEqual(got, tt.expect) {
+				t.Errorf("Expected=%v, Got=%v", tt.expect, got)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, format := range []string{"", "auto", "json", "logfmt", "none"} {
+		if _, err := New(format, "", nil); err != nil {
+			t.Errorf("%s: %v", format, err)
+		}
+	}
+	if _, err := New("xml", "", nil); err == nil {
+		t.Errorf("Expected an error for an unknown format")
+	}
+	if _, err := New("grok", "%{NOPE:x}", nil); err == nil {
+		t.Errorf("Expected an error for an unknown grok pattern")
+	}
+	if _, err := New("grok", "%{LOOP}", map[string]string{"LOOP": "%{LOOP}"}); err == nil {
+		t.Errorf("Expected an error for recursive grok patterns")
+	}
+}
+
+func TestMultiline(t *testing.T) {
+	tests := []struct {
+		name   string
+		start  string
+		lines  []string
+		expect []string
+	}{
+		{
+			name: "java",
+			lines: []string{
+				"2024-01-01 ERROR request failed",
+				"java.lang.IllegalStateException: boom",
+				"\tat com.acme.Main.run(Main.java:42)",
+				"Caused by: java.io.IOException: closed",
+				"\tat com.acme.Io.read(Io.java:7)",
+				"\t... 12 more",
+				"2024-01-01 INFO next",
+			},
+			// The exception line is not indented: it starts an event.
+			expect: []string{
+				"2024-01-01 ERROR request failed",
+				"java.lang.IllegalStateException: boom\n\tat com.acme.Main.run(Main.java:42)\nCaused by: java.io.IOException: closed\n\tat com.acme.Io.read(Io.java:7)\n\t... 12 more",
+				"2024-01-01 INFO next",
+			},
+		},
+		{
+			name: "python",
+			lines: []string{
+				"Traceback (most recent call last):",
+				`  File "app.py", line 3, in <module>`,
+				"    main()",
+				"ValueError: invalid literal",
+				"done",
+			},
+			expect: []string{
+				"Traceback (most recent call last):\n  File \"app.py\", line 3, in <module>\n    main()\nValueError: invalid literal",
+				"done",
+			},
+		},
+		{
+			name: "go",
+			lines: []string{
+				"panic: boom",
+				"",
+				"goroutine 1 [running]:",
+				"main.main()",
+				"\t/app/main.go:5 +0x1d",
+				"exit status 2",
+				"started",
+			},
+			expect: []string{
+				"panic: boom\n\ngoroutine 1 [running]:\nmain.main()\n\t/app/main.go:5 +0x1d\nexit status 2",
+				"started",
+			},
+		},
+		{
+			name:   "start pattern",
+			start:  `^\[`,
+			lines:  []string{"[1] a", "b", "[2] c", "[3] d", "e"},
+			expect: []string{"[1] a\nb", "[2] c", "[3] d\ne"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := NewRule(config.ParserRule{Multiline: "stacktrace"})
+			if tt.start != "" {
+				rule, err = NewRule(config.ParserRule{Multiline: tt.start})
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := rule.Multiline()
+			var got, event []string
+			for _, l := range tt.lines {
+				if !m.Continues(l) && len(event) > 0 {
+					got = append(got, strings.Join(event, "\n"))
+					event = nil
+				}
+				event = append(event, l)
+			}
+			got = append(got, strings.Join(event, "\n"))
+			if !reflect.DeepEqual(got, tt.expect) {
+				t.Errorf("Expected=%q, Got=%q", tt.expect, got)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	ts := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC).UnixNano()
+	tests := []struct {
+		name   string
+		rule   config.ParserRule
+		text   string
+		expect core.Log
+	}{
+		{
+			name: "json",
+			text: `{"level":"WARNING","ts":1709294400123,"msg":"slow","took":1.5}`,
+			expect: core.Log{
+				Level: "warn", Timestamp: ts + 123e6, Message: "slow",
+				Data: map[string]interface{}{"took": 1.5},
+			},
+		},
+		{
+			name: "json without message",
+			text: `{"severity":"crit","time":"2024-03-01T12:00:00Z","user":"a"}`,
+			expect: core.Log{
+				Level: "fatal", Timestamp: ts,
+				Data: map[string]interface{}{"user": "a"},
+			},
+		},
+		{
+			name: "logfmt",
+			rule: config.ParserRule{Format: "logfmt"},
+			text: `time="2024-03-01 12:00:00" lvl=err msg="disk full" disk=sda`,
+			expect: core.Log{
+				Level: "error", Timestamp: ts, Message: "disk full",
+				Data: map[string]interface{}{"disk": "sda"},
+			},
+		},
+		{
+			name: "free text",
+			text: "connection reset\n\tat x",
+			expect: core.Log{
+				Level: "info", Message: "connection reset\n\tat x",
+				Data: map[string]interface{}{},
+			},
+		},
+		{
+			name: "regex with fields and format",
+			rule: config.ParserRule{
+				Format:     "regex",
+				Pattern:    `^(?P<when>\S+) (?P<sev>\w+) (?P<message>.*)$`,
+				LevelField: "sev", TimeField: "when", TimeFormat: "20060102T150405",
+			},
+			text: "20240301T120000 notice started\nsecond line",
+			expect: core.Log{
+				Level: "info", Timestamp: ts, Message: "started\nsecond line",
+				Data: map[string]interface{}{},
+			},
+		},
+		{
+			name: "unknown level kept",
+			text: `{"level":"verbose","message":"m"}`,
+			expect: core.Log{
+				Level: "info", Message: "m",
+				Data: map[string]interface{}{"level": "verbose"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := NewRule(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := core.Log{Level: "info", Data: map[string]interface{}{}}
+			rule.Apply(&got, tt.text)
+			if !reflect.DeepEqual(got, tt.expect) {
+				t.Errorf("Expected=%+v, Got=%+v", tt.expect, got)
+			}
+		})
+	}
+}
+
+func TestSelect(t *testing.T) {
+	rules, err := NewRules(&config.Parsing{Rules: []config.ParserRule{
+		{Channel: "nginx", Format: "json"},
+		{Source: "/var/log/*.log", Format: "logfmt"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		channel, source string
+		expect          Parser
+	}{
+		{"nginx", "/var/log/app.log", JSON{}},
+		{"app", "/var/log/app.log", Logfmt{}},
+		{"app", "/tmp/app.log", Auto{}},
+	}
+	for _, tt := range tests {
+		if got := rules.Select(tt.channel, tt.source).parser; got != tt.expect {
+			t.Errorf("Expected=%T, Got=%T", tt.expect, got)
+		}
+	}
+	if _, err := NewRules(&config.Parsing{Rules: []config.ParserRule{{Multiline: "("}}}); err == nil {
+		t.Errorf("Expected an error for an invalid multiline expression")
+	}
+}
@@ -0,0 +1,287 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperbolicresearch/hlog/config"
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/pkg/logger"
+)
+
+var (
+	levelFields   = []string{"level", "lvl", "severity", "loglevel", "log.level"}
+	timeFields    = []string{"timestamp", "time", "ts", "@timestamp", "datetime"}
+	messageFields = []string{"message", "msg", "log"}
+
+	// timeLayouts are the layouts tried for the timestamps given as
+	// strings without TimeFormat.
+	timeLayouts = []string{
+		time.RFC3339Nano,
+		"2006-01-02T15:04:05.999999999",
+		"2006-01-02 15:04:05.999999999Z07:00",
+		"2006-01-02 15:04:05.999999999",
+		"2006-01-02 15:04:05,999999999",
+		"02/Jan/2006:15:04:05 -0700",
+		time.RFC1123Z,
+		time.RFC1123,
+		time.UnixDate,
+		time.Stamp,
+	}
+
+	// levelAliases are the level names used by other logging libraries.
+	levelAliases = map[string]logger.Level{
+		"trace":     logger.DEBUG,
+		"notice":    logger.INFO,
+		"err":       logger.ERROR,
+		"severe":    logger.ERROR,
+		"crit":      logger.FATAL,
+		"critical":  logger.FATAL,
+		"alert":     logger.FATAL,
+		"emerg":     logger.FATAL,
+		"emergency": logger.FATAL,
+		"panic":     logger.FATAL,
+	}
+)
+
+// Rule parses the lines of a channel or source.
+type Rule struct {
+	channel      string
+	source       string
+	parser       Parser
+	multiline    string
+	start        *regexp.Regexp
+	levelField   string
+	timeField    string
+	messageField string
+	timeFormat   string
+}
+
+// NewRule compiles a rule of the configuration.
+func NewRule(cfg config.ParserRule) (*Rule, error) {
+	if _, err := path.Match(cfg.Source, ""); err != nil {
+		return nil, fmt.Errorf("invalid source %q: %v", cfg.Source, err)
+	}
+	p, err := New(cfg.Format, cfg.Pattern, cfg.GrokPatterns)
+	if err != nil {
+		return nil, err
+	}
+	r := &Rule{
+		channel:      cfg.Channel,
+		source:       cfg.Source,
+		parser:       p,
+		multiline:    cfg.Multiline,
+		levelField:   cfg.LevelField,
+		timeField:    cfg.TimeField,
+		messageField: cfg.MessageField,
+		timeFormat:   cfg.TimeFormat,
+	}
+	if cfg.Multiline != "" && cfg.Multiline != "stacktrace" {
+		if r.start, err = regexp.Compile(cfg.Multiline); err != nil {
+			return nil, fmt.Errorf("invalid multiline expression: %v", err)
+		}
+	}
+	return r, nil
+}
+
+// matches tells whether the rule applies to the lines of a channel and
+// source.
+func (r *Rule) matches(channel, source string) bool {
+	if r.channel != "" && r.channel != channel {
+		return false
+	}
+	if r.source != "" {
+		ok, _ := path.Match(r.source, source)
+		return ok
+	}
+	return true
+}
+
+// Multiline returns a new grouper of the lines of the events, or nil if
+// the rule does not group lines.
+func (r *Rule) Multiline() *Multiline {
+	switch {
+	case r.start != nil:
+		return &Multiline{start: r.start}
+	case r.multiline == "stacktrace":
+		return &Multiline{}
+	}
+	return nil
+}
+
+// Apply parses text into l. The message is the text itself, or what
+// the fields tell, except for JSON documents whose content is all in
+// the fields. For multiline events, only the first line is parsed and
+// the following ones are appended to the message.
+func (r *Rule) Apply(l *core.Log, text string) {
+	first, rest, multiline := strings.Cut(text, "\n")
+	var fields map[string]interface{}
+	ok := false
+	if r.parser != nil {
+		fields, ok = r.parser.Parse(first)
+	}
+	if !ok {
+		l.Message = text
+		return
+	}
+	l.Message = first
+	if _, isJSON := r.parser.(JSON); isJSON || looksJSON(r.parser, first) {
+		l.Message = ""
+	}
+	r.ApplyFields(l, fields)
+	if multiline {
+		l.Message = strings.TrimPrefix(l.Message+"\n"+rest, "\n")
+	}
+}
+
+// ApplyFields sets the level, timestamp and message found in fields on
+// the envelope of l, and adds the other fields to its data. The values
+// that cannot be read, such as unknown levels, stay in the data. fields
+// is modified.
+func (r *Rule) ApplyFields(l *core.Log, fields map[string]interface{}) {
+	if l.Data == nil {
+		l.Data = map[string]interface{}{}
+	}
+	if key, v, found := lookup(fields, r.levelField, levelFields); found {
+		if level, ok := ParseLevel(v); ok {
+			l.Level = level.String()
+			delete(fields, key)
+		}
+	}
+	if key, v, found := lookup(fields, r.timeField, timeFields); found {
+		if ts, ok := ParseTime(v, r.timeFormat); ok {
+			l.Timestamp = ts.UnixNano()
+			delete(fields, key)
+		}
+	}
+	if key, v, found := lookup(fields, r.messageField, messageFields); found {
+		if s, ok := v.(string); ok {
+			l.Message = s
+			delete(fields, key)
+		}
+	}
+	for k, v := range fields {
+		l.Data[k] = v
+	}
+}
+
+// looksJSON tells whether the auto parser found a JSON document.
+func looksJSON(p Parser, line string) bool {
+	if _, ok := p.(Auto); !ok {
+		return false
+	}
+	return strings.HasPrefix(strings.TrimSpace(line), "{")
+}
+
+// lookup finds the first of the candidate fields, or the configured one.
+func lookup(fields map[string]interface{}, configured string, candidates []string) (string, interface{}, bool) {
+	if configured != "" {
+		v, ok := fields[configured]
+		return configured, v, ok
+	}
+	for _, key := range candidates {
+		if v, ok := fields[key]; ok {
+			return key, v, true
+		}
+	}
+	return "", nil, false
+}
+
+// ParseLevel parses the level names of logger and of other libraries.
+func ParseLevel(v interface{}) (logger.Level, bool) {
+	s, ok := v.(string)
+	if !ok {
+		return 0, false
+	}
+	if level, ok := logger.ParseLevel(s); ok {
+		return level, true
+	}
+	level, ok := levelAliases[strings.ToLower(strings.TrimSpace(s))]
+	return level, ok
+}
+
+// ParseTime parses a timestamp given with layout, or detected: numbers
+// are Unix times in seconds, milliseconds, microseconds or nanoseconds
+// depending on their magnitude, strings are tried against timeLayouts.
+func ParseTime(v interface{}, layout string) (time.Time, bool) {
+	var n float64
+	switch x := v.(type) {
+	case float64:
+		n = x
+	case int64:
+		n = float64(x)
+	case uint64:
+		n = float64(x)
+	case string:
+		if layout != "" {
+			ts, err := time.Parse(layout, x)
+			return ts, err == nil
+		}
+		if f, err := strconv.ParseFloat(x, 64); err == nil {
+			n = f
+			break
+		}
+		for _, l := range timeLayouts {
+			if ts, err := time.Parse(l, x); err == nil {
+				if ts.Year() == 0 {
+					ts = ts.AddDate(time.Now().Year(), 0, 0)
+				}
+				return ts, true
+			}
+		}
+		return time.Time{}, false
+	default:
+		return time.Time{}, false
+	}
+	if n <= 0 || math.IsInf(n, 0) || math.IsNaN(n) {
+		return time.Time{}, false
+	}
+	switch {
+	case n >= 1e17:
+		return time.Unix(0, int64(n)), true
+	case n >= 1e14:
+		return time.UnixMicro(int64(n)), true
+	case n >= 1e11:
+		return time.UnixMilli(int64(n)), true
+	}
+	sec, frac := math.Modf(n)
+	return time.Unix(int64(sec), int64(frac*1e9)), true
+}
+
+// Rules are the parsing rules of the configuration.
+type Rules struct {
+	rules []*Rule
+	auto  *Rule
+}
+
+// NewRules compiles the rules of the configuration.
+func NewRules(cfg *config.Parsing) (*Rules, error) {
+	rs := &Rules{auto: &Rule{parser: Auto{}}}
+	if cfg == nil {
+		return rs, nil
+	}
+	for i, rc := range cfg.Rules {
+		r, err := NewRule(rc)
+		if err != nil {
+			return nil, fmt.Errorf("parsing rule %d: %v", i, err)
+		}
+		rs.rules = append(rs.rules, r)
+	}
+	return rs, nil
+}
+
+// Select returns the first rule matching the channel and the source, or
+// the auto one.
+func (rs *Rules) Select(channel, source string) *Rule {
+	for _, r := range rs.rules {
+		if r.matches(channel, source) {
+			return r
+		}
+	}
+	return rs.auto
+}
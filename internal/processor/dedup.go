@@ -0,0 +1,205 @@
+package processor
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hyperbolicresearch/hlog/config"
+	"github.com/hyperbolicresearch/hlog/internal/core"
+)
+
+const (
+	defaultDedupWindow   = 10 * time.Second
+	defaultDedupKeys     = 10000
+	defaultDedupIDWindow = 10 * time.Minute
+	defaultDedupIDKeys   = 100000
+	templatePlaceholder  = "<*>"
+)
+
+// variablePattern matches the parts of the messages that change between
+// the logs of a same statement: quoted strings, UUIDs, hexadecimal
+// numbers and decimal ones, including the dotted ones like addresses.
+var variablePattern = regexp.MustCompile(`"[^"]*"|'[^']*'|(?i:` +
+	`[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}|0x[0-9a-f]+|` +
+	`\b[0-9a-f]*[a-f][0-9a-f]*\d[0-9a-f]*\b|\b[0-9a-f]*\d[0-9a-f]*[a-f][0-9a-f]*\b)|` +
+	`\d+(?:\.\d+)*`)
+
+// Template returns the template of a message, its variable parts being
+// replaced with <*>.
+func Template(message string) string {
+	return variablePattern.ReplaceAllLiteralString(message, templatePlaceholder)
+}
+
+// Flusher is implemented by the stages holding logs back, which they
+// release when Flush is called after their time has come.
+type Flusher interface {
+	Flush(now time.Time) []*core.Log
+}
+
+// repeats are the logs of a template seen within a window.
+type repeats struct {
+	started   time.Time
+	last      *core.Log
+	count     int
+	firstSeen int64
+	lastSeen  int64
+}
+
+// dedup collapses the repeated logs.
+type dedup struct {
+	window  time.Duration
+	maxKeys int
+
+	mu      sync.Mutex
+	groups  map[string]*repeats
+	pending []*core.Log
+
+	collapsed atomic.Uint64
+	summaries atomic.Uint64
+}
+
+func newDedup(cfg config.ProcessorStage) (Stage, error) {
+	s := &dedup{window: cfg.Window, maxKeys: cfg.MaxKeys, groups: map[string]*repeats{}}
+	if s.window < 0 || s.maxKeys < 0 {
+		return nil, fmt.Errorf("negative window or maximum number of keys")
+	}
+	if s.window == 0 {
+		s.window = defaultDedupWindow
+	}
+	if s.maxKeys == 0 {
+		s.maxKeys = defaultDedupKeys
+	}
+	return s, nil
+}
+
+func (s *dedup) Process(l *core.Log) (bool, error) {
+	now := time.Now()
+	key := l.Channel + "\x00" + l.SenderId + "\x00" + l.Level + "\x00" + Template(l.Message)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g := s.groups[key]
+	if g != nil && now.Sub(g.started) >= s.window {
+		s.close(key, g)
+		g = nil
+	}
+	if g == nil {
+		// Past the maximum number of templates, the new ones go through
+		// without being tracked.
+		if len(s.groups) < s.maxKeys {
+			s.groups[key] = &repeats{started: now, firstSeen: l.Timestamp, lastSeen: l.Timestamp}
+		}
+		return true, nil
+	}
+	g.count++
+	g.last = l
+	if l.Timestamp > g.lastSeen {
+		g.lastSeen = l.Timestamp
+	}
+	if l.Timestamp < g.firstSeen {
+		g.firstSeen = l.Timestamp
+	}
+	s.collapsed.Add(1)
+	return false, nil
+}
+
+// close ends the window of a template, the last of its repeated logs
+// being released with their count.
+func (s *dedup) close(key string, g *repeats) {
+	delete(s.groups, key)
+	if g.count == 0 {
+		return
+	}
+	l := g.last
+	if l.Data == nil {
+		l.Data = map[string]interface{}{}
+	}
+	l.Data["repeat_count"] = g.count
+	l.Data["first_seen"] = int(g.firstSeen)
+	l.Data["last_seen"] = int(g.lastSeen)
+	s.pending = append(s.pending, l)
+	s.summaries.Add(1)
+}
+
+func (s *dedup) Flush(now time.Time) []*core.Log {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, g := range s.groups {
+		if now.Sub(g.started) >= s.window {
+			s.close(key, g)
+		}
+	}
+	logs := s.pending
+	s.pending = nil
+	return logs
+}
+
+// Counts returns the number of logs collapsed, and of logs released in
+// their place.
+func (s *dedup) Counts() map[string]uint64 {
+	return map[string]uint64{
+		"collapsed": s.collapsed.Load(),
+		"summaries": s.summaries.Load(),
+	}
+}
+
+// seenID is a log id and when it was seen.
+type seenID struct {
+	id   string
+	seen time.Time
+}
+
+// dedupID drops the logs whose id was seen recently.
+type dedupID struct {
+	window  time.Duration
+	maxKeys int
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+	// order holds the ids in the order they were seen, which is also the
+	// order they expire in.
+	order []seenID
+
+	duplicates atomic.Uint64
+}
+
+func newDedupID(cfg config.ProcessorStage) (Stage, error) {
+	s := &dedupID{window: cfg.Window, maxKeys: cfg.MaxKeys, seen: map[string]time.Time{}}
+	if s.window < 0 || s.maxKeys < 0 {
+		return nil, fmt.Errorf("negative window or maximum number of keys")
+	}
+	if s.window == 0 {
+		s.window = defaultDedupIDWindow
+	}
+	if s.maxKeys == 0 {
+		s.maxKeys = defaultDedupIDKeys
+	}
+	return s, nil
+}
+
+func (s *dedupID) Process(l *core.Log) (bool, error) {
+	if l.LogId == "" {
+		return true, nil
+	}
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.order) > 0 && (now.Sub(s.order[0].seen) >= s.window || len(s.order) >= s.maxKeys) {
+		delete(s.seen, s.order[0].id)
+		s.order = s.order[1:]
+	}
+	if _, ok := s.seen[l.LogId]; ok {
+		s.duplicates.Add(1)
+		return false, nil
+	}
+	s.seen[l.LogId] = now
+	s.order = append(s.order, seenID{id: l.LogId, seen: now})
+	return true, nil
+}
+
+// Counts returns the number of duplicates dropped.
+func (s *dedupID) Counts() map[string]uint64 {
+	return map[string]uint64{"duplicates": s.duplicates.Load()}
+}
@@ -0,0 +1,373 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/hyperbolicresearch/hlog/config"
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/internal/mongodb"
+)
+
+const (
+	// defaultReloadInterval is how often the lookup tables are reloaded
+	// when their configuration does not tell.
+	defaultReloadInterval = 30 * time.Second
+	// loadTimeout bounds the loading of a table from MongoDB.
+	loadTimeout = 30 * time.Second
+)
+
+// rows are the rows of a lookup table, by key.
+type rows map[string]map[string]interface{}
+
+// table is a lookup table, reloaded in the background when it changes.
+type table struct {
+	cfg      config.LookupTable
+	name     string
+	interval time.Duration
+	coll     *mongo.Collection
+	columns  map[string]bool
+
+	rows atomic.Pointer[rows]
+	// checked is when the table was last checked for changes, in Unix
+	// nanoseconds, and reloading is set while it is being reloaded.
+	checked   atomic.Int64
+	reloading atomic.Bool
+	// modTime and size identify the version of the file loaded.
+	modTime time.Time
+	size    int64
+
+	reloads      atomic.Uint64
+	reloadErrors atomic.Uint64
+}
+
+// openTable loads a lookup table.
+func openTable(cfg config.LookupTable) (*table, error) {
+	if cfg.Key == "" {
+		return nil, fmt.Errorf("lookup table without key")
+	}
+	t := &table{cfg: cfg, interval: cfg.ReloadInterval}
+	if t.interval <= 0 {
+		t.interval = defaultReloadInterval
+	}
+	if len(cfg.Columns) > 0 {
+		t.columns = make(map[string]bool, len(cfg.Columns))
+		for _, c := range cfg.Columns {
+			t.columns[c] = true
+		}
+	}
+	switch {
+	case cfg.File != "" && cfg.Collection != "":
+		return nil, fmt.Errorf("lookup table with both a file and a collection")
+	case cfg.File != "":
+		t.name = cfg.File
+	case cfg.Collection != "":
+		if cfg.MongoServer == "" || cfg.Database == "" {
+			return nil, fmt.Errorf("lookup table %s without server or database", cfg.Collection)
+		}
+		t.name = cfg.Database + "." + cfg.Collection
+		t.coll = mongodb.Client(cfg.MongoServer).Database(cfg.Database).Collection(cfg.Collection)
+	default:
+		return nil, fmt.Errorf("lookup table without file or collection")
+	}
+	r, err := t.load()
+	if err != nil {
+		return nil, fmt.Errorf("loading %s: %v", t.name, err)
+	}
+	t.rows.Store(&r)
+	t.checked.Store(time.Now().UnixNano())
+	return t, nil
+}
+
+// lookup returns the row of key.
+func (t *table) lookup(key string) (map[string]interface{}, bool) {
+	t.maybeReload()
+	row, ok := (*t.rows.Load())[key]
+	return row, ok
+}
+
+// maybeReload reloads the table in the background when it was not
+// checked for a while.
+func (t *table) maybeReload() {
+	now := time.Now().UnixNano()
+	if now-t.checked.Load() < int64(t.interval) || !t.reloading.CompareAndSwap(false, true) {
+		return
+	}
+	t.checked.Store(now)
+	go func() {
+		defer t.reloading.Store(false)
+		r, err := t.load()
+		if err != nil {
+			t.reloadErrors.Add(1)
+			log.Printf("processor: reloading %s: %v", t.name, err)
+			return
+		}
+		if r != nil {
+			t.rows.Store(&r)
+			t.reloads.Add(1)
+		}
+	}()
+}
+
+// load reads the table, and returns nil rows when its file did not
+// change since it was last read.
+func (t *table) load() (rows, error) {
+	if t.coll != nil {
+		return t.loadCollection()
+	}
+	info, err := os.Stat(t.cfg.File)
+	if err != nil {
+		return nil, err
+	}
+	if info.ModTime().Equal(t.modTime) && info.Size() == t.size {
+		return nil, nil
+	}
+	b, err := os.ReadFile(t.cfg.File)
+	if err != nil {
+		return nil, err
+	}
+	var records []map[string]interface{}
+	if isJSON(t.cfg.File, b) {
+		records, err = decodeJSONTable(b, t.cfg.Key)
+	} else {
+		records, err = decodeCSVTable(b)
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.modTime, t.size = info.ModTime(), info.Size()
+	return t.index(records), nil
+}
+
+func (t *table) loadCollection() (rows, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), loadTimeout)
+	defer cancel()
+	cur, err := t.coll.Find(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	var records []map[string]interface{}
+	for cur.Next(ctx) {
+		// The documents go through their relaxed extended JSON, so that
+		// their values have the types of the decoded JSON.
+		b, err := bson.MarshalExtJSON(cur.Current, false, false)
+		if err != nil {
+			return nil, err
+		}
+		var record map[string]interface{}
+		if err := json.Unmarshal(b, &record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+	return t.index(records), nil
+}
+
+// index keys the records, keeping their configured columns.
+func (t *table) index(records []map[string]interface{}) rows {
+	r := make(rows, len(records))
+	for _, record := range records {
+		k, ok := record[t.cfg.Key]
+		if !ok {
+			continue
+		}
+		key, err := toString(k)
+		if err != nil {
+			continue
+		}
+		row := make(map[string]interface{}, len(record))
+		for c, v := range record {
+			if c == t.cfg.Key || c == "_id" || t.columns != nil && !t.columns[c] {
+				continue
+			}
+			row[c] = v
+		}
+		r[key] = row
+	}
+	return r
+}
+
+// isJSON tells whether a table file is JSON, from its extension or its
+// first character.
+func isJSON(name string, b []byte) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		return true
+	case ".csv":
+		return false
+	}
+	b = bytes.TrimSpace(b)
+	return len(b) > 0 && (b[0] == '[' || b[0] == '{')
+}
+
+// decodeJSONTable decodes an array of objects, or an object of objects
+// whose keys are given to their key column.
+func decodeJSONTable(b []byte, key string) ([]map[string]interface{}, error) {
+	var records []map[string]interface{}
+	if err := json.Unmarshal(b, &records); err == nil {
+		return records, nil
+	}
+	var keyed map[string]map[string]interface{}
+	if err := json.Unmarshal(b, &keyed); err != nil {
+		return nil, fmt.Errorf("neither an array nor an object of objects: %v", err)
+	}
+	for k, record := range keyed {
+		if record == nil {
+			record = map[string]interface{}{}
+		}
+		record[key] = k
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// decodeCSVTable decodes a CSV file whose first line names the columns.
+func decodeCSVTable(b []byte) ([]map[string]interface{}, error) {
+	r := csv.NewReader(bytes.NewReader(b))
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	var records []map[string]interface{}
+	for {
+		line, err := r.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		record := make(map[string]interface{}, len(header))
+		for i, v := range line {
+			if i < len(header) {
+				record[strings.TrimSpace(header[i])] = v
+			}
+		}
+		records = append(records, record)
+	}
+}
+
+// enrich adds the columns of the row of a lookup table matching a field.
+type enrich struct {
+	field, to string
+	table     *table
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+
+	mu sync.Mutex
+	// reported is when the misses were last logged, and unreported the
+	// number of misses since.
+	reported   time.Time
+	unreported uint64
+}
+
+func newEnrich(cfg config.ProcessorStage) (Stage, error) {
+	if !validField(cfg.Field) {
+		return nil, fmt.Errorf("invalid field %q", cfg.Field)
+	}
+	to := cfg.To
+	if to == "" {
+		to = "data"
+	}
+	if _, ok := dataPath(to); !ok && to != "data" {
+		return nil, fmt.Errorf("invalid destination %q, not in the data", to)
+	}
+	if cfg.Table == nil {
+		return nil, fmt.Errorf("no lookup table")
+	}
+	t, err := openTable(*cfg.Table)
+	if err != nil {
+		return nil, err
+	}
+	return &enrich{field: cfg.Field, to: to, table: t}, nil
+}
+
+func (s *enrich) Process(l *core.Log) (bool, error) {
+	v, ok := Get(l, s.field)
+	if !ok {
+		s.miss("")
+		return true, nil
+	}
+	key, err := toString(v)
+	if err != nil {
+		return true, err
+	}
+	row, ok := s.table.lookup(key)
+	if !ok {
+		s.miss(key)
+		return true, nil
+	}
+	s.hits.Add(1)
+	for c, v := range row {
+		if err := Set(l, s.to+"."+c, deepCopy(v)); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// miss counts a key not found, and logs it unless misses were logged
+// recently.
+func (s *enrich) miss(key string) {
+	s.misses.Add(1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.reported) < errorLogInterval {
+		s.unreported++
+		return
+	}
+	log.Printf("processor: %q not found in %s (%d more misses since the last report)",
+		key, s.table.name, s.unreported)
+	s.reported, s.unreported = time.Now(), 0
+}
+
+// Counts returns the number of keys found and missed, and of reloads of
+// the table.
+func (s *enrich) Counts() map[string]uint64 {
+	return map[string]uint64{
+		"hits":          s.hits.Load(),
+		"misses":        s.misses.Load(),
+		"reloads":       s.table.reloads.Load(),
+		"reload_errors": s.table.reloadErrors.Load(),
+	}
+}
+
+// deepCopy copies the objects and arrays of the values shared by the
+// logs, such as the ones of the tables, which the next stages may modify
+// in place.
+func deepCopy(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, child := range v {
+			m[k] = deepCopy(child)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(v))
+		for i, child := range v {
+			a[i] = deepCopy(child)
+		}
+		return a
+	}
+	return v
+}
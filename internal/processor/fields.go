@@ -0,0 +1,271 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/internal/filter"
+)
+
+// The fields of a log are named after its JSON encoding: channel,
+// log_id, sender_id, timestamp, level and message for the envelope, and
+// data.<key> for its data, nested objects being reached with dots.
+
+// dataPath returns the path in the data of a field, and whether the
+// field is in the data.
+func dataPath(field string) (string, bool) {
+	return strings.CutPrefix(field, "data.")
+}
+
+// validField reports whether field names a field of the logs.
+func validField(field string) bool {
+	switch field {
+	case "channel", "log_id", "sender_id", "timestamp", "level", "message", "data":
+		return true
+	}
+	path, ok := dataPath(field)
+	return ok && path != ""
+}
+
+// Get returns the value of a field of l.
+func Get(l *core.Log, field string) (interface{}, bool) {
+	switch field {
+	case "channel":
+		return l.Channel, true
+	case "log_id":
+		return l.LogId, true
+	case "sender_id":
+		return l.SenderId, true
+	case "timestamp":
+		return l.Timestamp, true
+	case "level":
+		return l.Level, true
+	case "message":
+		return l.Message, true
+	case "data":
+		return l.Data, l.Data != nil
+	}
+	path, ok := dataPath(field)
+	if !ok {
+		return nil, false
+	}
+	return filter.Lookup(l.Data, path)
+}
+
+// Set sets a field of l to v. The envelope fields take strings and
+// scalars, the timestamp numbers, and the objects of the data path are
+// created when missing.
+func Set(l *core.Log, field string, v interface{}) error {
+	switch field {
+	case "channel", "log_id", "sender_id", "level", "message":
+		s, err := toString(v)
+		if err != nil {
+			return fmt.Errorf("%s: %v", field, err)
+		}
+		switch field {
+		case "channel":
+			l.Channel = s
+		case "log_id":
+			l.LogId = s
+		case "sender_id":
+			l.SenderId = s
+		case "level":
+			l.Level = s
+		case "message":
+			l.Message = s
+		}
+		return nil
+	case "timestamp":
+		n, err := toInt(v)
+		if err != nil {
+			return fmt.Errorf("timestamp: %v", err)
+		}
+		l.Timestamp = int64(n)
+		return nil
+	case "data":
+		data, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("data: %T is not an object", v)
+		}
+		l.Data = data
+		return nil
+	}
+	path, ok := dataPath(field)
+	if !ok || path == "" {
+		return fmt.Errorf("unknown field %q", field)
+	}
+	if l.Data == nil {
+		l.Data = map[string]interface{}{}
+	}
+	setPath(l.Data, path, v)
+	return nil
+}
+
+// setPath sets the value at a dotted path of data: an existing key
+// holding the whole path is overwritten, otherwise the path goes through
+// the nested objects, created when missing.
+func setPath(data map[string]interface{}, path string, v interface{}) {
+	if _, ok := data[path]; ok {
+		data[path] = v
+		return
+	}
+	head, rest, nested := strings.Cut(path, ".")
+	if !nested {
+		data[path] = v
+		return
+	}
+	switch child := data[head].(type) {
+	case map[string]interface{}:
+		setPath(child, rest, v)
+	case nil:
+		m := map[string]interface{}{}
+		data[head] = m
+		setPath(m, rest, v)
+	default:
+		data[path] = v
+	}
+}
+
+// Delete removes a field of l, the envelope fields being emptied. It
+// reports whether the field was there.
+func Delete(l *core.Log, field string) bool {
+	v, ok := Get(l, field)
+	if !ok {
+		return false
+	}
+	switch field {
+	case "data":
+		l.Data = map[string]interface{}{}
+	case "channel", "log_id", "sender_id", "level", "message", "timestamp":
+		Set(l, field, zero(v))
+	default:
+		path, _ := dataPath(field)
+		deletePath(l.Data, path)
+	}
+	return true
+}
+
+func zero(v interface{}) interface{} {
+	if _, ok := v.(string); ok {
+		return ""
+	}
+	return 0
+}
+
+func deletePath(data map[string]interface{}, path string) {
+	if _, ok := data[path]; ok {
+		delete(data, path)
+		return
+	}
+	head, rest, _ := strings.Cut(path, ".")
+	if child, ok := data[head].(map[string]interface{}); ok {
+		deletePath(child, rest)
+	}
+}
+
+// Cast converts v to typ: int, float, string or bool.
+func Cast(v interface{}, typ string) (interface{}, error) {
+	switch typ {
+	case "int":
+		return toInt(v)
+	case "float":
+		return toFloat(v)
+	case "string":
+		return toString(v)
+	case "bool":
+		return toBool(v)
+	}
+	return nil, fmt.Errorf("unknown type %q", typ)
+}
+
+func toString(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case map[string]interface{}, []interface{}:
+		b, err := json.Marshal(v)
+		return string(b), err
+	}
+	return fmt.Sprint(v), nil
+}
+
+// toInt converts v to an int, the floats being truncated.
+func toInt(v interface{}) (int, error) {
+	f, err := toFloat(v)
+	if err != nil {
+		return 0, err
+	}
+	if f > math.MaxInt64 || f < math.MinInt64 {
+		return 0, fmt.Errorf("%v is out of range", v)
+	}
+	if s, ok := v.(string); ok {
+		// Parsed on their own not to lose precision.
+		if n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64); err == nil {
+			return int(n), nil
+		}
+	}
+	switch v := v.(type) {
+	case int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	}
+	return int(f), nil
+}
+
+func toFloat(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case json.Number:
+		return v.Float64()
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a number", v)
+		}
+		return f, nil
+	}
+	return 0, fmt.Errorf("%T is not a number", v)
+}
+
+func toBool(v interface{}) (bool, error) {
+	switch v := v.(type) {
+	case bool:
+		return v, nil
+	case string:
+		b, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			return false, fmt.Errorf("%q is not a boolean", v)
+		}
+		return b, nil
+	}
+	f, err := toFloat(v)
+	if err != nil {
+		return false, fmt.Errorf("%T is not a boolean", v)
+	}
+	return f != 0, nil
+}
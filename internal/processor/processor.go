@@ -0,0 +1,440 @@
+// Package processor runs the processor pipelines of the configuration on
+// the logs, between their consumption and their storage. A pipeline is
+// a chain of stages configured per channel, each stage modifying the
+// logs or dropping them, for example:
+//
+//	Channel: nginx
+//	Stages:
+//	  - {Type: rename, Field: data.msg, To: message}
+//	  - {Type: cast, Field: data.status, As: int}
+//	  - {Type: drop_log, If: {Field: data.path, Equals: [/healthz]}}
+//
+// Both ingesters run the same pipelines, so that the logs are stored the
+// same way in ClickHouse and MongoDB.
+package processor
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hyperbolicresearch/hlog/config"
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/internal/script"
+)
+
+// errorLogInterval is how often the errors of a stage are logged, the
+// ones in between being counted only.
+const errorLogInterval = time.Minute
+
+// statsLogInterval is how often the counters of the stages are logged.
+const statsLogInterval = 5 * time.Minute
+
+// Stage is a stage of a pipeline.
+type Stage interface {
+	// Process modifies l in place, and returns false to drop it. On
+	// error, the log goes on unchanged to the next stage.
+	Process(l *core.Log) (keep bool, err error)
+}
+
+// Counter is implemented by the stages counting what they do, such as the
+// values each detector of a redact stage redacted.
+type Counter interface {
+	Counts() map[string]uint64
+}
+
+// NewStage creates a stage from its configuration.
+func NewStage(cfg config.ProcessorStage) (Stage, error) {
+	var s Stage
+	var err error
+	switch cfg.Type {
+	case "rename", "copy":
+		s, err = newMove(cfg)
+	case "drop":
+		s, err = newDrop(cfg)
+	case "set", "add":
+		s, err = newSet(cfg)
+	case "cast":
+		s, err = newCast(cfg)
+	case "drop_log":
+		if cfg.If == nil {
+			return nil, fmt.Errorf("drop_log needs a condition")
+		}
+		s = dropLog{}
+	case "normalize_level":
+		s, err = newNormalizeLevel(cfg)
+	case "redact":
+		s, err = newRedact(cfg)
+	case "enrich":
+		s, err = newEnrich(cfg)
+	case "dedup":
+		s, err = newDedup(cfg)
+	case "dedup_id":
+		s, err = newDedupID(cfg)
+	case "filter":
+		s, err = newFilter(cfg)
+	case "eval":
+		s, err = newEval(cfg)
+	default:
+		return nil, fmt.Errorf("unknown stage type %q", cfg.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", cfg.Type, err)
+	}
+	if cfg.If != nil {
+		ccfg := *cfg.If
+		if ccfg.Timeout == 0 {
+			ccfg.Timeout = cfg.Timeout
+		}
+		cond, err := NewCondition(ccfg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", cfg.Type, err)
+		}
+		s = conditional{cond: cond, stage: s}
+	}
+	return s, nil
+}
+
+// Condition matches logs on the value of one of their fields, or with an
+// expression.
+type Condition struct {
+	field   string
+	equals  map[string]bool
+	matches *regexp.Regexp
+	expr    *script.Program
+}
+
+// NewCondition compiles a condition.
+func NewCondition(cfg config.ProcessorCondition) (*Condition, error) {
+	if cfg.Expr != "" {
+		program, err := script.CompileBool(cfg.Expr, cfg.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid condition expression: %v", err)
+		}
+		return &Condition{expr: program}, nil
+	}
+	if !validField(cfg.Field) {
+		return nil, fmt.Errorf("invalid condition field %q", cfg.Field)
+	}
+	c := &Condition{field: cfg.Field}
+	if len(cfg.Equals) > 0 {
+		c.equals = make(map[string]bool, len(cfg.Equals))
+		for _, v := range cfg.Equals {
+			c.equals[v] = true
+		}
+	}
+	if cfg.Matches != "" {
+		re, err := regexp.Compile(cfg.Matches)
+		if err != nil {
+			return nil, fmt.Errorf("invalid condition expression %q: %v", cfg.Matches, err)
+		}
+		c.matches = re
+	}
+	return c, nil
+}
+
+// Match reports whether l matches the condition.
+func (c *Condition) Match(l *core.Log) bool {
+	if c.expr != nil {
+		ok, err := c.expr.Bool(l)
+		return ok && err == nil
+	}
+	v, ok := Get(l, c.field)
+	if !ok {
+		return false
+	}
+	if c.equals == nil && c.matches == nil {
+		return true
+	}
+	s, err := toString(v)
+	if err != nil {
+		return false
+	}
+	return c.equals[s] || c.matches != nil && c.matches.MatchString(s)
+}
+
+// conditional runs a stage on the logs matching a condition only.
+type conditional struct {
+	cond  *Condition
+	stage Stage
+}
+
+func (c conditional) Process(l *core.Log) (bool, error) {
+	if !c.cond.Match(l) {
+		return true, nil
+	}
+	return c.stage.Process(l)
+}
+
+func (c conditional) Counts() map[string]uint64 {
+	if counter, ok := c.stage.(Counter); ok {
+		return counter.Counts()
+	}
+	return nil
+}
+
+func (c conditional) Flush(now time.Time) []*core.Log {
+	if flusher, ok := c.stage.(Flusher); ok {
+		return flusher.Flush(now)
+	}
+	return nil
+}
+
+// dropLog drops the logs, its condition selecting them.
+type dropLog struct{}
+
+func (dropLog) Process(l *core.Log) (bool, error) {
+	return false, nil
+}
+
+// Pipeline is the chain of stages run on the logs of some channels.
+type Pipeline struct {
+	channel string
+	stages  []*stageRunner
+}
+
+// stageRunner runs a stage and counts what it does.
+type stageRunner struct {
+	Stage
+	typ       string
+	processed atomic.Uint64
+	dropped   atomic.Uint64
+	errors    atomic.Uint64
+	nanos     atomic.Int64
+
+	mu        sync.Mutex
+	lastError string
+	// logged is when an error was last logged, and unlogged the number
+	// of errors since.
+	logged   time.Time
+	unlogged uint64
+}
+
+// StageStats are the counters of a stage.
+type StageStats struct {
+	// Channel is the channel pattern of the pipeline of the stage, and
+	// Index the position of the stage in it.
+	Channel string
+	Index   int
+	Type    string
+	// Processed is the number of logs the stage ran on, Dropped the
+	// number of logs it dropped, and Errors the number of logs it failed
+	// on, LastError being the last of these errors.
+	Processed uint64
+	Dropped   uint64
+	Errors    uint64
+	LastError string
+	// Duration is the time spent in the stage.
+	Duration time.Duration
+	// Counts are the counters specific to the type of the stage, such as
+	// the values redacted per detector.
+	Counts map[string]uint64
+}
+
+// Pipelines are the pipelines of the configuration.
+type Pipelines struct {
+	pipelines []*Pipeline
+
+	mu sync.Mutex
+	// logged is when the counters were last logged.
+	logged time.Time
+}
+
+// New creates the pipelines of cfg, which may be nil.
+func New(cfg *config.Processing) (*Pipelines, error) {
+	ps := &Pipelines{logged: time.Now()}
+	if cfg == nil {
+		return ps, nil
+	}
+	for i, pcfg := range cfg.Pipelines {
+		if _, err := path.Match(pcfg.Channel, ""); err != nil {
+			return nil, fmt.Errorf("pipeline %d: invalid channel %q: %v", i, pcfg.Channel, err)
+		}
+		p := &Pipeline{channel: pcfg.Channel}
+		for j, scfg := range pcfg.Stages {
+			s, err := NewStage(scfg)
+			if err != nil {
+				return nil, fmt.Errorf("pipeline %d, stage %d: %v", i, j, err)
+			}
+			p.stages = append(p.stages, &stageRunner{Stage: s, typ: scfg.Type})
+		}
+		ps.pipelines = append(ps.pipelines, p)
+	}
+	return ps, nil
+}
+
+// Select returns the pipeline of a channel, nil if there is none.
+func (ps *Pipelines) Select(channel string) *Pipeline {
+	if ps == nil {
+		return nil
+	}
+	for _, p := range ps.pipelines {
+		if ok, _ := path.Match(p.channel, channel); ok || p.channel == "" {
+			return p
+		}
+	}
+	return nil
+}
+
+// Process runs the pipeline of the channel of l on it, and reports
+// whether l is kept.
+func (ps *Pipelines) Process(l *core.Log) bool {
+	p := ps.Select(l.Channel)
+	if p == nil {
+		return true
+	}
+	return p.Process(l)
+}
+
+// Process runs the stages on l, and reports whether l is kept.
+func (p *Pipeline) Process(l *core.Log) bool {
+	return p.run(l, 0)
+}
+
+// run runs the stages on l from the one at index from.
+func (p *Pipeline) run(l *core.Log, from int) bool {
+	for i := from; i < len(p.stages); i++ {
+		s := p.stages[i]
+		start := time.Now()
+		keep, err := s.Process(l)
+		s.nanos.Add(int64(time.Since(start)))
+		s.processed.Add(1)
+		if err != nil {
+			s.fail(p.channel, i, l, err)
+		}
+		if !keep {
+			s.dropped.Add(1)
+			return false
+		}
+	}
+	return true
+}
+
+// Flush returns the logs the stages of every pipeline released, such as
+// the ones collapsing repeated logs once their window ended. It is to be
+// called regularly by the ingesters.
+func (ps *Pipelines) Flush() []*core.Log {
+	return ps.flush(time.Now())
+}
+
+// Close returns the logs the stages of every pipeline still hold, such as
+// the ones collapsing repeated logs in windows not ended yet. It is to be
+// called by the ingesters when they stop.
+func (ps *Pipelines) Close() []*core.Log {
+	// Every window has ended by then.
+	return ps.flush(time.Unix(1<<62, 0))
+}
+
+func (ps *Pipelines) flush(now time.Time) []*core.Log {
+	if ps == nil {
+		return nil
+	}
+	var logs []*core.Log
+	for _, p := range ps.pipelines {
+		for i, s := range p.stages {
+			flusher, ok := s.Stage.(Flusher)
+			if !ok {
+				continue
+			}
+			// The logs released go through the next stages only.
+			for _, l := range flusher.Flush(now) {
+				if p.run(l, i+1) {
+					logs = append(logs, l)
+				}
+			}
+		}
+	}
+	return logs
+}
+
+// fail counts an error of the stage, and logs it unless another one was
+// logged recently.
+func (s *stageRunner) fail(channel string, index int, l *core.Log, err error) {
+	s.errors.Add(1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastError = err.Error()
+	if time.Since(s.logged) < errorLogInterval {
+		s.unlogged++
+		return
+	}
+	log.Printf("processor: pipeline %q, stage %d (%s), log %s: %v (%d more errors since the last report)",
+		channel, index, s.typ, l.LogId, err, s.unlogged)
+	s.logged, s.unlogged = time.Now(), 0
+}
+
+// Stats returns the counters of the stages of every pipeline.
+func (ps *Pipelines) Stats() []StageStats {
+	if ps == nil {
+		return nil
+	}
+	var stats []StageStats
+	for _, p := range ps.pipelines {
+		for i, s := range p.stages {
+			s.mu.Lock()
+			lastError := s.lastError
+			s.mu.Unlock()
+			var counts map[string]uint64
+			if counter, ok := s.Stage.(Counter); ok {
+				counts = counter.Counts()
+			}
+			stats = append(stats, StageStats{
+				Channel:   p.channel,
+				Index:     i,
+				Type:      s.typ,
+				Processed: s.processed.Load(),
+				Dropped:   s.dropped.Load(),
+				Errors:    s.errors.Load(),
+				LastError: lastError,
+				Duration:  time.Duration(s.nanos.Load()),
+				Counts:    counts,
+			})
+		}
+	}
+	return stats
+}
+
+// LogStats logs the counters of the stages, one line per stage, unless
+// they were logged recently. It is to be called regularly by the
+// ingesters.
+func (ps *Pipelines) LogStats() {
+	if ps == nil {
+		return
+	}
+	ps.mu.Lock()
+	if time.Since(ps.logged) < statsLogInterval {
+		ps.mu.Unlock()
+		return
+	}
+	ps.logged = time.Now()
+	ps.mu.Unlock()
+	for _, st := range ps.Stats() {
+		log.Print(st)
+	}
+}
+
+// String formats the counters of the stage on one line, the counts being
+// sorted by name.
+func (st StageStats) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "processor: pipeline %q, stage %d (%s): %d processed, %d dropped, %d errors in %v",
+		st.Channel, st.Index, st.Type, st.Processed, st.Dropped, st.Errors, st.Duration)
+	names := make([]string, 0, len(st.Counts))
+	for name := range st.Counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, ", %s: %d", name, st.Counts[name])
+	}
+	if st.LastError != "" {
+		fmt.Fprintf(&b, ", last error: %s", st.LastError)
+	}
+	return b.String()
+}
@@ -0,0 +1,268 @@
+package processor
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/hyperbolicresearch/hlog/config"
+	"github.com/hyperbolicresearch/hlog/internal/core"
+)
+
+// builtinDetectors are the expressions of the built-in detectors, the
+// ones with a group redacting the group only when it matched. Some of
+// them only find candidates, checked by their validator.
+var builtinDetectors = map[string]*regexp.Regexp{
+	"email":   regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`),
+	"ipv4":    regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\.){3}(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\b`),
+	"ipv6":    regexp.MustCompile(`(?i)(?:^|[^\w:.])([0-9a-f]*:[0-9a-f]*:[0-9a-f:.]*)`),
+	"pan":     regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+	"jwt":     regexp.MustCompile(`\beyJ[A-Za-z0-9_-]*\.eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]*`),
+	"aws_key": regexp.MustCompile(`\b(?:AKIA|ASIA|AIDA|AROA|AGPA|ANPA|ANVA|AIPA)[A-Z0-9]{16}\b|(?i:aws)\w*(?i:secret)\w*['"]?\s*[:=]\s*['"]?([A-Za-z0-9/+]{40})\b`),
+	"bearer":  regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9._~+/-]+=*)`),
+}
+
+// builtinOrder is the order the built-in detectors run in when none is
+// configured: the tokens first, as they may hold the other values.
+var builtinOrder = []string{"bearer", "jwt", "aws_key", "pan", "email", "ipv4", "ipv6"}
+
+var validators = map[string]func(string) bool{
+	"ipv6": validIPv6,
+	"pan":  luhn,
+}
+
+// validIPv6 reports whether s is an IPv6 address with either at least
+// three colons or groups on both sides of its ::, not to take fragments
+// such as the d:: of std::vector for addresses.
+func validIPv6(s string) bool {
+	if net.ParseIP(s) == nil {
+		return false
+	}
+	colons := strings.Count(s, ":")
+	if colons >= 3 {
+		return true
+	}
+	i := strings.Index(s, "::")
+	return colons == 2 && i > 0 && i+2 < len(s)
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// luhn reports whether the digits of s, at least 13, pass the Luhn check.
+func luhn(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
+
+// detector finds the values to redact.
+type detector struct {
+	name     string
+	re       *regexp.Regexp
+	validate func(string) bool
+	action   string
+	fields   map[string]string
+	count    atomic.Uint64
+}
+
+// find returns the positions of the values to redact in s.
+func (d *detector) find(s string) [][2]int {
+	var spans [][2]int
+	for _, m := range d.re.FindAllStringSubmatchIndex(s, -1) {
+		start, end := m[0], m[1]
+		if len(m) > 2 && m[2] >= 0 {
+			start, end = m[2], m[3]
+		}
+		if d.name == "ipv6" {
+			// The candidates end on a non-word character, the expression
+			// having no lookahead.
+			if end < len(s) && isWordByte(s[end]) {
+				continue
+			}
+			end = start + len(strings.TrimRight(s[start:end], "."))
+		}
+		if d.validate != nil && !d.validate(s[start:end]) {
+			continue
+		}
+		spans = append(spans, [2]int{start, end})
+	}
+	return spans
+}
+
+// actionFor returns the action for field, or for its closest parent.
+func (d *detector) actionFor(field string) string {
+	for f := field; f != ""; {
+		if a, ok := d.fields[f]; ok {
+			return a
+		}
+		i := strings.LastIndexByte(f, '.')
+		if i < 0 {
+			break
+		}
+		f = f[:i]
+	}
+	return d.action
+}
+
+// redact replaces the secrets and personal data of the logs.
+type redact struct {
+	field     string
+	detectors []*detector
+	key       []byte
+}
+
+func validAction(action string) bool {
+	return action == "mask" || action == "drop" || action == "hmac"
+}
+
+func newRedact(cfg config.ProcessorStage) (Stage, error) {
+	if cfg.Field != "" && !validField(cfg.Field) {
+		return nil, fmt.Errorf("invalid field %q", cfg.Field)
+	}
+	r := &redact{field: cfg.Field, key: []byte(cfg.Key)}
+	detectors := cfg.Detectors
+	if len(detectors) == 0 {
+		for _, name := range builtinOrder {
+			detectors = append(detectors, config.RedactDetector{Name: name})
+		}
+	}
+	for _, dcfg := range detectors {
+		d := &detector{name: dcfg.Name, action: dcfg.Action, fields: dcfg.Fields}
+		if dcfg.Pattern != "" {
+			re, err := regexp.Compile(dcfg.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("detector %q: %v", dcfg.Name, err)
+			}
+			d.re = re
+		} else if d.re = builtinDetectors[dcfg.Name]; d.re == nil {
+			return nil, fmt.Errorf("unknown detector %q", dcfg.Name)
+		} else {
+			d.validate = validators[dcfg.Name]
+		}
+		if d.name == "" {
+			return nil, fmt.Errorf("detector %q has no name", dcfg.Pattern)
+		}
+		if d.action == "" {
+			d.action = "mask"
+		}
+		actions := []string{d.action}
+		for _, a := range d.fields {
+			actions = append(actions, a)
+		}
+		for _, a := range actions {
+			if !validAction(a) {
+				return nil, fmt.Errorf("detector %q: unknown action %q", d.name, a)
+			}
+			if a == "hmac" && len(r.key) == 0 {
+				return nil, fmt.Errorf("detector %q: the hmac action needs a key", d.name)
+			}
+		}
+		r.detectors = append(r.detectors, d)
+	}
+	return r, nil
+}
+
+func (r *redact) Process(l *core.Log) (bool, error) {
+	fields := []string{"message", "data"}
+	if r.field != "" {
+		fields = []string{r.field}
+	}
+	for _, field := range fields {
+		v, ok := Get(l, field)
+		if !ok {
+			continue
+		}
+		if v, keep := r.value(field, v); !keep {
+			Delete(l, field)
+		} else if err := Set(l, field, v); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// value redacts v, the value of field, and returns false when it is to
+// be dropped. The objects and arrays are redacted in place.
+func (r *redact) value(field string, v interface{}) (interface{}, bool) {
+	switch v := v.(type) {
+	case string:
+		return r.string(field, v)
+	case map[string]interface{}:
+		for k, child := range v {
+			if child, keep := r.value(field+"."+k, child); keep {
+				v[k] = child
+			} else {
+				delete(v, k)
+			}
+		}
+	case []interface{}:
+		kept := v[:0]
+		for _, child := range v {
+			if child, keep := r.value(field, child); keep {
+				kept = append(kept, child)
+			}
+		}
+		return kept, true
+	}
+	return v, true
+}
+
+func (r *redact) string(field, s string) (string, bool) {
+	for _, d := range r.detectors {
+		spans := d.find(s)
+		if len(spans) == 0 {
+			continue
+		}
+		d.count.Add(uint64(len(spans)))
+		action := d.actionFor(field)
+		if action == "drop" {
+			return "", false
+		}
+		var b strings.Builder
+		last := 0
+		for _, span := range spans {
+			b.WriteString(s[last:span[0]])
+			if action == "hmac" {
+				mac := hmac.New(sha256.New, r.key)
+				mac.Write([]byte(s[span[0]:span[1]]))
+				fmt.Fprintf(&b, "[%s:%s]", d.name, hex.EncodeToString(mac.Sum(nil)[:16]))
+			} else {
+				fmt.Fprintf(&b, "[REDACTED:%s]", d.name)
+			}
+			last = span[1]
+		}
+		b.WriteString(s[last:])
+		s = b.String()
+	}
+	return s, true
+}
+
+// Counts returns the number of values each detector redacted.
+func (r *redact) Counts() map[string]uint64 {
+	counts := make(map[string]uint64, len(r.detectors))
+	for _, d := range r.detectors {
+		counts[d.name] += d.count.Load()
+	}
+	return counts
+}
@@ -0,0 +1,199 @@
+package processor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hyperbolicresearch/hlog/config"
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/internal/parser"
+	"github.com/hyperbolicresearch/hlog/internal/script"
+)
+
+// move renames or copies a field.
+type move struct {
+	from, to string
+	keep     bool
+}
+
+func newMove(cfg config.ProcessorStage) (Stage, error) {
+	if !validField(cfg.Field) || !validField(cfg.To) {
+		return nil, fmt.Errorf("invalid fields %q and %q", cfg.Field, cfg.To)
+	}
+	return move{from: cfg.Field, to: cfg.To, keep: cfg.Type == "copy"}, nil
+}
+
+func (s move) Process(l *core.Log) (bool, error) {
+	v, ok := Get(l, s.from)
+	if !ok {
+		return true, nil
+	}
+	if s.keep {
+		// The copy does not share its objects and arrays with the field.
+		v = deepCopy(v)
+	}
+	if err := Set(l, s.to, v); err != nil {
+		return true, err
+	}
+	if !s.keep {
+		Delete(l, s.from)
+	}
+	return true, nil
+}
+
+// drop removes a field.
+type drop struct {
+	field string
+}
+
+func newDrop(cfg config.ProcessorStage) (Stage, error) {
+	if !validField(cfg.Field) {
+		return nil, fmt.Errorf("invalid field %q", cfg.Field)
+	}
+	return drop{field: cfg.Field}, nil
+}
+
+func (s drop) Process(l *core.Log) (bool, error) {
+	Delete(l, s.field)
+	return true, nil
+}
+
+// set sets a field to a value, or adds it only when it is missing.
+type set struct {
+	field     string
+	value     interface{}
+	onlyAdded bool
+}
+
+func newSet(cfg config.ProcessorStage) (Stage, error) {
+	if !validField(cfg.Field) {
+		return nil, fmt.Errorf("invalid field %q", cfg.Field)
+	}
+	s := set{field: cfg.Field, value: cfg.Value, onlyAdded: cfg.Type == "add"}
+	// The value is checked once rather than failing on every log.
+	if err := Set(&core.Log{}, s.field, s.value); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s set) Process(l *core.Log) (bool, error) {
+	if s.onlyAdded {
+		if v, ok := Get(l, s.field); ok && v != "" && v != int64(0) {
+			return true, nil
+		}
+	}
+	// Every log gets its own copy of the objects and arrays of the value,
+	// which the next stages may modify in place.
+	return true, Set(l, s.field, deepCopy(s.value))
+}
+
+// cast converts a field to another type.
+type cast struct {
+	field, typ string
+}
+
+func newCast(cfg config.ProcessorStage) (Stage, error) {
+	if !validField(cfg.Field) {
+		return nil, fmt.Errorf("invalid field %q", cfg.Field)
+	}
+	if _, err := Cast("0", cfg.As); err != nil {
+		return nil, err
+	}
+	return cast{field: cfg.Field, typ: cfg.As}, nil
+}
+
+func (s cast) Process(l *core.Log) (bool, error) {
+	v, ok := Get(l, s.field)
+	if !ok {
+		return true, nil
+	}
+	converted, err := Cast(v, s.typ)
+	if err != nil {
+		return true, fmt.Errorf("%s: %v", s.field, err)
+	}
+	return true, Set(l, s.field, converted)
+}
+
+// normalizeLevel lowercases a level, and maps the level aliases to their
+// canonical name.
+type normalizeLevel struct {
+	field string
+}
+
+func newNormalizeLevel(cfg config.ProcessorStage) (Stage, error) {
+	field := cfg.Field
+	if field == "" {
+		field = "level"
+	}
+	if !validField(field) {
+		return nil, fmt.Errorf("invalid field %q", field)
+	}
+	return normalizeLevel{field: field}, nil
+}
+
+func (s normalizeLevel) Process(l *core.Log) (bool, error) {
+	v, ok := Get(l, s.field)
+	if !ok {
+		return true, nil
+	}
+	if level, ok := parser.ParseLevel(v); ok {
+		return true, Set(l, s.field, level.String())
+	}
+	if str, ok := v.(string); ok {
+		return true, Set(l, s.field, strings.ToLower(strings.TrimSpace(str)))
+	}
+	return true, nil
+}
+
+// keepIf keeps the logs for which an expression is true.
+type keepIf struct {
+	program *script.Program
+}
+
+func newFilter(cfg config.ProcessorStage) (Stage, error) {
+	program, err := script.CompileBool(cfg.Expr, cfg.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return keepIf{program: program}, nil
+}
+
+func (s keepIf) Process(l *core.Log) (bool, error) {
+	keep, err := s.program.Bool(l)
+	if err != nil {
+		// The logs the expression fails on are kept, not to lose them to
+		// a mistake in the expression.
+		return true, err
+	}
+	return keep, nil
+}
+
+// eval sets a field to the value of an expression.
+type eval struct {
+	field   string
+	program *script.Program
+}
+
+func newEval(cfg config.ProcessorStage) (Stage, error) {
+	if !validField(cfg.Field) {
+		return nil, fmt.Errorf("invalid field %q", cfg.Field)
+	}
+	program, err := script.Compile(cfg.Expr, cfg.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return eval{field: cfg.Field, program: program}, nil
+}
+
+func (s eval) Process(l *core.Log) (bool, error) {
+	v, err := s.program.Run(l)
+	if err != nil {
+		return true, err
+	}
+	if v == nil {
+		Delete(l, s.field)
+		return true, nil
+	}
+	return true, Set(l, s.field, v)
+}
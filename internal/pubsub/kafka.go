@@ -0,0 +1,170 @@
+package pubsub
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+
+	"github.com/hyperbolicresearch/hlog/config"
+	"github.com/hyperbolicresearch/hlog/internal/kafkaservice"
+)
+
+// kafkaProducer is the Producer writing to Kafka.
+type kafkaProducer struct {
+	w *kafkaservice.KafkaWorker
+}
+
+func newKafkaProducer(cfg *config.Kafka) (*kafkaProducer, error) {
+	w, err := kafkaservice.NewKafkaWorker(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.ConfigureProducer(); err != nil {
+		return nil, err
+	}
+	// The delivery reports go to the calls of Produce, only the errors
+	// of the client itself end up here.
+	go func() {
+		for e := range w.Producer.Events() {
+			if err, ok := e.(kafka.Error); ok {
+				log.Printf("kafka: %v", err)
+			}
+		}
+	}()
+	return &kafkaProducer{w: w}, nil
+}
+
+func (p *kafkaProducer) Produce(msgs []*Message, timeout time.Duration) []error {
+	kmsgs := make([]*kafka.Message, len(msgs))
+	for i, m := range msgs {
+		topic := m.Topic
+		kmsgs[i] = &kafka.Message{
+			TopicPartition: kafka.TopicPartition{
+				Topic:     &topic,
+				Partition: kafka.PartitionAny,
+			},
+			Key:       m.Key,
+			Value:     m.Value,
+			Timestamp: m.Timestamp,
+		}
+	}
+	errs := p.w.ProduceMessages(kmsgs, timeout)
+	for i, err := range errs {
+		if err == nil {
+			msgs[i].Partition = kmsgs[i].TopicPartition.Partition
+			msgs[i].Offset = int64(kmsgs[i].TopicPartition.Offset)
+		}
+	}
+	return errs
+}
+
+func (p *kafkaProducer) Close() error {
+	p.w.Producer.Flush(5000)
+	p.w.Producer.Close()
+	return nil
+}
+
+// kafkaConsumer is the Consumer reading from Kafka.
+type kafkaConsumer struct {
+	w *kafkaservice.KafkaWorker
+}
+
+func newKafkaConsumer(cfg *config.Kafka) (*kafkaConsumer, error) {
+	w, err := kafkaservice.NewKafkaWorker(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.ConfigureConsumer(); err != nil {
+		return nil, err
+	}
+	return &kafkaConsumer{w: w}, nil
+}
+
+func (c *kafkaConsumer) Subscribe(topics []string) error {
+	return c.w.SubscribeTopics(topics)
+}
+
+// Read returns the next message of a topic allowed by the topic filter
+// of the consumer, kafkaservice.ErrTopicDenied for the messages of the
+// other ones.
+func (c *kafkaConsumer) Read(timeout time.Duration) (*Message, error) {
+	msg, err := c.w.ReadMessage(timeout)
+	if err != nil {
+		var kerr kafka.Error
+		if errors.As(err, &kerr) && kerr.Code() == kafka.ErrTimedOut {
+			return nil, ErrTimeout
+		}
+		return nil, err
+	}
+	m := &Message{
+		Partition: msg.TopicPartition.Partition,
+		Offset:    int64(msg.TopicPartition.Offset),
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Timestamp: msg.Timestamp,
+	}
+	if msg.TopicPartition.Topic != nil {
+		m.Topic = *msg.TopicPartition.Topic
+	}
+	return m, nil
+}
+
+func (c *kafkaConsumer) Commit() error {
+	_, err := c.w.Consumer.Commit()
+	var kerr kafka.Error
+	if errors.As(err, &kerr) && kerr.Code() == kafka.ErrNoOffset {
+		// Nothing was read since the last commit.
+		return nil
+	}
+	return err
+}
+
+// Pause pauses the partitions of the topics currently assigned to the
+// consumer, the ones assigned later being read.
+func (c *kafkaConsumer) Pause(topics ...string) error {
+	parts, err := c.assigned(topics)
+	if err != nil {
+		return err
+	}
+	return c.w.Consumer.Pause(parts)
+}
+
+func (c *kafkaConsumer) Resume(topics ...string) error {
+	parts, err := c.assigned(topics)
+	if err != nil {
+		return err
+	}
+	return c.w.Consumer.Resume(parts)
+}
+
+// assigned returns the partitions of topics assigned to the consumer.
+func (c *kafkaConsumer) assigned(topics []string) ([]kafka.TopicPartition, error) {
+	parts, err := c.w.Consumer.Assignment()
+	if err != nil {
+		return nil, err
+	}
+	var selected []kafka.TopicPartition
+	for _, p := range parts {
+		for _, t := range topics {
+			if p.Topic != nil && *p.Topic == t {
+				selected = append(selected, p)
+				break
+			}
+		}
+	}
+	return selected, nil
+}
+
+func (c *kafkaConsumer) Seek(topic string, partition int32, offset int64) error {
+	return c.w.Consumer.Seek(kafka.TopicPartition{
+		Topic:     &topic,
+		Partition: partition,
+		Offset:    kafka.Offset(offset),
+	}, 0)
+}
+
+func (c *kafkaConsumer) Close() error {
+	return c.w.Consumer.Close()
+}
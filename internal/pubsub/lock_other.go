@@ -0,0 +1,11 @@
+//go:build !unix
+
+package pubsub
+
+import "os"
+
+// lockDir creates the lock file of the queue of dir, without locking it
+// where flock is not available.
+func lockDir(dir string) (*os.File, error) {
+	return os.OpenFile(lockPath(dir), os.O_CREATE|os.O_RDWR, 0o644)
+}
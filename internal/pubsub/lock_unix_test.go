@@ -0,0 +1,25 @@
+//go:build unix
+
+package pubsub
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestQueueLock(t *testing.T) {
+	dir := t.TempDir()
+	q, err := OpenQueue(dir, QueueOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenQueue(dir, QueueOptions{}); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected=%v, Got=%v", ErrLocked, err)
+	}
+	q.Close()
+	q, err = OpenQueue(dir, QueueOptions{})
+	if err != nil {
+		t.Fatalf("Expected the lock to be released, Got=%v", err)
+	}
+	q.Close()
+}
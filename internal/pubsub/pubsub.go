@@ -0,0 +1,136 @@
+// Package pubsub is the messaging layer between the components of hlog:
+// the receivers produce the logs to the topics of their channels, and
+// the ingesters and the live tail consume them. It has two
+// implementations, Kafka and an embedded queue keeping its topics on
+// the local disk, which needs no broker and is meant for development
+// and single-node setups.
+package pubsub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hyperbolicresearch/hlog/config"
+	"github.com/hyperbolicresearch/hlog/internal/core"
+)
+
+// ErrTimeout is returned by Consumer.Read when no message arrived in
+// time.
+var ErrTimeout = errors.New("timed out waiting for a message")
+
+// Message is a message of a topic.
+type Message struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Timestamp time.Time
+}
+
+// Producer writes messages to topics, which are created on the first
+// message.
+type Producer interface {
+	// Produce writes the messages and waits at most timeout for them to
+	// be stored. It returns one error per message, nil for the ones
+	// stored, whose partition and offset are then set.
+	Produce(msgs []*Message, timeout time.Duration) []error
+	// Close waits for the pending messages and releases the producer.
+	Close() error
+}
+
+// Consumer reads the messages of the topics it subscribed to as a
+// member of a consumer group: every partition of these topics is read by
+// one member of the group, from the position committed by the group.
+type Consumer interface {
+	// Subscribe replaces the subscription of the consumer. The topics
+	// starting with ^ are regular expressions: every existing and future
+	// topic matching them is consumed.
+	Subscribe(topics []string) error
+	// Read returns the next message, or ErrTimeout when none arrived
+	// within timeout.
+	Read(timeout time.Duration) (*Message, error)
+	// Commit saves the positions of the consumer, after the last
+	// messages it read, as the ones of its group.
+	Commit() error
+	// Pause stops the reading of the partitions of the topics assigned
+	// to the consumer until they are resumed.
+	Pause(topics ...string) error
+	Resume(topics ...string) error
+	// Seek moves the position of the consumer in a partition it is
+	// assigned, the next message read from it being at offset.
+	Seek(topic string, partition int32, offset int64) error
+	Close() error
+}
+
+// NewProducer creates the producer of cfg: one writing to the embedded
+// queue when cfg.QueueDir is set, to Kafka otherwise.
+func NewProducer(cfg *config.Kafka) (Producer, error) {
+	if cfg.QueueDir != "" {
+		q, err := openShared(cfg.QueueDir)
+		if err != nil {
+			return nil, err
+		}
+		return &queueProducer{q: q, release: q.release}, nil
+	}
+	return newKafkaProducer(cfg)
+}
+
+// NewConsumer creates the consumer of cfg, a member of the group
+// cfg.GroupId: one reading from the embedded queue when cfg.QueueDir is
+// set, from Kafka otherwise. It has to subscribe to topics before
+// reading.
+func NewConsumer(cfg *config.Kafka) (Consumer, error) {
+	if cfg.QueueDir != "" {
+		q, err := openShared(cfg.QueueDir)
+		if err != nil {
+			return nil, err
+		}
+		c, err := q.newConsumer(cfg)
+		if err != nil {
+			q.release()
+			return nil, err
+		}
+		c.release = q.release
+		return c, nil
+	}
+	return newKafkaConsumer(cfg)
+}
+
+// ProduceLogs produces the logs to the topics of their channels, keyed
+// by sender id, and returns one error per log, nil for the ones
+// produced.
+func ProduceLogs(p Producer, logs []*core.Log, timeout time.Duration) []error {
+	errs := make([]error, len(logs))
+	msgs := make([]*Message, 0, len(logs))
+	indexes := make([]int, 0, len(logs))
+	for i, l := range logs {
+		value, err := json.Marshal(l)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		msgs = append(msgs, &Message{Topic: l.Channel, Key: []byte(l.SenderId), Value: value})
+		indexes = append(indexes, i)
+	}
+	for j, err := range p.Produce(msgs, timeout) {
+		errs[indexes[j]] = err
+	}
+	return errs
+}
+
+// DecodeLog unmarshals the log carried by msg. Clients do not have to
+// fill in the channel since they already produce to its topic, so the
+// channel defaults to the topic of the message.
+func DecodeLog(msg *Message) (*core.Log, error) {
+	var l core.Log
+	if err := json.Unmarshal(msg.Value, &l); err != nil {
+		return nil, fmt.Errorf("error unmarshalling value %s: %v", msg.Value, err)
+	}
+	if l.Channel == "" {
+		l.Channel = msg.Topic
+	}
+	return &l, nil
+}
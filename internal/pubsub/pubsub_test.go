@@ -0,0 +1,70 @@
+package pubsub
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hyperbolicresearch/hlog/internal/core"
+)
+
+type fakeProducer struct {
+	produced []*Message
+}
+
+func (p *fakeProducer) Produce(msgs []*Message, timeout time.Duration) []error {
+	errs := make([]error, len(msgs))
+	for i, m := range msgs {
+		if m.Topic == "down" {
+			errs[i] = errors.New("broker unavailable")
+			continue
+		}
+		p.produced = append(p.produced, m)
+	}
+	return errs
+}
+
+func (p *fakeProducer) Close() error {
+	return nil
+}
+
+func TestProduceLogs(t *testing.T) {
+	p := &fakeProducer{}
+	errs := ProduceLogs(p, []*core.Log{
+		{Channel: "billing", SenderId: "web-1", Message: "a"},
+		{Channel: "down", Message: "b"},
+	}, time.Second)
+	if errs[0] != nil || errs[1] == nil {
+		t.Errorf("Unexpected errors: %v", errs)
+	}
+	if len(p.produced) != 1 {
+		t.Fatalf("Expected 1 message, Got=%d", len(p.produced))
+	}
+	l, err := DecodeLog(p.produced[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := p.produced[0]; m.Topic != "billing" || string(m.Key) != "web-1" || l.Message != "a" {
+		t.Errorf("Unexpected message: %+v", m)
+	}
+}
+
+func TestDecodeLog(t *testing.T) {
+	topic := "hlog.payments"
+	msg := &Message{
+		Topic: topic,
+		Value: []byte(`{"log_id":"1","message":"hello"}`),
+	}
+	l, err := DecodeLog(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Channel != topic {
+		t.Errorf("Expected=%v, Got=%v", topic, l.Channel)
+	}
+	msg.Value = []byte(`{"channel":"other"}`)
+	l, _ = DecodeLog(msg)
+	if l.Channel != "other" {
+		t.Errorf("Expected=%v, Got=%v", "other", l.Channel)
+	}
+}
@@ -0,0 +1,667 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hyperbolicresearch/hlog/config"
+	"github.com/hyperbolicresearch/hlog/internal/kafkaservice"
+)
+
+// ErrClosed is returned when using a closed queue or consumer.
+var ErrClosed = errors.New("queue closed")
+
+// ErrLocked is returned when opening a queue opened by another process.
+var ErrLocked = errors.New("queue used by another process")
+
+// topicPattern matches the topic names, the ones of Kafka.
+var topicPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,249}$`)
+
+const (
+	// readBatchSize is the number of messages a consumer reads from a
+	// topic at once.
+	readBatchSize = 100
+	// autoCommitInterval is how often the consumers with auto commit
+	// commit their positions, as Kafka's do by default.
+	autoCommitInterval = 5 * time.Second
+)
+
+// QueueOptions configures the embedded queue.
+type QueueOptions struct {
+	// SegmentSize is the size from which a new segment is started.
+	SegmentSize int64
+	// Retention is how long the segments are kept after their last
+	// write, the one being written being always kept.
+	Retention time.Duration
+}
+
+// DefaultQueueOptions are the values used for the zero fields of
+// QueueOptions.
+var DefaultQueueOptions = QueueOptions{
+	SegmentSize: 64 << 20,
+	Retention:   7 * 24 * time.Hour,
+}
+
+// Queue is the embedded queue. Its topics have a single partition,
+// stored in segment files under topics/<topic>, and the positions
+// committed by its consumer groups are in groups/<group>.json. A queue
+// directory is only opened by one process at a time, which holds the
+// lock of its lock file: the components sharing a queue run in the same
+// process, as in 'hlog standalone'.
+type Queue struct {
+	dir  string
+	opts QueueOptions
+	lock *os.File
+
+	mu     sync.Mutex
+	topics map[string]*topic
+	groups map[string]*group
+	// wake is closed, and replaced, whenever messages are appended, to
+	// wake up the consumers waiting for them.
+	wake   chan struct{}
+	done   chan struct{}
+	closed bool
+	// refs counts the users of a shared queue.
+	refs int
+}
+
+// group is a consumer group of the queue.
+type group struct {
+	path    string
+	offsets map[string]int64
+	members []*queueConsumer
+}
+
+// OpenQueue opens the queue stored in dir, which is created if needed.
+// It fails with ErrLocked when another process has the queue open.
+func OpenQueue(dir string, opts QueueOptions) (*Queue, error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = DefaultQueueOptions.SegmentSize
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = DefaultQueueOptions.Retention
+	}
+	for _, sub := range []string{"topics", "groups"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, err
+		}
+	}
+	lock, err := lockDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	q := &Queue{
+		dir:    dir,
+		opts:   opts,
+		lock:   lock,
+		topics: map[string]*topic{},
+		groups: map[string]*group{},
+		wake:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "topics"))
+	if err != nil {
+		q.Close()
+		return nil, err
+	}
+	for _, e := range entries {
+		if !e.IsDir() || !validTopic(e.Name()) {
+			continue
+		}
+		t, err := openTopic(filepath.Join(dir, "topics", e.Name()), e.Name())
+		if err != nil {
+			q.Close()
+			return nil, err
+		}
+		q.topics[e.Name()] = t
+	}
+	go q.clean()
+	return q, nil
+}
+
+// lockPath is the path of the lock file of the queue of dir.
+func lockPath(dir string) string {
+	return filepath.Join(dir, "lock")
+}
+
+func validTopic(name string) bool {
+	return topicPattern.MatchString(name) && name != "." && name != ".."
+}
+
+// shared holds the queues opened by NewProducer and NewConsumer, by
+// directory.
+var shared = struct {
+	sync.Mutex
+	queues map[string]*Queue
+}{queues: map[string]*Queue{}}
+
+// openShared opens the queue of dir, which is shared by the producers
+// and consumers of the process until the last of them is closed.
+func openShared(dir string) (*Queue, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	shared.Lock()
+	defer shared.Unlock()
+	if q, ok := shared.queues[abs]; ok {
+		q.refs++
+		return q, nil
+	}
+	q, err := OpenQueue(abs, DefaultQueueOptions)
+	if err != nil {
+		return nil, err
+	}
+	q.refs = 1
+	shared.queues[abs] = q
+	return q, nil
+}
+
+func (q *Queue) release() {
+	shared.Lock()
+	defer shared.Unlock()
+	q.refs--
+	if q.refs == 0 {
+		delete(shared.queues, q.dir)
+		q.Close()
+	}
+}
+
+// Close closes the queue, whose consumers cannot read any more.
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	close(q.done)
+	close(q.wake)
+	for _, t := range q.topics {
+		t.close()
+	}
+	return q.lock.Close()
+}
+
+// clean applies the retention to the topics every minute.
+func (q *Queue) clean() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.done:
+			return
+		case <-ticker.C:
+			q.mu.Lock()
+			topics := make([]*topic, 0, len(q.topics))
+			for _, t := range q.topics {
+				topics = append(topics, t)
+			}
+			q.mu.Unlock()
+			for _, t := range topics {
+				t.clean(time.Now().Add(-q.opts.Retention))
+			}
+		}
+	}
+}
+
+// topic returns the topic name, creating it if create is set. It
+// returns nil for the topics that do not exist.
+func (q *Queue) topic(name string, create bool) (*topic, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil, ErrClosed
+	}
+	if t, ok := q.topics[name]; ok || !create {
+		return t, nil
+	}
+	t, err := openTopic(filepath.Join(q.dir, "topics", name), name)
+	if err != nil {
+		return nil, err
+	}
+	q.topics[name] = t
+	return t, nil
+}
+
+// waiter returns a channel closed when messages are appended.
+func (q *Queue) waiter() <-chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.wake
+}
+
+func (q *Queue) notify() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		close(q.wake)
+		q.wake = make(chan struct{})
+	}
+}
+
+// NewProducer creates a producer writing to the queue.
+func (q *Queue) NewProducer() Producer {
+	return &queueProducer{q: q}
+}
+
+// NewConsumer creates a consumer reading from the queue as a member of
+// the group cfg.GroupId. It starts at the position committed by its
+// group, or at the beginning of the topics when there is none and
+// cfg.AutoOffsetReset is earliest, at their end otherwise.
+func (q *Queue) NewConsumer(cfg *config.Kafka) (Consumer, error) {
+	return q.newConsumer(cfg)
+}
+
+func (q *Queue) newConsumer(cfg *config.Kafka) (*queueConsumer, error) {
+	if cfg.GroupId == "" {
+		return nil, errors.New("missing consumer group")
+	}
+	filter, err := kafkaservice.NewTopicFilter(cfg.AllowTopics, cfg.DenyTopics)
+	if err != nil {
+		return nil, err
+	}
+	reset := strings.ToLower(cfg.AutoOffsetReset)
+	c := &queueConsumer{
+		q:          q,
+		filter:     filter,
+		earliest:   reset == "earliest" || reset == "smallest" || reset == "beginning",
+		autoCommit: cfg.EnableAutoCommit,
+		positions:  map[string]int64{},
+		buffers:    map[string][]*Message{},
+		paused:     map[string]bool{},
+		lastCommit: time.Now(),
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil, ErrClosed
+	}
+	g, ok := q.groups[cfg.GroupId]
+	if !ok {
+		g = &group{
+			path:    filepath.Join(q.dir, "groups", url.PathEscape(cfg.GroupId)+".json"),
+			offsets: map[string]int64{},
+		}
+		data, err := os.ReadFile(g.path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, &g.offsets); err != nil {
+				return nil, fmt.Errorf("invalid offsets of group %q: %v", cfg.GroupId, err)
+			}
+		}
+		q.groups[cfg.GroupId] = g
+	}
+	c.group = g
+	g.members = append(g.members, c)
+	return c, nil
+}
+
+// assignment returns the topics assigned to c: the ones it subscribed to
+// and that no member of its group that joined before subscribed to.
+func (q *Queue) assignment(c *queueConsumer) ([]string, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil, ErrClosed
+	}
+	var names []string
+	for name := range q.topics {
+		for _, m := range c.group.members {
+			if m.subscribes(name) {
+				if m == c {
+					names = append(names, name)
+				}
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// start returns the position a consumer of g starts reading t from.
+func (q *Queue) start(g *group, t *topic, earliest bool) int64 {
+	q.mu.Lock()
+	offset, ok := g.offsets[t.name]
+	q.mu.Unlock()
+	switch {
+	case ok:
+		return offset
+	case earliest:
+		return t.first()
+	default:
+		return t.end()
+	}
+}
+
+// commit saves the positions as the ones of g.
+func (q *Queue) commit(g *group, positions map[string]int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for name, offset := range positions {
+		g.offsets[name] = offset
+	}
+	data, err := json.Marshal(g.offsets)
+	if err != nil {
+		return err
+	}
+	tmp := g.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, g.path)
+}
+
+func (q *Queue) leave(c *queueConsumer) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	members := c.group.members
+	for i, m := range members {
+		if m == c {
+			c.group.members = append(members[:i:i], members[i+1:]...)
+			break
+		}
+	}
+}
+
+// queueProducer is the Producer writing to the embedded queue.
+type queueProducer struct {
+	q       *Queue
+	release func()
+	once    sync.Once
+}
+
+// Produce appends the messages to their topics, which are synced to the
+// disk before it returns: timeout is not used.
+func (p *queueProducer) Produce(msgs []*Message, timeout time.Duration) []error {
+	errs := make([]error, len(msgs))
+	byTopic := map[string][]int{}
+	var names []string
+	for i, m := range msgs {
+		if !validTopic(m.Topic) {
+			errs[i] = fmt.Errorf("invalid topic %q", m.Topic)
+			continue
+		}
+		if _, ok := byTopic[m.Topic]; !ok {
+			names = append(names, m.Topic)
+		}
+		byTopic[m.Topic] = append(byTopic[m.Topic], i)
+	}
+	for _, name := range names {
+		indexes := byTopic[name]
+		t, err := p.q.topic(name, true)
+		if err == nil {
+			batch := make([]*Message, len(indexes))
+			for j, i := range indexes {
+				batch[j] = msgs[i]
+			}
+			err = t.append(batch, p.q.opts.SegmentSize)
+		}
+		if err != nil {
+			for _, i := range indexes {
+				errs[i] = err
+			}
+		}
+	}
+	p.q.notify()
+	return errs
+}
+
+func (p *queueProducer) Close() error {
+	if p.release != nil {
+		p.once.Do(p.release)
+	}
+	return nil
+}
+
+// queueConsumer is the Consumer reading from the embedded queue.
+type queueConsumer struct {
+	q          *Queue
+	group      *group
+	filter     *kafkaservice.TopicFilter
+	earliest   bool
+	autoCommit bool
+	release    func()
+
+	// The subscription is guarded by the mutex of the queue, which
+	// reads the ones of all the members of the group.
+	topics   map[string]bool
+	patterns []*regexp.Regexp
+
+	mu sync.Mutex
+	// positions are the offsets of the next messages to read from the
+	// topics assigned to the consumer.
+	positions map[string]int64
+	buffers   map[string][]*Message
+	paused    map[string]bool
+	assigned  []string
+	// cursor is the index in assigned of the topic read next, for the
+	// topics to be read in turn.
+	cursor     int
+	lastCommit time.Time
+	closed     bool
+}
+
+func (c *queueConsumer) Subscribe(topics []string) error {
+	names := map[string]bool{}
+	var patterns []*regexp.Regexp
+	for _, t := range topics {
+		if !strings.HasPrefix(t, "^") {
+			names[t] = true
+			continue
+		}
+		re, err := regexp.Compile(t)
+		if err != nil {
+			return fmt.Errorf("failed to subscribe to topics: %v, error: %v", topics, err)
+		}
+		patterns = append(patterns, re)
+	}
+	c.q.mu.Lock()
+	c.topics, c.patterns = names, patterns
+	c.q.mu.Unlock()
+
+	// The topics are assigned right away, for the consumers starting at
+	// the end of the topics to get the messages produced from now on.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	return c.refresh()
+}
+
+// subscribes tells whether the subscription of c includes the topic.
+func (c *queueConsumer) subscribes(name string) bool {
+	if !c.filter.Allows(name) {
+		return false
+	}
+	if c.topics[name] {
+		return true
+	}
+	for _, re := range c.patterns {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *queueConsumer) Read(timeout time.Duration) (*Message, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		// Taken before looking for messages, not to miss the ones
+		// appended in between.
+		wake := c.q.waiter()
+		msg, err := c.next()
+		if msg != nil || err != nil {
+			return msg, err
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, ErrTimeout
+		}
+		timer := time.NewTimer(remaining)
+		select {
+		case <-wake:
+			timer.Stop()
+		case <-timer.C:
+			return nil, ErrTimeout
+		}
+	}
+}
+
+// next returns the next message of the assigned topics, nil if there is
+// none.
+func (c *queueConsumer) next() (*Message, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, ErrClosed
+	}
+	if err := c.refresh(); err != nil {
+		return nil, err
+	}
+	if c.autoCommit && time.Since(c.lastCommit) >= autoCommitInterval {
+		if err := c.commit(); err != nil {
+			log.Printf("queue: auto commit: %v", err)
+		}
+	}
+	for i := range c.assigned {
+		name := c.assigned[(c.cursor+i)%len(c.assigned)]
+		if c.paused[name] {
+			continue
+		}
+		buf := c.buffers[name]
+		if len(buf) == 0 {
+			t, err := c.q.topic(name, false)
+			if err != nil {
+				return nil, err
+			}
+			if buf, err = t.read(c.positions[name], readBatchSize); err != nil {
+				return nil, err
+			}
+			if len(buf) == 0 {
+				continue
+			}
+		}
+		msg := buf[0]
+		c.buffers[name] = buf[1:]
+		c.positions[name] = msg.Offset + 1
+		c.cursor = (c.cursor + i + 1) % len(c.assigned)
+		return msg, nil
+	}
+	return nil, nil
+}
+
+// refresh updates the topics assigned to the consumer: the positions of
+// the new ones are the ones committed by the group, and the ones that
+// are not assigned any more are forgotten, their messages read since the
+// last commit being read again by their new consumer.
+func (c *queueConsumer) refresh() error {
+	assigned, err := c.q.assignment(c)
+	if err != nil {
+		return err
+	}
+	current := make(map[string]bool, len(assigned))
+	for _, name := range assigned {
+		current[name] = true
+		if _, ok := c.positions[name]; ok {
+			continue
+		}
+		t, err := c.q.topic(name, false)
+		if err != nil {
+			return err
+		}
+		c.positions[name] = c.q.start(c.group, t, c.earliest)
+	}
+	for name := range c.positions {
+		if !current[name] {
+			delete(c.positions, name)
+			delete(c.buffers, name)
+		}
+	}
+	c.assigned = assigned
+	return nil
+}
+
+func (c *queueConsumer) Commit() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	return c.commit()
+}
+
+func (c *queueConsumer) commit() error {
+	c.lastCommit = time.Now()
+	return c.q.commit(c.group, c.positions)
+}
+
+func (c *queueConsumer) Pause(topics ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, t := range topics {
+		c.paused[t] = true
+	}
+	return nil
+}
+
+func (c *queueConsumer) Resume(topics ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, t := range topics {
+		delete(c.paused, t)
+	}
+	return nil
+}
+
+func (c *queueConsumer) Seek(topic string, partition int32, offset int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	if err := c.refresh(); err != nil {
+		return err
+	}
+	if _, ok := c.positions[topic]; !ok || partition != 0 {
+		return fmt.Errorf("partition %s[%d] is not assigned to the consumer", topic, partition)
+	}
+	c.positions[topic] = offset
+	delete(c.buffers, topic)
+	return nil
+}
+
+// Close closes the consumer, committing its positions when it has auto
+// commit, and hands its topics over to the other members of its group.
+func (c *queueConsumer) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	var err error
+	if c.autoCommit {
+		err = c.commit()
+	}
+	c.closed = true
+	c.q.leave(c)
+	if c.release != nil {
+		c.release()
+	}
+	return err
+}
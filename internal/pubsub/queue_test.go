@@ -0,0 +1,193 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hyperbolicresearch/hlog/config"
+)
+
+func produce(t *testing.T, p Producer, topic string, values ...string) {
+	t.Helper()
+	msgs := make([]*Message, len(values))
+	for i, v := range values {
+		msgs[i] = &Message{Topic: topic, Key: []byte("k"), Value: []byte(v)}
+	}
+	for _, err := range p.Produce(msgs, time.Second) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readAll reads the messages until none arrives for a while.
+func readAll(t *testing.T, c Consumer) []string {
+	t.Helper()
+	var values []string
+	for {
+		msg, err := c.Read(50 * time.Millisecond)
+		if errors.Is(err, ErrTimeout) {
+			return values
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, fmt.Sprintf("%s:%d:%s", msg.Topic, msg.Offset, msg.Value))
+	}
+}
+
+func newConsumer(t *testing.T, q *Queue, cfg config.Kafka, topics ...string) Consumer {
+	t.Helper()
+	c, err := q.NewConsumer(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Subscribe(topics); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestQueue(t *testing.T) {
+	dir := t.TempDir()
+	q, err := OpenQueue(dir, QueueOptions{SegmentSize: 32})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := q.NewProducer()
+	produce(t, p, "billing", "a", "b")
+	produce(t, p, "auth", "c")
+	errs := p.Produce([]*Message{{Topic: "../x"}}, time.Second)
+	if errs[0] == nil {
+		t.Errorf("Expected an error for an invalid topic")
+	}
+
+	cfg := config.Kafka{GroupId: "g", AutoOffsetReset: "earliest", DenyTopics: []string{"^__"}}
+	c := newConsumer(t, q, cfg, "^.*")
+	got := readAll(t, c)
+	expect := []string{"auth:0:c", "billing:0:a", "billing:1:b"}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("Expected=%v, Got=%v", expect, got)
+	}
+
+	// A second member of the group gets nothing while the first one
+	// holds the topics, and takes over from the committed position.
+	if err := c.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	produce(t, p, "billing", "d")
+	other := newConsumer(t, q, cfg, "billing")
+	if got := readAll(t, other); len(got) != 0 {
+		t.Errorf("Expected no messages, Got=%v", got)
+	}
+	c.Close()
+	expect = []string{"billing:2:d"}
+	if got := readAll(t, other); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Expected=%v, Got=%v", expect, got)
+	}
+	other.Close()
+
+	// Another group starting at the end only sees the new messages.
+	latest := newConsumer(t, q, config.Kafka{GroupId: "h", AutoOffsetReset: "latest"}, "billing", "auth")
+	produce(t, p, "__internal", "x")
+	produce(t, p, "auth", "e")
+	expect = []string{"auth:1:e"}
+	if got := readAll(t, latest); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Expected=%v, Got=%v", expect, got)
+	}
+
+	// Seek and pause.
+	if err := latest.Seek("billing", 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := latest.Seek("payments", 0, 0); err == nil {
+		t.Errorf("Expected an error seeking a topic that is not assigned")
+	}
+	latest.Pause("auth")
+	produce(t, p, "auth", "f")
+	expect = []string{"billing:1:b", "billing:2:d"}
+	if got := readAll(t, latest); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Expected=%v, Got=%v", expect, got)
+	}
+	latest.Resume("auth")
+	expect = []string{"auth:2:f"}
+	if got := readAll(t, latest); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Expected=%v, Got=%v", expect, got)
+	}
+	latest.Close()
+	q.Close()
+
+	// The messages and the committed positions survive a restart, even
+	// with a torn record at the end of a segment.
+	segments, _ := filepath.Glob(filepath.Join(dir, "topics", "billing", "*.log"))
+	if len(segments) < 2 {
+		t.Fatalf("Expected several segments, Got=%v", segments)
+	}
+	f, err := os.OpenFile(segments[len(segments)-1], os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 40, 1, 2})
+	f.Close()
+
+	q, err = OpenQueue(dir, QueueOptions{SegmentSize: 32})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	produce(t, q.NewProducer(), "billing", "g")
+	c = newConsumer(t, q, cfg, "billing")
+	defer c.Close()
+	expect = []string{"billing:2:d", "billing:3:g"}
+	if got := readAll(t, c); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Expected=%v, Got=%v", expect, got)
+	}
+}
+
+func TestQueueWakesReaders(t *testing.T) {
+	q, err := OpenQueue(t.TempDir(), QueueOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	c := newConsumer(t, q, config.Kafka{GroupId: "g", AutoOffsetReset: "earliest"}, "^.*")
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		produce(t, q.NewProducer(), "late", "a")
+	}()
+	msg, err := c.Read(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.Value) != "a" {
+		t.Errorf("Expected=%v, Got=%s", "a", msg.Value)
+	}
+}
+
+func TestSharedQueue(t *testing.T) {
+	cfg := &config.Kafka{QueueDir: t.TempDir(), GroupId: "g", AutoOffsetReset: "earliest"}
+	p, err := NewProducer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewConsumer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Subscribe([]string{"default"})
+	produce(t, p, "default", "a")
+	p.Close()
+	expect := []string{"default:0:a"}
+	if got := readAll(t, c); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Expected=%v, Got=%v", expect, got)
+	}
+	c.Close()
+	if _, err := c.Read(0); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected=%v, Got=%v", ErrClosed, err)
+	}
+}
@@ -0,0 +1,297 @@
+package pubsub
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The topics of the embedded queue are directories of segment files,
+// named after the offset of their first record, which hold the records
+// one after the other:
+//
+//	length     uint32  size of what follows the checksum
+//	checksum   uint32  CRC-32C of what follows
+//	offset     int64
+//	timestamp  int64   Unix nanoseconds
+//	key length uint32
+//	key
+//	value
+const (
+	recordHeaderSize = 8
+	recordFixedSize  = 20
+	segmentSuffix    = ".log"
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// segment is a file of records.
+type segment struct {
+	base int64
+	file *os.File
+	size int64
+	// positions are the positions of the records in the file.
+	positions []int64
+	modTime   time.Time
+}
+
+// end returns the offset following the last record of the segment.
+func (s *segment) end() int64 {
+	return s.base + int64(len(s.positions))
+}
+
+// topic is a topic of the embedded queue, which has a single partition.
+type topic struct {
+	sync.RWMutex
+	name     string
+	dir      string
+	segments []*segment
+	// next is the offset of the next record.
+	next int64
+}
+
+// openTopic opens the topic stored in dir, which is created if needed.
+func openTopic(dir, name string) (*topic, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var bases []int64
+	for _, e := range entries {
+		base, err := strconv.ParseInt(strings.TrimSuffix(e.Name(), segmentSuffix), 10, 64)
+		if err != nil || !strings.HasSuffix(e.Name(), segmentSuffix) {
+			continue
+		}
+		bases = append(bases, base)
+	}
+	sort.Slice(bases, func(i, j int) bool { return bases[i] < bases[j] })
+
+	t := &topic{name: name, dir: dir}
+	for _, base := range bases {
+		s, err := openSegment(segmentPath(dir, base), base)
+		if err != nil {
+			t.close()
+			return nil, err
+		}
+		t.segments = append(t.segments, s)
+	}
+	if n := len(t.segments); n > 0 {
+		t.next = t.segments[n-1].end()
+	}
+	return t, nil
+}
+
+func segmentPath(dir string, base int64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", base, segmentSuffix))
+}
+
+// openSegment opens a segment and indexes its records. A segment ending
+// with an incomplete or corrupted record, as left by a crash in the
+// middle of a write, is truncated after its last valid record.
+func openSegment(path string, base int64) (*segment, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	s := &segment{base: base, file: f, modTime: info.ModTime()}
+	r := bufio.NewReader(io.NewSectionReader(f, 0, info.Size()))
+	header := make([]byte, recordHeaderSize)
+	var payload []byte
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			break
+		}
+		n := int64(binary.BigEndian.Uint32(header))
+		if n < recordFixedSize || n > info.Size()-s.size-recordHeaderSize {
+			break
+		}
+		if int64(cap(payload)) < n {
+			payload = make([]byte, n)
+		}
+		payload = payload[:n]
+		if _, err := io.ReadFull(r, payload); err != nil {
+			break
+		}
+		if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:]) ||
+			int64(binary.BigEndian.Uint64(payload)) != s.end() {
+			break
+		}
+		s.positions = append(s.positions, s.size)
+		s.size += recordHeaderSize + n
+	}
+	if s.size < info.Size() {
+		log.Printf("queue: truncating %s after its record %d", path, len(s.positions))
+		if err := f.Truncate(s.size); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// append writes the messages at the end of the topic, starting a new
+// segment when the last one reached segmentSize, and sets their offsets.
+func (t *topic) append(msgs []*Message, segmentSize int64) error {
+	t.Lock()
+	defer t.Unlock()
+	n := len(t.segments)
+	if n == 0 || t.segments[n-1].size >= segmentSize {
+		s, err := openSegment(segmentPath(t.dir, t.next), t.next)
+		if err != nil {
+			return err
+		}
+		t.segments = append(t.segments, s)
+	}
+	s := t.segments[len(t.segments)-1]
+
+	var buf []byte
+	positions := make([]int64, len(msgs))
+	for i, m := range msgs {
+		if m.Timestamp.IsZero() {
+			m.Timestamp = time.Now()
+		}
+		positions[i] = s.size + int64(len(buf))
+		buf = appendRecord(buf, t.next+int64(i), m)
+	}
+	if _, err := s.file.WriteAt(buf, s.size); err != nil {
+		s.file.Truncate(s.size)
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		s.file.Truncate(s.size)
+		return err
+	}
+	for i, m := range msgs {
+		m.Partition, m.Offset = 0, t.next+int64(i)
+	}
+	s.positions = append(s.positions, positions...)
+	s.size += int64(len(buf))
+	s.modTime = time.Now()
+	t.next += int64(len(msgs))
+	return nil
+}
+
+func appendRecord(buf []byte, offset int64, m *Message) []byte {
+	start := len(buf)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(recordFixedSize+len(m.Key)+len(m.Value)))
+	buf = binary.BigEndian.AppendUint32(buf, 0)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(offset))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(m.Timestamp.UnixNano()))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(m.Key)))
+	buf = append(buf, m.Key...)
+	buf = append(buf, m.Value...)
+	binary.BigEndian.PutUint32(buf[start+4:], crc32.Checksum(buf[start+recordHeaderSize:], crcTable))
+	return buf
+}
+
+// read returns at most max messages from offset on. The offsets that are
+// not stored any more, deleted by the retention, are skipped.
+func (t *topic) read(offset int64, max int) ([]*Message, error) {
+	t.RLock()
+	defer t.RUnlock()
+	var msgs []*Message
+	for _, s := range t.segments {
+		if offset >= s.end() {
+			continue
+		}
+		if offset < s.base {
+			offset = s.base
+		}
+		first := int(offset - s.base)
+		last := len(s.positions)
+		if last-first > max-len(msgs) {
+			last = first + max - len(msgs)
+		}
+		stop := s.size
+		if last < len(s.positions) {
+			stop = s.positions[last]
+		}
+		buf := make([]byte, stop-s.positions[first])
+		if _, err := s.file.ReadAt(buf, s.positions[first]); err != nil {
+			return nil, err
+		}
+		for len(buf) > 0 {
+			n := recordHeaderSize + int(binary.BigEndian.Uint32(buf))
+			msgs = append(msgs, decodeRecord(t.name, buf[recordHeaderSize:n]))
+			buf = buf[n:]
+		}
+		offset = s.base + int64(last)
+		if len(msgs) == max {
+			break
+		}
+	}
+	return msgs, nil
+}
+
+func decodeRecord(topic string, payload []byte) *Message {
+	keyLen := int(binary.BigEndian.Uint32(payload[16:]))
+	data := payload[recordFixedSize:]
+	return &Message{
+		Topic:     topic,
+		Offset:    int64(binary.BigEndian.Uint64(payload)),
+		Timestamp: time.Unix(0, int64(binary.BigEndian.Uint64(payload[8:]))),
+		Key:       data[:keyLen:keyLen],
+		Value:     data[keyLen:],
+	}
+}
+
+// first returns the offset of the first message still stored, and end
+// the offset of the next one.
+func (t *topic) first() int64 {
+	t.RLock()
+	defer t.RUnlock()
+	for _, s := range t.segments {
+		if len(s.positions) > 0 {
+			return s.base
+		}
+	}
+	return t.next
+}
+
+func (t *topic) end() int64 {
+	t.RLock()
+	defer t.RUnlock()
+	return t.next
+}
+
+// clean deletes the segments last written before cutoff, but the one
+// being written.
+func (t *topic) clean(cutoff time.Time) {
+	t.Lock()
+	defer t.Unlock()
+	for len(t.segments) > 1 && t.segments[0].modTime.Before(cutoff) {
+		s := t.segments[0]
+		s.file.Close()
+		if err := os.Remove(s.file.Name()); err != nil {
+			log.Printf("queue: %v", err)
+		}
+		t.segments = t.segments[1:]
+	}
+}
+
+func (t *topic) close() {
+	t.Lock()
+	defer t.Unlock()
+	for _, s := range t.segments {
+		s.file.Close()
+	}
+	t.segments = nil
+}
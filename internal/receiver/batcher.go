@@ -0,0 +1,87 @@
+package receiver
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hyperbolicresearch/hlog/internal/core"
+)
+
+// Batcher groups the logs of the receivers getting them one at a time,
+// such as the ones of streaming protocols, and submits them in batches.
+// Add blocks when the producer falls behind, which slows down the
+// readers instead of buffering without limit.
+type Batcher struct {
+	producer Producer
+	size     int
+	linger   time.Duration
+	onResult func(logs []*core.Log, res Result)
+	queue    chan *core.Log
+	closing  sync.Once
+	done     chan struct{}
+}
+
+// NewBatcher creates a Batcher submitting to p batches of at most size
+// logs, waiting at most linger for a batch to fill up. onResult, if not
+// nil, is called with the outcome of every batch.
+func NewBatcher(p Producer, size int, linger time.Duration, onResult func(logs []*core.Log, res Result)) *Batcher {
+	if size <= 0 {
+		size = 1
+	}
+	b := &Batcher{
+		producer: p,
+		size:     size,
+		linger:   linger,
+		onResult: onResult,
+		queue:    make(chan *core.Log, size),
+		done:     make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// Add queues a log, waiting for room if the queue is full. It must not
+// be called after Close.
+func (b *Batcher) Add(l *core.Log) {
+	b.queue <- l
+}
+
+// Close submits the queued logs and stops the batcher.
+func (b *Batcher) Close() {
+	b.closing.Do(func() {
+		close(b.queue)
+	})
+	<-b.done
+}
+
+func (b *Batcher) run() {
+	defer close(b.done)
+	ticker := time.NewTicker(b.linger)
+	defer ticker.Stop()
+	batch := make([]*core.Log, 0, b.size)
+	submit := func() {
+		if len(batch) == 0 {
+			return
+		}
+		res := Submit(b.producer, batch)
+		if b.onResult != nil {
+			b.onResult(batch, res)
+		}
+		batch = make([]*core.Log, 0, b.size)
+	}
+	for {
+		select {
+		case l, ok := <-b.queue:
+			if !ok {
+				submit()
+				return
+			}
+			batch = append(batch, l)
+			if len(batch) >= b.size {
+				submit()
+			}
+		case <-ticker.C:
+			submit()
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package receiver
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	ErrTooLarge            = errors.New("request body too large")
+	ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+)
+
+// ReadBody reads the body of r, decompressing it according to its
+// Content-Encoding (gzip or zstd). maxSize bounds the body as sent and
+// maxDecoded once decompressed, so that small bodies cannot expand
+// without limit.
+func ReadBody(w http.ResponseWriter, r *http.Request, maxSize, maxDecoded int64) ([]byte, error) {
+	var body io.Reader = http.MaxBytesReader(w, r.Body, maxSize)
+	switch strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))) {
+	case "", "identity":
+	case "gzip":
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, decodeError(err)
+		}
+		defer zr.Close()
+		body = zr
+	case "zstd":
+		zr, err := zstd.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		body = zr
+	default:
+		return nil, ErrUnsupportedEncoding
+	}
+	data, err := io.ReadAll(io.LimitReader(body, maxDecoded+1))
+	if err != nil {
+		return nil, decodeError(err)
+	}
+	if int64(len(data)) > maxDecoded {
+		return nil, ErrTooLarge
+	}
+	return data, nil
+}
+
+// BodyErrorStatus is the HTTP status matching an error of ReadBody.
+func BodyErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrUnsupportedEncoding):
+		return http.StatusUnsupportedMediaType
+	}
+	return http.StatusBadRequest
+}
+
+func decodeError(err error) error {
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		return ErrTooLarge
+	}
+	return fmt.Errorf("invalid body: %v", err)
+}
@@ -0,0 +1,133 @@
+// Package receiver holds what the ingestion endpoints accepting logs
+// from outside of Kafka have in common: checking and completing the
+// envelopes they build, and producing them to their channel.
+package receiver
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/pkg/logger"
+)
+
+// Producer produces logs to the topics of their channels. Produce waits
+// for the logs to be acknowledged and returns one error per log, nil
+// for the ones produced.
+type Producer interface {
+	Produce(logs []*core.Log) []error
+}
+
+// ProducerFunc adapts a function to the Producer interface.
+type ProducerFunc func(logs []*core.Log) []error
+
+func (f ProducerFunc) Produce(logs []*core.Log) []error {
+	return f(logs)
+}
+
+// channelPattern matches the names Kafka accepts for topics.
+var channelPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,249}$`)
+
+var (
+	ErrMissingChannel = errors.New("missing channel")
+	ErrEmptyLog       = errors.New("log has neither message nor data")
+)
+
+// ValidChannel reports whether name can be used as a channel, that is
+// as the name of a Kafka topic.
+func ValidChannel(name string) bool {
+	return channelPattern.MatchString(name)
+}
+
+// Prepare checks that l is a valid log and fills in what producers may
+// omit: the log id, the timestamp (now) and the level (info). The level
+// is normalized to its canonical name.
+func Prepare(l *core.Log) error {
+	if l.Channel == "" {
+		return ErrMissingChannel
+	}
+	if !ValidChannel(l.Channel) {
+		return fmt.Errorf("invalid channel %q", l.Channel)
+	}
+	if l.Message == "" && len(l.Data) == 0 {
+		return ErrEmptyLog
+	}
+	if l.Level == "" {
+		l.Level = logger.INFO.String()
+	} else {
+		level, ok := logger.ParseLevel(l.Level)
+		if !ok {
+			return fmt.Errorf("invalid level %q", l.Level)
+		}
+		l.Level = level.String()
+	}
+	if l.Timestamp < 0 {
+		return fmt.Errorf("invalid timestamp %d", l.Timestamp)
+	}
+	if l.Timestamp == 0 {
+		l.Timestamp = time.Now().UnixNano()
+	}
+	if l.LogId == "" {
+		l.LogId = uuid.New().String()
+	}
+	return nil
+}
+
+// Result is the outcome of Submit.
+type Result struct {
+	// Errors holds the error of every log, nil for the accepted ones.
+	Errors   []error
+	Accepted int
+	Rejected int
+	// Unavailable is the number of logs rejected by the producer rather
+	// than because they are invalid, which are worth retrying.
+	Unavailable int
+}
+
+// Retryable reports whether nothing was accepted because of the
+// producer, so that the whole submission can be retried.
+func (r Result) Retryable() bool {
+	return r.Accepted == 0 && r.Unavailable > 0
+}
+
+// FirstError returns the first error of the result, if any.
+func (r Result) FirstError() error {
+	for _, err := range r.Errors {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Submit prepares the logs and produces the valid ones.
+func Submit(p Producer, logs []*core.Log) Result {
+	res := Result{Errors: make([]error, len(logs))}
+	valid := make([]*core.Log, 0, len(logs))
+	indexes := make([]int, 0, len(logs))
+	for i, l := range logs {
+		if err := Prepare(l); err != nil {
+			res.Errors[i] = err
+			res.Rejected++
+			continue
+		}
+		valid = append(valid, l)
+		indexes = append(indexes, i)
+	}
+	if len(valid) > 0 {
+		for j, err := range p.Produce(valid) {
+			if err != nil {
+				res.Errors[indexes[j]] = err
+				res.Rejected++
+				res.Unavailable++
+				continue
+			}
+			res.Accepted++
+		}
+	}
+	return res
+}
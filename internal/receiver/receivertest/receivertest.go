@@ -0,0 +1,76 @@
+// Package receivertest provides a receiver.Producer for the tests of
+// the ingestion endpoints, recording the logs produced and failing on
+// demand.
+package receivertest
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/hyperbolicresearch/hlog/internal/core"
+)
+
+// ErrUnavailable is the error of the logs failed by a Producer without
+// Err.
+var ErrUnavailable = errors.New("broker unavailable")
+
+// Producer records the logs it produces. Its fields may only be changed
+// while no logs are being produced.
+type Producer struct {
+	// Err fails every log when set, and Failures is the number of calls
+	// failing before the logs are accepted.
+	Err      error
+	Failures int
+
+	mu       sync.Mutex
+	produced []*core.Log
+}
+
+// Produce records the logs that do not fail.
+func (p *Producer) Produce(logs []*core.Log) []error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	errs := make([]error, len(logs))
+	if p.Failures > 0 {
+		p.Failures--
+		for i := range errs {
+			errs[i] = p.failure()
+		}
+		return errs
+	}
+	if p.Err != nil {
+		for i := range errs {
+			errs[i] = p.Err
+		}
+		return errs
+	}
+	p.produced = append(p.produced, logs...)
+	return errs
+}
+
+func (p *Producer) failure() error {
+	if p.Err != nil {
+		return p.Err
+	}
+	return ErrUnavailable
+}
+
+// Logs returns the logs produced.
+func (p *Producer) Logs() []*core.Log {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*core.Log(nil), p.produced...)
+}
+
+// Messages returns the messages of the logs produced since the last
+// call, and forgets these logs.
+func (p *Producer) Messages() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var msgs []string
+	for _, l := range p.produced {
+		msgs = append(msgs, l.Message)
+	}
+	p.produced = nil
+	return msgs
+}
@@ -0,0 +1,156 @@
+// Package router decides where the ingesters store the logs, following
+// the routing rules of the configuration: a log may be stored in another
+// channel than the one it was produced to, copied to other channels, or
+// kept out of some sinks.
+package router
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/hyperbolicresearch/hlog/config"
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/internal/processor"
+	"github.com/hyperbolicresearch/hlog/internal/receiver"
+)
+
+// The sinks, each ingester being one.
+const (
+	ClickHouse = "clickhouse"
+	MongoDB    = "mongodb"
+)
+
+// route is a compiled route.
+type route struct {
+	channel string
+	copies  []string
+	// sinks are the sinks of the route, nil meaning all of them.
+	sinks map[string]bool
+	drop  bool
+}
+
+func newRoute(cfg config.Route) (*route, error) {
+	r := &route{channel: cfg.Channel, copies: cfg.Copies, drop: cfg.Drop}
+	for _, c := range append([]string{cfg.Channel}, cfg.Copies...) {
+		if c != "" && !receiver.ValidChannel(c) {
+			return nil, fmt.Errorf("invalid channel %q", c)
+		}
+	}
+	if len(cfg.Sinks) > 0 {
+		r.sinks = map[string]bool{}
+		for _, s := range cfg.Sinks {
+			if s != ClickHouse && s != MongoDB {
+				return nil, fmt.Errorf("unknown sink %q", s)
+			}
+			r.sinks[s] = true
+		}
+	}
+	return r, nil
+}
+
+// rule is a compiled routing rule.
+type rule struct {
+	channel string
+	cond    *processor.Condition
+	route   *route
+	next    bool
+}
+
+func (r *rule) match(l *core.Log) bool {
+	if r.channel != "" {
+		if ok, _ := path.Match(r.channel, l.Channel); !ok {
+			return false
+		}
+	}
+	return r.cond == nil || r.cond.Match(l)
+}
+
+// Router routes the logs.
+type Router struct {
+	rules []*rule
+	def   *route
+}
+
+// New creates the router of cfg, which may be nil.
+func New(cfg *config.Routing) (*Router, error) {
+	if cfg == nil {
+		cfg = &config.Routing{}
+	}
+	def, err := newRoute(cfg.Default)
+	if err != nil {
+		return nil, fmt.Errorf("router: default route: %v", err)
+	}
+	rt := &Router{def: def}
+	for i, rcfg := range cfg.Rules {
+		if _, err := path.Match(rcfg.Channel, ""); err != nil {
+			return nil, fmt.Errorf("router: rule %d: invalid channel %q", i, rcfg.Channel)
+		}
+		r := &rule{channel: rcfg.Channel, next: rcfg.Continue}
+		if r.route, err = newRoute(rcfg.Route); err != nil {
+			return nil, fmt.Errorf("router: rule %d: %v", i, err)
+		}
+		if rcfg.If != nil {
+			if r.cond, err = processor.NewCondition(*rcfg.If); err != nil {
+				return nil, fmt.Errorf("router: rule %d: %v", i, err)
+			}
+		}
+		rt.rules = append(rt.rules, r)
+	}
+	return rt, nil
+}
+
+// resolve combines the routes of the rules matching l.
+func (rt *Router) resolve(l *core.Log) *route {
+	var resolved *route
+	for _, r := range rt.rules {
+		if !r.match(l) {
+			continue
+		}
+		if resolved == nil {
+			resolved = &route{}
+		}
+		if r.route.channel != "" {
+			resolved.channel = r.route.channel
+		}
+		resolved.copies = append(resolved.copies, r.route.copies...)
+		if r.route.sinks != nil {
+			resolved.sinks = r.route.sinks
+		}
+		resolved.drop = resolved.drop || r.route.drop
+		if !r.next {
+			break
+		}
+	}
+	if resolved == nil {
+		return rt.def
+	}
+	return resolved
+}
+
+// Route returns the logs sink stores for l: l itself, its channel being
+// changed if its route says so, and its copies, each in its channel.
+// The copies share the data of l.
+func (rt *Router) Route(l *core.Log, sink string) []*core.Log {
+	if rt == nil {
+		return []*core.Log{l}
+	}
+	r := rt.resolve(l)
+	if r.drop || r.sinks != nil && !r.sinks[sink] {
+		return nil
+	}
+	if r.channel != "" {
+		l.Channel = r.channel
+	}
+	logs := []*core.Log{l}
+	seen := map[string]bool{l.Channel: true}
+	for _, c := range r.copies {
+		if seen[c] {
+			continue
+		}
+		seen[c] = true
+		copied := *l
+		copied.Channel = c
+		logs = append(logs, &copied)
+	}
+	return logs
+}
@@ -0,0 +1,73 @@
+package router
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hyperbolicresearch/hlog/config"
+	"github.com/hyperbolicresearch/hlog/internal/core"
+)
+
+func TestRoute(t *testing.T) {
+	rt, err := New(&config.Routing{
+		Rules: []config.RouteRule{
+			{
+				If:       &config.ProcessorCondition{Field: "level", Equals: []string{"fatal"}},
+				Route:    config.Route{Copies: []string{"incidents"}},
+				Continue: true,
+			},
+			{Channel: "audit*", Route: config.Route{Sinks: []string{ClickHouse}}},
+			{Channel: "legacy-*", Route: config.Route{Channel: "legacy", Copies: []string{"legacy"}}},
+			{If: &config.ProcessorCondition{Expr: `data.debug == true`}, Route: config.Route{Drop: true}},
+		},
+		Default: config.Route{Sinks: []string{MongoDB, ClickHouse}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		log        core.Log
+		clickhouse []string
+		mongodb    []string
+	}{
+		{"default", core.Log{Channel: "api", Level: "info"}, []string{"api"}, []string{"api"}},
+		{"fan out", core.Log{Channel: "api", Level: "fatal"}, []string{"api", "incidents"}, []string{"api", "incidents"}},
+		{"only some sinks", core.Log{Channel: "audit-login", Level: "info"}, []string{"audit-login"}, nil},
+		{"fan out and sinks", core.Log{Channel: "audit-login", Level: "fatal"}, []string{"audit-login", "incidents"}, nil},
+		{"reroute", core.Log{Channel: "legacy-billing"}, []string{"legacy"}, []string{"legacy"}},
+		{"drop", core.Log{Channel: "api", Data: map[string]interface{}{"debug": true}}, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for sink, expect := range map[string][]string{ClickHouse: tt.clickhouse, MongoDB: tt.mongodb} {
+				l := tt.log
+				var got []string
+				for _, routed := range rt.Route(&l, sink) {
+					got = append(got, routed.Channel)
+				}
+				if !reflect.DeepEqual(got, expect) {
+					t.Errorf("%s: Expected=%v, Got=%v", sink, expect, got)
+				}
+			}
+		})
+	}
+
+	var none *Router
+	if got := none.Route(&core.Log{Channel: "api"}, MongoDB); len(got) != 1 {
+		t.Errorf("Expected the log to be kept without router, Got=%v", got)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	for _, cfg := range []*config.Routing{
+		{Default: config.Route{Sinks: []string{"elastic"}}},
+		{Rules: []config.RouteRule{{Route: config.Route{Channel: "in valid"}}}},
+		{Rules: []config.RouteRule{{Channel: "["}}},
+		{Rules: []config.RouteRule{{If: &config.ProcessorCondition{Expr: "level =="}}}},
+	} {
+		if _, err := New(cfg); err == nil {
+			t.Errorf("Expected an error for %+v", cfg)
+		}
+	}
+}
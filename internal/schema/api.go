@@ -0,0 +1,123 @@
+package schema
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// SchemasPath is the endpoint listing, uploading and deleting the
+	// schemas.
+	SchemasPath = "/v1/schemas"
+	// ReportPath is the endpoint returning the violations of the schema
+	// of a channel per sender.
+	ReportPath = "/v1/schemas/report"
+
+	defaultTop = 10
+	// maxSchemaSize bounds the size of the schemas uploaded.
+	maxSchemaSize = 1 << 20
+)
+
+// Register adds the endpoints of the store to mux.
+func (s *Store) Register(mux *http.ServeMux) {
+	mux.HandleFunc(SchemasPath, s.ServeSchemas)
+	mux.HandleFunc(ReportPath, s.ServeReport)
+}
+
+// schemaJSON is a schema as returned by the API.
+type schemaJSON struct {
+	*Schema
+	Source json.RawMessage `json:"schema"`
+}
+
+// ServeSchemas lists the schemas on GET, the one of the channel parameter
+// only when it is given. A PUT or POST uploads the schema in the body for
+// the channel parameter, with the mode parameter, warn by default, and
+// DELETE removes the schema of the channel parameter.
+func (s *Store) ServeSchemas(w http.ResponseWriter, r *http.Request) {
+	channel := r.URL.Query().Get("channel")
+	switch r.Method {
+	case http.MethodGet:
+		schemas, err := s.Schemas(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		list := []schemaJSON{}
+		for _, sch := range schemas {
+			if channel == "" || sch.Channel == channel {
+				list = append(list, schemaJSON{sch, json.RawMessage(sch.Source)})
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"schemas": list})
+	case http.MethodPut, http.MethodPost:
+		mode := r.URL.Query().Get("mode")
+		if mode == "" {
+			mode = Warn
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSchemaSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sch, err := New(channel, mode, string(body))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.Put(r.Context(), sch); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(schemaJSON{sch, json.RawMessage(sch.Source)})
+	case http.MethodDelete:
+		found, err := s.Delete(r.Context(), channel)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "no schema for channel "+strconv.Quote(channel), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ServeReport returns the top violations per sender of the schema of the
+// channel parameter, top being given by the top parameter, 10 by
+// default.
+func (s *Store) ServeReport(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	channel := params.Get("channel")
+	if channel == "" {
+		http.Error(w, "missing channel", http.StatusBadRequest)
+		return
+	}
+	top := defaultTop
+	if v := params.Get("top"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid top "+strconv.Quote(v), http.StatusBadRequest)
+			return
+		}
+		top = n
+	}
+	reports, err := s.Report(r.Context(), channel, top)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if reports == nil {
+		reports = []*SenderReport{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"channel": channel, "senders": reports})
+}
@@ -0,0 +1,161 @@
+// Package schema validates the data of the logs against the JSON Schema
+// of their channel. The schemas are uploaded through the query API and
+// stored in MongoDB, from where the ingesters load them. Each schema has
+// a mode:
+//   - enforce: the logs that do not validate are stored in the
+//     quarantine channel, along with their channel and what is wrong
+//   - warn: the logs that do not validate are stored in their channel,
+//     along with what is wrong
+//   - off: the logs are not validated
+//
+// The violations are counted per channel, sender and location, which
+// makes the report of the senders breaking the schema of a channel.
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+
+	"github.com/hyperbolicresearch/hlog/internal/receiver"
+)
+
+// The modes of the schemas.
+const (
+	Enforce = "enforce"
+	Warn    = "warn"
+	Off     = "off"
+)
+
+const (
+	// maxViolations bounds the number of violations kept per log.
+	maxViolations = 10
+	// schemaURL is the URL the schemas are compiled under.
+	schemaURL = "hlog://schema.json"
+)
+
+// Schema is the JSON Schema of a channel.
+type Schema struct {
+	Channel string    `json:"channel" bson:"_id"`
+	Mode    string    `json:"mode" bson:"mode"`
+	Source  string    `json:"-" bson:"schema"`
+	Updated time.Time `json:"updated" bson:"updated"`
+
+	compiled *jsonschema.Schema
+}
+
+// New compiles the schema source of channel.
+func New(channel, mode, source string) (*Schema, error) {
+	s := &Schema{Channel: channel, Mode: mode, Source: source}
+	if err := s.compile(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Schema) compile() error {
+	if !receiver.ValidChannel(s.Channel) {
+		return fmt.Errorf("invalid channel %q", s.Channel)
+	}
+	switch s.Mode {
+	case Enforce, Warn, Off:
+	default:
+		return fmt.Errorf("unknown mode %q", s.Mode)
+	}
+	c := jsonschema.NewCompiler()
+	c.Draft = jsonschema.Draft2020
+	// The schemas cannot refer to files or URLs.
+	c.LoadURL = func(url string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("cannot load %s", url)
+	}
+	if err := c.AddResource(schemaURL, strings.NewReader(s.Source)); err != nil {
+		return fmt.Errorf("invalid schema: %v", err)
+	}
+	compiled, err := c.Compile(schemaURL)
+	if err != nil {
+		return fmt.Errorf("invalid schema: %v", err)
+	}
+	s.compiled = compiled
+	return nil
+}
+
+// Violation is a value of the data that does not validate.
+type Violation struct {
+	// Location is the JSON pointer of the value in the data, Keyword the
+	// keyword of the schema it breaks, like type or required, and Message
+	// tells what is wrong.
+	Location string `json:"location"`
+	Keyword  string `json:"keyword"`
+	Message  string `json:"message"`
+}
+
+func (v Violation) String() string {
+	return v.Location + ": " + v.Message
+}
+
+// Check validates data, returning at most maxViolations violations.
+func (s *Schema) Check(data map[string]interface{}) []Violation {
+	v, err := normalize(data)
+	if err == nil {
+		err = s.compiled.Validate(v)
+	}
+	if err == nil {
+		return nil
+	}
+	var verr *jsonschema.ValidationError
+	if !errors.As(err, &verr) {
+		return []Violation{{Location: "/", Message: err.Error()}}
+	}
+	var violations []Violation
+	var walk func(*jsonschema.ValidationError)
+	walk = func(e *jsonschema.ValidationError) {
+		if len(violations) == maxViolations {
+			return
+		}
+		if len(e.Causes) == 0 {
+			violations = append(violations, Violation{
+				Location: location(e.InstanceLocation),
+				Keyword:  e.KeywordLocation[strings.LastIndex(e.KeywordLocation, "/")+1:],
+				Message:  e.Message,
+			})
+			return
+		}
+		for _, cause := range e.Causes {
+			walk(cause)
+		}
+	}
+	walk(verr)
+	return violations
+}
+
+func location(pointer string) string {
+	if pointer == "" {
+		return "/"
+	}
+	return pointer
+}
+
+// normalize returns data as decoded from JSON, the values put in the
+// logs by the parsers and processors not all being JSON values.
+func normalize(data map[string]interface{}) (interface{}, error) {
+	if data == nil {
+		return map[string]interface{}{}, nil
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
@@ -0,0 +1,194 @@
+package schema
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/hyperbolicresearch/hlog/config"
+	"github.com/hyperbolicresearch/hlog/internal/core"
+)
+
+const orderSchema = `{
+	"type": "object",
+	"required": ["order_id", "amount"],
+	"properties": {
+		"order_id": {"type": "string"},
+		"amount": {"type": "number", "minimum": 0},
+		"status": {"enum": ["paid", "refunded"]}
+	}
+}`
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		channel string
+		mode    string
+		source  string
+		ok      bool
+	}{
+		{"valid", "orders", Enforce, orderSchema, true},
+		{"invalid channel", "orders/eu", Warn, orderSchema, false},
+		{"unknown mode", "orders", "strict", orderSchema, false},
+		{"not json", "orders", Warn, `{"type": `, false},
+		{"invalid keyword", "orders", Warn, `{"type": "thing"}`, false},
+		{"remote reference", "orders", Warn, `{"$ref": "file:///etc/passwd"}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.channel, tt.mode, tt.source)
+			if (err == nil) != tt.ok {
+				t.Errorf("Expected=%v, Got=%v", tt.ok, err)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	s, err := New("orders", Enforce, orderSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		data   map[string]interface{}
+		expect []Violation
+	}{
+		{"valid", map[string]interface{}{"order_id": "o-1", "amount": 12.5, "status": "paid"}, nil},
+		{"go values", map[string]interface{}{"order_id": "o-1", "amount": int64(3), "tags": []string{"a"}}, nil},
+		{"missing", map[string]interface{}{"order_id": "o-1"}, []Violation{
+			{Location: "/", Keyword: "required", Message: "missing properties: 'amount'"},
+		}},
+		{"no data", nil, []Violation{
+			{Location: "/", Keyword: "required", Message: "missing properties: 'order_id', 'amount'"},
+		}},
+		{"several", map[string]interface{}{"order_id": 1, "amount": -1, "status": "lost"}, []Violation{
+			{Location: "/order_id", Keyword: "type", Message: "expected string, but got number"},
+			{Location: "/amount", Keyword: "minimum", Message: "must be >= 0 but found -1"},
+			{Location: "/status", Keyword: "enum", Message: `value must be one of "paid", "refunded"`},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := s.Check(tt.data)
+			sortViolations(got)
+			sortViolations(tt.expect)
+			if !reflect.DeepEqual(got, tt.expect) {
+				t.Errorf("Expected=%v, Got=%v", tt.expect, got)
+			}
+		})
+	}
+}
+
+func sortViolations(v []Violation) {
+	sort.Slice(v, func(i, j int) bool { return v[i].Location < v[j].Location })
+}
+
+func TestValidate(t *testing.T) {
+	v, err := NewValidator(&config.DefaultValidationConfig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.Report = true
+	var schemas []*Schema
+	for channel, mode := range map[string]string{"orders": Enforce, "payments": Warn, "refunds": Off} {
+		s, err := New(channel, mode, orderSchema)
+		if err != nil {
+			t.Fatal(err)
+		}
+		schemas = append(schemas, s)
+	}
+	v.Set(schemas)
+
+	invalid := map[string]interface{}{"order_id": "o-1", "amount": "12"}
+	tests := []struct {
+		name    string
+		log     core.Log
+		changed bool
+		channel string
+		data    map[string]interface{}
+	}{
+		{"no schema", core.Log{Channel: "api", Data: map[string]interface{}{}}, false, "api", map[string]interface{}{}},
+		{"off", core.Log{Channel: "refunds", Data: invalid}, false, "refunds", invalid},
+		{"valid", core.Log{Channel: "orders", Data: map[string]interface{}{"order_id": "o-1", "amount": 12}},
+			false, "orders", map[string]interface{}{"order_id": "o-1", "amount": 12}},
+		{"warn", core.Log{Channel: "payments", SenderId: "shop", Data: copyData(invalid)}, true, "payments",
+			map[string]interface{}{"order_id": "o-1", "amount": "12",
+				ErrorsField: "/amount: expected number, but got string"}},
+		{"enforce", core.Log{Channel: "orders", SenderId: "shop", Data: copyData(invalid)}, true, "quarantine",
+			map[string]interface{}{"order_id": "o-1", "amount": "12",
+				ErrorsField: "/amount: expected number, but got string", ChannelField: "orders"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := tt.log
+			if got := v.Validate(&l); got != tt.changed {
+				t.Errorf("Expected=%v, Got=%v", tt.changed, got)
+			}
+			if l.Channel != tt.channel {
+				t.Errorf("Expected=%v, Got=%v", tt.channel, l.Channel)
+			}
+			if !reflect.DeepEqual(l.Data, tt.data) {
+				t.Errorf("Expected=%v, Got=%v", tt.data, l.Data)
+			}
+		})
+	}
+
+	counts := v.Drain()
+	if len(counts) != 2 {
+		t.Fatalf("Expected=2 counts, Got=%v", len(counts))
+	}
+	for _, c := range counts {
+		if c.Sender != "shop" || c.Location != "/amount" || c.Keyword != "type" || c.Count != 1 {
+			t.Errorf("Unexpected count %+v", c)
+		}
+	}
+	v.Restore(counts)
+	if got := v.Drain(); len(got) != 2 {
+		t.Errorf("Expected=2 restored counts, Got=%v", len(got))
+	}
+
+	var none *Validator
+	if none.Validate(&core.Log{Channel: "orders"}) {
+		t.Errorf("Expected no validation without validator")
+	}
+}
+
+func copyData(data map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		c[k] = v
+	}
+	return c
+}
+
+func TestReport(t *testing.T) {
+	counts := []*Count{
+		{Sender: "shop", Location: "/amount", Keyword: "type", Count: 5},
+		{Sender: "billing", Location: "/order_id", Keyword: "required", Count: 40},
+		{Sender: "shop", Location: "/status", Keyword: "enum", Count: 20},
+		{Sender: "shop", Location: "/order_id", Keyword: "type", Count: 1},
+		{Sender: "billing", Location: "/amount", Keyword: "minimum", Count: 2},
+	}
+	got := report(counts, 2)
+	type summary struct {
+		sender    string
+		total     uint64
+		locations []string
+	}
+	var gotSummary []summary
+	for _, r := range got {
+		s := summary{sender: r.Sender, total: r.Total}
+		for _, c := range r.Violations {
+			s.locations = append(s.locations, c.Location)
+		}
+		gotSummary = append(gotSummary, s)
+	}
+	expect := []summary{
+		{"billing", 42, []string{"/order_id", "/amount"}},
+		{"shop", 26, []string{"/status", "/amount"}},
+	}
+	if !reflect.DeepEqual(gotSummary, expect) {
+		t.Errorf("Expected=%v, Got=%v", expect, gotSummary)
+	}
+}
@@ -0,0 +1,163 @@
+package schema
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/hyperbolicresearch/hlog/config"
+)
+
+// Store keeps the schemas and the counts of the violations in MongoDB.
+type Store struct {
+	schemas *mongo.Collection
+	counts  *mongo.Collection
+}
+
+// NewStore returns the store of the collections of cfg in db.
+func NewStore(db *mongo.Database, cfg *config.Validation) *Store {
+	return &Store{
+		schemas: db.Collection(cfg.Collection),
+		counts:  db.Collection(cfg.ReportCollection),
+	}
+}
+
+// Schemas returns the schemas, skipping the ones that do not compile.
+func (s *Store) Schemas(ctx context.Context) ([]*Schema, error) {
+	cur, err := s.schemas.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	// The documents are decoded one by one, so that one that is not a
+	// schema does not hide the others.
+	var schemas []*Schema
+	for cur.Next(ctx) {
+		var sch Schema
+		if err := cur.Decode(&sch); err != nil {
+			log.Printf("schema: skipping the document %v: %v", cur.Current.Lookup("_id"), err)
+			continue
+		}
+		if err := sch.compile(); err != nil {
+			log.Printf("schema: skipping the schema of %s: %v", sch.Channel, err)
+			continue
+		}
+		schemas = append(schemas, &sch)
+	}
+	return schemas, cur.Err()
+}
+
+// Put stores sch, replacing the schema of its channel.
+func (s *Store) Put(ctx context.Context, sch *Schema) error {
+	sch.Updated = time.Now().UTC()
+	_, err := s.schemas.ReplaceOne(ctx, bson.D{{Key: "_id", Value: sch.Channel}}, sch,
+		options.Replace().SetUpsert(true))
+	return err
+}
+
+// Delete removes the schema of channel, and reports whether there was
+// one.
+func (s *Store) Delete(ctx context.Context, channel string) (bool, error) {
+	res, err := s.schemas.DeleteOne(ctx, bson.D{{Key: "_id", Value: channel}})
+	if err != nil {
+		return false, err
+	}
+	return res.DeletedCount > 0, nil
+}
+
+// WriteCounts adds counts to the stored ones.
+func (s *Store) WriteCounts(ctx context.Context, counts []*Count) error {
+	if len(counts) == 0 {
+		return nil
+	}
+	models := make([]mongo.WriteModel, 0, len(counts))
+	for _, c := range counts {
+		id := bson.D{
+			{Key: "channel", Value: c.Channel},
+			{Key: "sender", Value: c.Sender},
+			{Key: "location", Value: c.Location},
+			{Key: "keyword", Value: c.Keyword},
+		}
+		update := bson.D{
+			{Key: "$inc", Value: bson.D{{Key: "count", Value: int64(c.Count)}}},
+			{Key: "$set", Value: bson.D{
+				{Key: "channel", Value: c.Channel},
+				{Key: "sender", Value: c.Sender},
+				{Key: "location", Value: c.Location},
+				{Key: "keyword", Value: c.Keyword},
+				{Key: "message", Value: c.Message},
+			}},
+			{Key: "$max", Value: bson.D{{Key: "last_seen", Value: c.LastSeen}}},
+		}
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{Key: "_id", Value: id}}).
+			SetUpdate(update).
+			SetUpsert(true))
+	}
+	_, err := s.counts.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+// SenderReport holds the violations of the schema of a channel by the
+// logs of a sender, the most frequent first.
+type SenderReport struct {
+	Sender     string   `json:"sender"`
+	Total      uint64   `json:"total"`
+	Violations []*Count `json:"violations"`
+}
+
+// Report returns the top violations of the schema of channel per sender,
+// the senders with the most violations first.
+func (s *Store) Report(ctx context.Context, channel string, top int) ([]*SenderReport, error) {
+	cur, err := s.counts.Find(ctx, bson.D{{Key: "channel", Value: channel}})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	var counts []*Count
+	for cur.Next(ctx) {
+		var c Count
+		if err := cur.Decode(&c); err != nil {
+			log.Printf("schema: skipping the count %v: %v", cur.Current.Lookup("_id"), err)
+			continue
+		}
+		counts = append(counts, &c)
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+	return report(counts, top), nil
+}
+
+// report groups counts per sender, keeping the top ones of each.
+func report(counts []*Count, top int) []*SenderReport {
+	sort.SliceStable(counts, func(i, j int) bool {
+		return counts[i].Count > counts[j].Count
+	})
+	bySender := map[string]*SenderReport{}
+	var reports []*SenderReport
+	for _, c := range counts {
+		r, ok := bySender[c.Sender]
+		if !ok {
+			r = &SenderReport{Sender: c.Sender}
+			bySender[c.Sender] = r
+			reports = append(reports, r)
+		}
+		r.Total += c.Count
+		if top <= 0 || len(r.Violations) < top {
+			r.Violations = append(r.Violations, c)
+		}
+	}
+	sort.SliceStable(reports, func(i, j int) bool {
+		if reports[i].Total != reports[j].Total {
+			return reports[i].Total > reports[j].Total
+		}
+		return reports[i].Sender < reports[j].Sender
+	})
+	return reports
+}
@@ -0,0 +1,223 @@
+package schema
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hyperbolicresearch/hlog/config"
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/internal/receiver"
+)
+
+const (
+	// ErrorsField holds the violations of a log that does not validate,
+	// and ChannelField the channel of a log put in quarantine.
+	ErrorsField  = "schema_errors"
+	ChannelField = "schema_channel"
+
+	// loadTimeout bounds the loading of the schemas from MongoDB.
+	loadTimeout = 30 * time.Second
+	// maxCounts bounds the number of counts kept between two drains.
+	maxCounts = 10000
+)
+
+// Count counts the violations of the schema of a channel by the logs of
+// a sender, at a location and keyword, Message being the latest message.
+type Count struct {
+	Channel  string    `json:"channel" bson:"channel"`
+	Sender   string    `json:"sender" bson:"sender"`
+	Location string    `json:"location" bson:"location"`
+	Keyword  string    `json:"keyword" bson:"keyword"`
+	Message  string    `json:"message" bson:"message"`
+	Count    uint64    `json:"count" bson:"count"`
+	LastSeen time.Time `json:"last_seen" bson:"last_seen"`
+}
+
+func (c *Count) key() string {
+	return strings.Join([]string{c.Channel, c.Sender, c.Location, c.Keyword}, "\x00")
+}
+
+// Validator validates the logs against the schemas of their channel.
+type Validator struct {
+	store      *Store
+	quarantine string
+	interval   time.Duration
+
+	schemas atomic.Pointer[map[string]*Schema]
+	// checked is when the schemas were last loaded, in Unix nanoseconds,
+	// and reloading is set while they are being loaded.
+	checked   atomic.Int64
+	reloading atomic.Bool
+
+	// Report enables the counting of the violations, drained with Drain.
+	// Only one of the ingesters reports them, so that the logs they both
+	// store are not counted twice.
+	Report bool
+	mu     sync.Mutex
+	counts map[string]*Count
+}
+
+// NewValidator creates the validator of cfg, loading the schemas from
+// store, which may be nil.
+func NewValidator(cfg *config.Validation, store *Store) (*Validator, error) {
+	if !receiver.ValidChannel(cfg.QuarantineChannel) {
+		return nil, fmt.Errorf("schema: invalid quarantine channel %q", cfg.QuarantineChannel)
+	}
+	v := &Validator{
+		store:      store,
+		quarantine: cfg.QuarantineChannel,
+		interval:   cfg.ReloadInterval,
+		counts:     map[string]*Count{},
+	}
+	v.Set(nil)
+	if store != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), loadTimeout)
+		defer cancel()
+		schemas, err := store.Schemas(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("schema: loading the schemas: %v", err)
+		}
+		v.Set(schemas)
+	}
+	v.checked.Store(time.Now().UnixNano())
+	return v, nil
+}
+
+// Set replaces the schemas.
+func (v *Validator) Set(schemas []*Schema) {
+	m := make(map[string]*Schema, len(schemas))
+	for _, s := range schemas {
+		m[s.Channel] = s
+	}
+	v.schemas.Store(&m)
+}
+
+// maybeReload loads the schemas again in the background when they were
+// not loaded for a while.
+func (v *Validator) maybeReload() {
+	now := time.Now().UnixNano()
+	if v.store == nil || v.interval <= 0 || now-v.checked.Load() < int64(v.interval) ||
+		!v.reloading.CompareAndSwap(false, true) {
+		return
+	}
+	v.checked.Store(now)
+	go func() {
+		defer v.reloading.Store(false)
+		ctx, cancel := context.WithTimeout(context.Background(), loadTimeout)
+		defer cancel()
+		schemas, err := v.store.Schemas(ctx)
+		if err != nil {
+			log.Printf("schema: reloading the schemas: %v", err)
+			return
+		}
+		v.Set(schemas)
+	}()
+}
+
+// Validate validates l against the schema of its channel, if any. The
+// violations of a log that does not validate are put in its ErrorsField,
+// and in enforce mode the log is moved to the quarantine channel, its
+// channel being kept in its ChannelField. Validate reports whether l was
+// changed.
+func (v *Validator) Validate(l *core.Log) bool {
+	if v == nil {
+		return false
+	}
+	v.maybeReload()
+	s := (*v.schemas.Load())[l.Channel]
+	if s == nil || s.Mode == Off {
+		return false
+	}
+	violations := s.Check(l.Data)
+	if len(violations) == 0 {
+		return false
+	}
+	if v.Report {
+		v.count(l, violations)
+	}
+	messages := make([]string, len(violations))
+	for i, viol := range violations {
+		messages[i] = viol.String()
+	}
+	if l.Data == nil {
+		l.Data = map[string]interface{}{}
+	}
+	l.Data[ErrorsField] = strings.Join(messages, "; ")
+	if s.Mode == Enforce {
+		l.Data[ChannelField] = l.Channel
+		l.Channel = v.quarantine
+	}
+	return true
+}
+
+// Quarantined reports whether l is in the quarantine channel.
+func (v *Validator) Quarantined(l *core.Log) bool {
+	return v != nil && l.Channel == v.quarantine
+}
+
+func (v *Validator) count(l *core.Log, violations []Violation) {
+	now := time.Now()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, viol := range violations {
+		c := &Count{
+			Channel:  l.Channel,
+			Sender:   l.SenderId,
+			Location: viol.Location,
+			Keyword:  viol.Keyword,
+		}
+		key := c.key()
+		if cur, ok := v.counts[key]; ok {
+			c = cur
+		} else if len(v.counts) < maxCounts {
+			v.counts[key] = c
+		}
+		c.Message = viol.Message
+		c.Count++
+		c.LastSeen = now
+	}
+}
+
+// Drain returns the counts of the violations since the last call.
+func (v *Validator) Drain() []*Count {
+	if v == nil {
+		return nil
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	counts := make([]*Count, 0, len(v.counts))
+	for _, c := range v.counts {
+		counts = append(counts, c)
+	}
+	v.counts = map[string]*Count{}
+	return counts
+}
+
+// Restore puts back counts drained but not stored, to be stored with the
+// next ones.
+func (v *Validator) Restore(counts []*Count) {
+	if v == nil {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, c := range counts {
+		key := c.key()
+		cur, ok := v.counts[key]
+		if !ok {
+			if len(v.counts) < maxCounts {
+				v.counts[key] = c
+			}
+			continue
+		}
+		cur.Count += c.Count
+		if c.LastSeen.After(cur.LastSeen) {
+			cur.Message, cur.LastSeen = c.Message, c.LastSeen
+		}
+	}
+}
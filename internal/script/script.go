@@ -0,0 +1,133 @@
+// Package script compiles and runs the expressions of the processor
+// pipelines, such as:
+//
+//	level == "error" && data.status >= 500
+//	data.bytes / 1024
+//	sender_id startsWith "web-" ? "frontend" : "backend"
+//
+// The expressions use the expr language (https://expr-lang.org). They
+// see the fields of a log under the names of its JSON encoding: channel,
+// log_id, sender_id, timestamp, level, message and data, and nothing
+// else: they cannot reach the file system, the network or the process.
+//
+// The work of an expression on a log is bounded by its size and by its
+// memory budget, which every iteration counts against, so that it runs
+// in the goroutine of the caller without having to be interrupted.
+package script
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+
+	"github.com/hyperbolicresearch/hlog/internal/core"
+)
+
+const (
+	// DefaultTimeout is how long an expression may run on a log when its
+	// configuration does not tell, its value being ignored beyond.
+	DefaultTimeout = 10 * time.Millisecond
+	// maxNodes bounds the size of the expressions, and memoryBudget the
+	// memory they may allocate on a log.
+	maxNodes     = 1000
+	memoryBudget = 100000
+)
+
+// ErrTimeout is returned when an expression runs for too long.
+var ErrTimeout = errors.New("script: expression timed out")
+
+// env declares the variables of the expressions to the compiler.
+var env = map[string]interface{}{
+	"channel":   "",
+	"log_id":    "",
+	"sender_id": "",
+	"timestamp": int64(0),
+	"level":     "",
+	"message":   "",
+	"data":      map[string]interface{}{},
+}
+
+// Program is a compiled expression.
+type Program struct {
+	source  string
+	program *vm.Program
+	timeout time.Duration
+}
+
+// Compile compiles an expression, run for at most timeout on a log, or
+// DefaultTimeout if it is zero.
+func Compile(source string, timeout time.Duration) (*Program, error) {
+	return compile(source, timeout)
+}
+
+// CompileBool compiles an expression like Compile, failing unless its
+// value is a boolean, or of a type only known when it runs.
+func CompileBool(source string, timeout time.Duration) (*Program, error) {
+	return compile(source, timeout, expr.AsBool())
+}
+
+func compile(source string, timeout time.Duration, opts ...expr.Option) (*Program, error) {
+	opts = append([]expr.Option{expr.Env(env), expr.MaxNodes(maxNodes)}, opts...)
+	program, err := expr.Compile(source, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Program{source: source, program: program, timeout: timeout}, nil
+}
+
+// String returns the source of the expression.
+func (p *Program) String() string {
+	return p.source
+}
+
+// Env returns the variables of the expressions run on l.
+func Env(l *core.Log) map[string]interface{} {
+	data := l.Data
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	return map[string]interface{}{
+		"channel":   l.Channel,
+		"log_id":    l.LogId,
+		"sender_id": l.SenderId,
+		"timestamp": l.Timestamp,
+		"level":     l.Level,
+		"message":   l.Message,
+		"data":      data,
+	}
+}
+
+// Run runs the expression on l, and returns its value. The expressions
+// that exhaust their memory budget fail, and the ones that ran for longer
+// than their timeout fail with ErrTimeout once they ended.
+func (p *Program) Run(l *core.Log) (interface{}, error) {
+	start := time.Now()
+	machine := vm.VM{MemoryBudget: memoryBudget}
+	v, err := machine.Run(p.program, Env(l))
+	if err != nil {
+		return nil, err
+	}
+	if time.Since(start) > p.timeout {
+		return nil, ErrTimeout
+	}
+	return v, nil
+}
+
+// Bool runs an expression whose value is a boolean.
+func (p *Program) Bool(l *core.Log) (bool, error) {
+	v, err := p.Run(l)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("script: %q returned %T, not a boolean", p.source, v)
+	}
+	return b, nil
+}
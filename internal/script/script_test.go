@@ -0,0 +1,98 @@
+package script
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hyperbolicresearch/hlog/internal/core"
+)
+
+func TestRun(t *testing.T) {
+	l := &core.Log{
+		Channel:   "api",
+		SenderId:  "web-1",
+		Timestamp: 1700000000,
+		Level:     "error",
+		Message:   "upstream timed out",
+		Data:      map[string]interface{}{"status": 503.0, "bytes": 2048.0, "user": map[string]interface{}{"id": "42"}},
+	}
+	tests := []struct {
+		source string
+		expect interface{}
+		ok     bool
+	}{
+		{`level == "error" && data.status >= 500`, true, true},
+		{`data.bytes / 1024`, 2.0, true},
+		{`sender_id startsWith "web-" ? "frontend" : "backend"`, "frontend", true},
+		{`data.user.id + "@" + channel`, "42@api", true},
+		{`message contains "timed out" and timestamp > 0`, true, true},
+		{`data.missing ?? "none"`, "none", true},
+		{`data.missing > 1`, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			p, err := Compile(tt.source, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := p.Run(l)
+			if (err == nil) != tt.ok {
+				t.Fatalf("Expected ok=%v, Got=%v", tt.ok, err)
+			}
+			if !reflect.DeepEqual(got, tt.expect) {
+				t.Errorf("Expected=%#v, Got=%#v", tt.expect, got)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, source := range []string{`lvl == "error"`, `level ==`, `os.Exit(1)`} {
+		if _, err := Compile(source, 0); err == nil {
+			t.Errorf("Expected an error for %q", source)
+		}
+	}
+}
+
+func TestCompileBool(t *testing.T) {
+	tests := []struct {
+		source string
+		ok     bool
+	}{
+		{`level == "error" && data.status >= 500`, true},
+		{`data.sampled`, true},
+		{`level`, false},
+		{`data.bytes / 1024`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			if _, err := CompileBool(tt.source, 0); (err == nil) != tt.ok {
+				t.Errorf("Expected ok=%v, Got=%v", tt.ok, err)
+			}
+		})
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	p, err := Compile(`len(filter(1..100000000, # % 7 == 0))`, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	_, err = p.Run(&core.Log{})
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the expression to exhaust its budget, Got=%v", elapsed)
+	}
+
+	p, err = Compile(`level == "error"`, time.Nanosecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Run(&core.Log{}); err != ErrTimeout {
+		t.Errorf("Expected=%v, Got=%v", ErrTimeout, err)
+	}
+}
@@ -0,0 +1,243 @@
+package syslog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Message is a parsed syslog message.
+type Message struct {
+	// Format is rfc5424 or rfc3164.
+	Format   string
+	Facility int
+	Severity int
+	// Timestamp is the zero time when the message has none.
+	Timestamp time.Time
+	Hostname  string
+	AppName   string
+	ProcId    string
+	MsgId     string
+	// StructuredData maps the SD-IDs of an RFC 5424 message to their
+	// parameters.
+	StructuredData map[string]map[string]string
+	Message        string
+}
+
+var (
+	errNoPriority   = errors.New("missing priority")
+	errBadPriority  = errors.New("invalid priority")
+	errTruncated    = errors.New("truncated message")
+	errBadTimestamp = errors.New("invalid timestamp")
+	errBadSD        = errors.New("invalid structured data")
+)
+
+// Parse parses an RFC 5424 or RFC 3164 message, the format being told
+// by the version following the priority. The year missing from RFC 3164
+// timestamps is the one of now, or the previous one for dates that
+// would otherwise be in the future.
+func Parse(b []byte, now time.Time) (*Message, error) {
+	b = bytes.TrimRight(b, "\r\n\x00")
+	if len(b) == 0 || b[0] != '<' {
+		return nil, errNoPriority
+	}
+	end := bytes.IndexByte(b, '>')
+	if end < 2 || end > 4 {
+		return nil, errBadPriority
+	}
+	// The priority is made of 1 to 3 digits, without any sign.
+	pri := 0
+	for _, c := range b[1:end] {
+		if c < '0' || c > '9' {
+			return nil, errBadPriority
+		}
+		pri = pri*10 + int(c-'0')
+	}
+	if pri > 191 {
+		return nil, errBadPriority
+	}
+	m := &Message{Facility: pri / 8, Severity: pri % 8}
+	rest := b[end+1:]
+	if len(rest) >= 2 && rest[0] == '1' && rest[1] == ' ' {
+		m.Format = "rfc5424"
+		return m, m.parse5424(rest[2:])
+	}
+	m.Format = "rfc3164"
+	m.parse3164(rest, now)
+	return m, nil
+}
+
+// field reads a space-terminated field, the nil value "-" being empty.
+func field(b []byte) (string, []byte, error) {
+	i := bytes.IndexByte(b, ' ')
+	if i < 0 {
+		return "", nil, errTruncated
+	}
+	v := string(b[:i])
+	if v == "-" {
+		v = ""
+	}
+	return v, b[i+1:], nil
+}
+
+func (m *Message) parse5424(b []byte) error {
+	ts, b, err := field(b)
+	if err != nil {
+		return err
+	}
+	if ts != "" {
+		m.Timestamp, err = time.Parse(time.RFC3339Nano, ts)
+		if err != nil {
+			return errBadTimestamp
+		}
+	}
+	if m.Hostname, b, err = field(b); err != nil {
+		return err
+	}
+	if m.AppName, b, err = field(b); err != nil {
+		return err
+	}
+	if m.ProcId, b, err = field(b); err != nil {
+		return err
+	}
+	if m.MsgId, b, err = field(b); err != nil {
+		return err
+	}
+	// The structured data may be the last field of the message.
+	if b, err = m.parseSD(b); err != nil {
+		return err
+	}
+	if len(b) > 0 {
+		if b[0] != ' ' {
+			return errBadSD
+		}
+		b = bytes.TrimPrefix(b[1:], []byte("\xef\xbb\xbf"))
+	}
+	m.Message = string(b)
+	return nil
+}
+
+// parseSD parses the structured data, [id key="value" ...] elements or
+// the nil value, and returns what follows it.
+func (m *Message) parseSD(b []byte) ([]byte, error) {
+	if len(b) == 0 {
+		return nil, errTruncated
+	}
+	if b[0] == '-' {
+		return b[1:], nil
+	}
+	for len(b) > 0 && b[0] == '[' {
+		b = b[1:]
+		i := bytes.IndexAny(b, " ]")
+		if i <= 0 {
+			return nil, errBadSD
+		}
+		id := string(b[:i])
+		params := map[string]string{}
+		b = b[i:]
+		for len(b) > 0 && b[0] == ' ' {
+			b = b[1:]
+			eq := bytes.IndexByte(b, '=')
+			if eq <= 0 || eq+1 >= len(b) || b[eq+1] != '"' {
+				return nil, errBadSD
+			}
+			name := string(b[:eq])
+			b = b[eq+2:]
+			var value strings.Builder
+			closed := false
+			for j := 0; j < len(b); j++ {
+				c := b[j]
+				if c == '\\' && j+1 < len(b) && (b[j+1] == '"' || b[j+1] == '\\' || b[j+1] == ']') {
+					value.WriteByte(b[j+1])
+					j++
+					continue
+				}
+				if c == '"' {
+					b = b[j+1:]
+					closed = true
+					break
+				}
+				value.WriteByte(c)
+			}
+			if !closed {
+				return nil, errBadSD
+			}
+			params[name] = value.String()
+		}
+		if len(b) == 0 || b[0] != ']' {
+			return nil, errBadSD
+		}
+		b = b[1:]
+		if m.StructuredData == nil {
+			m.StructuredData = map[string]map[string]string{}
+		}
+		m.StructuredData[id] = params
+	}
+	return b, nil
+}
+
+// parse3164 parses what follows the priority of an RFC 3164 message,
+// "Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG". The format is loosely
+// followed in the wild, so the missing parts are skipped rather than
+// treated as errors.
+func (m *Message) parse3164(b []byte, now time.Time) {
+	s := string(b)
+	if len(s) >= len(time.Stamp) {
+		if ts, err := time.ParseInLocation(time.Stamp, s[:len(time.Stamp)], now.Location()); err == nil {
+			ts = ts.AddDate(now.Year(), 0, 0)
+			if ts.After(now.Add(time.Duration(24) * time.Hour)) {
+				ts = ts.AddDate(-1, 0, 0)
+			}
+			m.Timestamp = ts
+			s = strings.TrimPrefix(s[len(time.Stamp):], " ")
+		}
+	}
+	// The hostname is only there when the first word is not the tag.
+	if i := strings.IndexByte(s, ' '); i > 0 && !isTag(s[:i]) && !m.Timestamp.IsZero() {
+		m.Hostname = s[:i]
+		s = s[i+1:]
+	}
+	if i := strings.IndexByte(s, ' '); i > 0 && isTag(s[:i]) {
+		tag := strings.TrimSuffix(s[:i], ":")
+		if open := strings.IndexByte(tag, '['); open > 0 && strings.HasSuffix(tag, "]") {
+			m.ProcId = tag[open+1 : len(tag)-1]
+			tag = tag[:open]
+		}
+		m.AppName = tag
+		s = s[i+1:]
+	}
+	m.Message = s
+}
+
+// isTag tells whether a word is a TAG[PID]: prefix.
+func isTag(word string) bool {
+	return len(word) > 1 && strings.HasSuffix(word, ":")
+}
+
+var facilityNames = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+var severityNames = []string{
+	"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug",
+}
+
+// FacilityName returns the keyword of a facility.
+func FacilityName(facility int) string {
+	if facility >= 0 && facility < len(facilityNames) {
+		return facilityNames[facility]
+	}
+	return fmt.Sprintf("facility(%d)", facility)
+}
+
+// SeverityName returns the keyword of a severity.
+func SeverityName(severity int) string {
+	if severity >= 0 && severity < len(severityNames) {
+		return severityNames[severity]
+	}
+	return fmt.Sprintf("severity(%d)", severity)
+}
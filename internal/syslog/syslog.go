@@ -0,0 +1,414 @@
+// Package syslog receives the logs of network gear and daemons that can
+// only emit syslog. It parses RFC 5424 and RFC 3164 messages received
+// over UDP, TCP or TLS, routes them to channels and produces them.
+package syslog
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hyperbolicresearch/hlog/config"
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/internal/receiver"
+	"github.com/hyperbolicresearch/hlog/pkg/logger"
+)
+
+// Router chooses the channel of the messages.
+type Router struct {
+	routes []route
+	def    string
+}
+
+type route struct {
+	field   string
+	re      *regexp.Regexp
+	channel string
+}
+
+// NewRouter compiles the routes. The messages matching none of them go
+// to def.
+func NewRouter(routes []config.SyslogRoute, def string) (*Router, error) {
+	if !receiver.ValidChannel(def) {
+		return nil, fmt.Errorf("invalid default channel %q", def)
+	}
+	r := &Router{def: def}
+	for _, rt := range routes {
+		switch rt.Field {
+		case "app_name", "hostname", "facility", "severity", "msg_id":
+		default:
+			return nil, fmt.Errorf("invalid route field %q", rt.Field)
+		}
+		re, err := regexp.Compile(rt.Match)
+		if err != nil {
+			return nil, fmt.Errorf("invalid route expression %q: %v", rt.Match, err)
+		}
+		if !receiver.ValidChannel(rt.Channel) {
+			return nil, fmt.Errorf("invalid route channel %q", rt.Channel)
+		}
+		r.routes = append(r.routes, route{rt.Field, re, rt.Channel})
+	}
+	return r, nil
+}
+
+// Channel returns the channel of the first route matching m.
+func (r *Router) Channel(m *Message) string {
+	for _, rt := range r.routes {
+		var v string
+		switch rt.field {
+		case "app_name":
+			v = m.AppName
+		case "hostname":
+			v = m.Hostname
+		case "facility":
+			v = FacilityName(m.Facility)
+		case "severity":
+			v = SeverityName(m.Severity)
+		case "msg_id":
+			v = m.MsgId
+		}
+		if rt.re.MatchString(v) {
+			return rt.channel
+		}
+	}
+	return r.def
+}
+
+// Stats are the counters of a Server.
+type Stats struct {
+	Received uint64
+	// Failed is the number of messages that could not be parsed, which
+	// are sent to the fallback channel.
+	Failed uint64
+	// Rejected is the number of logs that could not be produced.
+	Rejected uint64
+}
+
+// Server receives syslog messages and produces them.
+type Server struct {
+	cfg     config.Syslog
+	router  *Router
+	batcher *receiver.Batcher
+
+	received atomic.Uint64
+	failed   atomic.Uint64
+	rejected atomic.Uint64
+
+	mu        sync.Mutex
+	closed    bool
+	listeners []io.Closer
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+}
+
+// NewServer creates a Server producing the logs with p.
+func NewServer(p receiver.Producer, cfg config.Syslog) (*Server, error) {
+	router, err := NewRouter(cfg.Routes, cfg.DefaultChannel)
+	if err != nil {
+		return nil, err
+	}
+	if !receiver.ValidChannel(cfg.FallbackChannel) {
+		return nil, fmt.Errorf("invalid fallback channel %q", cfg.FallbackChannel)
+	}
+	if cfg.MaxMessageSize <= 0 {
+		cfg.MaxMessageSize = config.DefaultSyslogConfig.MaxMessageSize
+	}
+	if cfg.Linger <= 0 {
+		cfg.Linger = config.DefaultSyslogConfig.Linger
+	}
+	s := &Server{
+		cfg:    cfg,
+		router: router,
+		conns:  map[net.Conn]struct{}{},
+	}
+	s.batcher = receiver.NewBatcher(p, cfg.BatchSize, cfg.Linger, func(_ []*core.Log, res receiver.Result) {
+		if res.Rejected > 0 {
+			s.rejected.Add(uint64(res.Rejected))
+			log.Printf("syslog: %d logs rejected: %v", res.Rejected, res.FirstError())
+		}
+	})
+	return s, nil
+}
+
+// Stats returns the counters of the server.
+func (s *Server) Stats() Stats {
+	return Stats{
+		Received: s.received.Load(),
+		Failed:   s.failed.Load(),
+		Rejected: s.rejected.Load(),
+	}
+}
+
+// ListenAndServe starts the listeners of the configuration and serves
+// them until Close.
+func (s *Server) ListenAndServe() error {
+	if s.cfg.UDPAddr != "" {
+		pc, err := net.ListenPacket("udp", s.cfg.UDPAddr)
+		if err != nil {
+			return err
+		}
+		go s.ServePacket(pc)
+	}
+	if s.cfg.TCPAddr != "" {
+		ln, err := net.Listen("tcp", s.cfg.TCPAddr)
+		if err != nil {
+			return err
+		}
+		go s.Serve(ln)
+	}
+	if s.cfg.TLSAddr != "" {
+		cert, err := tls.LoadX509KeyPair(s.cfg.TLSCertFile, s.cfg.TLSKeyFile)
+		if err != nil {
+			return err
+		}
+		ln, err := tls.Listen("tcp", s.cfg.TLSAddr, &tls.Config{Certificates: []tls.Certificate{cert}})
+		if err != nil {
+			return err
+		}
+		go s.Serve(ln)
+	}
+	return nil
+}
+
+// track registers a listener or connection to be closed by Close. It
+// returns false if the server is already closed.
+func (s *Server) track(c io.Closer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		c.Close()
+		return false
+	}
+	if conn, ok := c.(net.Conn); ok {
+		s.conns[conn] = struct{}{}
+	} else {
+		s.listeners = append(s.listeners, c)
+	}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	s.wg.Done()
+}
+
+// ServePacket reads one message per datagram from pc.
+func (s *Server) ServePacket(pc net.PacketConn) error {
+	if !s.track(pc) {
+		return net.ErrClosed
+	}
+	defer s.wg.Done()
+	buf := make([]byte, s.cfg.MaxMessageSize)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		s.handle(bytes.Clone(buf[:n]), addr)
+	}
+}
+
+// Serve accepts stream connections, TCP or TLS, from ln.
+func (s *Server) Serve(ln net.Listener) error {
+	if !s.track(ln) {
+		return net.ErrClosed
+	}
+	defer s.wg.Done()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		if !s.track(conn) {
+			return nil
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.untrack(conn)
+	defer conn.Close()
+	r := bufio.NewReaderSize(conn, s.cfg.MaxMessageSize+1)
+	for {
+		frame, err := readFrame(r, s.cfg.MaxMessageSize)
+		if len(frame) > 0 {
+			s.handle(frame, conn.RemoteAddr())
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Printf("syslog: closing connection of %v: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+	}
+}
+
+var errFrameTooLarge = errors.New("message too large")
+
+// readFrame reads a message of a stream, framed either with its length
+// ("LEN MSG", octet counting) or with a trailing newline.
+func readFrame(r *bufio.Reader, max int) ([]byte, error) {
+	c, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if c[0] >= '1' && c[0] <= '9' {
+		prefix, err := r.ReadSlice(' ')
+		if err != nil {
+			return nil, fmt.Errorf("invalid frame length: %v", err)
+		}
+		n, err := strconv.Atoi(string(prefix[:len(prefix)-1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid frame length %q", prefix)
+		}
+		if n > max {
+			return nil, errFrameTooLarge
+		}
+		frame := make([]byte, n)
+		if _, err := io.ReadFull(r, frame); err != nil {
+			return nil, err
+		}
+		return frame, nil
+	}
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		// The line is truncated, and the rest of it skipped.
+		frame := bytes.Clone(line[:max])
+		for errors.Is(err, bufio.ErrBufferFull) {
+			_, err = r.ReadSlice('\n')
+		}
+		return frame, err
+	}
+	return bytes.Clone(line), err
+}
+
+// handle converts a message to a log and queues it. The messages that
+// cannot be parsed are sent to the fallback channel as they are.
+func (s *Server) handle(raw []byte, addr net.Addr) {
+	raw = bytes.TrimRight(raw, "\r\n\x00")
+	if len(raw) == 0 {
+		return
+	}
+	s.received.Add(1)
+	remote := addr.String()
+	m, err := Parse(raw, time.Now())
+	if err != nil {
+		s.failed.Add(1)
+		host, _, _ := net.SplitHostPort(remote)
+		s.batcher.Add(&core.Log{
+			Channel:  s.cfg.FallbackChannel,
+			SenderId: host,
+			Level:    logger.WARNING.String(),
+			Message:  string(raw),
+			Data: map[string]interface{}{
+				"parse_error": err.Error(),
+				"remote_addr": remote,
+			},
+		})
+		return
+	}
+	s.batcher.Add(s.convert(m, remote))
+}
+
+// convert turns a message into a log of the channel chosen by the router.
+func (s *Server) convert(m *Message, remote string) *core.Log {
+	data := map[string]interface{}{
+		"format":      m.Format,
+		"facility":    FacilityName(m.Facility),
+		"severity":    SeverityName(m.Severity),
+		"remote_addr": remote,
+	}
+	for k, v := range map[string]string{
+		"hostname": m.Hostname,
+		"app_name": m.AppName,
+		"proc_id":  m.ProcId,
+		"msg_id":   m.MsgId,
+	} {
+		if v != "" {
+			data[k] = v
+		}
+	}
+	if len(m.StructuredData) > 0 {
+		sd := make(map[string]interface{}, len(m.StructuredData))
+		for id, params := range m.StructuredData {
+			p := make(map[string]interface{}, len(params))
+			for k, v := range params {
+				p[k] = v
+			}
+			sd[id] = p
+		}
+		data["structured_data"] = sd
+	}
+	sender := m.Hostname
+	if sender == "" {
+		sender, _, _ = net.SplitHostPort(remote)
+	}
+	l := &core.Log{
+		Channel:  s.router.Channel(m),
+		SenderId: sender,
+		Level:    severityLevel(m.Severity).String(),
+		Message:  m.Message,
+		Data:     data,
+	}
+	if !m.Timestamp.IsZero() {
+		l.Timestamp = m.Timestamp.UnixNano()
+	}
+	return l
+}
+
+// severityLevel maps the syslog severities to the levels, emergencies,
+// alerts and critical conditions being fatal.
+func severityLevel(severity int) logger.Level {
+	switch {
+	case severity <= 2:
+		return logger.FATAL
+	case severity == 3:
+		return logger.ERROR
+	case severity == 4:
+		return logger.WARNING
+	case severity == 7:
+		return logger.DEBUG
+	}
+	return logger.INFO
+}
+
+// Close stops the listeners, closes the connections and submits the
+// queued logs.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	for _, l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	s.batcher.Close()
+	return nil
+}
@@ -0,0 +1,191 @@
+package syslog
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperbolicresearch/hlog/config"
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/internal/receiver/receivertest"
+)
+
+func TestParse(t *testing.T) {
+	now := time.Date(2024, time.January, 2, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		input  string
+		expect *Message
+	}{
+		{
+			name:  "rfc5424",
+			input: `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog 1234 ID47 [exampleSDID@32473 iut="3" eventSource="Appli\"cation"][origin ip="10.0.0.1"] ` + "\xef\xbb\xbf" + `An application event`,
+			expect: &Message{
+				Format: "rfc5424", Facility: 20, Severity: 5,
+				Timestamp: time.Date(2003, time.October, 11, 22, 14, 15, 3000000, time.UTC),
+				Hostname:  "mymachine.example.com", AppName: "evntslog", ProcId: "1234", MsgId: "ID47",
+				StructuredData: map[string]map[string]string{
+					"exampleSDID@32473": {"iut": "3", "eventSource": `Appli"cation`},
+					"origin":            {"ip": "10.0.0.1"},
+				},
+				Message: "An application event",
+			},
+		},
+		{
+			name:  "rfc5424 nil values",
+			input: `<34>1 - - su - - -`,
+			expect: &Message{
+				Format: "rfc5424", Facility: 4, Severity: 2, AppName: "su",
+			},
+		},
+		{
+			name:  "rfc3164",
+			input: `<34>Oct 11 22:14:15 mymachine su[42]: 'su root' failed for lonvick on /dev/pts/8`,
+			expect: &Message{
+				Format: "rfc3164", Facility: 4, Severity: 2,
+				// October would be in the future, so it is last year's.
+				Timestamp: time.Date(2023, time.October, 11, 22, 14, 15, 0, time.UTC),
+				Hostname:  "mymachine", AppName: "su", ProcId: "42",
+				Message: "'su root' failed for lonvick on /dev/pts/8",
+			},
+		},
+		{
+			name:  "rfc3164 without hostname nor timestamp",
+			input: `<13>myapp: hello world`,
+			expect: &Message{
+				Format: "rfc3164", Facility: 1, Severity: 5, AppName: "myapp", Message: "hello world",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := Parse([]byte(tt.input), now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(m, tt.expect) {
+				t.Errorf("Expected=%+v, Got=%+v", tt.expect, m)
+			}
+		})
+	}
+
+	for _, input := range []string{
+		"no priority",
+		"<999>1 - - - - - -",
+		"<-1>1 - - - - - -",
+		"<+13>1 - - - - - -",
+		"<1a>1 - - - - - -",
+		"<>1 - - - - - -",
+		"<13>1 yesterday host app - - -",
+		`<13>1 - host app - - [unterminated`,
+	} {
+		if _, err := Parse([]byte(input), now); err == nil {
+			t.Errorf("Expected an error for %q", input)
+		}
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	input := "5 <13>a11 <13>1 - - -<13>b\n<13>" + strings.Repeat("x", 20) + "\n<13>last"
+	r := bufio.NewReaderSize(strings.NewReader(input), 17)
+	var frames []string
+	for {
+		frame, err := readFrame(r, 16)
+		if len(frame) > 0 {
+			frames = append(frames, strings.TrimRight(string(frame), "\n"))
+		}
+		if err != nil {
+			break
+		}
+	}
+	expect := []string{"<13>a", "<13>1 - - -", "<13>b", "<13>" + strings.Repeat("x", 12), "<13>last"}
+	if !reflect.DeepEqual(frames, expect) {
+		t.Errorf("Expected=%q, Got=%q", expect, frames)
+	}
+}
+
+func TestServer(t *testing.T) {
+	cfg := config.DefaultSyslogConfig
+	cfg.Routes = []config.SyslogRoute{
+		{Field: "app_name", Match: "^nginx$", Channel: "web"},
+		{Field: "facility", Match: "^auth", Channel: "security"},
+	}
+	cfg.Linger = time.Duration(10) * time.Millisecond
+	p := &receivertest.Producer{}
+	s, err := NewServer(p, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.ServePacket(pc)
+	go s.Serve(ln)
+
+	udp, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	udp.Write([]byte("<38>Oct 11 22:14:15 gw sshd[7]: accepted key"))
+	udp.Close()
+
+	tcp, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := "<11>1 2024-01-02T03:04:05Z web-1 nginx - - - upstream timed out"
+	tcp.Write([]byte(strings.Join([]string{
+		strconv.Itoa(len(msg)) + " " + msg,
+		"<14>plain: routed to the default channel\n",
+		"not syslog at all\n",
+	}, "")))
+	tcp.Close()
+
+	deadline := time.Now().Add(time.Duration(2) * time.Second)
+	for s.Stats().Received < 4 && time.Now().Before(deadline) {
+		time.Sleep(time.Duration(10) * time.Millisecond)
+	}
+	s.Close()
+
+	stats := s.Stats()
+	if stats.Received != 4 || stats.Failed != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	channels := map[string]*core.Log{}
+	for _, l := range p.Logs() {
+		channels[l.Channel] = l
+	}
+	if l := channels["security"]; l == nil || l.Level != "info" || l.SenderId != "gw" || l.Data["app_name"] != "sshd" {
+		t.Errorf("Unexpected security log: %+v", l)
+	}
+	if l := channels["web"]; l == nil || l.Level != "error" || l.Message != "upstream timed out" || l.Timestamp != 1704164645e9 {
+		t.Errorf("Unexpected web log: %+v", l)
+	}
+	if l := channels["syslog"]; l == nil || l.Message != "routed to the default channel" {
+		t.Errorf("Unexpected default log: %+v", l)
+	}
+	if l := channels["syslog-unparsed"]; l == nil || l.Message != "not syslog at all" || l.Data["parse_error"] == nil {
+		t.Errorf("Unexpected fallback log: %+v", l)
+	}
+}
+
+func TestNewRouter(t *testing.T) {
+	for _, routes := range [][]config.SyslogRoute{
+		{{Field: "pid", Match: ".", Channel: "a"}},
+		{{Field: "app_name", Match: "(", Channel: "a"}},
+		{{Field: "app_name", Match: ".", Channel: "not a channel"}},
+	} {
+		if _, err := NewRouter(routes, "syslog"); err == nil {
+			t.Errorf("Expected an error for %+v", routes)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package tui
+
+import (
+	"strings"
+	"time"
+
+	"github.com/hyperbolicresearch/hlog/pkg/logger"
+)
+
+var sparkRunes = []rune("▁▂▃▄▅▆▇█")
+
+// levels is the display order of the levels in the rate panel.
+var levels = []logger.Level{
+	logger.DEBUG,
+	logger.INFO,
+	logger.WARNING,
+	logger.ERROR,
+	logger.FATAL,
+}
+
+// rateTracker counts the received logs per level and per second over
+// a sliding window of `size` seconds.
+type rateTracker struct {
+	size    int
+	last    int64
+	buckets map[logger.Level][]int
+}
+
+func newRateTracker(size int) *rateTracker {
+	r := &rateTracker{
+		size:    size,
+		buckets: make(map[logger.Level][]int),
+	}
+	for _, level := range levels {
+		r.buckets[level] = make([]int, size)
+	}
+	return r
+}
+
+// advance moves the window up to now, clearing the seconds that went
+// by without any log.
+func (r *rateTracker) advance(now time.Time) {
+	sec := now.Unix()
+	if r.last == 0 {
+		r.last = sec
+		return
+	}
+	if sec-r.last >= int64(r.size) {
+		for _, b := range r.buckets {
+			for i := range b {
+				b[i] = 0
+			}
+		}
+		r.last = sec
+		return
+	}
+	for r.last < sec {
+		r.last++
+		for _, b := range r.buckets {
+			b[r.last%int64(r.size)] = 0
+		}
+	}
+}
+
+// Add records a log of the given level received at now.
+func (r *rateTracker) Add(level logger.Level, now time.Time) {
+	r.advance(now)
+	b, ok := r.buckets[level]
+	if !ok {
+		return
+	}
+	b[now.Unix()%int64(r.size)]++
+}
+
+// Series returns the counts of the level, from the oldest second to now.
+func (r *rateTracker) Series(level logger.Level, now time.Time) []int {
+	r.advance(now)
+	b := r.buckets[level]
+	series := make([]int, r.size)
+	for i := 0; i < r.size; i++ {
+		series[i] = b[(r.last+1+int64(i))%int64(r.size)]
+	}
+	return series
+}
+
+// sparkline renders values as a line of block characters scaled to the
+// largest value.
+func sparkline(values []int) string {
+	max := 0
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+	var b strings.Builder
+	for _, v := range values {
+		if max == 0 || v == 0 {
+			b.WriteRune(' ')
+			continue
+		}
+		ndx := v * (len(sparkRunes) - 1) / max
+		b.WriteRune(sparkRunes[ndx])
+	}
+	return b.String()
+}
@@ -0,0 +1,217 @@
+package tui
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/pkg/logger"
+)
+
+const (
+	reset     = "\033[0m"
+	bold      = "\033[1m"
+	reverse   = "\033[7m"
+	highlight = "\033[30;43m"
+	clearLine = "\033[K"
+)
+
+var levelColors = map[logger.Level]string{
+	logger.DEBUG:   "\033[90m",
+	logger.INFO:    "\033[36m",
+	logger.WARNING: "\033[33m",
+	logger.ERROR:   "\033[31m",
+	logger.FATAL:   "\033[1;35m",
+}
+
+// lineFormatter renders the logs of the list, with a shorter time since
+// the date is rarely useful when watching the stream.
+var lineFormatter = &logger.PrettyFormatter{
+	Time: logger.TimeFormat{Layout: "15:04:05"},
+}
+
+// formatLine returns the uncolored, single-line representation of a log.
+func formatLine(l *core.Log) string {
+	line, err := lineFormatter.Format(*l)
+	if err != nil {
+		return err.Error()
+	}
+	return strings.TrimSuffix(string(line), "\n")
+}
+
+// render builds the whole screen. The caller must hold the lock.
+func (t *TUI) render(width, height int, now time.Time) string {
+	var b strings.Builder
+	b.WriteString("\033[H")
+
+	lines := []string{t.header(width)}
+	lines = append(lines, t.rateLines(width, now)...)
+
+	// The remaining space is shared between the list of logs, the detail
+	// pane when it is shown and the footer.
+	available := height - len(lines) - 1
+	detail := []string{}
+	if t.showDetail && len(t.view) > 0 {
+		detail = t.detailLines(width, available/2)
+	}
+	rows := available - len(detail)
+	if rows < 1 {
+		rows = 1
+	}
+	lines = append(lines, t.listLines(width, rows)...)
+	lines = append(lines, detail...)
+
+	for _, line := range lines {
+		b.WriteString(line)
+		b.WriteString(reset + clearLine + "\r\n")
+	}
+	b.WriteString(t.footer(width))
+	b.WriteString(reset + clearLine)
+	b.WriteString("\033[J")
+	return b.String()
+}
+
+func (t *TUI) header(width int) string {
+	state := "\033[32mLIVE" + reset
+	if t.paused {
+		state = fmt.Sprintf("\033[33mPAUSED +%d%s", len(t.held), reset)
+		if t.dropped > 0 {
+			state = fmt.Sprintf("\033[33mPAUSED +%d (%d dropped)%s", len(t.held), t.dropped, reset)
+		}
+	}
+	text := fmt.Sprintf(" %d/%d logs", len(t.view), len(t.logs))
+	if f := t.filter.String(); f != "" {
+		text += "  filter: " + f
+	}
+	if t.search != "" {
+		text += "  search: " + t.search
+	}
+	return bold + "hlog livetail " + reset + state + truncate(text, width-22)
+}
+
+func (t *TUI) rateLines(width int, now time.Time) []string {
+	lines := make([]string, 0, len(levels))
+	spark := width - 18
+	if spark > t.rates.size {
+		spark = t.rates.size
+	}
+	if spark < 1 {
+		return lines
+	}
+	for _, level := range levels {
+		series := t.rates.Series(level, now)
+		series = series[len(series)-spark:]
+		lines = append(lines, fmt.Sprintf("%s%-5s%s %s %5d/s",
+			levelColors[level], strings.ToUpper(level.String()), reset,
+			sparkline(series), series[len(series)-1]))
+	}
+	return lines
+}
+
+func (t *TUI) listLines(width, rows int) []string {
+	if t.selected < t.offset {
+		t.offset = t.selected
+	}
+	if t.selected >= t.offset+rows {
+		t.offset = t.selected - rows + 1
+	}
+	if t.offset < 0 {
+		t.offset = 0
+	}
+	lines := make([]string, 0, rows)
+	for i := t.offset; i < len(t.view) && len(lines) < rows; i++ {
+		l := &t.logs[t.view[i]]
+		level, _ := logger.ParseLevel(l.Level)
+		style := levelColors[level]
+		if i == t.selected {
+			style = reverse
+		}
+		text := truncate(formatLine(l), width)
+		lines = append(lines, style+highlightMatches(text, t.search, style))
+	}
+	for len(lines) < rows {
+		lines = append(lines, "")
+	}
+	return lines
+}
+
+func (t *TUI) detailLines(width, rows int) []string {
+	if rows < 2 || t.selected < 0 || t.selected >= len(t.view) {
+		return nil
+	}
+	l := &t.logs[t.view[t.selected]]
+	data, err := json.MarshalIndent(l.Data, "", "  ")
+	if err != nil {
+		data = []byte(err.Error())
+	}
+	lines := []string{bold + truncate(fmt.Sprintf("── %s %s ", l.LogId, strings.Repeat("─", width)), width)}
+	for _, line := range strings.Split(string(data), "\n") {
+		if len(lines) == rows {
+			break
+		}
+		lines = append(lines, highlightMatches(truncate(line, width), t.search, ""))
+	}
+	for len(lines) < rows {
+		lines = append(lines, "")
+	}
+	return lines
+}
+
+func (t *TUI) footer(width int) string {
+	switch t.mode {
+	case modeSearch:
+		return "/" + t.input
+	case modeFilter:
+		prompt := "filter> " + t.input
+		if t.status != "" {
+			prompt += "  \033[31m" + t.status
+		}
+		return truncate(prompt, width)
+	}
+	if t.status != "" {
+		return "\033[31m" + truncate(t.status, width)
+	}
+	help := "q quit  space pause  / search  n/N next/prev  f filter  enter detail  c clear  j/k move  G follow"
+	return "\033[90m" + truncate(help, width)
+}
+
+// truncate cuts s so that it fits in width columns.
+func truncate(s string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	r := []rune(s)
+	if len(r) <= width {
+		return s
+	}
+	return string(r[:width])
+}
+
+// highlightMatches surrounds every case-insensitive occurrence of term
+// in text with the highlight style, restoring style after each of them.
+func highlightMatches(text, term, style string) string {
+	if term == "" {
+		return text
+	}
+	lower := strings.ToLower(text)
+	term = strings.ToLower(term)
+	// Lowering some runes changes their length, in which case we fall back
+	// to a case-sensitive search to keep the offsets valid.
+	if len(lower) != len(text) {
+		lower = text
+	}
+	var b strings.Builder
+	for {
+		ndx := strings.Index(lower, term)
+		if ndx < 0 {
+			b.WriteString(text)
+			return b.String()
+		}
+		end := ndx + len(term)
+		b.WriteString(text[:ndx])
+		b.WriteString(highlight + text[ndx:end] + reset + style)
+		text, lower = text[end:], lower[end:]
+	}
+}
@@ -0,0 +1,433 @@
+package tui
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/internal/filter"
+	"github.com/hyperbolicresearch/hlog/pkg/logger"
+)
+
+type mode int
+
+const (
+	modeNormal mode = iota
+	modeSearch
+	modeFilter
+)
+
+// Options holds the parameters of the terminal UI.
+type Options struct {
+	// Filter is the filter applied when the UI starts.
+	Filter filter.Filter
+	// MaxLogs is the number of logs kept in memory for scrolling back,
+	// and the number of logs held while paused.
+	MaxLogs int
+	// RefreshInterval is the interval between two redraws of the screen.
+	RefreshInterval time.Duration
+	// RateWindow is the number of seconds covered by the rate sparklines.
+	RateWindow int
+}
+
+// DefaultOptions are the options used for the zero values of Options.
+var DefaultOptions = Options{
+	MaxLogs:         10000,
+	RefreshInterval: time.Duration(100) * time.Millisecond,
+	RateWindow:      30,
+}
+
+// TUI is a full-screen, interactive viewer for a stream of logs. It
+// supports pausing, incremental search, live filter editing and shows
+// the data of the selected log and the rate of logs per level.
+type TUI struct {
+	sync.Mutex
+	opts  Options
+	in    *os.File
+	out   *os.File
+	logs  []core.Log
+	view  []int
+	rates *rateTracker
+	// held are the logs received while paused, at most MaxLogs of them;
+	// dropped counts the older ones that did not fit.
+	held    []core.Log
+	dropped int
+
+	filter     filter.Filter
+	prevFilter filter.Filter
+	search     string
+	mode       mode
+	input      string
+	paused     bool
+	follow     bool
+	selected   int
+	offset     int
+	showDetail bool
+	status     string
+	dirty      bool
+	drawnAt    int64
+	quit       bool
+}
+
+// New creates a TUI drawing to out and reading the keyboard from in.
+func New(in, out *os.File, opts Options) *TUI {
+	if opts.MaxLogs <= 0 {
+		opts.MaxLogs = DefaultOptions.MaxLogs
+	}
+	if opts.RefreshInterval <= 0 {
+		opts.RefreshInterval = DefaultOptions.RefreshInterval
+	}
+	if opts.RateWindow <= 0 {
+		opts.RateWindow = DefaultOptions.RateWindow
+	}
+	return &TUI{
+		opts:   opts,
+		in:     in,
+		out:    out,
+		rates:  newRateTracker(opts.RateWindow),
+		filter: opts.Filter,
+		follow: true,
+		dirty:  true,
+	}
+}
+
+// IsTerminal reports whether f is attached to a terminal, that is
+// whether the TUI can be used on it.
+func IsTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// Write makes the TUI usable as the output of the standard logger; the
+// last written line is shown in the status bar instead of corrupting
+// the screen.
+func (t *TUI) Write(p []byte) (int, error) {
+	t.Lock()
+	t.status = strings.TrimSpace(string(p))
+	t.dirty = true
+	t.Unlock()
+	return len(p), nil
+}
+
+// Push adds a newly received log to the UI.
+func (t *TUI) Push(l core.Log) {
+	t.Lock()
+	defer t.Unlock()
+	level, _ := logger.ParseLevel(l.Level)
+	t.rates.Add(level, time.Now())
+	t.dirty = true
+	if t.paused {
+		if len(t.held) == t.opts.MaxLogs {
+			t.held = t.held[1:]
+			t.dropped++
+		}
+		t.held = append(t.held, l)
+		return
+	}
+	t.append(l)
+}
+
+func (t *TUI) append(l core.Log) {
+	t.logs = append(t.logs, l)
+	if t.filter.Match(&l) {
+		t.view = append(t.view, len(t.logs)-1)
+	}
+	// We trim by chunks to avoid copying the buffer on every log.
+	if len(t.logs) > t.opts.MaxLogs+t.opts.MaxLogs/4 {
+		dropped := len(t.logs) - t.opts.MaxLogs
+		t.logs = append([]core.Log(nil), t.logs[dropped:]...)
+		removed := 0
+		for removed < len(t.view) && t.view[removed] < dropped {
+			removed++
+		}
+		view := make([]int, 0, len(t.view)-removed)
+		for _, ndx := range t.view[removed:] {
+			view = append(view, ndx-dropped)
+		}
+		t.view = view
+		t.selected -= removed
+		if t.selected < 0 {
+			t.selected = 0
+		}
+	}
+	if t.follow {
+		t.selected = len(t.view) - 1
+	}
+}
+
+// rebuildView recomputes the indexes of the logs matching the filter.
+func (t *TUI) rebuildView() {
+	t.view = t.view[:0]
+	for i := range t.logs {
+		if t.filter.Match(&t.logs[i]) {
+			t.view = append(t.view, i)
+		}
+	}
+	if t.selected >= len(t.view) {
+		t.selected = len(t.view) - 1
+	}
+}
+
+// Run takes over the terminal and displays the logs received on logs
+// until the user quits or a signal is received on stop. See stopKeys
+// about running it again.
+func (t *TUI) Run(logs <-chan core.Log, stop <-chan os.Signal) error {
+	state, err := term.MakeRaw(int(t.in.Fd()))
+	if err != nil {
+		return fmt.Errorf("failed to switch the terminal to raw mode: %v", err)
+	}
+	defer term.Restore(int(t.in.Fd()), state)
+
+	// alternate screen and hidden cursor
+	fmt.Fprint(t.out, "\033[?1049h\033[?25l")
+	defer fmt.Fprint(t.out, "\033[?25h\033[?1049l")
+
+	keys := make(chan []byte)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t.readKeys(keys, done)
+	}()
+	defer t.stopKeys(done, stopped)
+
+	ticker := time.NewTicker(t.opts.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case l, ok := <-logs:
+			if !ok {
+				logs = nil
+				continue
+			}
+			t.Push(l)
+		case key := <-keys:
+			t.Lock()
+			t.handleKey(key)
+			quit := t.quit
+			t.Unlock()
+			if quit {
+				return nil
+			}
+			t.draw()
+		case <-ticker.C:
+			t.draw()
+		}
+	}
+}
+
+// readKeys sends the keys read from t.in to keys until done is closed.
+func (t *TUI) readKeys(keys chan<- []byte, done <-chan struct{}) {
+	buf := make([]byte, 64)
+	for {
+		n, err := t.in.Read(buf)
+		if err != nil {
+			return
+		}
+		key := make([]byte, n)
+		copy(key, buf[:n])
+		select {
+		case keys <- key:
+		case <-done:
+			return
+		}
+	}
+}
+
+// stopKeys stops readKeys once Run returns. A read in progress is
+// interrupted with a deadline when t.in supports them, as pipes and the
+// files opened in non-blocking mode do. Otherwise, as with a blocking
+// stdin, the read only ends with the next key, which is then lost, so
+// Run is meant to be called once per process.
+func (t *TUI) stopKeys(done, stopped chan struct{}) {
+	close(done)
+	if err := t.in.SetReadDeadline(time.Now()); err != nil {
+		return
+	}
+	<-stopped
+	t.in.SetReadDeadline(time.Time{})
+}
+
+func (t *TUI) draw() {
+	t.Lock()
+	defer t.Unlock()
+	width, height, err := term.GetSize(int(t.out.Fd()))
+	if err != nil {
+		width, height = 80, 24
+	}
+	// The sparklines move with time, so we redraw at least once a second
+	// even if nothing happened.
+	now := time.Now()
+	if !t.dirty && now.Unix() == t.drawnAt {
+		return
+	}
+	t.dirty = false
+	t.drawnAt = now.Unix()
+	fmt.Fprint(t.out, t.render(width, height, now))
+}
+
+// handleKey updates the state of the UI according to a key press. The
+// caller must hold the lock.
+func (t *TUI) handleKey(key []byte) {
+	t.dirty = true
+	switch t.mode {
+	case modeSearch, modeFilter:
+		t.handlePromptKey(key)
+		return
+	}
+	switch string(key) {
+	case "q", "\x03":
+		t.quit = true
+	case " ", "p":
+		t.togglePause()
+	case "/":
+		t.mode = modeSearch
+		t.input = t.search
+	case "f":
+		t.mode = modeFilter
+		t.prevFilter = t.filter
+		t.input = t.filter.String()
+	case "n":
+		t.jumpToMatch(1)
+	case "N":
+		t.jumpToMatch(-1)
+	case "\r", "d":
+		t.showDetail = !t.showDetail
+	case "c":
+		t.logs, t.held, t.view = nil, nil, nil
+		t.dropped = 0
+		t.selected, t.offset, t.follow = 0, 0, true
+	case "k", "\033[A":
+		t.move(-1)
+	case "j", "\033[B":
+		t.move(1)
+	case "\033[5~":
+		t.move(-10)
+	case "\033[6~":
+		t.move(10)
+	case "g", "\033[H":
+		t.move(-len(t.view))
+	case "G", "\033[F":
+		t.move(len(t.view))
+	case "\033":
+		t.search = ""
+		t.status = ""
+	}
+}
+
+func (t *TUI) handlePromptKey(key []byte) {
+	switch k := string(key); k {
+	case "\r":
+		if t.mode == modeFilter {
+			t.applyFilter(t.input)
+		}
+		t.mode = modeNormal
+	case "\033", "\x03":
+		if t.mode == modeSearch {
+			t.search = ""
+		} else {
+			t.filter = t.prevFilter
+			t.rebuildView()
+			t.status = ""
+		}
+		t.mode = modeNormal
+	case "\x7f", "\b":
+		if r := []rune(t.input); len(r) > 0 {
+			t.input = string(r[:len(r)-1])
+		}
+		t.updateFromInput()
+	default:
+		if strings.HasPrefix(k, "\033") {
+			return
+		}
+		for _, r := range k {
+			if r >= ' ' {
+				t.input += string(r)
+			}
+		}
+		t.updateFromInput()
+	}
+}
+
+// updateFromInput applies the prompt as it is being typed: the search
+// highlights matches right away and the filter is applied as soon as it
+// can be parsed.
+func (t *TUI) updateFromInput() {
+	switch t.mode {
+	case modeSearch:
+		t.search = t.input
+		t.jumpToMatch(0)
+	case modeFilter:
+		t.applyFilter(t.input)
+	}
+}
+
+func (t *TUI) applyFilter(expr string) {
+	f, err := filter.Parse(expr)
+	if err != nil {
+		t.status = err.Error()
+		return
+	}
+	t.status = ""
+	t.filter = f
+	t.rebuildView()
+	if t.follow || t.selected < 0 {
+		t.selected = len(t.view) - 1
+	}
+}
+
+func (t *TUI) togglePause() {
+	t.paused = !t.paused
+	if t.paused {
+		return
+	}
+	held := t.held
+	t.held, t.dropped = nil, 0
+	for _, l := range held {
+		t.append(l)
+	}
+}
+
+func (t *TUI) move(delta int) {
+	if len(t.view) == 0 {
+		return
+	}
+	t.selected += delta
+	if t.selected < 0 {
+		t.selected = 0
+	}
+	if t.selected >= len(t.view)-1 {
+		t.selected = len(t.view) - 1
+		t.follow = true
+	} else {
+		t.follow = false
+	}
+}
+
+// jumpToMatch moves the selection to the next (dir > 0) or previous
+// (dir < 0) log matching the search, starting from the selected log
+// itself when dir is 0.
+func (t *TUI) jumpToMatch(dir int) {
+	if t.search == "" || len(t.view) == 0 {
+		return
+	}
+	step := dir
+	if step == 0 {
+		step = -1
+	}
+	start := t.selected + dir
+	for i := start; i >= 0 && i < len(t.view); i += step {
+		if strings.Contains(strings.ToLower(formatLine(&t.logs[t.view[i]])), strings.ToLower(t.search)) {
+			t.selected = i
+			t.follow = i == len(t.view)-1
+			return
+		}
+	}
+	t.status = fmt.Sprintf("no match for %q", t.search)
+}
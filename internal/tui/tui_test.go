@@ -0,0 +1,139 @@
+package tui
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/pkg/logger"
+)
+
+func TestSparkline(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  []int
+		expect string
+	}{
+		{"Empty", []int{0, 0, 0}, "   "},
+		{"Scaled", []int{0, 1, 2, 4}, " ▂▄█"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sparkline(tt.input); got != tt.expect {
+				t.Errorf("Expected=%q, Got=%q", tt.expect, got)
+			}
+		})
+	}
+}
+
+func TestRateTracker(t *testing.T) {
+	r := newRateTracker(3)
+	now := time.Unix(1000, 0)
+	r.Add(logger.ERROR, now)
+	r.Add(logger.ERROR, now)
+	r.Add(logger.ERROR, now.Add(time.Second))
+	got := r.Series(logger.ERROR, now.Add(time.Second))
+	if got[1] != 2 || got[2] != 1 {
+		t.Errorf("Expected=[0 2 1], Got=%v", got)
+	}
+	// Everything falls out of the window after a long pause.
+	got = r.Series(logger.ERROR, now.Add(time.Minute))
+	if got[0]+got[1]+got[2] != 0 {
+		t.Errorf("Expected=[0 0 0], Got=%v", got)
+	}
+}
+
+func TestHighlightMatches(t *testing.T) {
+	got := highlightMatches("Foo bar foo", "foo", reverse)
+	expect := highlight + "Foo" + reset + reverse + " bar " + highlight + "foo" + reset + reverse
+	if got != expect {
+		t.Errorf("Expected=%q, Got=%q", expect, got)
+	}
+}
+
+func TestPauseHeldLimit(t *testing.T) {
+	ui := New(nil, nil, Options{MaxLogs: 2})
+	ui.handleKey([]byte(" "))
+	for i := 1; i <= 5; i++ {
+		ui.Push(core.Log{Level: "info", Message: fmt.Sprint(i)})
+	}
+	if len(ui.held) != 2 || ui.held[0].Message != "4" || ui.dropped != 3 {
+		t.Errorf("Expected=[4 5] held and 3 dropped, Got=%v held and %v dropped", ui.held, ui.dropped)
+	}
+	if header := ui.header(80); !strings.Contains(header, "PAUSED +2 (3 dropped)") {
+		t.Errorf("Expected=PAUSED +2 (3 dropped), Got=%q", header)
+	}
+	ui.handleKey([]byte(" "))
+	if len(ui.logs) != 2 || ui.logs[0].Message != "4" || ui.dropped != 0 {
+		t.Errorf("Expected=[4 5] shown and 0 dropped, Got=%v shown and %v dropped", ui.logs, ui.dropped)
+	}
+}
+
+func TestPauseAndFilter(t *testing.T) {
+	ui := New(nil, nil, Options{})
+	ui.Push(core.Log{Level: "info", Channel: "a"})
+	ui.handleKey([]byte(" "))
+	ui.Push(core.Log{Level: "error", Channel: "b"})
+	if len(ui.logs) != 1 || len(ui.held) != 1 {
+		t.Errorf("Expected=1 shown and 1 held, Got=%v shown and %v held", len(ui.logs), len(ui.held))
+	}
+	ui.handleKey([]byte(" "))
+	if len(ui.logs) != 2 || len(ui.held) != 0 {
+		t.Errorf("Expected=2 shown and 0 held, Got=%v shown and %v held", len(ui.logs), len(ui.held))
+	}
+
+	// The filter is applied while it is typed.
+	ui.handleKey([]byte("f"))
+	for _, k := range "level:error" {
+		ui.handleKey([]byte(string(k)))
+	}
+	if len(ui.view) != 1 {
+		t.Errorf("Expected=1, Got=%v", len(ui.view))
+	}
+	// Escape restores the previous filter.
+	ui.handleKey([]byte("\033"))
+	if len(ui.view) != 2 {
+		t.Errorf("Expected=2, Got=%v", len(ui.view))
+	}
+}
+
+func TestStopKeys(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	tui := New(r, w, Options{})
+	keys := make(chan []byte)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		tui.readKeys(keys, done)
+	}()
+	w.Write([]byte("q"))
+	if key := <-keys; string(key) != "q" {
+		t.Errorf("Expected=%q, Got=%q", "q", key)
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		tui.stopKeys(done, stopped)
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the key reader to stop")
+	}
+	// The keys typed afterwards are left to the next reader.
+	w.Write([]byte("x"))
+	buf := make([]byte, 1)
+	if n, err := r.Read(buf); err != nil || string(buf[:n]) != "x" {
+		t.Errorf("Expected=%q, Got=%q (%v)", "x", buf[:n], err)
+	}
+}
@@ -0,0 +1,267 @@
+// Package hlog is the Go client of hlog. It builds the log envelopes
+// (id, sender id, timestamp, level) and delivers them asynchronously to
+// the channel they belong to.
+//
+//	client, err := hlog.New(hlog.Config{
+//		SenderId:    "billing-1",
+//		Channel:     "billing",
+//		KafkaServer: "localhost:65007",
+//	})
+//	defer client.Close()
+//	client.Info("invoice sent", map[string]interface{}{"invoice": 42})
+//	client.With(map[string]interface{}{"tenant": "acme"}).Warn("retrying", nil)
+package hlog
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/hyperbolicresearch/hlog/pkg/logger"
+)
+
+// Log is the envelope of a log, as sent to hlog. Timestamp is in Unix
+// nanoseconds.
+type Log struct {
+	Channel   string                 `json:"channel"`
+	LogId     string                 `json:"log_id"`
+	SenderId  string                 `json:"sender_id"`
+	Timestamp int64                  `json:"timestamp"`
+	Level     string                 `json:"level"`
+	Message   string                 `json:"message"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// Transport delivers the logs to hlog. Send must not block on the
+// network: the delivery happens in the background and its outcome is
+// reported through done.
+type Transport interface {
+	Send(l *Log, done func(error)) error
+	// Flush waits for the pending logs to be delivered, for at most
+	// timeout, and returns the number of logs still pending.
+	Flush(timeout time.Duration) int
+	Close() error
+}
+
+// Delivery is the outcome of the delivery of a log.
+type Delivery struct {
+	LogId   string
+	Channel string
+	// Err is nil if the log was delivered.
+	Err error
+}
+
+// Config holds the configuration of a Client.
+type Config struct {
+	// SenderId identifies the process producing the logs.
+	SenderId string
+	// Channel is the channel the logs are sent to.
+	Channel string
+	// Level is the minimum level of the logs to send. Defaults to DEBUG.
+	Level logger.Level
+	// KafkaServer is the address of the Kafka broker. It is used when no
+	// Transport nor IngestURL is given.
+	KafkaServer string
+	// IngestURL is the address of an HTTP ingestion endpoint, such as
+	// http://localhost:8080/v1/logs. When set, the logs are sent to it
+	// instead of Kafka.
+	IngestURL string
+	// BatchSize is the maximum number of logs sent in one request, and
+	// Linger how long logs wait for a batch to fill up.
+	BatchSize int
+	Linger    time.Duration
+	// Compression is the codec of the batches: none, gzip, snappy, lz4
+	// or zstd.
+	Compression string
+	// FlushTimeout bounds how long Close waits for the pending logs.
+	FlushTimeout time.Duration
+	// OnDelivery is called with the outcome of every log, from a
+	// background goroutine.
+	OnDelivery func(Delivery)
+	// Transport overrides the default Kafka transport.
+	Transport Transport
+}
+
+// DefaultConfig holds the values used for the zero fields of Config.
+var DefaultConfig = Config{
+	Level:        logger.DEBUG,
+	BatchSize:    1000,
+	Linger:       time.Duration(50) * time.Millisecond,
+	Compression:  "zstd",
+	FlushTimeout: time.Duration(10) * time.Second,
+}
+
+// ErrClosed is returned when logging through a closed client.
+var ErrClosed = errors.New("hlog: client closed")
+
+// Client sends logs to hlog. It is safe for concurrent use.
+type Client struct {
+	*shared
+	fields map[string]interface{}
+}
+
+// shared is the state common to a client and its children.
+type shared struct {
+	sync.RWMutex
+	cfg       Config
+	transport Transport
+	closed    bool
+	delivered atomic.Uint64
+	failed    atomic.Uint64
+}
+
+// New creates a Client. Without a Transport in the config, the logs are
+// sent to IngestURL if set, or produced to Kafka otherwise.
+func New(cfg Config) (*Client, error) {
+	if cfg.Channel == "" {
+		return nil, errors.New("hlog: a channel is required")
+	}
+	if cfg.Level == 0 {
+		cfg.Level = DefaultConfig.Level
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultConfig.BatchSize
+	}
+	if cfg.Linger <= 0 {
+		cfg.Linger = DefaultConfig.Linger
+	}
+	if cfg.Compression == "" {
+		cfg.Compression = DefaultConfig.Compression
+	}
+	if cfg.FlushTimeout <= 0 {
+		cfg.FlushTimeout = DefaultConfig.FlushTimeout
+	}
+	transport := cfg.Transport
+	switch {
+	case transport != nil:
+	case cfg.IngestURL != "":
+		transport = NewHTTPTransport(cfg)
+	default:
+		kt, err := NewKafkaTransport(cfg)
+		if err != nil {
+			return nil, err
+		}
+		transport = kt
+	}
+	return &Client{
+		shared: &shared{
+			cfg:       cfg,
+			transport: transport,
+		},
+	}, nil
+}
+
+// With returns a child client adding fields to the data of every log.
+// The fields of the log itself take precedence over the ones of the
+// client. Closing a child closes its parent.
+func (c *Client) With(fields map[string]interface{}) *Client {
+	merged := make(map[string]interface{}, len(c.fields)+len(fields))
+	for k, v := range c.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &Client{shared: c.shared, fields: merged}
+}
+
+// Log sends a log of the given level. It returns an error if the log
+// could not be queued; the delivery itself is reported to OnDelivery.
+func (c *Client) Log(level logger.Level, message string, data map[string]interface{}) error {
+	if level < c.cfg.Level {
+		return nil
+	}
+	return c.send(c.envelope(level, message, data))
+}
+
+// send hands an envelope over to the transport.
+func (c *Client) send(l *Log) error {
+	c.RLock()
+	defer c.RUnlock()
+	if c.closed {
+		return ErrClosed
+	}
+	return c.transport.Send(l, func(err error) {
+		if err != nil {
+			c.failed.Add(1)
+		} else {
+			c.delivered.Add(1)
+		}
+		if c.cfg.OnDelivery != nil {
+			c.cfg.OnDelivery(Delivery{LogId: l.LogId, Channel: l.Channel, Err: err})
+		}
+	})
+}
+
+func (c *Client) envelope(level logger.Level, message string, data map[string]interface{}) *Log {
+	merged := make(map[string]interface{}, len(c.fields)+len(data))
+	for k, v := range c.fields {
+		merged[k] = v
+	}
+	for k, v := range data {
+		merged[k] = v
+	}
+	return &Log{
+		Channel:   c.cfg.Channel,
+		LogId:     uuid.New().String(),
+		SenderId:  c.cfg.SenderId,
+		Timestamp: time.Now().UnixNano(),
+		Level:     level.String(),
+		Message:   message,
+		Data:      merged,
+	}
+}
+
+func (c *Client) Debug(message string, data map[string]interface{}) error {
+	return c.Log(logger.DEBUG, message, data)
+}
+
+func (c *Client) Info(message string, data map[string]interface{}) error {
+	return c.Log(logger.INFO, message, data)
+}
+
+func (c *Client) Warn(message string, data map[string]interface{}) error {
+	return c.Log(logger.WARNING, message, data)
+}
+
+func (c *Client) Error(message string, data map[string]interface{}) error {
+	return c.Log(logger.ERROR, message, data)
+}
+
+func (c *Client) Fatal(message string, data map[string]interface{}) error {
+	return c.Log(logger.FATAL, message, data)
+}
+
+// Stats returns the number of logs delivered and failed so far.
+func (c *Client) Stats() (delivered, failed uint64) {
+	return c.delivered.Load(), c.failed.Load()
+}
+
+// Flush waits for the pending logs to be delivered, for at most timeout.
+// It returns the number of logs still pending.
+func (c *Client) Flush(timeout time.Duration) int {
+	return c.transport.Flush(timeout)
+}
+
+// Close flushes the pending logs and releases the transport. It returns
+// an error if some logs could not be delivered within FlushTimeout.
+func (c *Client) Close() error {
+	c.Lock()
+	if c.closed {
+		c.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.Unlock()
+
+	pending := c.transport.Flush(c.cfg.FlushTimeout)
+	err := c.transport.Close()
+	if pending > 0 {
+		return fmt.Errorf("hlog: %d logs not delivered before closing", pending)
+	}
+	return err
+}
@@ -0,0 +1,103 @@
+package hlog
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hyperbolicresearch/hlog/pkg/logger"
+)
+
+// fakeTransport records the logs and delivers them on Flush.
+type fakeTransport struct {
+	sync.Mutex
+	sent    []*Log
+	pending []func(error)
+	fail    bool
+	closed  bool
+}
+
+func (t *fakeTransport) Send(l *Log, done func(error)) error {
+	t.Lock()
+	defer t.Unlock()
+	t.sent = append(t.sent, l)
+	t.pending = append(t.pending, done)
+	return nil
+}
+
+func (t *fakeTransport) Flush(timeout time.Duration) int {
+	t.Lock()
+	defer t.Unlock()
+	for _, done := range t.pending {
+		if t.fail {
+			done(errors.New("broker down"))
+		} else {
+			done(nil)
+		}
+	}
+	t.pending = nil
+	return 0
+}
+
+func (t *fakeTransport) Close() error {
+	t.closed = true
+	return nil
+}
+
+func TestClient(t *testing.T) {
+	transport := &fakeTransport{}
+	var deliveries []Delivery
+	client, err := New(Config{
+		SenderId:   "sender-1",
+		Channel:    "billing",
+		Level:      logger.INFO,
+		Transport:  transport,
+		OnDelivery: func(d Delivery) { deliveries = append(deliveries, d) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	child := client.With(map[string]interface{}{"tenant": "acme", "region": "eu"})
+	client.Debug("filtered out", nil)
+	child.Warn("retrying", map[string]interface{}{"region": "us", "attempt": 2})
+
+	if len(transport.sent) != 1 {
+		t.Fatalf("Expected=1, Got=%v", len(transport.sent))
+	}
+	l := transport.sent[0]
+	if l.Channel != "billing" || l.SenderId != "sender-1" || l.Level != "warn" || l.Message != "retrying" {
+		t.Errorf("Unexpected envelope: %+v", l)
+	}
+	if l.LogId == "" || l.Timestamp == 0 {
+		t.Errorf("Expected a log id and a timestamp, Got=%+v", l)
+	}
+	expected := map[string]interface{}{"tenant": "acme", "region": "us", "attempt": 2}
+	if !reflect.DeepEqual(l.Data, expected) {
+		t.Errorf("Expected=%v, Got=%v", expected, l.Data)
+	}
+
+	if err := client.Close(); err != nil {
+		t.Error(err)
+	}
+	if !transport.closed {
+		t.Error("Expected the transport to be closed")
+	}
+	if len(deliveries) != 1 || deliveries[0].LogId != l.LogId || deliveries[0].Err != nil {
+		t.Errorf("Unexpected deliveries: %+v", deliveries)
+	}
+	if delivered, failed := client.Stats(); delivered != 1 || failed != 0 {
+		t.Errorf("Expected=1 delivered 0 failed, Got=%v delivered %v failed", delivered, failed)
+	}
+	if err := child.Info("too late", nil); err != ErrClosed {
+		t.Errorf("Expected=%v, Got=%v", ErrClosed, err)
+	}
+}
+
+func TestClientRequiresChannel(t *testing.T) {
+	if _, err := New(Config{Transport: &fakeTransport{}}); err == nil {
+		t.Error("Expected an error without a channel")
+	}
+}
@@ -0,0 +1,275 @@
+package hlog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ErrQueueFull is returned by Send when the transport cannot buffer
+// more logs.
+var ErrQueueFull = errors.New("hlog: send queue full")
+
+const maxAttempts = 3
+
+// IngestResult is the outcome of one log in the response of the HTTP
+// ingestion endpoint.
+type IngestResult struct {
+	LogId    string `json:"log_id,omitempty"`
+	Accepted bool   `json:"accepted"`
+	Error    string `json:"error,omitempty"`
+}
+
+// IngestResponse is the response of the HTTP ingestion endpoint. The
+// results are in the order of the submitted logs.
+type IngestResponse struct {
+	Accepted int            `json:"accepted"`
+	Rejected int            `json:"rejected"`
+	Results  []IngestResult `json:"results"`
+}
+
+type pendingLog struct {
+	log  *Log
+	done func(error)
+}
+
+// HTTPTransport sends the logs in batches, as JSON arrays, to an HTTP
+// ingestion endpoint.
+type HTTPTransport struct {
+	sync.RWMutex
+	url         string
+	compression string
+	batchSize   int
+	linger      time.Duration
+	client      *http.Client
+	queue       chan pendingLog
+	flushes     chan chan struct{}
+	pending     atomic.Int64
+	closed      bool
+	done        chan struct{}
+}
+
+// NewHTTPTransport creates an HTTPTransport from the HTTP related fields
+// of cfg. Only gzip and zstd are supported for compressing the requests,
+// the other codecs fall back to gzip.
+func NewHTTPTransport(cfg Config) *HTTPTransport {
+	compression := cfg.Compression
+	switch compression {
+	case "none", "gzip", "zstd":
+	default:
+		compression = "gzip"
+	}
+	t := &HTTPTransport{
+		url:         cfg.IngestURL,
+		compression: compression,
+		batchSize:   cfg.BatchSize,
+		linger:      cfg.Linger,
+		client:      &http.Client{Timeout: time.Duration(30) * time.Second},
+		queue:       make(chan pendingLog, cfg.BatchSize*10),
+		flushes:     make(chan chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+func (t *HTTPTransport) Send(l *Log, done func(error)) error {
+	t.RLock()
+	defer t.RUnlock()
+	if t.closed {
+		return ErrClosed
+	}
+	t.pending.Add(1)
+	select {
+	case t.queue <- pendingLog{l, done}:
+		return nil
+	default:
+		t.pending.Add(-1)
+		return ErrQueueFull
+	}
+}
+
+func (t *HTTPTransport) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(t.linger)
+	defer ticker.Stop()
+	batch := make([]pendingLog, 0, t.batchSize)
+	send := func() {
+		if len(batch) > 0 {
+			t.post(batch)
+			batch = batch[:0]
+		}
+	}
+	for {
+		select {
+		case p, ok := <-t.queue:
+			if !ok {
+				send()
+				return
+			}
+			batch = append(batch, p)
+			if len(batch) >= t.batchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case ack := <-t.flushes:
+			// We take what is already queued before sending.
+			for len(t.queue) > 0 && len(batch) < t.batchSize {
+				batch = append(batch, <-t.queue)
+			}
+			send()
+			close(ack)
+		}
+	}
+}
+
+// post sends a batch, retrying on network and server errors, and
+// reports the outcome of every log of the batch.
+func (t *HTTPTransport) post(batch []pendingLog) {
+	defer t.pending.Add(-int64(len(batch)))
+	logs := make([]*Log, len(batch))
+	for i, p := range batch {
+		logs[i] = p.log
+	}
+	body, err := t.encode(logs)
+	if err != nil {
+		for _, p := range batch {
+			p.done(err)
+		}
+		return
+	}
+
+	var resp IngestResponse
+	backoff := time.Duration(100) * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		resp, err = t.do(body)
+		if err == nil || attempt == maxAttempts {
+			break
+		}
+		var se *statusError
+		if errors.As(err, &se) && !se.retryable() {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	for i, p := range batch {
+		switch {
+		case err != nil:
+			p.done(err)
+		case i < len(resp.Results) && !resp.Results[i].Accepted:
+			p.done(fmt.Errorf("hlog: log rejected: %s", resp.Results[i].Error))
+		default:
+			p.done(nil)
+		}
+	}
+}
+
+type statusError struct {
+	code int
+	body string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("hlog: ingestion failed with status %d: %s", e.code, e.body)
+}
+
+func (e *statusError) retryable() bool {
+	return e.code >= 500 || e.code == http.StatusTooManyRequests
+}
+
+func (t *HTTPTransport) do(body []byte) (IngestResponse, error) {
+	var resp IngestResponse
+	req, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return resp, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if t.compression != "none" {
+		req.Header.Set("Content-Encoding", t.compression)
+	}
+	res, err := t.client.Do(req)
+	if err != nil {
+		return resp, err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(res.Body, 16<<20))
+	if err != nil {
+		return resp, err
+	}
+	// A 207 carries per-item results, some of which being rejections.
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusAccepted && res.StatusCode != http.StatusMultiStatus {
+		return resp, &statusError{res.StatusCode, string(data)}
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &resp); err != nil {
+			return resp, fmt.Errorf("hlog: invalid ingestion response: %v", err)
+		}
+	}
+	return resp, nil
+}
+
+func (t *HTTPTransport) encode(logs []*Log) ([]byte, error) {
+	data, err := json.Marshal(logs)
+	if err != nil {
+		return nil, err
+	}
+	switch t.compression {
+	case "gzip":
+		var b bytes.Buffer
+		zw := gzip.NewWriter(&b)
+		if _, err := zw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	case "zstd":
+		enc, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer enc.Close()
+		return enc.EncodeAll(data, nil), nil
+	}
+	return data, nil
+}
+
+func (t *HTTPTransport) Flush(timeout time.Duration) int {
+	deadline := time.Now().Add(timeout)
+	for t.pending.Load() > 0 && time.Now().Before(deadline) {
+		ack := make(chan struct{})
+		select {
+		case t.flushes <- ack:
+			<-ack
+		case <-t.done:
+			return int(t.pending.Load())
+		case <-time.After(time.Until(deadline)):
+		}
+	}
+	return int(t.pending.Load())
+}
+
+func (t *HTTPTransport) Close() error {
+	t.Lock()
+	if t.closed {
+		t.Unlock()
+		return nil
+	}
+	t.closed = true
+	close(t.queue)
+	t.Unlock()
+	<-t.done
+	return nil
+}
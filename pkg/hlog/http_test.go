@@ -0,0 +1,82 @@
+package hlog
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/hyperbolicresearch/hlog/internal/core"
+)
+
+func TestHTTPTransport(t *testing.T) {
+	for _, compression := range []string{"none", "gzip", "zstd"} {
+		t.Run(compression, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var body io.Reader = r.Body
+				switch r.Header.Get("Content-Encoding") {
+				case "gzip":
+					body, _ = gzip.NewReader(r.Body)
+				case "zstd":
+					zr, _ := zstd.NewReader(r.Body)
+					defer zr.Close()
+					body = zr
+				}
+				var logs []core.Log
+				if err := json.NewDecoder(body).Decode(&logs); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				// Every log but the ones without message is accepted.
+				resp := IngestResponse{}
+				for _, l := range logs {
+					if l.Message == "" {
+						resp.Rejected++
+						resp.Results = append(resp.Results, IngestResult{LogId: l.LogId, Error: "missing message"})
+						continue
+					}
+					resp.Accepted++
+					resp.Results = append(resp.Results, IngestResult{LogId: l.LogId, Accepted: true})
+				}
+				w.WriteHeader(http.StatusMultiStatus)
+				json.NewEncoder(w).Encode(resp)
+			}))
+			defer srv.Close()
+
+			var mu sync.Mutex
+			var failed []string
+			client, err := New(Config{
+				Channel:     "billing",
+				IngestURL:   srv.URL,
+				Compression: compression,
+				Linger:      time.Hour,
+				OnDelivery: func(d Delivery) {
+					if d.Err != nil {
+						mu.Lock()
+						failed = append(failed, d.LogId)
+						mu.Unlock()
+					}
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client.Info("first", nil)
+			client.Info("", nil)
+			client.Info("third", nil)
+			if err := client.Close(); err != nil {
+				t.Fatal(err)
+			}
+			delivered, rejected := client.Stats()
+			if delivered != 2 || rejected != 1 || len(failed) != 1 {
+				t.Errorf("Expected=2 delivered 1 rejected, Got=%v delivered %v rejected", delivered, rejected)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package hlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// KafkaTransport produces the logs to the Kafka topic of their channel.
+// Batching and compression are done by the producer.
+type KafkaTransport struct {
+	producer *kafka.Producer
+	done     chan struct{}
+}
+
+// NewKafkaTransport creates a KafkaTransport from the Kafka related
+// fields of cfg.
+func NewKafkaTransport(cfg Config) (*KafkaTransport, error) {
+	if cfg.KafkaServer == "" {
+		return nil, fmt.Errorf("hlog: a kafka server is required")
+	}
+	kcfg := kafka.ConfigMap{
+		"bootstrap.servers":  cfg.KafkaServer,
+		"client.id":          cfg.SenderId,
+		"compression.type":   cfg.Compression,
+		"linger.ms":          int(cfg.Linger.Milliseconds()),
+		"batch.num.messages": cfg.BatchSize,
+	}
+	producer, err := kafka.NewProducer(&kcfg)
+	if err != nil {
+		return nil, fmt.Errorf("hlog: failed to create producer: %v", err)
+	}
+	t := &KafkaTransport{
+		producer: producer,
+		done:     make(chan struct{}),
+	}
+	go t.deliveries()
+	return t, nil
+}
+
+// deliveries reports the outcome of the produced messages to the
+// callbacks stored in their Opaque field.
+func (t *KafkaTransport) deliveries() {
+	defer close(t.done)
+	for e := range t.producer.Events() {
+		msg, ok := e.(*kafka.Message)
+		if !ok {
+			continue
+		}
+		if done, ok := msg.Opaque.(func(error)); ok {
+			done(msg.TopicPartition.Error)
+		}
+	}
+}
+
+func (t *KafkaTransport) Send(l *Log, done func(error)) error {
+	value, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	topic := l.Channel
+	return t.producer.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{
+			Topic:     &topic,
+			Partition: kafka.PartitionAny,
+		},
+		Key:    []byte(l.SenderId),
+		Value:  value,
+		Opaque: done,
+	}, nil)
+}
+
+func (t *KafkaTransport) Flush(timeout time.Duration) int {
+	return t.producer.Flush(int(timeout.Milliseconds()))
+}
+
+func (t *KafkaTransport) Close() error {
+	t.producer.Close()
+	<-t.done
+	return nil
+}
@@ -0,0 +1,226 @@
+package hlog
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"time"
+
+	"github.com/hyperbolicresearch/hlog/pkg/logger"
+)
+
+// HandlerOptions configures a Handler.
+type HandlerOptions struct {
+	// Channel and SenderId identify where the logs go and who sends them.
+	Channel  string
+	SenderId string
+	// Level is the minimum slog level handled. Defaults to slog.LevelInfo.
+	Level slog.Leveler
+	// AddSource adds the file, line and function of the call site to the
+	// data of the logs, under "source".
+	AddSource bool
+	// KafkaServer or IngestURL decide where the logs are delivered, see
+	// Config. They are ignored when Client is set.
+	KafkaServer string
+	IngestURL   string
+	// Client is an existing client to send the logs through. Its channel
+	// and sender id take precedence over the ones of the options.
+	Client *Client
+}
+
+// Handler is a slog.Handler sending the records to hlog: the level of
+// the record becomes the level of the log, the message its message and
+// the attributes its data, groups becoming nested objects.
+//
+//	h, err := hlog.NewHandler(hlog.HandlerOptions{
+//		Channel:   "billing",
+//		SenderId:  "billing-1",
+//		IngestURL: "http://localhost:8080/v1/logs",
+//	})
+//	slog.SetDefault(slog.New(h))
+type Handler struct {
+	client *Client
+	level  slog.Leveler
+	source bool
+	// data holds the attributes added with WithAttrs, and groups the
+	// path of the groups opened with WithGroup.
+	data   map[string]interface{}
+	groups []string
+}
+
+// NewHandler creates a Handler.
+func NewHandler(opts HandlerOptions) (*Handler, error) {
+	client := opts.Client
+	if client == nil {
+		var err error
+		client, err = New(Config{
+			Channel:     opts.Channel,
+			SenderId:    opts.SenderId,
+			KafkaServer: opts.KafkaServer,
+			IngestURL:   opts.IngestURL,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	level := opts.Level
+	if level == nil {
+		level = slog.LevelInfo
+	}
+	return &Handler{
+		client: client,
+		level:  level,
+		source: opts.AddSource,
+		data:   map[string]interface{}{},
+	}, nil
+}
+
+// Client returns the client the handler sends the logs through, for
+// flushing or closing it.
+func (h *Handler) Client() *Client {
+	return h.client
+}
+
+func (h *Handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *Handler) Handle(_ context.Context, r slog.Record) error {
+	data := cloneData(h.data)
+	// The attributes of the record go in the innermost group, which is
+	// only created if there is at least one of them.
+	if r.NumAttrs() > 0 {
+		target := groupMap(data, h.groups)
+		r.Attrs(func(a slog.Attr) bool {
+			addAttr(target, a)
+			return true
+		})
+	}
+	if h.source && r.PC != 0 {
+		frames := runtime.CallersFrames([]uintptr{r.PC})
+		frame, _ := frames.Next()
+		data[slog.SourceKey] = map[string]interface{}{
+			"function": frame.Function,
+			"file":     frame.File,
+			"line":     frame.Line,
+		}
+	}
+
+	l := h.client.envelope(levelFromSlog(r.Level), r.Message, data)
+	if !r.Time.IsZero() {
+		l.Timestamp = r.Time.UnixNano()
+	}
+	return h.client.send(l)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	h2 := *h
+	h2.data = cloneData(h.data)
+	target := groupMap(h2.data, h.groups)
+	for _, a := range attrs {
+		addAttr(target, a)
+	}
+	return &h2
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.groups = append(h.groups[:len(h.groups):len(h.groups)], name)
+	return &h2
+}
+
+// levelFromSlog maps the slog levels to the hlog ones. Anything above
+// slog.LevelError is considered fatal.
+func levelFromSlog(level slog.Level) logger.Level {
+	switch {
+	case level > slog.LevelError:
+		return logger.FATAL
+	case level >= slog.LevelError:
+		return logger.ERROR
+	case level >= slog.LevelWarn:
+		return logger.WARNING
+	case level >= slog.LevelInfo:
+		return logger.INFO
+	default:
+		return logger.DEBUG
+	}
+}
+
+// addAttr adds a resolved attribute to data, following the slog rules:
+// empty attributes are ignored, groups become nested objects and groups
+// without key are inlined.
+func addAttr(data map[string]interface{}, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		attrs := a.Value.Group()
+		if len(attrs) == 0 {
+			return
+		}
+		target := data
+		if a.Key != "" {
+			child, ok := data[a.Key].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+				data[a.Key] = child
+			}
+			target = child
+		}
+		for _, ga := range attrs {
+			addAttr(target, ga)
+		}
+		return
+	}
+	data[a.Key] = attrValue(a.Value)
+}
+
+func attrValue(v slog.Value) interface{} {
+	switch v.Kind() {
+	case slog.KindTime:
+		return v.Time().Format(time.RFC3339Nano)
+	case slog.KindDuration:
+		return v.Duration().String()
+	case slog.KindAny:
+		if err, ok := v.Any().(error); ok {
+			return err.Error()
+		}
+	}
+	return v.Any()
+}
+
+// groupMap returns the object of data at the path of groups, creating
+// the missing ones.
+func groupMap(data map[string]interface{}, groups []string) map[string]interface{} {
+	for _, g := range groups {
+		child, ok := data[g].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			data[g] = child
+		}
+		data = child
+	}
+	return data
+}
+
+// cloneData deeply copies the nested objects of data, which are the only
+// values mutated when adding attributes.
+func cloneData(data map[string]interface{}) map[string]interface{} {
+	clone := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		if child, ok := v.(map[string]interface{}); ok {
+			v = cloneData(child)
+		}
+		clone[k] = v
+	}
+	return clone
+}
+
+var _ slog.Handler = (*Handler)(nil)
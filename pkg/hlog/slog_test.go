@@ -0,0 +1,83 @@
+package hlog
+
+import (
+	"errors"
+	"log/slog"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHandler(t *testing.T) {
+	transport := &fakeTransport{}
+	client, err := New(Config{SenderId: "sender-1", Channel: "billing", Transport: transport})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewHandler(HandlerOptions{Client: client, Level: slog.LevelDebug})
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := slog.New(h).With("service", "api").WithGroup("req").With("method", "GET")
+
+	log.Info("handled", "status", 200, slog.Group("user", "id", 42), "err", errors.New("boom"))
+	log.WithGroup("empty").Debug("no attrs")
+	log.Log(nil, slog.LevelError+4, "down", "took", time.Second)
+
+	tests := []struct {
+		level   string
+		message string
+		data    map[string]interface{}
+	}{
+		{
+			"info", "handled",
+			map[string]interface{}{
+				"service": "api",
+				"req": map[string]interface{}{
+					"method": "GET",
+					"status": int64(200),
+					"user":   map[string]interface{}{"id": int64(42)},
+					"err":    "boom",
+				},
+			},
+		},
+		{
+			"debug", "no attrs",
+			map[string]interface{}{
+				"service": "api",
+				"req":     map[string]interface{}{"method": "GET"},
+			},
+		},
+		{
+			"fatal", "down",
+			map[string]interface{}{
+				"service": "api",
+				"req":     map[string]interface{}{"method": "GET", "took": "1s"},
+			},
+		},
+	}
+	if len(transport.sent) != len(tests) {
+		t.Fatalf("Expected=%v, Got=%v", len(tests), len(transport.sent))
+	}
+	for i, tt := range tests {
+		t.Run(tt.message, func(t *testing.T) {
+			l := transport.sent[i]
+			if l.Level != tt.level || l.Message != tt.message || l.Channel != "billing" {
+				t.Errorf("Unexpected envelope: %+v", l)
+			}
+			if !reflect.DeepEqual(l.Data, tt.data) {
+				t.Errorf("Expected=%v, Got=%v", tt.data, l.Data)
+			}
+		})
+	}
+}
+
+func TestHandlerEnabled(t *testing.T) {
+	h, err := NewHandler(HandlerOptions{Client: &Client{}, Level: slog.LevelWarn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Enabled(nil, slog.LevelInfo) || !h.Enabled(nil, slog.LevelError) {
+		t.Error("Expected only warnings and above to be enabled")
+	}
+}
@@ -0,0 +1,245 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"golang.org/x/term"
+
+	"github.com/hyperbolicresearch/hlog/internal/core"
+)
+
+// Formatter turns a log into the bytes written to a writer.
+type Formatter interface {
+	Format(l core.Log) ([]byte, error)
+}
+
+// TimeFormat describes how the timestamps of the logs are rendered.
+type TimeFormat struct {
+	// Location is the timezone of the rendered time. Defaults to the
+	// local timezone.
+	Location *time.Location
+	// Layout is a time.Format layout. Defaults to DefaultTimeLayout with
+	// as many fractional digits as required by Precision.
+	Layout string
+	// Precision truncates the time before it is rendered. Defaults to
+	// a second.
+	Precision time.Duration
+}
+
+// DefaultTimeLayout is the layout of the times when none is given.
+const DefaultTimeLayout = "2006-01-02 15:04:05"
+
+// Format renders the timestamp of a log, whatever its unit.
+func (tf TimeFormat) Format(timestamp int64) string {
+	return tf.FormatTime(core.Time(timestamp))
+}
+
+// FormatTime renders t according to the time format.
+func (tf TimeFormat) FormatTime(t time.Time) string {
+	precision := tf.Precision
+	if precision <= 0 {
+		precision = time.Second
+	}
+	if tf.Location != nil {
+		t = t.In(tf.Location)
+	}
+	t = t.Truncate(precision)
+	layout := tf.Layout
+	if layout == "" {
+		layout = DefaultTimeLayout
+		switch {
+		case precision < time.Microsecond:
+			layout += ".000000000"
+		case precision < time.Millisecond:
+			layout += ".000000"
+		case precision < time.Second:
+			layout += ".000"
+		}
+	}
+	return t.Format(layout)
+}
+
+// IsTerminal reports whether w is a terminal, which is what decides
+// whether the default formatter uses colors.
+func IsTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	return ok && term.IsTerminal(int(f.Fd()))
+}
+
+// DefaultFormatter returns the formatter used for a writer registered
+// without one: the pretty format, colored if w is a terminal.
+func DefaultFormatter(w io.Writer) Formatter {
+	return &PrettyFormatter{Color: IsTerminal(w)}
+}
+
+// PrettyFormatter is the human readable format of the logs:
+//
+//	[channel | sender_id] 2006-01-02 15:04:05 LEVEL message {"data":"..."}
+type PrettyFormatter struct {
+	Time  TimeFormat
+	Color bool
+}
+
+func (f *PrettyFormatter) Format(l core.Log) ([]byte, error) {
+	jsonData, err := json.Marshal(l.Data)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	b.WriteString("[")
+	b.WriteString(l.Channel)
+	b.WriteString(" | ")
+	b.WriteString(l.SenderId)
+	b.WriteString("] ")
+	b.WriteString(f.Time.Format(l.Timestamp))
+	b.WriteString(" ")
+	b.WriteString(strings.ToUpper(l.Level))
+	b.WriteString(" ")
+	if f.Color {
+		b.WriteString(GRAY)
+	}
+	b.WriteString(l.Message)
+	b.WriteString(" ")
+	b.Write(jsonData)
+	if f.Color {
+		b.WriteString(RESET)
+	}
+	b.WriteString("\n")
+	return b.Bytes(), nil
+}
+
+// JSONFormatter writes the logs as JSON documents, one per line. When
+// Time is set, the rendered timestamp is added as a "time" field.
+type JSONFormatter struct {
+	Time *TimeFormat
+}
+
+func (f *JSONFormatter) Format(l core.Log) ([]byte, error) {
+	var v interface{} = l
+	if f.Time != nil {
+		v = struct {
+			core.Log
+			Time string `json:"time"`
+		}{l, f.Time.Format(l.Timestamp)}
+	}
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append(js, '\n'), nil
+}
+
+// LogfmtFormatter writes the logs as logfmt lines, the fields of Data
+// being flattened with dotted keys:
+//
+//	time="2006-01-02 15:04:05" level=info channel=c sender_id=s log_id=i msg=hello data.user.id=42
+type LogfmtFormatter struct {
+	Time TimeFormat
+}
+
+func (f *LogfmtFormatter) Format(l core.Log) ([]byte, error) {
+	var b bytes.Buffer
+	writeLogfmt(&b, "time", f.Time.Format(l.Timestamp))
+	writeLogfmt(&b, "level", l.Level)
+	writeLogfmt(&b, "channel", l.Channel)
+	writeLogfmt(&b, "sender_id", l.SenderId)
+	writeLogfmt(&b, "log_id", l.LogId)
+	writeLogfmt(&b, "msg", l.Message)
+	fields := map[string]interface{}{}
+	flatten("data", l.Data, fields)
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		var value string
+		switch v := fields[k].(type) {
+		case string:
+			value = v
+		case nil:
+			value = ""
+		default:
+			js, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			value = string(js)
+		}
+		writeLogfmt(&b, k, value)
+	}
+	b.WriteString("\n")
+	return b.Bytes(), nil
+}
+
+func writeLogfmt(b *bytes.Buffer, key, value string) {
+	if b.Len() > 0 {
+		b.WriteByte(' ')
+	}
+	b.WriteString(key)
+	b.WriteByte('=')
+	if value == "" || strings.ContainsAny(value, " =\"\t\n\r\\") {
+		b.WriteString(strconv.Quote(value))
+		return
+	}
+	b.WriteString(value)
+}
+
+func flatten(prefix string, data map[string]interface{}, out map[string]interface{}) {
+	for k, v := range data {
+		key := prefix + "." + k
+		if child, ok := v.(map[string]interface{}); ok {
+			flatten(key, child, out)
+			continue
+		}
+		out[key] = v
+	}
+}
+
+// TemplateFormatter renders the logs with a user provided text/template.
+// The template is executed with the core.Log and can use the following
+// functions: time (renders a timestamp with the TimeFormat), json,
+// upper and lower. A newline is added if the output lacks one.
+//
+//	{{time .Timestamp}} {{upper .Level}} {{.Message}} {{json .Data}}
+type TemplateFormatter struct {
+	tmpl *template.Template
+}
+
+// NewTemplateFormatter parses text into a TemplateFormatter.
+func NewTemplateFormatter(text string, tf TimeFormat) (*TemplateFormatter, error) {
+	funcs := template.FuncMap{
+		"time":  tf.Format,
+		"upper": strings.ToUpper,
+		"lower": strings.ToLower,
+		"json": func(v interface{}) (string, error) {
+			js, err := json.Marshal(v)
+			return string(js), err
+		},
+	}
+	tmpl, err := template.New("log").Funcs(funcs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log template: %v", err)
+	}
+	return &TemplateFormatter{tmpl: tmpl}, nil
+}
+
+func (f *TemplateFormatter) Format(l core.Log) ([]byte, error) {
+	var b bytes.Buffer
+	if err := f.tmpl.Execute(&b, l); err != nil {
+		return nil, err
+	}
+	if !bytes.HasSuffix(b.Bytes(), []byte("\n")) {
+		b.WriteString("\n")
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,96 @@
+package logger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperbolicresearch/hlog/internal/core"
+)
+
+func TestFormatters(t *testing.T) {
+	log := core.Log{
+		Channel:   "channel",
+		LogId:     "0000",
+		SenderId:  "sender_id",
+		Timestamp: 1618304400,
+		Level:     "info",
+		Message:   "hello world",
+		Data: map[string]interface{}{
+			"foo":  "bar",
+			"user": map[string]interface{}{"id": 42},
+		},
+	}
+	utc := TimeFormat{Location: time.UTC}
+	tmpl, err := NewTemplateFormatter(`{{time .Timestamp}} {{upper .Level}} {{.Message}} {{json .Data.foo}}`, utc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		formatter Formatter
+		expected  string
+	}{
+		{
+			"Pretty without color",
+			&PrettyFormatter{Time: utc},
+			`[channel | sender_id] 2021-04-13 09:00:00 INFO hello world {"foo":"bar","user":{"id":42}}` + "\n",
+		},
+		{
+			"JSON",
+			&JSONFormatter{},
+			`{"channel":"channel","log_id":"0000","sender_id":"sender_id","timestamp":1618304400,"level":"info","message":"hello world","data":{"foo":"bar","user":{"id":42}}}` + "\n",
+		},
+		{
+			"JSON with time",
+			&JSONFormatter{Time: &TimeFormat{Location: time.UTC, Layout: time.RFC3339}},
+			`{"channel":"channel","log_id":"0000","sender_id":"sender_id","timestamp":1618304400,"level":"info","message":"hello world","data":{"foo":"bar","user":{"id":42}},"time":"2021-04-13T09:00:00Z"}` + "\n",
+		},
+		{
+			"Logfmt",
+			&LogfmtFormatter{Time: utc},
+			`time="2021-04-13 09:00:00" level=info channel=channel sender_id=sender_id log_id=0000 msg="hello world" data.foo=bar data.user.id=42` + "\n",
+		},
+		{
+			"Template",
+			tmpl,
+			`2021-04-13 09:00:00 INFO hello world "bar"` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := tt.formatter.Format(log)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(output) != tt.expected {
+				t.Errorf("Expected=%s, Got=%s", tt.expected, output)
+			}
+		})
+	}
+}
+
+func TestTimeFormat(t *testing.T) {
+	ts := time.Date(2021, 4, 13, 9, 0, 0, 123456789, time.UTC)
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skip("no timezone database")
+	}
+	tests := []struct {
+		name     string
+		format   TimeFormat
+		expected string
+	}{
+		{"Default", TimeFormat{Location: time.UTC}, "2021-04-13 09:00:00"},
+		{"Milliseconds", TimeFormat{Location: time.UTC, Precision: time.Millisecond}, "2021-04-13 09:00:00.123"},
+		{"Nanoseconds", TimeFormat{Location: time.UTC, Precision: time.Nanosecond}, "2021-04-13 09:00:00.123456789"},
+		{"Timezone", TimeFormat{Location: paris, Layout: "15:04 MST"}, "11:00 CEST"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.format.FormatTime(ts); got != tt.expected {
+				t.Errorf("Expected=%v, Got=%v", tt.expected, got)
+			}
+		})
+	}
+}
@@ -1,13 +1,11 @@
 package logger
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"os"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/hyperbolicresearch/hlog/internal/core"
 )
@@ -32,6 +30,28 @@ var levelCorrespondence = map[string]Level{
 	"fatal": FATAL,
 }
 
+// ParseLevel returns the Level matching the given name. The lookup is
+// case-insensitive and also accepts "warning" as an alias of "warn",
+// since clients are not consistent about it.
+func ParseLevel(name string) (Level, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "warning" {
+		name = "warn"
+	}
+	level, ok := levelCorrespondence[name]
+	return level, ok
+}
+
+// String returns the canonical lowercase name of the level.
+func (l Level) String() string {
+	for name, level := range levelCorrespondence {
+		if level == l {
+			return name
+		}
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
 const (
 	// Color codes for pretty logging
 	RESET string = "\033[0m"
@@ -46,111 +66,140 @@ type LoggerI interface {
 type Logger struct {
 	sync.RWMutex
 	Level   Level
-	Writers []io.Writer
+	Writers []*Writer
 }
 
-func New(defaultLevel Level, writer io.Writer) *Logger {
-	return &Logger{
-		Level:   defaultLevel,
-		Writers: []io.Writer{writer},
-	}
+// New creates a logger writing to writer, configured by opts.
+func New(defaultLevel Level, writer io.Writer, opts ...WriterOption) *Logger {
+	l := &Logger{Level: defaultLevel}
+	l.AddWriter(writer, opts...)
+	return l
+}
+
+// NewWithFormatter creates a logger writing to writer with formatter.
+func NewWithFormatter(defaultLevel Level, writer io.Writer, formatter Formatter) *Logger {
+	return New(defaultLevel, writer, WithFormatter(formatter))
 }
 
-// AddWriter adds a new writer to the logger
-func (l *Logger) AddWriter(w io.Writer) error {
+// AddWriter adds a new writer to the logger, configured by opts. Adding
+// a writer twice does nothing.
+func (l *Logger) AddWriter(w io.Writer, opts ...WriterOption) error {
 	l.Lock()
+	defer l.Unlock()
 	for _, v := range l.Writers {
-		if v == w {
-			l.Unlock()
+		if v.Writer == w {
 			return nil
 		}
 	}
-	l.Writers = append(l.Writers, w)
+	writer := newWriter(w, opts...)
+	writer.start(l)
+	l.Writers = append(l.Writers, writer)
+	return nil
+}
+
+// SetFormatter changes the formatter of a writer of the logger.
+func (l *Logger) SetFormatter(w io.Writer, f Formatter) error {
+	l.RLock()
+	defer l.RUnlock()
+	for _, v := range l.Writers {
+		if v.Writer == w {
+			v.setFormatter(f)
+			return nil
+		}
+	}
+	return fmt.Errorf("writer %v is not registered", w)
+}
+
+// RemoveWriter removes a writer from the logger. The logs buffered by
+// an asynchronous writer are written before it returns.
+func (l *Logger) RemoveWriter(w io.Writer) error {
+	l.Lock()
+	var removed *Writer
+	for i, v := range l.Writers {
+		if v.Writer == w {
+			removed = v
+			l.Writers = append(l.Writers[:i:i], l.Writers[i+1:]...)
+			break
+		}
+	}
 	l.Unlock()
+	if removed != nil {
+		removed.stop()
+	}
 	return nil
 }
 
-// RemoveWriter removes a writer from the logger
-func (l *Logger) RemoveWriter(w io.Writer) error { 
+// detach removes a writer that failed too many times. It may be called
+// from the goroutine of an asynchronous writer, so it does not wait.
+func (l *Logger) detach(w *Writer) {
 	l.Lock()
 	for i, v := range l.Writers {
 		if v == w {
-			l.Writers = append(l.Writers[:i], l.Writers[i+1:]...)
+			l.Writers = append(l.Writers[:i:i], l.Writers[i+1:]...)
+			break
 		}
 	}
 	l.Unlock()
-	return nil 
+	go w.stop()
+}
+
+// Close removes every writer, waiting for the asynchronous ones to
+// write what they buffered.
+func (l *Logger) Close() error {
+	l.Lock()
+	writers := l.Writers
+	l.Writers = nil
+	l.Unlock()
+	for _, w := range writers {
+		w.stop()
+	}
+	return nil
 }
 
 // Log will take a Log and write it in a readable/formatted manner
-// to the passed io.Writer
+// to the registered writers, each one with its own formatter and level.
+// The writers are independent: every one of them is given the log even
+// if others fail, and the returned error joins their errors.
 func (l *Logger) Log(data interface{}) error {
-	l.Lock()
-	defer l.Unlock()
+	// The lock is only held to take a snapshot of the writers, the
+	// writes themselves happen without it.
+	l.RLock()
+	writers := append([]*Writer(nil), l.Writers...)
+	minLevel := l.Level
+	l.RUnlock()
+
+	var errs []error
 	switch data := data.(type) {
 	case []byte:
-		data = append(data, '\n')
-		for _, l := range l.Writers {
-			_, err := l.Write(data)
-			if err != nil {
-				return err
-			}
+		p := make([]byte, 0, len(data)+1)
+		p = append(append(p, data...), '\n')
+		for _, w := range writers {
+			errs = append(errs, w.send(l, p))
 		}
-		return nil
 	case string:
-		for _, l := range l.Writers {
-			_, err := l.Write([]byte(data + "\n"))
-			if err != nil {
-				return err
-			}
+		p := []byte(data + "\n")
+		for _, w := range writers {
+			errs = append(errs, w.send(l, p))
 		}
-		return nil
 	case core.Log:
-		if levelCorrespondence[data.Level] < l.Level {
-			return nil
-		}
-		unix := time.Unix(data.Timestamp, 0)
-		readableTime := unix.Format("2006-01-02 15:04:05")
-		jsonData, err := json.Marshal(data.Data)
-		if err != nil {
-			return err
-		}
-		// Format the log and write it
-		str := "["
-		str += data.Channel
-		str += " | "
-		str += data.SenderId
-		str += "]"
-		str += " "
-		str += readableTime
-		str += " "
-		str += strings.ToUpper(data.Level)
-		str += " "
-		str += GRAY
-		str += data.Message
-		str += " "
-		str += string(jsonData)
-		str += RESET
-		str += "\n"
-
-		for _, l := range l.Writers {
-			if _, ok := l.(*os.File); ok {
-				_, err = l.Write([]byte(str))
-				if err != nil {
-					return err
-				}
-				continue
+		level, _ := ParseLevel(data.Level)
+		for _, w := range writers {
+			min := w.Level
+			if min == 0 {
+				min = minLevel
 			}
-			js, err := json.Marshal(data)
-			if err != nil {
-				return err
+			if level < min {
+				continue
 			}
-			_, err = l.Write([]byte(js))
+			p, err := w.formatter().Format(data)
 			if err != nil {
-				return err
+				errs = append(errs, err)
+				continue
 			}
+			errs = append(errs, w.send(l, p))
 		}
-		return nil
+	default:
+		return fmt.Errorf("unsupported log format: %T", data)
 	}
-	return fmt.Errorf("unsupported log format: %T", data)
+	return errors.Join(errs...)
 }
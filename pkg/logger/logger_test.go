@@ -3,11 +3,16 @@ package logger
 import (
 	"bytes"
 	"testing"
+	"time"
 
 	"github.com/hyperbolicresearch/hlog/internal/core"
 )
 
 func TestLogger(t *testing.T) {
+	// The times are rendered in the local time zone by default.
+	local := time.Local
+	time.Local = time.FixedZone("UTC+3", 3*60*60)
+	defer func() { time.Local = local }()
 	var buff bytes.Buffer
 	logger := New(DEBUG, &buff)
 	// string
@@ -38,7 +43,8 @@ func TestLogger(t *testing.T) {
 		{name: "Logging bytes", expected: "foo\n", input: []byte("foo")},
 		{
 			name:     "Logging core.Log",
-			expected: "[channel | sender_id] 2021-04-13 12:00:00 DEBUG " + "\033[37mthis is a test message " + `{"foo":"bar"}` + "\033[0m\n",
+			// Buffers are not terminals, so there is no color.
+			expected: "[channel | sender_id] 2021-04-13 12:00:00 DEBUG " + "this is a test message " + `{"foo":"bar"}` + "\n",
 			input:    log,
 		},
 	}
@@ -54,3 +60,43 @@ func TestLogger(t *testing.T) {
 		})
 	}
 }
+
+func TestPrettyFormatter(t *testing.T) {
+	var buff bytes.Buffer
+	logger := NewWithFormatter(DEBUG, &buff, &PrettyFormatter{
+		Time:  TimeFormat{Location: time.UTC},
+		Color: true,
+	})
+	logger.Log(core.Log{
+		Channel:   "channel",
+		SenderId:  "sender_id",
+		Timestamp: 1618304400,
+		Level:     "debug",
+		Message:   "this is a test message",
+		Data:      map[string]interface{}{"foo": "bar"},
+	})
+	expected := "[channel | sender_id] 2021-04-13 09:00:00 DEBUG " + "\033[37mthis is a test message " + `{"foo":"bar"}` + "\033[0m\n"
+	if got := buff.String(); got != expected {
+		t.Errorf("Expected=%s, Got=%s", expected, got)
+	}
+}
+
+func TestDefaultFormatter(t *testing.T) {
+	var buff bytes.Buffer
+	logger := New(DEBUG, &buff)
+	logger.Log(core.Log{Level: "info", Message: "hello", Timestamp: 1618304400})
+	// Buffers are not terminals, so there should not be any color.
+	if bytes.Contains(buff.Bytes(), []byte("\033")) {
+		t.Errorf("Expected no color, Got=%q", buff.String())
+	}
+}
+
+func TestLevelThreshold(t *testing.T) {
+	var buff bytes.Buffer
+	logger := New(WARNING, &buff)
+	logger.Log(core.Log{Level: "INFO", Message: "hidden"})
+	logger.Log(core.Log{Level: "ERROR", Message: "shown"})
+	if !bytes.Contains(buff.Bytes(), []byte("shown")) || bytes.Contains(buff.Bytes(), []byte("hidden")) {
+		t.Errorf("Expected only the error, Got=%q", buff.String())
+	}
+}
@@ -0,0 +1,237 @@
+package logger
+
+import (
+	"io"
+	"sync"
+)
+
+// OverflowPolicy decides what an asynchronous writer does with a new
+// log when its buffer is full.
+type OverflowPolicy int
+
+const (
+	// Block makes the caller wait until there is room in the buffer.
+	Block OverflowPolicy = iota
+	// DropNewest discards the log being written.
+	DropNewest
+	// DropOldest discards the oldest buffered log to make room.
+	DropOldest
+)
+
+// Writer is a destination of the logger along with the options it was
+// registered with.
+type Writer struct {
+	io.Writer
+	// Formatter formats the logs written to the writer.
+	Formatter Formatter
+	// Level is the minimum level of the logs written to the writer. The
+	// zero value falls back to the level of the logger.
+	Level Level
+	// MaxErrors is the number of consecutive failed writes after which
+	// the writer is removed from the logger. Zero never removes it.
+	MaxErrors int
+	// OnError is called with every failed write.
+	OnError func(w io.Writer, err error)
+
+	// mu protects the state below, writeMu serializes the writes and
+	// queueMu is held for reading while sending to the queue and for
+	// writing while closing it.
+	mu       sync.Mutex
+	writeMu  sync.Mutex
+	queueMu  sync.RWMutex
+	errors   int
+	dropped  uint64
+	removed  bool
+	closed   bool
+	queue    chan []byte
+	overflow OverflowPolicy
+	done     chan struct{}
+}
+
+// WriterOption configures a writer when it is added to the logger.
+type WriterOption func(w *Writer)
+
+// WithFormatter sets the formatter of the writer.
+func WithFormatter(f Formatter) WriterOption {
+	return func(w *Writer) {
+		w.Formatter = f
+	}
+}
+
+// WithLevel sets the minimum level of the logs written to the writer.
+func WithLevel(level Level) WriterOption {
+	return func(w *Writer) {
+		w.Level = level
+	}
+}
+
+// WithAsync makes the writes happen in the background, through a buffer
+// of size logs, so that a slow writer does not slow down the callers.
+// policy decides what happens when the buffer is full.
+func WithAsync(size int, policy OverflowPolicy) WriterOption {
+	return func(w *Writer) {
+		w.queue = make(chan []byte, size)
+		w.overflow = policy
+	}
+}
+
+// WithErrorHandler sets the function called with every failed write.
+func WithErrorHandler(fn func(w io.Writer, err error)) WriterOption {
+	return func(w *Writer) {
+		w.OnError = fn
+	}
+}
+
+// WithMaxErrors removes the writer from the logger after n consecutive
+// failed writes.
+func WithMaxErrors(n int) WriterOption {
+	return func(w *Writer) {
+		w.MaxErrors = n
+	}
+}
+
+func newWriter(w io.Writer, opts ...WriterOption) *Writer {
+	writer := &Writer{Writer: w}
+	for _, opt := range opts {
+		opt(writer)
+	}
+	if writer.Formatter == nil {
+		writer.Formatter = DefaultFormatter(w)
+	}
+	return writer
+}
+
+// Dropped returns the number of logs discarded because the buffer of
+// an asynchronous writer was full.
+func (w *Writer) Dropped() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.dropped
+}
+
+func (w *Writer) formatter() Formatter {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.Formatter == nil {
+		return DefaultFormatter(w.Writer)
+	}
+	return w.Formatter
+}
+
+func (w *Writer) setFormatter(f Formatter) {
+	w.mu.Lock()
+	w.Formatter = f
+	w.mu.Unlock()
+}
+
+func (w *Writer) isRemoved() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.removed
+}
+
+// start spins up the background writing of an asynchronous writer.
+func (w *Writer) start(l *Logger) {
+	if w.queue == nil {
+		return
+	}
+	w.done = make(chan struct{})
+	go func() {
+		defer close(w.done)
+		for p := range w.queue {
+			// A writer removed because of its errors only drains its
+			// buffer, so that blocked callers are released.
+			if w.isRemoved() {
+				continue
+			}
+			w.write(l, p)
+		}
+	}()
+}
+
+// stop closes the buffer of an asynchronous writer and waits for the
+// buffered logs to be written.
+func (w *Writer) stop() {
+	if w.queue == nil {
+		return
+	}
+	w.queueMu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.queueMu.Unlock()
+	<-w.done
+}
+
+// send writes p, or buffers it for asynchronous writers. The returned
+// error is the one of the synchronous write.
+func (w *Writer) send(l *Logger, p []byte) error {
+	if w.queue == nil {
+		return w.write(l, p)
+	}
+	w.queueMu.RLock()
+	defer w.queueMu.RUnlock()
+	if w.closed {
+		return nil
+	}
+	switch w.overflow {
+	case DropNewest:
+		select {
+		case w.queue <- p:
+		default:
+			w.drop()
+		}
+	case DropOldest:
+		for {
+			select {
+			case w.queue <- p:
+				return nil
+			default:
+			}
+			select {
+			case <-w.queue:
+				w.drop()
+			default:
+			}
+		}
+	default:
+		w.queue <- p
+	}
+	return nil
+}
+
+func (w *Writer) drop() {
+	w.mu.Lock()
+	w.dropped++
+	w.mu.Unlock()
+}
+
+// write performs the actual write and keeps track of the failures.
+func (w *Writer) write(l *Logger, p []byte) error {
+	w.writeMu.Lock()
+	_, err := w.Writer.Write(p)
+	w.writeMu.Unlock()
+
+	w.mu.Lock()
+	if err == nil {
+		w.errors = 0
+		w.mu.Unlock()
+		return nil
+	}
+	w.errors++
+	remove := w.MaxErrors > 0 && w.errors >= w.MaxErrors && !w.removed
+	if remove {
+		w.removed = true
+	}
+	onError := w.OnError
+	w.mu.Unlock()
+
+	if onError != nil {
+		onError(w.Writer, err)
+	}
+	if remove {
+		l.detach(w)
+	}
+	return err
+}
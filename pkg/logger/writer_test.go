@@ -0,0 +1,125 @@
+package logger
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hyperbolicresearch/hlog/internal/core"
+)
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+// slowWriter blocks every write until it is released.
+type slowWriter struct {
+	sync.Mutex
+	bytes.Buffer
+	release chan struct{}
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	<-w.release
+	w.Lock()
+	defer w.Unlock()
+	return w.Buffer.Write(p)
+}
+
+func TestWriterLevels(t *testing.T) {
+	var all, errs bytes.Buffer
+	logger := New(DEBUG, &all, WithFormatter(&JSONFormatter{}))
+	logger.AddWriter(&errs, WithLevel(ERROR), WithFormatter(&JSONFormatter{}))
+	logger.Log(core.Log{Level: "info"})
+	logger.Log(core.Log{Level: "error"})
+	if got := bytes.Count(all.Bytes(), []byte("\n")); got != 2 {
+		t.Errorf("Expected=2, Got=%v", got)
+	}
+	if got := bytes.Count(errs.Bytes(), []byte("\n")); got != 1 {
+		t.Errorf("Expected=1, Got=%v", got)
+	}
+}
+
+func TestFailingWriter(t *testing.T) {
+	var buff bytes.Buffer
+	var reported int
+	logger := New(DEBUG, failingWriter{},
+		WithMaxErrors(2),
+		WithErrorHandler(func(w io.Writer, err error) { reported++ }),
+	)
+	logger.AddWriter(&buff)
+
+	for i := 0; i < 3; i++ {
+		logger.Log("foo")
+	}
+	// The failing writer does not starve the next one.
+	if buff.String() != "foo\nfoo\nfoo\n" {
+		t.Errorf("Expected=%q, Got=%q", "foo\nfoo\nfoo\n", buff.String())
+	}
+	// And it is removed after two errors.
+	if reported != 2 {
+		t.Errorf("Expected=2, Got=%v", reported)
+	}
+	logger.RLock()
+	count := len(logger.Writers)
+	logger.RUnlock()
+	if count != 1 {
+		t.Errorf("Expected=1, Got=%v", count)
+	}
+}
+
+func TestAsyncWriter(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   OverflowPolicy
+		expected string
+		dropped  uint64
+	}{
+		{"Drop newest", DropNewest, "0\n1\n", 2},
+		{"Drop oldest", DropOldest, "0\n3\n", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &slowWriter{release: make(chan struct{})}
+			logger := &Logger{Level: DEBUG}
+			logger.AddWriter(w, WithAsync(1, tt.policy))
+			writer := logger.Writers[0]
+
+			// The first log is taken by the background goroutine and
+			// stays blocked in Write, the buffer holds a second one.
+			logger.Log("0")
+			waitFor(t, func() bool { return len(writer.queue) == 0 })
+			start := time.Now()
+			for _, s := range []string{"1", "2", "3"} {
+				logger.Log(s)
+			}
+			if time.Since(start) > time.Second {
+				t.Error("Expected the callers not to be blocked")
+			}
+			close(w.release)
+			logger.Close()
+			if w.String() != tt.expected {
+				t.Errorf("Expected=%q, Got=%q", tt.expected, w.String())
+			}
+			if writer.Dropped() != tt.dropped {
+				t.Errorf("Expected=%v, Got=%v", tt.dropped, writer.Dropped())
+			}
+		})
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before the deadline")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -1,33 +1,74 @@
 package utils
 
 import (
-	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
+	"syscall"
 	"time"
 
 	"github.com/hyperbolicresearch/hlog/config"
 	"github.com/hyperbolicresearch/hlog/internal/core"
-	"github.com/hyperbolicresearch/hlog/internal/kafkaservice"
+	"github.com/hyperbolicresearch/hlog/internal/livetail"
+	"github.com/hyperbolicresearch/hlog/internal/pubsub"
+	"github.com/hyperbolicresearch/hlog/internal/tui"
 	"github.com/hyperbolicresearch/hlog/pkg/logger"
 	"golang.org/x/net/websocket"
 )
 
-// LiveTail is a real-time, bridge between Kafka and a logging medium
+// LiveTail is a real-time, bridge between the topics and a logging medium
 // that allows the observation as they are occuring of newly ingested
 // messages.
 func LiveTail(config *config.Livetail, sigchan chan os.Signal) {
-	kw, err := kafkaservice.NewKafkaWorker(&config.KafkaConfigs)
+	logger := logger.New(config.DefaultLevel, os.Stdout)
+	liveTail(config, sigchan, logger, nil)
+}
+
+// LiveTailTUI is the interactive version of LiveTail: the logs are
+// shown in a full-screen terminal UI instead of being printed to the
+// standard output. The websocket server keeps running as usual.
+func LiveTailTUI(config *config.Livetail, sigchan chan os.Signal, opts tui.Options) error {
+	logs := make(chan core.Log, 1024)
+	ui := tui.New(os.Stdin, os.Stdout, opts)
+
+	// Anything printed by the standard logger would corrupt the screen,
+	// so it goes to the status bar of the UI while it runs.
+	log.SetOutput(ui)
+	defer log.SetOutput(os.Stderr)
+
+	stop := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	go func() {
+		liveTail(config, stop, &logger.Logger{Level: config.DefaultLevel}, func(l core.Log) {
+			select {
+			case logs <- l:
+			default:
+				// The UI cannot keep up, we rather drop than block the
+				// websocket clients.
+			}
+		})
+		close(done)
+	}()
+
+	err := ui.Run(logs, sigchan)
+	stop <- syscall.SIGTERM
+	<-done
+	return err
+}
+
+func liveTail(config *config.Livetail, sigchan chan os.Signal, lg *logger.Logger, onLog func(core.Log)) {
+	consumer, err := pubsub.NewConsumer(&config.KafkaConfigs)
 	if err != nil {
 		panic(err)
 	}
-	kw.ConfigureConsumer()
-	kw.SubscribeTopics(config.KafkaTopics)
+	defer consumer.Close()
+	consumer.Subscribe(config.KafkaTopics)
 
-	logger := logger.New(config.DefaultLevel, os.Stdout)
+	// Remote observers (hlog tail) subscribe through the hub, which
+	// filters the logs for them and replays the ones they missed.
+	hub := livetail.NewHub(config.ReplaySize)
+	hub.Register(http.DefaultServeMux)
 
 	// We build and spin up a new websocket server that is responsible
 	// for basically adding and removing connections to the list of
@@ -36,15 +77,22 @@ func LiveTail(config *config.Livetail, sigchan chan os.Signal) {
 		buf := make([]byte, 1024)
 		for {
 			_, err := ws.Read(buf)
-			if err == io.EOF {
-				if err := logger.RemoveWriter(ws); err != nil {
+			if err != nil {
+				if err := lg.RemoveWriter(ws); err != nil {
 					panic(err)
 				}
-				continue
+				return
 			}
 			// We assume that all call to this endpoint constitues
-			// a demand of connection.
-			if err := logger.AddWriter(ws); err != nil {
+			// a demand of connection. The web clients parse every
+			// message as a JSON log, and a slow or gone browser must
+			// neither block the others nor the consumption.
+			err = lg.AddWriter(ws,
+				logger.WithFormatter(&logger.JSONFormatter{}),
+				logger.WithAsync(256, logger.DropOldest),
+				logger.WithMaxErrors(3),
+			)
+			if err != nil {
 				panic(err)
 			}
 			ws.Write([]byte("Connected"))
@@ -70,15 +118,18 @@ func LiveTail(config *config.Livetail, sigchan chan os.Signal) {
 			log.Printf("Caught signal: %v", sigchan)
 			run = false
 		default:
-			ev, err := kw.Consumer.ReadMessage(time.Duration(100) * time.Millisecond)
+			ev, err := consumer.Read(time.Duration(100) * time.Millisecond)
 			if err != nil {
 				continue
 			}
-			var l core.Log
-			if err := json.Unmarshal(ev.Value, &l); err != nil {
-				fmt.Printf("error unmarshalling value %v: %v", ev.Value, err)
+			if l, err := pubsub.DecodeLog(ev); err != nil {
+				log.Print(err)
 			} else {
-				err := logger.Log(l)
+				hub.Publish(*l)
+				if onLog != nil {
+					onLog(*l)
+				}
+				err := lg.Log(*l)
 				if err != nil {
 					panic(err)
 				}
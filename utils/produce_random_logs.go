@@ -8,40 +8,23 @@ import (
 	"strings"
 	"time"
 
-	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/google/uuid"
 	randomstring "github.com/xyproto/randomstring"
 
 	"github.com/hyperbolicresearch/hlog/config"
 	"github.com/hyperbolicresearch/hlog/internal/core"
+	"github.com/hyperbolicresearch/hlog/internal/pubsub"
 )
 
 // GenerateRandomLogs generates logs every in k seconds
 // in a choice of numTopics topics, simulating how many processes
 // would produce logs in ra real-life scenario.
 func GenerateRandomLogs(cfg *config.Config, stop chan os.Signal) {
-	kafkaConfigs := kafka.ConfigMap{
-		"bootstrap.servers": cfg.Simulator.KafkaConfigs.Server,
-	}
-	producer, err := kafka.NewProducer(&kafkaConfigs)
+	producer, err := pubsub.NewProducer(&cfg.Simulator.KafkaConfigs)
 	if err != nil {
 		panic(err)
 	}
-
-	go func() {
-		for e := range producer.Events() {
-			switch ev := e.(type) {
-			case *kafka.Message:
-				if ev.TopicPartition.Error != nil {
-					log.Printf("Error producing: %v", err)
-				} else {
-					log.Printf("Produced to topic=%-9v partition=%v",
-						*ev.TopicPartition.Topic,
-						ev.TopicPartition.Partition)
-				}
-			}
-		}
-	}()
+	defer producer.Close()
 
 	ticker := time.NewTicker(time.Second * time.Duration(5))
 	run := true
@@ -57,8 +40,8 @@ func GenerateRandomLogs(cfg *config.Config, stop chan os.Signal) {
 }
 
 // Generate generates a new log with random data and produces it
-// to a random topic via the kafka producer provided to it.
-func Generate(kafkaProducer *kafka.Producer, cfg *config.Config) {
+// to a random topic via the producer provided to it.
+func Generate(producer pubsub.Producer, cfg *config.Config) {
 	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
 	index := rnd.Intn(len(cfg.Simulator.KafkaTopics))
 	topics := cfg.Simulator.KafkaTopics
@@ -124,10 +107,10 @@ func Generate(kafkaProducer *kafka.Producer, cfg *config.Config) {
 		panic(err)
 	}
 
-	kafkaProducer.Produce(&kafka.Message{
-		TopicPartition: kafka.TopicPartition{
-			Topic:     &channel,
-			Partition: kafka.PartitionAny},
-		Value: []byte(value),
-	}, nil)
+	msg := &pubsub.Message{Topic: channel, Value: value}
+	if err := producer.Produce([]*pubsub.Message{msg}, cfg.Simulator.ProduceInterval)[0]; err != nil {
+		log.Printf("Error producing: %v", err)
+	} else {
+		log.Printf("Produced to topic=%-9v partition=%v", msg.Topic, msg.Partition)
+	}
 }